@@ -3,17 +3,51 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
+
+	"ledger-link/internal/models"
 )
 
+// DefaultJWTSecret is the placeholder HS256 secret, accepted only in
+// development
+const DefaultJWTSecret = "your-256-bit-secret"
+
+// defaultApprovalThresholds is about 10000 USD in each supported currency
+const defaultApprovalThresholds = "USD=10000,EUR=10000,GBP=8000,CHF=9000,CAD=14000,AUD=15000,SEK=100000,NOK=100000," +
+	"DKK=70000,PLN=40000,CNY=70000,TRY=300000,JPY=1500000,KRW=13000000,KWD=3000,BHD=4000"
+
+// defaultStepUpThresholds is about 1000 USD in each supported currency
+const defaultStepUpThresholds = "USD=1000,EUR=1000,GBP=800,CHF=900,CAD=1400,AUD=1500,SEK=10000,NOK=10000," +
+	"DKK=7000,PLN=4000,CNY=7000,TRY=30000,JPY=150000,KRW=1300000,KWD=300,BHD=400"
+
 type Config struct {
-	LogLevel string
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	Redis    RedisConfig
+	// Env is the APP_ENV the service runs in; only "development" relaxes the
+	// startup checks
+	Env         string
+	LogLevel    string
+	Server      ServerConfig
+	Database    DatabaseConfig
+	JWT         JWTConfig
+	Redis       RedisConfig
+	Idempotency IdempotencyConfig
+	FX          FXConfig
+	Reversal    ReversalConfig
+	Holds       HoldConfig
+	Scheduler   SchedulerConfig
+	Snapshots   SnapshotConfig
+	Reconcile   ReconcileConfig
+	Webhooks    WebhookConfig
+	Outbox      OutboxConfig
+	Stream      StreamConfig
+	Risk        RiskConfig
+	Approvals   ApprovalConfig
+	APIKeys     APIKeyConfig
+	TwoFactor   TwoFactorConfig
+	Lockout     LockoutConfig
+	Mail        MailConfig
 }
 
 type ServerConfig struct {
@@ -31,7 +65,17 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	SecretKey string
+	// SecretKey signs HS256 tokens unless PrivateKeyFile is set, in which
+	// case tokens are signed RS256 or EdDSA with that key and also verified
+	// against the keys in VerificationKeyFiles, such as the key being
+	// rotated out
+	SecretKey            string
+	PrivateKeyFile       string
+	VerificationKeyFiles []string
+	// AccessTTL is how long an access token is valid; RefreshTTL is how long
+	// a session can be refreshed without logging in again
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
 }
 
 type RedisConfig struct {
@@ -41,12 +85,158 @@ type RedisConfig struct {
 	DB       int
 }
 
+type IdempotencyConfig struct {
+	// Store selects where idempotency keys live: "redis" or "database"
+	Store string
+	TTL   time.Duration
+}
+
+type FXConfig struct {
+	// RatesFile is a JSON file of "FROM/TO" mid rates; empty disables conversion
+	RatesFile string
+	SpreadBps int64
+	QuoteTTL  time.Duration
+}
+
+type ReversalConfig struct {
+	// Window is how long senders may reverse their own transfers; admins are
+	// not limited. Zero leaves reversals to admins.
+	Window time.Duration
+}
+
+type HoldConfig struct {
+	// TTL applies to holds placed without an explicit expiry
+	TTL           time.Duration
+	SweepInterval time.Duration
+}
+
+type SchedulerConfig struct {
+	Interval time.Duration
+	// RetryDelay is the wait before the first retry of a failed run; later
+	// retries wait proportionally longer
+	RetryDelay time.Duration
+	// LeaseStore selects where the scheduler lease lives: "redis" or "database"
+	LeaseStore string
+}
+
+type SnapshotConfig struct {
+	// Interval is how often replicas check whether today's balance snapshot
+	// has been taken. Snapshots share the scheduler's lease store.
+	Interval time.Duration
+}
+
+type ReconcileConfig struct {
+	// Interval between scheduled reconciliation runs
+	Interval time.Duration
+}
+
+type WebhookConfig struct {
+	// Interval is how often due deliveries are sent. Delivery shares the
+	// scheduler's lease store.
+	Interval time.Duration
+	// MaxAttempts before a delivery is moved to the dead letters
+	MaxAttempts int
+	Timeout     time.Duration
+}
+
+type OutboxConfig struct {
+	// Sink selects where outbox events are published: "redis" (a Redis
+	// stream), "file" (newline-delimited JSON) or "channel" (in process).
+	// The relay shares the scheduler's lease store.
+	Sink string
+	// File is the path the file sink appends to
+	File string
+	// Stream is the Redis stream the redis sink adds to, trimmed to about
+	// StreamMaxLen entries
+	Stream       string
+	StreamMaxLen int64
+	Interval     time.Duration
+	// Retention is how long published events stay in the outbox
+	Retention time.Duration
+}
+
+type StreamConfig struct {
+	// Heartbeat is how often an idle update stream sends a comment to keep
+	// proxies from closing it
+	Heartbeat time.Duration
+	// Replay is roughly how many recent updates per user are kept for
+	// clients resuming with Last-Event-ID
+	Replay int64
+}
+
+type RiskConfig struct {
+	// RulesFile is a JSON file of the risk rules debits and transfers are
+	// screened with; empty allows every transaction
+	RulesFile string
+}
+
+type ApprovalConfig struct {
+	// Thresholds are, per currency, the most an admin may adjust or transfer
+	// alone within a day; beyond them requests wait for a second admin's
+	// approval, as do requests in currencies without one
+	Thresholds models.CurrencyAmounts
+	// TTL is how long a request waits for approval before it expires
+	TTL time.Duration
+}
+
+type APIKeyConfig struct {
+	// TrustProxy checks API key allowlists against the address the proxy in
+	// front of the service put last in X-Forwarded-For, rather than the
+	// peer address
+	TrustProxy bool
+}
+
+type TwoFactorConfig struct {
+	// Issuer names the service in users' authenticator apps
+	Issuer string
+	// StepUpThresholds are, per currency, the largest amount users with
+	// two-factor authentication on may move out of their account without a
+	// fresh TOTP code; amounts in a currency without one always need it
+	StepUpThresholds models.CurrencyAmounts
+}
+
+type LockoutConfig struct {
+	// Threshold is how many failed logins in a row lock an email out
+	Threshold int
+	// BaseDelay is the first lockout, doubled with each further failure up
+	// to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long after the last failure the count starts over
+	Window time.Duration
+}
+
+type MailConfig struct {
+	// Mailer selects how email is sent: "smtp", or for local development
+	// "file" (newline-delimited JSON) or "log"
+	Mailer string
+	// File is the path the file mailer appends to
+	File string
+	From string
+	SMTP SMTPConfig
+	// BaseURL is where the links in emails point: the app's pages that
+	// take the token from the query and call the API with it
+	BaseURL string
+	// PasswordResetTTL and EmailVerificationTTL are how long the links in
+	// those emails work
+	PasswordResetTTL     time.Duration
+	EmailVerificationTTL time.Duration
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
 func Load() (*Config, error) {
 	if err := godotenv.Load(); err != nil {
 		return nil, err
 	}
 
 	return &Config{
+		Env:      getEnv("APP_ENV", "production"),
 		LogLevel: getEnv("LOG_LEVEL", "info"),
 		Server: ServerConfig{
 			Port:            getEnv("SERVER_PORT", "8080"),
@@ -61,7 +251,11 @@ func Load() (*Config, error) {
 			DBName:   getEnv("DB_NAME", "ledger_link"),
 		},
 		JWT: JWTConfig{
-			SecretKey: getEnv("JWT_SECRET_KEY", "your-256-bit-secret"),
+			SecretKey:            getEnv("JWT_SECRET_KEY", DefaultJWTSecret),
+			PrivateKeyFile:       getEnv("JWT_PRIVATE_KEY_FILE", ""),
+			VerificationKeyFiles: getEnvAsList("JWT_VERIFICATION_KEY_FILES"),
+			AccessTTL:            time.Duration(getEnvAsInt("AUTH_ACCESS_TTL_MINUTES", 15)) * time.Minute,
+			RefreshTTL:           time.Duration(getEnvAsInt("AUTH_REFRESH_TTL_HOURS", 720)) * time.Hour,
 		},
 		Redis: RedisConfig{
 			Host:     getEnv("REDIS_HOST", "localhost"),
@@ -69,9 +263,92 @@ func Load() (*Config, error) {
 			Password: getEnv("REDIS_PASSWORD", ""),
 			DB:       getEnvAsInt("REDIS_DB", 0),
 		},
+		Idempotency: IdempotencyConfig{
+			Store: getEnv("IDEMPOTENCY_STORE", "redis"),
+			TTL:   time.Duration(getEnvAsInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour,
+		},
+		FX: FXConfig{
+			RatesFile: getEnv("FX_RATES_FILE", ""),
+			SpreadBps: int64(getEnvAsInt("FX_SPREAD_BPS", 50)),
+			QuoteTTL:  time.Duration(getEnvAsInt("FX_QUOTE_TTL_SECONDS", 30)) * time.Second,
+		},
+		Reversal: ReversalConfig{
+			Window: time.Duration(getEnvAsInt("REVERSAL_WINDOW_HOURS", 24)) * time.Hour,
+		},
+		Holds: HoldConfig{
+			TTL:           time.Duration(getEnvAsInt("HOLD_TTL_HOURS", 168)) * time.Hour,
+			SweepInterval: time.Duration(getEnvAsInt("HOLD_SWEEP_INTERVAL_SECONDS", 60)) * time.Second,
+		},
+		Scheduler: SchedulerConfig{
+			Interval:   time.Duration(getEnvAsInt("SCHEDULER_INTERVAL_SECONDS", 30)) * time.Second,
+			RetryDelay: time.Duration(getEnvAsInt("SCHEDULER_RETRY_DELAY_SECONDS", 300)) * time.Second,
+			LeaseStore: getEnv("SCHEDULER_LEASE_STORE", "redis"),
+		},
+		Snapshots: SnapshotConfig{
+			Interval: time.Duration(getEnvAsInt("SNAPSHOT_INTERVAL_SECONDS", 3600)) * time.Second,
+		},
+		Reconcile: ReconcileConfig{
+			Interval: time.Duration(getEnvAsInt("RECONCILE_INTERVAL_HOURS", 24)) * time.Hour,
+		},
+		Webhooks: WebhookConfig{
+			Interval:    time.Duration(getEnvAsInt("WEBHOOK_INTERVAL_SECONDS", 5)) * time.Second,
+			MaxAttempts: getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 8),
+			Timeout:     time.Duration(getEnvAsInt("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second,
+		},
+		Outbox: OutboxConfig{
+			Sink:         getEnv("OUTBOX_SINK", "redis"),
+			File:         getEnv("OUTBOX_FILE", "events.ndjson"),
+			Stream:       getEnv("OUTBOX_STREAM", "ledger:events"),
+			StreamMaxLen: int64(getEnvAsInt("OUTBOX_STREAM_MAX_LEN", 100000)),
+			Interval:     time.Duration(getEnvAsInt("OUTBOX_INTERVAL_SECONDS", 1)) * time.Second,
+			Retention:    time.Duration(getEnvAsInt("OUTBOX_RETENTION_HOURS", 168)) * time.Hour,
+		},
+		Stream: StreamConfig{
+			Heartbeat: time.Duration(getEnvAsInt("STREAM_HEARTBEAT_SECONDS", 15)) * time.Second,
+			Replay:    int64(getEnvAsInt("STREAM_REPLAY_LENGTH", 1000)),
+		},
+		Risk: RiskConfig{
+			RulesFile: getEnv("RISK_RULES_FILE", ""),
+		},
+		Approvals: ApprovalConfig{
+			Thresholds: getEnvAsCurrencyAmounts("APPROVAL_THRESHOLDS", defaultApprovalThresholds),
+			TTL:        time.Duration(getEnvAsInt("APPROVAL_TTL_HOURS", 48)) * time.Hour,
+		},
+		APIKeys: APIKeyConfig{
+			TrustProxy: getEnvAsBool("API_KEY_TRUST_PROXY", false),
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer:           getEnv("TWO_FACTOR_ISSUER", "Ledger Link"),
+			StepUpThresholds: getEnvAsCurrencyAmounts("TWO_FACTOR_STEP_UP_THRESHOLDS", defaultStepUpThresholds),
+		},
+		Lockout: LockoutConfig{
+			Threshold: getEnvAsInt("LOCKOUT_THRESHOLD", 5),
+			BaseDelay: time.Duration(getEnvAsInt("LOCKOUT_BASE_SECONDS", 60)) * time.Second,
+			MaxDelay:  time.Duration(getEnvAsInt("LOCKOUT_MAX_MINUTES", 60)) * time.Minute,
+			Window:    time.Duration(getEnvAsInt("LOCKOUT_WINDOW_HOURS", 24)) * time.Hour,
+		},
+		Mail: MailConfig{
+			Mailer: getEnv("MAILER", "log"),
+			File:   getEnv("MAIL_FILE", "mail.ndjson"),
+			From:   getEnv("MAIL_FROM", "Ledger Link <no-reply@localhost>"),
+			SMTP: SMTPConfig{
+				Host:     getEnv("SMTP_HOST", "localhost"),
+				Port:     getEnv("SMTP_PORT", "587"),
+				Username: getEnv("SMTP_USERNAME", ""),
+				Password: getEnv("SMTP_PASSWORD", ""),
+			},
+			BaseURL:              getEnv("APP_BASE_URL", "http://localhost:3000"),
+			PasswordResetTTL:     time.Duration(getEnvAsInt("PASSWORD_RESET_TTL_MINUTES", 60)) * time.Minute,
+			EmailVerificationTTL: time.Duration(getEnvAsInt("EMAIL_VERIFICATION_TTL_HOURS", 48)) * time.Hour,
+		},
 	}, nil
 }
 
+// IsDevelopment reports whether the service runs in development mode.
+func (c *Config) IsDevelopment() bool {
+	return c.Env == "development"
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -87,3 +364,34 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsCurrencyAmounts parses CODE=AMOUNT pairs such as "USD=1000,JPY=150000".
+func getEnvAsCurrencyAmounts(key, defaultValue string) models.CurrencyAmounts {
+	if value, exists := os.LookupEnv(key); exists {
+		if amounts, err := models.ParseCurrencyAmounts(value); err == nil {
+			return amounts
+		}
+	}
+	amounts, _ := models.ParseCurrencyAmounts(defaultValue)
+	return amounts
+}
+
+// getEnvAsList splits a comma-separated variable, skipping empty entries.
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -3,32 +3,80 @@ package config
 import (
 	"fmt"
 	"ledger-link/internal/handlers"
+	"ledger-link/internal/models"
 	"ledger-link/internal/repositories"
 	"ledger-link/internal/services"
 	"ledger-link/pkg/auth"
 	"ledger-link/pkg/cache"
+	"ledger-link/pkg/events"
 	"ledger-link/pkg/logger"
+	"ledger-link/pkg/mailer"
 	"ledger-link/pkg/redis"
 
 	"gorm.io/gorm"
 )
 
+// outboxChannelBuffer is how many events the channel sink holds before the
+// relay waits for its consumers
+const outboxChannelBuffer = 1024
+
 type ServiceContainer struct {
 	// Services
-	AuthService        *services.AuthService
-	UserService        *services.UserService
-	TransactionService *services.TransactionService
-	BalanceService     *services.BalanceService
-	AuditService       *services.AuditService
+	AuthService           *services.AuthService
+	UserService           *services.UserService
+	TransactionService    *services.TransactionService
+	BalanceService        *services.BalanceService
+	AuditService          *services.AuditService
+	JournalService        *services.JournalService
+	FXService             *services.FXService
+	ReversalService       *services.ReversalService
+	HoldService           *services.HoldService
+	ScheduleService       *services.ScheduleService
+	SnapshotService       *services.SnapshotService
+	ReconciliationService *services.ReconciliationService
+	StatementService      *services.StatementService
+	WebhookService        *services.WebhookService
+	OutboxRelay           *services.OutboxRelay
+	LimitService          *services.LimitService
+	RiskService           *services.RiskService
+	ApprovalService       *services.ApprovalService
+	APIKeyService         *services.APIKeyService
+	RoleService           *services.RoleService
+	TwoFactorService      *services.TwoFactorService
+	UserTokenService      *services.UserTokenService
 
 	// Handlers
-	AuthHandler        *handlers.AuthHandler
-	UserHandler        *handlers.UserHandler
-	TransactionHandler *handlers.TransactionHandler
-	BalanceHandler     *handlers.BalanceHandler
+	AuthHandler           *handlers.AuthHandler
+	UserHandler           *handlers.UserHandler
+	TransactionHandler    *handlers.TransactionHandler
+	BalanceHandler        *handlers.BalanceHandler
+	LedgerHandler         *handlers.LedgerHandler
+	FXHandler             *handlers.FXHandler
+	ReversalHandler       *handlers.ReversalHandler
+	HoldHandler           *handlers.HoldHandler
+	ScheduleHandler       *handlers.ScheduleHandler
+	StatementHandler      *handlers.StatementHandler
+	ReconciliationHandler *handlers.ReconciliationHandler
+	WebhookHandler        *handlers.WebhookHandler
+	StreamHandler         *handlers.StreamHandler
+	LimitHandler          *handlers.LimitHandler
+	RiskHandler           *handlers.RiskHandler
+	ApprovalHandler       *handlers.ApprovalHandler
+	JWKSHandler           *handlers.JWKSHandler
+	APIKeyHandler         *handlers.APIKeyHandler
+	RoleHandler           *handlers.RoleHandler
+	AuditHandler          *handlers.AuditHandler
+	TwoFactorHandler      *handlers.TwoFactorHandler
 
 	// Redis
 	CacheService *cache.CacheService
+
+	// EventSink receives the events relayed from the outbox; with the
+	// channel sink, in-process consumers read from it
+	EventSink models.EventSink
+
+	// Idempotency
+	IdempotencyStore models.IdempotencyStore
 }
 
 func NewServiceContainer(db *gorm.DB, logger *logger.Logger, cfg *Config) (*ServiceContainer, error) {
@@ -51,38 +99,256 @@ func NewServiceContainer(db *gorm.DB, logger *logger.Logger, cfg *Config) (*Serv
 	transactionRepo := repositories.NewTransactionRepository(db)
 	balanceRepo := repositories.NewBalanceRepository(db)
 	auditRepo := repositories.NewAuditLogRepository(db)
+	journalRepo := repositories.NewJournalRepository(db)
+	fxQuoteRepo := repositories.NewFXQuoteRepository(db)
+	holdRepo := repositories.NewHoldRepository(db)
+	scheduleRepo := repositories.NewScheduleRepository(db)
+	reconciliationRepo := repositories.NewReconciliationRepository(db)
+	webhookRepo := repositories.NewWebhookRepository(db)
+	outboxRepo := repositories.NewOutboxRepository(db)
+	limitRepo := repositories.NewLimitRepository(db)
+	riskRepo := repositories.NewRiskRepository(db)
+	approvalRepo := repositories.NewApprovalRepository(db)
+	sessionRepo := repositories.NewSessionRepository(db)
+	apiKeyRepo := repositories.NewAPIKeyRepository(db)
+	roleRepo := repositories.NewRoleRepository(db)
+	twoFactorRepo := repositories.NewTwoFactorRepository(db)
+	lockoutRepo := repositories.NewLockoutRepository(db)
+	userTokenRepo := repositories.NewUserTokenRepository(db)
+	uow := repositories.NewUnitOfWork(db)
 
 	// Initialize JWT token maker
-	tokenMaker := auth.NewJWTMaker(cfg.JWT.SecretKey)
+	tokenMaker, err := newTokenMaker(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize the lease that keeps each background job (the scheduler,
+	// the daily balance snapshots, reconciliation, webhook delivery and the
+	// outbox relay) to one replica
+	var schedulerLease models.Lease
+	switch cfg.Scheduler.LeaseStore {
+	case "redis":
+		schedulerLease = cache.NewLease(cacheService)
+	case "database":
+		schedulerLease = repositories.NewLeaseRepository(db)
+	default:
+		return nil, fmt.Errorf("unknown scheduler lease store %q", cfg.Scheduler.LeaseStore)
+	}
+
+	// Initialize the broker that pushes live balance and transaction updates
+	// to clients connected to any replica
+	updateBroker := cache.NewUpdateBroker(cacheService, cfg.Stream.Replay)
 
 	// Initialize services
 	auditSvc := services.NewAuditService(auditRepo, logger)
-	balanceSvc := services.NewBalanceService(balanceRepo, auditSvc, logger, cacheService)
-	userSvc := services.NewUserService(userRepo, balanceSvc, auditSvc, logger)
-	authSvc := services.NewAuthService(userSvc, tokenMaker, logger, balanceSvc)
-	transactionSvc := services.NewTransactionService(transactionRepo, balanceSvc, auditSvc, logger)
+	balanceSvc := services.NewBalanceService(balanceRepo, holdRepo, uow, auditSvc, logger, cacheService, updateBroker)
+	userSvc := services.NewUserService(userRepo, lockoutRepo, uow, balanceSvc, auditSvc, logger, services.LockoutPolicy{
+		Threshold: cfg.Lockout.Threshold,
+		BaseDelay: cfg.Lockout.BaseDelay,
+		MaxDelay:  cfg.Lockout.MaxDelay,
+		Window:    cfg.Lockout.Window,
+	})
+
+	// Initialize the mailer password reset and email verification links
+	// are sent with
+	mail, err := newMailer(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	userTokenSvc := services.NewUserTokenService(userTokenRepo, userRepo, userSvc, uow, mail, auditSvc, logger, cfg.Mail.BaseURL, cfg.Mail.PasswordResetTTL, cfg.Mail.EmailVerificationTTL)
+
+	// Revoked access tokens are looked up in Redis, with the database as the
+	// record that survives a Redis outage
+	twoFactorSvc := services.NewTwoFactorService(twoFactorRepo, userRepo, uow, auditSvc, logger, cfg.TwoFactor.Issuer)
+	revocations := services.NewRevocationList(cache.NewRevocationStore(cacheService), repositories.NewRevocationRepository(db), logger)
+	authSvc := services.NewAuthService(userSvc, tokenMaker, sessionRepo, uow, revocations, twoFactorSvc, userTokenSvc, logger, balanceSvc, cfg.JWT.AccessTTL, cfg.JWT.RefreshTTL)
+	userSvc.SetSessionRevoker(authSvc)
+	apiKeySvc := services.NewAPIKeyService(apiKeyRepo, userRepo, uow, auditSvc, logger)
+	roleSvc := services.NewRoleService(roleRepo, userRepo, uow, auditSvc, logger)
+	limitSvc := services.NewLimitService(limitRepo, userRepo, uow, auditSvc, logger)
+
+	// Initialize the risk rules; without a rule file every transaction is
+	// allowed
+	riskRules, err := services.NewRuleEngine(nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Risk.RulesFile != "" {
+		riskRules, err = services.LoadRiskRules(cfg.Risk.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	riskSvc := services.NewRiskService(riskRules, riskRepo, userRepo, auditSvc, logger)
+
+	webhookSvc := services.NewWebhookService(webhookRepo, uow, auditSvc, schedulerLease, logger, cfg.Webhooks.Interval, cfg.Webhooks.MaxAttempts, cfg.Webhooks.Timeout, cfg.IsDevelopment())
+	transactionSvc := services.NewTransactionService(transactionRepo, journalRepo, uow, balanceSvc, auditSvc, webhookSvc, updateBroker, limitSvc, riskSvc, twoFactorSvc, cfg.TwoFactor.StepUpThresholds, logger)
+	journalSvc := services.NewJournalService(journalRepo, balanceRepo, logger)
+
+	// Initialize FX rates; without a rate file every pair is unavailable
+	rateProvider := services.NewStaticRateProvider(nil)
+	if cfg.FX.RatesFile != "" {
+		rateProvider, err = services.LoadRateFile(cfg.FX.RatesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fxSvc := services.NewFXService(fxQuoteRepo, rateProvider, transactionSvc, logger, cfg.FX.SpreadBps, cfg.FX.QuoteTTL)
+	reversalSvc := services.NewReversalService(transactionRepo, transactionSvc, logger, cfg.Reversal.Window)
+	approvalSvc := services.NewApprovalService(approvalRepo, transactionSvc, uow, auditSvc, logger, cfg.Approvals.Thresholds, cfg.Approvals.TTL)
+	holdSvc := services.NewHoldService(holdRepo, uow, balanceSvc, transactionSvc, auditSvc, logger, cfg.Holds.TTL, cfg.Holds.SweepInterval)
+
+	// Initialize the scheduler, the daily balance snapshots and reconciliation
+	scheduleSvc := services.NewScheduleService(scheduleRepo, uow, transactionSvc, auditSvc, schedulerLease, logger, cfg.Scheduler.Interval, cfg.Scheduler.RetryDelay)
+	snapshotSvc := services.NewSnapshotService(balanceRepo, uow, balanceSvc, schedulerLease, logger, cfg.Snapshots.Interval)
+	reconciliationSvc := services.NewReconciliationService(reconciliationRepo, balanceRepo, transactionRepo, journalRepo, uow, auditSvc, schedulerLease, logger, cfg.Reconcile.Interval)
+	statementSvc := services.NewStatementService(balanceRepo, transactionRepo, balanceSvc, logger)
+
+	// Initialize the sink the outbox relay publishes ledger events to
+	var eventSink models.EventSink
+	switch cfg.Outbox.Sink {
+	case "redis":
+		eventSink = events.NewRedisStreamSink(redisClient, cfg.Outbox.Stream, cfg.Outbox.StreamMaxLen)
+	case "file":
+		eventSink, err = events.NewFileSink(cfg.Outbox.File)
+		if err != nil {
+			return nil, err
+		}
+	case "channel":
+		eventSink = events.NewChannelSink(outboxChannelBuffer)
+	default:
+		return nil, fmt.Errorf("unknown outbox sink %q", cfg.Outbox.Sink)
+	}
+	outboxRelay := services.NewOutboxRelay(outboxRepo, eventSink, schedulerLease, logger, cfg.Outbox.Interval, cfg.Outbox.Retention)
+
+	// Initialize idempotency key store
+	var idempotencyStore models.IdempotencyStore
+	switch cfg.Idempotency.Store {
+	case "redis":
+		idempotencyStore = cache.NewIdempotencyStore(cacheService)
+	case "database":
+		idempotencyStore = repositories.NewIdempotencyRepository(db)
+	default:
+		return nil, fmt.Errorf("unknown idempotency store %q", cfg.Idempotency.Store)
+	}
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authSvc, logger)
+	authHandler := handlers.NewAuthHandler(authSvc, userTokenSvc, logger)
 	userHandler := handlers.NewUserHandler(userSvc, logger)
 	transactionHandler := handlers.NewTransactionHandler(transactionSvc, logger)
 	balanceHandler := handlers.NewBalanceHandler(balanceSvc, logger, nil) // Using default config
+	ledgerHandler := handlers.NewLedgerHandler(journalSvc, logger)
+	fxHandler := handlers.NewFXHandler(fxSvc, logger)
+	reversalHandler := handlers.NewReversalHandler(reversalSvc, logger)
+	holdHandler := handlers.NewHoldHandler(holdSvc, logger)
+	scheduleHandler := handlers.NewScheduleHandler(scheduleSvc, logger)
+	statementHandler := handlers.NewStatementHandler(statementSvc, logger)
+	reconciliationHandler := handlers.NewReconciliationHandler(reconciliationSvc, logger)
+	webhookHandler := handlers.NewWebhookHandler(webhookSvc, logger)
+	streamHandler := handlers.NewStreamHandler(updateBroker, logger, cfg.Stream.Heartbeat)
+	limitHandler := handlers.NewLimitHandler(limitSvc, logger)
+	riskHandler := handlers.NewRiskHandler(riskSvc, transactionSvc, logger)
+	approvalHandler := handlers.NewApprovalHandler(approvalSvc, logger)
+	jwksHandler := handlers.NewJWKSHandler(tokenMaker, logger)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeySvc, logger)
+	roleHandler := handlers.NewRoleHandler(roleSvc, logger)
+	auditHandler := handlers.NewAuditHandler(auditSvc, logger)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorSvc, logger)
 
 	return &ServiceContainer{
 		// Services
-		AuthService:        authSvc,
-		UserService:        userSvc,
-		TransactionService: transactionSvc,
-		BalanceService:     balanceSvc,
-		AuditService:       auditSvc,
+		AuthService:           authSvc,
+		UserService:           userSvc,
+		TransactionService:    transactionSvc,
+		BalanceService:        balanceSvc,
+		AuditService:          auditSvc,
+		JournalService:        journalSvc,
+		FXService:             fxSvc,
+		ReversalService:       reversalSvc,
+		HoldService:           holdSvc,
+		ScheduleService:       scheduleSvc,
+		SnapshotService:       snapshotSvc,
+		ReconciliationService: reconciliationSvc,
+		StatementService:      statementSvc,
+		WebhookService:        webhookSvc,
+		OutboxRelay:           outboxRelay,
+		LimitService:          limitSvc,
+		RiskService:           riskSvc,
+		ApprovalService:       approvalSvc,
+		APIKeyService:         apiKeySvc,
+		RoleService:           roleSvc,
+		TwoFactorService:      twoFactorSvc,
+		UserTokenService:      userTokenSvc,
 
 		// Handlers
-		AuthHandler:        authHandler,
-		UserHandler:        userHandler,
-		TransactionHandler: transactionHandler,
-		BalanceHandler:     balanceHandler,
+		AuthHandler:           authHandler,
+		UserHandler:           userHandler,
+		TransactionHandler:    transactionHandler,
+		BalanceHandler:        balanceHandler,
+		LedgerHandler:         ledgerHandler,
+		FXHandler:             fxHandler,
+		ReversalHandler:       reversalHandler,
+		HoldHandler:           holdHandler,
+		ScheduleHandler:       scheduleHandler,
+		StatementHandler:      statementHandler,
+		ReconciliationHandler: reconciliationHandler,
+		WebhookHandler:        webhookHandler,
+		StreamHandler:         streamHandler,
+		LimitHandler:          limitHandler,
+		RiskHandler:           riskHandler,
+		ApprovalHandler:       approvalHandler,
+		JWKSHandler:           jwksHandler,
+		APIKeyHandler:         apiKeyHandler,
+		RoleHandler:           roleHandler,
+		AuditHandler:          auditHandler,
+		TwoFactorHandler:      twoFactorHandler,
 
 		// Redis
 		CacheService: cacheService,
+
+		// Events
+		EventSink: eventSink,
+
+		// Idempotency
+		IdempotencyStore: idempotencyStore,
 	}, nil
 }
+
+// newTokenMaker signs tokens with the private key file when one is set, and
+// otherwise with the shared secret, which must not be the placeholder
+// outside development.
+func newTokenMaker(cfg *Config) (auth.TokenMaker, error) {
+	if cfg.JWT.PrivateKeyFile != "" {
+		maker, err := auth.NewKeySetMakerFromPEM(cfg.JWT.PrivateKeyFile, cfg.JWT.VerificationKeyFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWT signing keys: %w", err)
+		}
+		return maker, nil
+	}
+
+	if !cfg.IsDevelopment() && (cfg.JWT.SecretKey == "" || cfg.JWT.SecretKey == DefaultJWTSecret) {
+		return nil, fmt.Errorf("refusing to start in %q with the default JWT secret: set JWT_SECRET_KEY or JWT_PRIVATE_KEY_FILE", cfg.Env)
+	}
+	return auth.NewJWTMaker(cfg.JWT.SecretKey), nil
+}
+
+// newMailer returns the mailer cfg selects. The file and log mailers keep
+// live tokens where operators can read them, so they are refused outside
+// development.
+func newMailer(cfg *Config, logger *logger.Logger) (models.Mailer, error) {
+	switch cfg.Mail.Mailer {
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.Mail.SMTP.Host, cfg.Mail.SMTP.Port, cfg.Mail.SMTP.Username, cfg.Mail.SMTP.Password, cfg.Mail.From)
+	case "file", "log":
+		if !cfg.IsDevelopment() {
+			return nil, fmt.Errorf("refusing to start in %q with the %s mailer: set MAILER=smtp", cfg.Env, cfg.Mail.Mailer)
+		}
+		if cfg.Mail.Mailer == "file" {
+			return mailer.NewFileMailer(cfg.Mail.File, cfg.Mail.From)
+		}
+		return mailer.NewLogMailer(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", cfg.Mail.Mailer)
+	}
+}
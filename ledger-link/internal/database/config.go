@@ -34,6 +34,32 @@ func InitDB(cfg config.DatabaseConfig) (*gorm.DB, error) {
 		&models.Transaction{},
 		&models.BalanceHistory{},
 		&models.AuditLog{},
+		&models.Posting{},
+		&models.IdempotencyRecord{},
+		&models.FXQuote{},
+		&models.Hold{},
+		&models.Schedule{},
+		&models.ScheduleRun{},
+		&models.LeaseRecord{},
+		&models.BalanceSnapshot{},
+		&models.ReconciliationRun{},
+		&models.Discrepancy{},
+		&models.WebhookSubscription{},
+		&models.WebhookDelivery{},
+		&models.OutboxEvent{},
+		&models.SpendingLimit{},
+		&models.RiskReview{},
+		&models.Approval{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.APIKey{},
+		&models.Role{},
+		&models.TwoFactor{},
+		&models.RecoveryCode{},
+		&models.LoginChallenge{},
+		&models.LoginLockout{},
+		&models.UserToken{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
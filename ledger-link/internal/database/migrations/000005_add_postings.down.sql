@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS postings;
+
+DELETE t FROM transactions t
+JOIN journal_openings o ON o.transaction_id = t.id;
+
+DROP TABLE IF EXISTS journal_openings;
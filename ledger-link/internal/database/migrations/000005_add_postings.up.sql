@@ -0,0 +1,38 @@
+CREATE TABLE postings (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    transaction_id BIGINT UNSIGNED NOT NULL,
+    account_type VARCHAR(20) NOT NULL,
+    account_id BIGINT UNSIGNED NOT NULL,
+    amount DECIMAL(20,8) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_postings_transaction_id (transaction_id),
+    KEY idx_postings_account (account_type, account_id)
+);
+
+-- The transactions opening the journal, so the rollback removes exactly these
+CREATE TABLE journal_openings (
+    transaction_id BIGINT UNSIGNED NOT NULL PRIMARY KEY
+);
+
+-- Open the journal with the balances that existed before it did
+SET @last_transaction_id = (SELECT COALESCE(MAX(id), 0) FROM transactions);
+
+INSERT INTO transactions (from_user_id, to_user_id, amount, type, status, notes, created_at, updated_at)
+SELECT user_id, user_id, amount, 'adjustment', 'completed', 'Opening balance', NOW(), NOW()
+FROM balances
+WHERE amount > 0 AND deleted_at IS NULL;
+
+INSERT INTO journal_openings (transaction_id)
+SELECT id
+FROM transactions
+WHERE id > @last_transaction_id;
+
+INSERT INTO postings (transaction_id, account_type, account_id, amount, created_at)
+SELECT t.id, 'user', t.to_user_id, t.amount, t.created_at
+FROM transactions t
+JOIN journal_openings o ON o.transaction_id = t.id;
+
+INSERT INTO postings (transaction_id, account_type, account_id, amount, created_at)
+SELECT t.id, 'adjustment', 0, -t.amount, t.created_at
+FROM transactions t
+JOIN journal_openings o ON o.transaction_id = t.id;
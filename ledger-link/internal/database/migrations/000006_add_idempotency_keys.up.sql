@@ -0,0 +1,14 @@
+CREATE TABLE idempotency_records (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    `key` VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    completed BOOLEAN NOT NULL DEFAULT FALSE,
+    status_code INT,
+    content_type VARCHAR(255),
+    response_body BLOB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    UNIQUE KEY idx_idempotency_user_key (user_id, `key`),
+    KEY idx_idempotency_records_expires_at (expires_at)
+);
@@ -0,0 +1,19 @@
+-- Only the default currency fits the single-currency schema
+DELETE FROM postings WHERE currency <> 'USD';
+DELETE FROM balance_history WHERE currency <> 'USD';
+DELETE FROM transactions WHERE currency <> 'USD';
+DELETE FROM balances WHERE currency <> 'USD';
+
+ALTER TABLE postings
+    DROP KEY idx_postings_account,
+    ADD KEY idx_postings_account (account_type, account_id),
+    DROP COLUMN currency;
+
+ALTER TABLE balance_history
+    DROP KEY idx_balance_history_user_currency,
+    DROP COLUMN currency;
+
+ALTER TABLE transactions DROP COLUMN currency;
+
+ALTER TABLE balances DROP PRIMARY KEY, ADD PRIMARY KEY (user_id);
+ALTER TABLE balances DROP COLUMN currency;
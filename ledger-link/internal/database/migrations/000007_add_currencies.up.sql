@@ -0,0 +1,14 @@
+-- Existing balances, transactions and journal lines are denominated in USD
+ALTER TABLE balances ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD' AFTER user_id;
+ALTER TABLE balances DROP PRIMARY KEY, ADD PRIMARY KEY (user_id, currency);
+
+ALTER TABLE transactions ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD' AFTER amount;
+
+ALTER TABLE balance_history
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD' AFTER user_id,
+    ADD KEY idx_balance_history_user_currency (user_id, currency);
+
+ALTER TABLE postings
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD' AFTER account_id,
+    DROP KEY idx_postings_account,
+    ADD KEY idx_postings_account (account_type, account_id, currency);
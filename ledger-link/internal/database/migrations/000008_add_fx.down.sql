@@ -0,0 +1,9 @@
+ALTER TABLE transactions
+    DROP KEY idx_transactions_fx_quote_id,
+    DROP COLUMN fx_quote_id,
+    DROP COLUMN fx_spread,
+    DROP COLUMN fx_rate,
+    DROP COLUMN to_amount,
+    DROP COLUMN to_currency;
+
+DROP TABLE IF EXISTS fx_quotes;
@@ -0,0 +1,25 @@
+CREATE TABLE fx_quotes (
+    id CHAR(36) PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    from_currency CHAR(3) NOT NULL,
+    to_currency CHAR(3) NOT NULL,
+    source_amount DECIMAL(20,8) NOT NULL,
+    target_amount DECIMAL(20,8) NOT NULL,
+    mid_rate DECIMAL(20,10) NOT NULL,
+    rate DECIMAL(20,10) NOT NULL,
+    spread DECIMAL(20,8) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    transaction_id BIGINT UNSIGNED NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_fx_quotes_user_id (user_id)
+);
+
+-- The target leg of a conversion; amount and currency stay the source leg
+ALTER TABLE transactions
+    ADD COLUMN to_currency CHAR(3) NULL AFTER currency,
+    ADD COLUMN to_amount DECIMAL(20,8) NULL AFTER to_currency,
+    ADD COLUMN fx_rate DECIMAL(20,10) NULL AFTER to_amount,
+    ADD COLUMN fx_spread DECIMAL(20,8) NULL AFTER fx_rate,
+    ADD COLUMN fx_quote_id CHAR(36) NULL AFTER fx_spread,
+    ADD KEY idx_transactions_fx_quote_id (fx_quote_id);
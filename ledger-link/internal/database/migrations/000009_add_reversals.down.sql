@@ -0,0 +1,4 @@
+ALTER TABLE transactions
+    DROP KEY idx_transactions_reversal_of,
+    DROP COLUMN refunded_amount,
+    DROP COLUMN reversal_of;
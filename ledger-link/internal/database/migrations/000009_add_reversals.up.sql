@@ -0,0 +1,6 @@
+-- Reversals point at the transaction they refund; the original keeps a
+-- running total so refunds cannot exceed it
+ALTER TABLE transactions
+    ADD COLUMN reversal_of BIGINT UNSIGNED NULL,
+    ADD COLUMN refunded_amount DECIMAL(20,8) NOT NULL DEFAULT 0,
+    ADD KEY idx_transactions_reversal_of (reversal_of);
@@ -0,0 +1,16 @@
+CREATE TABLE holds (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    currency CHAR(3) NOT NULL,
+    amount DECIMAL(20,8) NOT NULL,
+    captured_amount DECIMAL(20,8) NOT NULL DEFAULT 0,
+    status VARCHAR(10) NOT NULL,
+    reference VARCHAR(255),
+    notes TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    transaction_id BIGINT UNSIGNED NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_holds_user_status (user_id, currency, status),
+    KEY idx_holds_expires_at (expires_at)
+);
@@ -0,0 +1,43 @@
+CREATE TABLE schedules (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    to_user_id BIGINT UNSIGNED NOT NULL,
+    amount DECIMAL(20,8) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    notes TEXT,
+    kind VARCHAR(10) NOT NULL,
+    interval_seconds BIGINT NOT NULL DEFAULT 0,
+    cron_expr VARCHAR(100),
+    start_at TIMESTAMP NOT NULL,
+    end_at TIMESTAMP NULL,
+    failure_policy VARCHAR(10) NOT NULL,
+    max_retries INT NOT NULL DEFAULT 0,
+    status VARCHAR(10) NOT NULL,
+    next_run_at TIMESTAMP NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_run_at TIMESTAMP NULL,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_schedules_user_id (user_id),
+    KEY idx_schedules_due (status, next_run_at)
+);
+
+CREATE TABLE schedule_runs (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    schedule_id BIGINT UNSIGNED NOT NULL,
+    scheduled_for TIMESTAMP NOT NULL,
+    attempt INT NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    transaction_id BIGINT UNSIGNED NULL,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_schedule_runs_schedule_id (schedule_id),
+    CONSTRAINT fk_schedule_runs_schedule FOREIGN KEY (schedule_id) REFERENCES schedules(id)
+);
+
+CREATE TABLE leases (
+    name VARCHAR(100) PRIMARY KEY,
+    holder VARCHAR(100) NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
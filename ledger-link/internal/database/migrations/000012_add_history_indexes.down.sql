@@ -0,0 +1,2 @@
+DROP INDEX idx_transactions_to_history ON transactions;
+DROP INDEX idx_transactions_from_history ON transactions;
@@ -0,0 +1,5 @@
+-- History pages walk a user's transactions by (created_at, id) from either
+-- side of the transfer. InnoDB appends the primary key to secondary indexes,
+-- so id does not need to be listed.
+CREATE INDEX idx_transactions_from_history ON transactions(from_user_id, created_at);
+CREATE INDEX idx_transactions_to_history ON transactions(to_user_id, created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE balance_history
+    DROP KEY idx_balance_history_transaction_id,
+    DROP COLUMN transaction_id;
@@ -0,0 +1,5 @@
+-- Statements describe each balance change by the transaction that posted it.
+-- Changes from batched deposits cover several transactions and stay NULL.
+ALTER TABLE balance_history
+    ADD COLUMN transaction_id BIGINT UNSIGNED NULL,
+    ADD KEY idx_balance_history_transaction_id (transaction_id);
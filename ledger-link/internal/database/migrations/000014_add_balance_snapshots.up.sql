@@ -0,0 +1,12 @@
+-- Daily checkpoints for as-of balance queries, which replay the history
+-- recorded after the latest snapshot.
+CREATE TABLE balance_snapshots (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    currency CHAR(3) NOT NULL,
+    as_of TIMESTAMP NOT NULL,
+    amount DECIMAL(20,8) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_balance_snapshots_balance (user_id, currency, as_of),
+    KEY idx_balance_snapshots_as_of (as_of)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE transactions DROP COLUMN debit;
+DROP TABLE IF EXISTS reconciliation_discrepancies;
+DROP TABLE IF EXISTS reconciliation_runs;
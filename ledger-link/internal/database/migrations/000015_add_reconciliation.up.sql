@@ -0,0 +1,37 @@
+-- Reports of reconciliation runs recomputing every balance from its
+-- completed transactions, and the balances each run found drifting.
+CREATE TABLE reconciliation_runs (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    `trigger` VARCHAR(20) NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    balances_checked INT NOT NULL,
+    KEY idx_reconciliation_runs_started_at (started_at)
+);
+
+CREATE TABLE reconciliation_discrepancies (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    run_id BIGINT UNSIGNED NOT NULL,
+    user_id BIGINT UNSIGNED NOT NULL,
+    currency CHAR(3) NOT NULL,
+    stored_balance DECIMAL(20,8) NOT NULL,
+    computed_balance DECIMAL(20,8) NOT NULL,
+    posted_balance DECIMAL(20,8) NOT NULL,
+    history_balance DECIMAL(20,8) NULL,
+    difference DECIMAL(20,8) NOT NULL,
+    offending_transactions TEXT,
+    status VARCHAR(20) NOT NULL,
+    repair_transaction_id BIGINT UNSIGNED NULL,
+    repaired_by BIGINT UNSIGNED NULL,
+    repaired_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    KEY idx_reconciliation_discrepancies_run_id (run_id),
+    KEY idx_reconciliation_discrepancies_user_id (user_id),
+    KEY idx_reconciliation_discrepancies_status (status),
+    FOREIGN KEY (run_id) REFERENCES reconciliation_runs(id)
+);
+
+-- Repairs book negative drift as adjustments charged to the user.
+ALTER TABLE transactions
+    ADD COLUMN debit BOOLEAN NOT NULL DEFAULT FALSE;
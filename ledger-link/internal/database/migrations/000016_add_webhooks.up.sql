@@ -0,0 +1,40 @@
+-- Webhook subscriptions to transaction lifecycle events. Subscriptions
+-- without a user are global and receive every user's events.
+CREATE TABLE webhook_subscriptions (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NULL,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    events TEXT,
+    created_by BIGINT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    KEY idx_webhook_subscriptions_user_id (user_id),
+    KEY idx_webhook_subscriptions_deleted_at (deleted_at)
+);
+
+-- The delivery outbox, written in the same transaction as the status change
+-- that raised each event.
+CREATE TABLE webhook_deliveries (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    subscription_id BIGINT UNSIGNED NOT NULL,
+    event_id CHAR(36) NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    transaction_id BIGINT UNSIGNED NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NULL,
+    last_attempt_at TIMESTAMP NULL,
+    last_status_code INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    delivered_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    KEY idx_webhook_deliveries_subscription_id (subscription_id),
+    KEY idx_webhook_deliveries_event_id (event_id),
+    KEY idx_webhook_deliveries_transaction_id (transaction_id),
+    KEY idx_webhook_deliveries_due (status, next_attempt_at),
+    FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions(id)
+);
@@ -0,0 +1,14 @@
+-- Ledger events written in the same transaction as the transaction, balance
+-- or audit log change that raised them, and published to the event sink by
+-- the outbox relay.
+CREATE TABLE outbox_events (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    type VARCHAR(50) NOT NULL,
+    account_id BIGINT UNSIGNED NOT NULL,
+    entity_id VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMP NULL,
+    KEY idx_outbox_events_account_id (account_id),
+    KEY idx_outbox_events_published_at (published_at)
+);
@@ -0,0 +1,2 @@
+DROP INDEX idx_transactions_spending ON transactions;
+DROP TABLE IF EXISTS spending_limits;
@@ -0,0 +1,23 @@
+-- Spending limits per role, and per-user overrides (role left empty), for
+-- one transaction type and currency. Empty caps do not apply.
+CREATE TABLE spending_limits (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    role VARCHAR(20) NOT NULL DEFAULT '',
+    user_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    transaction_type VARCHAR(20) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    per_transaction DECIMAL(20,8) NULL,
+    daily_amount DECIMAL(20,8) NULL,
+    weekly_amount DECIMAL(20,8) NULL,
+    monthly_amount DECIMAL(20,8) NULL,
+    daily_count BIGINT NULL,
+    weekly_count BIGINT NULL,
+    monthly_count BIGINT NULL,
+    created_by BIGINT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_spending_limits_scope (role, user_id, transaction_type, currency)
+);
+
+-- Usage is summed from the sender's completed transactions of a type
+CREATE INDEX idx_transactions_spending ON transactions (from_user_id, type, currency, created_at);
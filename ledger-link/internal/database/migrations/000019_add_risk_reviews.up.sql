@@ -0,0 +1,17 @@
+-- Debits and transfers the risk rules held in review status, waiting for an
+-- admin to approve or reject them.
+CREATE TABLE risk_reviews (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    transaction_id BIGINT UNSIGNED NOT NULL,
+    user_id BIGINT UNSIGNED NOT NULL,
+    reasons TEXT,
+    status VARCHAR(20) NOT NULL,
+    reviewed_by BIGINT UNSIGNED NULL,
+    reviewed_at TIMESTAMP NULL,
+    note TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_risk_reviews_transaction_id (transaction_id),
+    KEY idx_risk_reviews_user_id (user_id),
+    KEY idx_risk_reviews_status (status)
+);
@@ -0,0 +1,23 @@
+-- Adjustments and transfers admins requested above the approval threshold,
+-- waiting for a second admin. transaction_id is set once one is approved
+-- and executed.
+CREATE TABLE approvals (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    type VARCHAR(20) NOT NULL,
+    from_user_id BIGINT UNSIGNED NOT NULL,
+    to_user_id BIGINT UNSIGNED NOT NULL,
+    amount DECIMAL(20,8) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    debit BOOLEAN NOT NULL DEFAULT FALSE,
+    notes TEXT,
+    status VARCHAR(20) NOT NULL,
+    requested_by BIGINT UNSIGNED NOT NULL,
+    decided_by BIGINT UNSIGNED NULL,
+    decided_at TIMESTAMP NULL,
+    reason TEXT,
+    transaction_id BIGINT UNSIGNED NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    KEY idx_approvals_status (status, expires_at)
+);
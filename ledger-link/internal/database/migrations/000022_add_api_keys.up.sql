@@ -0,0 +1,21 @@
+-- API keys machine clients act as a user with. Only the SHA-256 of a key is
+-- kept; prefix identifies it. scopes and allowed_ips are JSON arrays.
+CREATE TABLE api_keys (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    user_id BIGINT UNSIGNED NOT NULL,
+    scopes TEXT,
+    allowed_ips TEXT,
+    expires_at TIMESTAMP NULL,
+    last_used_at TIMESTAMP NULL,
+    last_used_ip VARCHAR(45),
+    revoked_at TIMESTAMP NULL,
+    created_by BIGINT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_api_keys_prefix (prefix),
+    KEY idx_api_keys_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
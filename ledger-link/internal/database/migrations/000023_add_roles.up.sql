@@ -0,0 +1,17 @@
+-- Permissions granted to each role, as a JSON array. Admins hold every
+-- permission and have no row; the other roles start with their defaults.
+CREATE TABLE roles (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(20) NOT NULL,
+    permissions TEXT,
+    updated_by BIGINT UNSIGNED,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_roles_name (name)
+);
+
+INSERT INTO roles (name, permissions) VALUES
+    ('user', '[]'),
+    ('support', '["accounts:read","statements:read"]'),
+    ('auditor', '["audit_logs:read","statements:read"]'),
+    ('operator', '["adjustments:create","transactions:reverse"]');
@@ -0,0 +1,40 @@
+-- TOTP secrets of users who enrolled in two-factor authentication; it
+-- protects their logins once confirmed_at is set. last_used_step is the time
+-- step of the last accepted code, so no code is accepted twice.
+CREATE TABLE two_factor_credentials (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    confirmed_at TIMESTAMP NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_two_factor_credentials_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Single-use recovery codes, kept by SHA-256.
+CREATE TABLE recovery_codes (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_recovery_codes_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- The second step of logins by users with two-factor authentication on,
+-- identified by the SHA-256 of their opaque token.
+CREATE TABLE login_challenges (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_login_challenges_token_hash (token_hash),
+    KEY idx_login_challenges_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
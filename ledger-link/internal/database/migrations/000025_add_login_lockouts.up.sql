@@ -0,0 +1,15 @@
+-- Failed logins per email address, known or not, and the lockout they
+-- earned. user_id is set when an account has the email.
+CREATE TABLE login_lockouts (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    user_id BIGINT UNSIGNED NULL,
+    failed_attempts INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP NULL,
+    locked_until TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_login_lockouts_email (email),
+    KEY idx_login_lockouts_user_id (user_id),
+    KEY idx_login_lockouts_locked_until (locked_until)
+);
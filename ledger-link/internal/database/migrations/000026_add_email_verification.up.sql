@@ -0,0 +1,19 @@
+-- When the user proved they receive mail at their address. Existing users
+-- signed up before verification and are taken as verified.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP NULL;
+UPDATE users SET email_verified_at = created_at;
+
+-- Single-use tokens mailed to users to verify their email address or reset
+-- their password, identified by their SHA-256.
+CREATE TABLE user_tokens (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    purpose VARCHAR(30) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_user_tokens_token_hash (token_hash),
+    KEY idx_user_tokens_user_id_purpose (user_id, purpose),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
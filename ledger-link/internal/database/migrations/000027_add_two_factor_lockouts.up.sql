@@ -0,0 +1,5 @@
+-- Wrong codes given in a row to step up, change a password or manage
+-- two-factor authentication, and the lockout they earned.
+ALTER TABLE two_factor_credentials
+    ADD COLUMN failed_attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN locked_until TIMESTAMP NULL;
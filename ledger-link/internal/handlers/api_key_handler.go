@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+type APIKeyHandler struct {
+	apiKeyService models.APIKeyService
+	logger        *logger.Logger
+}
+
+func NewAPIKeyHandler(apiKeyService models.APIKeyService, logger *logger.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+		logger:        logger,
+	}
+}
+
+// CreateAPIKeyRequest issues a key acting as UserID. AllowedIPs holds IPs
+// or CIDR ranges; left out, the key works from anywhere. Without ExpiresAt
+// the key does not expire.
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name"`
+	UserID     uint       `json:"user_id"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse carries the key itself, which is shown only once.
+type CreateAPIKeyResponse struct {
+	Key string `json:"key"`
+	*models.APIKey
+}
+
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrInvalidAPIKey):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *APIKeyHandler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	key, secret, err := h.apiKeyService.Create(r.Context(), &models.APIKey{
+		Name:       req.Name,
+		UserID:     req.UserID,
+		Scopes:     req.Scopes,
+		AllowedIPs: req.AllowedIPs,
+		ExpiresAt:  req.ExpiresAt,
+	})
+	if err != nil {
+		h.logger.Error("failed to create API key", "error", err)
+		http.Error(w, err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{Key: secret, APIKey: key})
+}
+
+// HandleListAPIKeys lists the keys, filtered by the user_id query
+// parameter.
+func (h *APIKeyHandler) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	var userID uint
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" {
+		id, err := strconv.ParseUint(userIDStr, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = uint(id)
+	}
+
+	keys, err := h.apiKeyService.List(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to list API keys", "error", err)
+		http.Error(w, "Failed to list API keys", apiKeyErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func (h *APIKeyHandler) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.apiKeyService.Revoke(r.Context(), id)
+	if err != nil {
+		h.logger.Error("failed to revoke API key", "error", err, "key_id", id)
+		http.Error(w, err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/shopspring/decimal"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+type ApprovalHandler struct {
+	approvalService models.ApprovalService
+	logger          *logger.Logger
+}
+
+func NewApprovalHandler(approvalService models.ApprovalService, logger *logger.Logger) *ApprovalHandler {
+	return &ApprovalHandler{
+		approvalService: approvalService,
+		logger:          logger,
+	}
+}
+
+// AdjustmentRequest credits the user's balance, or charges it when Debit is
+// set.
+type AdjustmentRequest struct {
+	UserID   uint            `json:"user_id"`
+	Amount   decimal.Decimal `json:"amount"`
+	Currency string          `json:"currency"`
+	Debit    bool            `json:"debit"`
+	Notes    string          `json:"notes"`
+}
+
+// AdminTransferRequest moves funds between two users on an admin's behalf.
+type AdminTransferRequest struct {
+	FromUserID uint            `json:"from_user_id"`
+	ToUserID   uint            `json:"to_user_id"`
+	Amount     decimal.Decimal `json:"amount"`
+	Currency   string          `json:"currency"`
+	Notes      string          `json:"notes"`
+}
+
+// DecisionRequest is the checker's optional reason for approving or
+// rejecting.
+type DecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+func approvalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrApprovalSameChecker):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrApprovalResolved):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrApprovalExpired):
+		return http.StatusGone
+	case errors.Is(err, models.ErrInvalidApproval),
+		errors.Is(err, models.ErrInvalidType):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrInsufficientFunds):
+		return http.StatusUnprocessableEntity
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+func (h *ApprovalHandler) HandleAdjustment(w http.ResponseWriter, r *http.Request) {
+	var req AdjustmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.request(w, r, &models.Approval{
+		Type:     models.TypeAdjustment,
+		ToUserID: req.UserID,
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		Debit:    req.Debit,
+		Notes:    req.Notes,
+	})
+}
+
+func (h *ApprovalHandler) HandleTransfer(w http.ResponseWriter, r *http.Request) {
+	var req AdminTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.request(w, r, &models.Approval{
+		Type:       models.TypeTransfer,
+		FromUserID: req.FromUserID,
+		ToUserID:   req.ToUserID,
+		Amount:     req.Amount,
+		Currency:   req.Currency,
+		Notes:      req.Notes,
+	})
+}
+
+// request answers 201 with the transaction when it ran at once, and 202
+// with the approval when it waits for a second admin.
+func (h *ApprovalHandler) request(w http.ResponseWriter, r *http.Request, request *models.Approval) {
+	approval, tx, err := h.approvalService.Request(r.Context(), request)
+	if err != nil {
+		h.logger.Error("failed to request admin "+string(request.Type), "error", err)
+		http.Error(w, err.Error(), approvalErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if approval != nil {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(approval)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tx)
+}
+
+// HandleListApprovals lists the approvals with the status query parameter,
+// pending by default.
+func (h *ApprovalHandler) HandleListApprovals(w http.ResponseWriter, r *http.Request) {
+	status := models.ApprovalStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = models.ApprovalPending
+	}
+
+	approvals, err := h.approvalService.ListApprovals(r.Context(), status)
+	if err != nil {
+		h.logger.Error("failed to list approvals", "error", err)
+		http.Error(w, "Failed to list approvals", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(approvals)
+}
+
+func (h *ApprovalHandler) HandleApprove(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.approvalService.Approve)
+}
+
+func (h *ApprovalHandler) HandleReject(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.approvalService.Reject)
+}
+
+func (h *ApprovalHandler) decide(w http.ResponseWriter, r *http.Request, decide func(ctx context.Context, id uint, reason string) (*models.Approval, error)) {
+	id, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid approval ID", http.StatusBadRequest)
+		return
+	}
+
+	var req DecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	approval, err := decide(r.Context(), id, req.Reason)
+	if err != nil {
+		h.logger.Error("failed to decide approval", "error", err, "approval_id", id)
+		http.Error(w, err.Error(), approvalErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(approval)
+}
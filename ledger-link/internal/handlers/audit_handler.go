@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+type AuditHandler struct {
+	auditService models.AuditService
+	logger       *logger.Logger
+}
+
+func NewAuditHandler(auditService models.AuditService, logger *logger.Logger) *AuditHandler {
+	return &AuditHandler{
+		auditService: auditService,
+		logger:       logger,
+	}
+}
+
+// HandleListAuditLogs returns the audit trail of the entity named by the
+// entity_type and entity_id query parameters, oldest first.
+func (h *AuditHandler) HandleListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	entityType := query.Get("entity_type")
+	if entityType == "" {
+		http.Error(w, "entity_type is required", http.StatusBadRequest)
+		return
+	}
+	entityID, err := strconv.ParseUint(query.Get("entity_id"), 10, 32)
+	if err != nil {
+		http.Error(w, "invalid entity ID", http.StatusBadRequest)
+		return
+	}
+
+	logs, err := h.auditService.GetEntityAuditLog(r.Context(), entityType, uint(entityID))
+	if err != nil {
+		h.logger.Error("failed to get audit log", "error", err, "entity_type", entityType, "entity_id", entityID)
+		http.Error(w, "Failed to get audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs)
+}
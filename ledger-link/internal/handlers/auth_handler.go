@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"ledger-link/internal/models"
 	"ledger-link/internal/services"
+	"ledger-link/pkg/auth"
 	"ledger-link/pkg/logger"
 	"ledger-link/pkg/validator"
 )
@@ -16,14 +22,16 @@ var (
 )
 
 type AuthHandler struct {
-	authSvc *services.AuthService
-	logger  *logger.Logger
+	authSvc    *services.AuthService
+	userTokens models.UserTokenService
+	logger     *logger.Logger
 }
 
-func NewAuthHandler(authSvc *services.AuthService, logger *logger.Logger) *AuthHandler {
+func NewAuthHandler(authSvc *services.AuthService, userTokens models.UserTokenService, logger *logger.Logger) *AuthHandler {
 	return &AuthHandler{
-		authSvc: authSvc,
-		logger:  logger,
+		authSvc:    authSvc,
+		userTokens: userTokens,
+		logger:     logger,
 	}
 }
 
@@ -39,40 +47,270 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.authSvc.Login(r.Context(), input.Email, input.Password)
+	result, err := h.authSvc.Login(withClient(r), input.Email, input.Password)
 	if err != nil {
 		if err == models.ErrInvalidCredentials {
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
+		var locked *models.AccountLockedError
+		if errors.As(err, &locked) {
+			retryAfter := int(math.Ceil(time.Until(locked.LockedUntil).Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+			return
+		}
 		h.logger.Error("failed to login user", "error", err)
 		http.Error(w, "Failed to login user", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	json.NewEncoder(w).Encode(result)
+}
+
+// VerifyLogin completes the login challenge of a user with two-factor
+// authentication on.
+func (h *AuthHandler) VerifyLogin(w http.ResponseWriter, r *http.Request) {
+	var input models.VerifyLoginInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validator.Validate(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.authSvc.VerifyLogin(withClient(r), input.ChallengeToken, input.Code)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidTwoFactorCode) || errors.Is(err, models.ErrInvalidChallenge) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, models.ErrTwoFactorLocked) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		h.logger.Error("failed to verify login", "error", err)
+		http.Error(w, "Failed to login user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// ChangePassword changes the calling user's password. With two-factor
+// authentication on, a fresh TOTP code must be sent in X-TOTP-Code. Every
+// session of the user ends, this one included.
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var input models.ChangePasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validator.Validate(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.authSvc.ChangePassword(r.Context(), input.OldPassword, input.NewPassword, r.Header.Get(TOTPCodeHeader))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		case errors.Is(err, models.ErrTwoFactorRequired), errors.Is(err, models.ErrInvalidTwoFactorCode):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, models.ErrTwoFactorLocked):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			h.logger.Error("failed to change password", "error", err)
+			http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForgotPassword mails a password reset link to the account with the
+// email. It answers 202 whether or not there is one.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var input models.ForgotPasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validator.Validate(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userTokens.RequestPasswordReset(r.Context(), input.Email); err != nil {
+		h.logger.Error("failed to request password reset", "error", err)
+		http.Error(w, "Failed to request password reset", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with the token from a reset link, and
+// ends every session of the user.
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var input models.ResetPasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validator.Validate(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userTokens.ResetPassword(r.Context(), input.Token, input.NewPassword); err != nil {
+		if errors.Is(err, models.ErrInvalidUserToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.logger.Error("failed to reset password", "error", err)
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyEmail marks the email address verified with the token from a
+// verification link.
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var input models.VerifyEmailInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validator.Validate(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userTokens.VerifyEmail(r.Context(), input.Token); err != nil {
+		if errors.Is(err, models.ErrInvalidUserToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.logger.Error("failed to verify email", "error", err)
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequestEmailVerification mails the calling user a new verification link.
+func (h *AuthHandler) RequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	if err := h.userTokens.RequestEmailVerification(r.Context()); err != nil {
+		if errors.Is(err, models.ErrEmailAlreadyVerified) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		h.logger.Error("failed to send email verification", "error", err)
+		http.Error(w, "Failed to send email verification", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RefreshRequest carries the refresh token to rotate.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
-	oldToken := r.Header.Get("Authorization")
-	if oldToken == "" {
-		http.Error(w, "Authorization token is required", http.StatusBadRequest)
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
 		return
 	}
 
-	if len(oldToken) > 7 && oldToken[:7] == "Bearer " {
-		oldToken = oldToken[7:]
+	tokens, err := h.authSvc.RefreshToken(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, models.ErrRefreshTokenReused) {
+			h.logger.Warn("refresh token reuse detected", "error", err)
+		} else {
+			h.logger.Error("failed to refresh token", "error", err)
+		}
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
 	}
 
-	newToken, err := h.authSvc.RefreshToken(r.Context(), oldToken)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout ends the session of the presented access token.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := h.authSvc.Logout(r.Context(), bearerToken(r)); err != nil {
+		h.logger.Error("failed to logout", "error", err)
+		http.Error(w, "Failed to logout", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll ends every session of the calling user.
+func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	revoked, err := h.authSvc.LogoutAll(r.Context())
+	if err != nil {
+		h.logger.Error("failed to logout all sessions", "error", err)
+		http.Error(w, "Failed to logout", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked_sessions": revoked})
+}
+
+// ListSessions lists the calling user's active sessions.
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.authSvc.ListSessions(r.Context(), bearerToken(r))
 	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		h.logger.Error("failed to list sessions", "error", err)
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": newToken})
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession ends one of the calling user's sessions.
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	id, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authSvc.RevokeSession(r.Context(), id); err != nil {
+		h.logger.Error("failed to revoke session", "error", err, "session_id", id)
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -87,7 +325,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.authSvc.Register(r.Context(), input.Email, input.Password, input.Username)
+	tokens, err := h.authSvc.Register(withClient(r), input.Email, input.Password, input.Username)
 	if err != nil {
 		if err == models.ErrEmailAlreadyExists {
 			http.Error(w, "Email already exists", http.StatusConflict)
@@ -99,5 +337,20 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// withClient records the client's user agent and address for the session
+// a login opens.
+func withClient(r *http.Request) context.Context {
+	ipAddress := r.RemoteAddr
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		ipAddress = strings.TrimSpace(strings.Split(xff, ",")[0])
+	}
+	return auth.SetClientInContext(r.Context(), r.UserAgent(), ipAddress)
+}
+
+// bearerToken returns the access token of an authenticated request.
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 }
\ No newline at end of file
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"ledger-link/internal/models"
 	"ledger-link/pkg/auth"
@@ -27,7 +29,22 @@ func NewBalanceHandler(balanceService models.BalanceService, logger *logger.Logg
 	}
 }
 
-// GetCurrentBalance returns the current user's balance
+// currencyFromQuery returns the normalized currency query parameter, or an
+// empty string when the parameter is absent.
+func currencyFromQuery(r *http.Request) (string, error) {
+	code := r.URL.Query().Get("currency")
+	if code == "" {
+		return "", nil
+	}
+	currency, err := models.LookupCurrency(code)
+	if err != nil {
+		return "", err
+	}
+	return currency.Code, nil
+}
+
+// GetCurrentBalance returns the current user's balance in the requested
+// currency, or in the default currency when none is given
 func (h *BalanceHandler) GetCurrentBalance(w http.ResponseWriter, r *http.Request) {
 	user, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
@@ -35,7 +52,13 @@ func (h *BalanceHandler) GetCurrentBalance(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	balance, err := h.balanceService.GetBalance(r.Context(), user.ID)
+	currency, err := currencyFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	balance, err := h.balanceService.GetBalance(r.Context(), user.ID, currency)
 	if err != nil {
 		h.logger.Error("failed to get balance", "error", err, "user_id", user.ID)
 		http.Error(w, "Failed to get balance", http.StatusInternalServerError)
@@ -46,7 +69,41 @@ func (h *BalanceHandler) GetCurrentBalance(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(balance)
 }
 
-// GetBalanceHistory returns the current user's balance history
+// ListBalances returns the current user's balances in every currency, or only
+// the one named by the currency query parameter
+func (h *BalanceHandler) ListBalances(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	currency, err := currencyFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	balances, err := h.balanceService.GetBalances(r.Context(), user.ID)
+	if err != nil {
+		h.logger.Error("failed to get balances", "error", err, "user_id", user.ID)
+		http.Error(w, "Failed to get balances", http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]*models.Balance, 0, len(balances))
+	for i := range balances {
+		if currency == "" || balances[i].Currency == currency {
+			result = append(result, &balances[i])
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetBalanceHistory returns the current user's balance history, optionally
+// limited to one currency
 func (h *BalanceHandler) GetBalanceHistory(w http.ResponseWriter, r *http.Request) {
 	user, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
@@ -65,7 +122,13 @@ func (h *BalanceHandler) GetBalanceHistory(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	history, err := h.balanceService.GetBalanceHistory(r.Context(), user.ID, limit)
+	currency, err := currencyFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.balanceService.GetBalanceHistory(r.Context(), user.ID, currency, limit)
 	if err != nil {
 		h.logger.Error("failed to get balance history", "error", err, "user_id", user.ID)
 		http.Error(w, "Failed to get balance history", http.StatusInternalServerError)
@@ -75,3 +138,88 @@ func (h *BalanceHandler) GetBalanceHistory(w http.ResponseWriter, r *http.Reques
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(history)
 }
+
+// timestampFromQuery parses the required timestamp query parameter. A date
+// stands for the end of that day, so a report for a date closes the day.
+func timestampFromQuery(r *http.Request) (time.Time, error) {
+	value := r.URL.Query().Get("timestamp")
+	if value == "" {
+		return time.Time{}, errors.New("timestamp is required")
+	}
+	timestamp, dateOnly, err := parseHistoryTime(value)
+	if err != nil {
+		return time.Time{}, errors.New("invalid timestamp")
+	}
+	if dateOnly {
+		timestamp = timestamp.AddDate(0, 0, 1)
+	}
+	return timestamp, nil
+}
+
+// GetBalanceAtTime returns the current user's balance as it stood at the
+// timestamp query parameter, in the default currency unless currency is
+// given; staff who may read any account may pass user_id
+func (h *BalanceHandler) GetBalanceAtTime(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID := user.ID
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" && auth.HasPermission(r.Context(), models.PermissionAccountsRead) {
+		id, err := strconv.ParseUint(userIDStr, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = uint(id)
+	}
+
+	timestamp, err := timestampFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	currency, err := currencyFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if currency == "" {
+		currency = models.DefaultCurrency
+	}
+
+	balance, err := h.balanceService.GetBalanceAtTime(r.Context(), userID, currency, timestamp)
+	if err != nil {
+		h.logger.Error("failed to get balance at time", "error", err, "user_id", userID)
+		http.Error(w, "Failed to get balance", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(balance)
+}
+
+// ListBalancesAtTime returns every balance as it stood at the timestamp
+// query parameter, for end-of-day reports
+func (h *BalanceHandler) ListBalancesAtTime(w http.ResponseWriter, r *http.Request) {
+	timestamp, err := timestampFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	balances, err := h.balanceService.GetBalancesAtTime(r.Context(), timestamp)
+	if err != nil {
+		h.logger.Error("failed to get balances at time", "error", err, "timestamp", timestamp)
+		http.Error(w, "Failed to get balances", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"as_of":    timestamp,
+		"balances": balances,
+	})
+}
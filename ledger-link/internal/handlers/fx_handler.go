@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+
+	"github.com/shopspring/decimal"
+)
+
+type FXHandler struct {
+	fxService models.FXService
+	logger    *logger.Logger
+}
+
+func NewFXHandler(fxService models.FXService, logger *logger.Logger) *FXHandler {
+	return &FXHandler{
+		fxService: fxService,
+		logger:    logger,
+	}
+}
+
+type QuoteRequest struct {
+	FromCurrency string          `json:"from_currency"`
+	ToCurrency   string          `json:"to_currency"`
+	Amount       decimal.Decimal `json:"amount"`
+}
+
+type ExecuteQuoteRequest struct {
+	QuoteID  string `json:"quote_id"`
+	ToUserID uint   `json:"to_user_id"`
+	Notes    string `json:"notes"`
+}
+
+// fxErrorStatus extends transactionErrorStatus with the quote lifecycle errors.
+func fxErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrQuoteUsed):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrQuoteExpired):
+		return http.StatusGone
+	case errors.Is(err, models.ErrRateUnavailable):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, models.ErrSameCurrency):
+		return http.StatusBadRequest
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+func (h *FXHandler) HandleCreateQuote(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req QuoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	quote, err := h.fxService.CreateQuote(r.Context(), user.ID, req.FromCurrency, req.ToCurrency, req.Amount)
+	if err != nil {
+		h.logger.Error("failed to create quote", "error", err)
+		http.Error(w, err.Error(), fxErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(quote)
+}
+
+func (h *FXHandler) HandleExecuteQuote(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ExecuteQuoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.QuoteID == "" {
+		http.Error(w, "quote ID is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.fxService.ExecuteQuote(withTOTPCode(r), user.ID, req.QuoteID, req.ToUserID, req.Notes)
+	if err != nil {
+		h.logger.Error("failed to execute quote", "error", err)
+		http.Error(w, err.Error(), fxErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":          "success",
+		"transaction_id":  tx.ID,
+		"source_amount":   tx.Amount,
+		"source_currency": tx.Currency,
+		"target_amount":   tx.ToAmount,
+		"target_currency": tx.ToCurrency,
+		"rate":            tx.FXRate,
+	})
+}
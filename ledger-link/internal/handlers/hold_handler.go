@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/httputil"
+	"ledger-link/pkg/logger"
+
+	"github.com/shopspring/decimal"
+)
+
+type HoldHandler struct {
+	holdService models.HoldService
+	logger      *logger.Logger
+}
+
+func NewHoldHandler(holdService models.HoldService, logger *logger.Logger) *HoldHandler {
+	return &HoldHandler{
+		holdService: holdService,
+		logger:      logger,
+	}
+}
+
+type PlaceHoldRequest struct {
+	Amount           decimal.Decimal `json:"amount"`
+	Currency         string          `json:"currency"`
+	Reference        string          `json:"reference"`
+	Notes            string          `json:"notes"`
+	ExpiresInSeconds int64           `json:"expires_in_seconds"`
+}
+
+// CaptureHoldRequest captures Amount of the hold; an omitted amount captures
+// the full held amount.
+type CaptureHoldRequest struct {
+	Amount decimal.Decimal `json:"amount"`
+	Notes  string          `json:"notes"`
+}
+
+func holdErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrHoldNotActive):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrInsufficientFunds),
+		errors.Is(err, models.ErrCaptureExceedsHold):
+		return http.StatusUnprocessableEntity
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+func holdIDFromPath(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(httputil.GetPathParam(r.Context(), "id"), 10, 64)
+	return uint(id), err
+}
+
+func (h *HoldHandler) HandlePlaceHold(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req PlaceHoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInSeconds < 0 {
+		http.Error(w, "expires_in_seconds cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	ttl := time.Duration(req.ExpiresInSeconds) * time.Second
+	hold, err := h.holdService.Place(r.Context(), user.ID, req.Amount, req.Currency, req.Reference, req.Notes, ttl)
+	if err != nil {
+		h.logger.Error("failed to place hold", "error", err, "user_id", user.ID)
+		http.Error(w, err.Error(), holdErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hold)
+}
+
+// HandleListHolds lists the current user's holds; staff who may read any
+// account may pass user_id.
+// The status query parameter filters by status.
+func (h *HoldHandler) HandleListHolds(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID := user.ID
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" && auth.HasPermission(r.Context(), models.PermissionAccountsRead) {
+		id, err := strconv.ParseUint(userIDStr, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = uint(id)
+	}
+
+	holds, err := h.holdService.List(r.Context(), userID, models.HoldStatus(r.URL.Query().Get("status")))
+	if err != nil {
+		h.logger.Error("failed to list holds", "error", err, "user_id", userID)
+		http.Error(w, "Failed to list holds", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(holds)
+}
+
+func (h *HoldHandler) HandleGetHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := holdIDFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid hold ID", http.StatusBadRequest)
+		return
+	}
+
+	hold, err := h.holdService.Get(r.Context(), holdID)
+	if err != nil {
+		http.Error(w, err.Error(), holdErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hold)
+}
+
+func (h *HoldHandler) HandleCaptureHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := holdIDFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid hold ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CaptureHoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Amount.IsNegative() {
+		http.Error(w, models.ErrInvalidAmount.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.holdService.Capture(withTOTPCode(r), holdID, req.Amount, req.Notes)
+	if err != nil {
+		h.logger.Error("failed to capture hold", "error", err, "hold_id", holdID)
+		http.Error(w, err.Error(), holdErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "success",
+		"hold_id":        holdID,
+		"transaction_id": tx.ID,
+		"amount":         tx.Amount,
+		"currency":       tx.Currency,
+	})
+}
+
+func (h *HoldHandler) HandleVoidHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := holdIDFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid hold ID", http.StatusBadRequest)
+		return
+	}
+
+	hold, err := h.holdService.Void(r.Context(), holdID)
+	if err != nil {
+		h.logger.Error("failed to void hold", "error", err, "hold_id", holdID)
+		http.Error(w, err.Error(), holdErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hold)
+}
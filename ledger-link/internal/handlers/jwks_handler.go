@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+// jwksMaxAge is how long other services may cache the key set; a rotated-in
+// key should be published at least this long before it signs tokens
+const jwksMaxAge = "max-age=300"
+
+type JWKSHandler struct {
+	keys   auth.KeySet
+	logger *logger.Logger
+}
+
+// NewJWKSHandler publishes the verification keys of the token maker. A
+// token maker with a shared secret has none to publish, and the set is
+// empty.
+func NewJWKSHandler(tokenMaker auth.TokenMaker, logger *logger.Logger) *JWKSHandler {
+	keys, _ := tokenMaker.(auth.KeySet)
+	return &JWKSHandler{
+		keys:   keys,
+		logger: logger,
+	}
+}
+
+func (h *JWKSHandler) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	set := auth.JWKSet{Keys: []auth.JWK{}}
+	if h.keys != nil {
+		set = h.keys.JWKS()
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", jwksMaxAge)
+	if err := json.NewEncoder(w).Encode(set); err != nil {
+		h.logger.Error("failed to encode JWKS", "error", err)
+	}
+}
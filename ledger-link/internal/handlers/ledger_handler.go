@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+type LedgerHandler struct {
+	journalSvc models.JournalService
+	logger     *logger.Logger
+}
+
+func NewLedgerHandler(journalSvc models.JournalService, logger *logger.Logger) *LedgerHandler {
+	return &LedgerHandler{
+		journalSvc: journalSvc,
+		logger:     logger,
+	}
+}
+
+// HandleCheckInvariants runs the double-entry invariant check over the whole journal
+func (h *LedgerHandler) HandleCheckInvariants(w http.ResponseWriter, r *http.Request) {
+	report, err := h.journalSvc.CheckInvariants(r.Context())
+	if err != nil {
+		h.logger.Error("failed to check ledger invariants", "error", err)
+		http.Error(w, "Failed to check ledger invariants", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !report.OK() {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+type LimitHandler struct {
+	limitService models.LimitService
+	logger       *logger.Logger
+}
+
+func NewLimitHandler(limitService models.LimitService, logger *logger.Logger) *LimitHandler {
+	return &LimitHandler{
+		limitService: limitService,
+		logger:       logger,
+	}
+}
+
+// SetLimitRequest sets the limit of a role, or overrides it for one user.
+// Caps left out or null do not apply.
+type SetLimitRequest struct {
+	Role            string                 `json:"role"`
+	UserID          uint                   `json:"user_id"`
+	TransactionType models.TransactionType `json:"transaction_type"`
+	Currency        string                 `json:"currency"`
+	PerTransaction  decimal.NullDecimal    `json:"per_transaction"`
+	DailyAmount     decimal.NullDecimal    `json:"daily_amount"`
+	WeeklyAmount    decimal.NullDecimal    `json:"weekly_amount"`
+	MonthlyAmount   decimal.NullDecimal    `json:"monthly_amount"`
+	DailyCount      *int                   `json:"daily_count"`
+	WeeklyCount     *int                   `json:"weekly_count"`
+	MonthlyCount    *int                   `json:"monthly_count"`
+}
+
+// LimitExceededResponse is the body of a transaction rejected by a spending
+// limit.
+type LimitExceededResponse struct {
+	Message string `json:"error"`
+	*models.LimitExceededError
+}
+
+func limitErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrInvalidLimit):
+		return http.StatusBadRequest
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+// writeTransactionError answers a failed transaction. A spending limit
+// rejection carries its code and the remaining allowance as JSON, and a
+// risk decision its reasons.
+func writeTransactionError(w http.ResponseWriter, err error) {
+	var riskErr *models.RiskError
+	if errors.As(err, &riskErr) {
+		writeRiskError(w, riskErr)
+		return
+	}
+	var limitErr *models.LimitExceededError
+	if errors.As(err, &limitErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(LimitExceededResponse{Message: limitErr.Error(), LimitExceededError: limitErr})
+		return
+	}
+	http.Error(w, err.Error(), transactionErrorStatus(err))
+}
+
+func (h *LimitHandler) HandleSetLimit(w http.ResponseWriter, r *http.Request) {
+	var req SetLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := h.limitService.SetLimit(r.Context(), &models.SpendingLimit{
+		Role:            req.Role,
+		UserID:          req.UserID,
+		TransactionType: req.TransactionType,
+		Currency:        req.Currency,
+		PerTransaction:  req.PerTransaction,
+		DailyAmount:     req.DailyAmount,
+		WeeklyAmount:    req.WeeklyAmount,
+		MonthlyAmount:   req.MonthlyAmount,
+		DailyCount:      req.DailyCount,
+		WeeklyCount:     req.WeeklyCount,
+		MonthlyCount:    req.MonthlyCount,
+	})
+	if err != nil {
+		h.logger.Error("failed to set spending limit", "error", err)
+		http.Error(w, err.Error(), limitErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(limit)
+}
+
+// HandleListLimits lists the limits, filtered by the role or user_id query
+// parameters.
+func (h *LimitHandler) HandleListLimits(w http.ResponseWriter, r *http.Request) {
+	var userID uint
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" {
+		id, err := strconv.ParseUint(userIDStr, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = uint(id)
+	}
+
+	limits, err := h.limitService.ListLimits(r.Context(), r.URL.Query().Get("role"), userID)
+	if err != nil {
+		h.logger.Error("failed to list spending limits", "error", err)
+		http.Error(w, "Failed to list spending limits", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(limits)
+}
+
+func (h *LimitHandler) HandleDeleteLimit(w http.ResponseWriter, r *http.Request) {
+	id, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid limit ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.limitService.DeleteLimit(r.Context(), id); err != nil {
+		h.logger.Error("failed to delete spending limit", "error", err, "limit_id", id)
+		http.Error(w, err.Error(), limitErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/httputil"
+	"ledger-link/pkg/logger"
+)
+
+type ReconciliationHandler struct {
+	reconciliationService models.ReconciliationService
+	logger                *logger.Logger
+}
+
+func NewReconciliationHandler(reconciliationService models.ReconciliationService, logger *logger.Logger) *ReconciliationHandler {
+	return &ReconciliationHandler{
+		reconciliationService: reconciliationService,
+		logger:                logger,
+	}
+}
+
+func reconciliationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrDiscrepancyRepaired),
+		errors.Is(err, models.ErrDiscrepancyChanged):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrInvalidInput):
+		return http.StatusBadRequest
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+func idFromPath(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(httputil.GetPathParam(r.Context(), "id"), 10, 64)
+	return uint(id), err
+}
+
+// HandleRun reconciles every balance now and returns the report.
+func (h *ReconciliationHandler) HandleRun(w http.ResponseWriter, r *http.Request) {
+	run, err := h.reconciliationService.Run(r.Context(), models.ReconciliationTriggerAPI)
+	if err != nil {
+		h.logger.Error("failed to run reconciliation", "error", err)
+		http.Error(w, "Failed to run reconciliation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(run)
+}
+
+// HandleListRuns lists the latest runs without their discrepancies.
+func (h *ReconciliationHandler) HandleListRuns(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	runs, err := h.reconciliationService.ListRuns(r.Context(), limit)
+	if err != nil {
+		h.logger.Error("failed to list reconciliation runs", "error", err)
+		http.Error(w, "Failed to list reconciliation runs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+func (h *ReconciliationHandler) HandleGetRun(w http.ResponseWriter, r *http.Request) {
+	runID, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	run, err := h.reconciliationService.GetRun(r.Context(), runID)
+	if err != nil {
+		http.Error(w, err.Error(), reconciliationErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
+
+// HandleRepair approves the repair of one discrepancy by the calling admin.
+func (h *ReconciliationHandler) HandleRepair(w http.ResponseWriter, r *http.Request) {
+	discrepancyID, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid discrepancy ID", http.StatusBadRequest)
+		return
+	}
+
+	discrepancy, err := h.reconciliationService.Repair(r.Context(), discrepancyID)
+	if err != nil {
+		h.logger.Error("failed to repair discrepancy", "error", err, "discrepancy_id", discrepancyID)
+		http.Error(w, err.Error(), reconciliationErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(discrepancy)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/httputil"
+	"ledger-link/pkg/logger"
+
+	"github.com/shopspring/decimal"
+)
+
+type ReversalHandler struct {
+	reversalService models.ReversalService
+	logger          *logger.Logger
+}
+
+func NewReversalHandler(reversalService models.ReversalService, logger *logger.Logger) *ReversalHandler {
+	return &ReversalHandler{
+		reversalService: reversalService,
+		logger:          logger,
+	}
+}
+
+// ReversalRequest refunds Amount of the transaction; an omitted amount
+// refunds everything that is left.
+type ReversalRequest struct {
+	Amount decimal.Decimal `json:"amount"`
+	Notes  string          `json:"notes"`
+}
+
+func reversalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrForbidden),
+		errors.Is(err, models.ErrReversalWindowClosed):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrNotReversible):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrRefundExceedsOriginal):
+		return http.StatusUnprocessableEntity
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+func (h *ReversalHandler) HandleReverse(w http.ResponseWriter, r *http.Request) {
+	transID, err := strconv.ParseUint(httputil.GetPathParam(r.Context(), "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ReversalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Amount.IsNegative() {
+		http.Error(w, models.ErrInvalidAmount.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reversal, err := h.reversalService.Reverse(r.Context(), uint(transID), req.Amount, req.Notes)
+	if err != nil {
+		h.logger.Error("failed to reverse transaction", "error", err, "transaction_id", transID)
+		http.Error(w, err.Error(), reversalErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "success",
+		"transaction_id": reversal.ID,
+		"reversal_of":    transID,
+		"amount":         reversal.Amount,
+		"currency":       reversal.Currency,
+	})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+type RiskHandler struct {
+	riskService        models.RiskService
+	transactionService models.TransactionService
+	logger             *logger.Logger
+}
+
+func NewRiskHandler(riskService models.RiskService, transactionService models.TransactionService, logger *logger.Logger) *RiskHandler {
+	return &RiskHandler{
+		riskService:        riskService,
+		transactionService: transactionService,
+		logger:             logger,
+	}
+}
+
+// ReviewRequest is the reviewer's optional note on an approval or rejection.
+type ReviewRequest struct {
+	Note string `json:"note"`
+}
+
+// RiskErrorResponse is the body of a debit or transfer the risk rules
+// denied or held for review.
+type RiskErrorResponse struct {
+	Message string `json:"error"`
+	*models.RiskError
+}
+
+func riskErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrReviewResolved),
+		errors.Is(err, models.ErrInvalidStatus):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrInvalidInput):
+		return http.StatusBadRequest
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+// writeRiskError answers a transaction stopped by the risk rules: 202 for
+// one held for review and 422 for one denied.
+func writeRiskError(w http.ResponseWriter, riskErr *models.RiskError) {
+	status := http.StatusUnprocessableEntity
+	if riskErr.Decision == models.DecisionReview {
+		status = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(RiskErrorResponse{Message: riskErr.Error(), RiskError: riskErr})
+}
+
+// HandleListReviews lists the reviews with the status query parameter,
+// pending by default.
+func (h *RiskHandler) HandleListReviews(w http.ResponseWriter, r *http.Request) {
+	status := models.RiskReviewStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = models.RiskReviewPending
+	}
+
+	reviews, err := h.riskService.ListReviews(r.Context(), status)
+	if err != nil {
+		h.logger.Error("failed to list risk reviews", "error", err)
+		http.Error(w, "Failed to list risk reviews", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reviews)
+}
+
+// HandleApproveReview executes a held transaction on the calling admin's
+// approval.
+func (h *RiskHandler) HandleApproveReview(w http.ResponseWriter, r *http.Request) {
+	h.resolve(w, r, h.transactionService.ApproveReview)
+}
+
+// HandleRejectReview fails a held transaction on the calling admin's
+// rejection.
+func (h *RiskHandler) HandleRejectReview(w http.ResponseWriter, r *http.Request) {
+	h.resolve(w, r, h.transactionService.RejectReview)
+}
+
+func (h *RiskHandler) resolve(w http.ResponseWriter, r *http.Request, resolve func(ctx context.Context, reviewID uint, note string) (*models.Transaction, error)) {
+	reviewID, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid review ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := resolve(r.Context(), reviewID, req.Note)
+	if err != nil && tx == nil {
+		h.logger.Error("failed to resolve risk review", "error", err, "review_id", reviewID)
+		http.Error(w, err.Error(), riskErrorStatus(err))
+		return
+	}
+	if err != nil {
+		// Approved, but the transaction failed when it ran
+		h.logger.Error("approved transaction failed", "error", err, "review_id", reviewID, "tx_id", tx.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tx)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/httputil"
+	"ledger-link/pkg/logger"
+)
+
+type RoleHandler struct {
+	roleService models.RoleService
+	logger      *logger.Logger
+}
+
+func NewRoleHandler(roleService models.RoleService, logger *logger.Logger) *RoleHandler {
+	return &RoleHandler{
+		roleService: roleService,
+		logger:      logger,
+	}
+}
+
+// SetPermissionsRequest replaces every permission of a role.
+type SetPermissionsRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+type AssignRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func roleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrInvalidRole),
+		errors.Is(err, models.ErrInvalidPermission):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// HandleListRoles lists every role with the permissions it holds.
+func (h *RoleHandler) HandleListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.roleService.ListRoles(r.Context())
+	if err != nil {
+		h.logger.Error("failed to list roles", "error", err)
+		http.Error(w, "Failed to list roles", roleErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+func (h *RoleHandler) HandleSetPermissions(w http.ResponseWriter, r *http.Request) {
+	var req SetPermissionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := httputil.GetPathParam(r.Context(), "role")
+	role, err := h.roleService.SetPermissions(r.Context(), name, req.Permissions)
+	if err != nil {
+		h.logger.Error("failed to set role permissions", "error", err, "role", name)
+		http.Error(w, err.Error(), roleErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+func (h *RoleHandler) HandleAssignRole(w http.ResponseWriter, r *http.Request) {
+	id, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req AssignRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.roleService.AssignRole(r.Context(), id, req.Role)
+	if err != nil {
+		h.logger.Error("failed to assign role", "error", err, "user_id", id, "role", req.Role)
+		http.Error(w, err.Error(), roleErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user.SafeCopy())
+}
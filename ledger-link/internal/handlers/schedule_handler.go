@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/httputil"
+	"ledger-link/pkg/logger"
+
+	"github.com/shopspring/decimal"
+)
+
+type ScheduleHandler struct {
+	scheduleService models.ScheduleService
+	logger          *logger.Logger
+}
+
+func NewScheduleHandler(scheduleService models.ScheduleService, logger *logger.Logger) *ScheduleHandler {
+	return &ScheduleHandler{
+		scheduleService: scheduleService,
+		logger:          logger,
+	}
+}
+
+// CreateScheduleRequest describes a standing order. Setting cron makes it a
+// cron schedule and interval_seconds an interval schedule; with neither it
+// is a one-off transfer at start_at.
+type CreateScheduleRequest struct {
+	ToUserID        uint                 `json:"to_user_id"`
+	Amount          decimal.Decimal      `json:"amount"`
+	Currency        string               `json:"currency"`
+	Notes           string               `json:"notes"`
+	StartAt         *time.Time           `json:"start_at"`
+	IntervalSeconds int64                `json:"interval_seconds"`
+	Cron            string               `json:"cron"`
+	EndAt           *time.Time           `json:"end_at"`
+	FailurePolicy   models.FailurePolicy `json:"failure_policy"`
+	MaxRetries      int                  `json:"max_retries"`
+}
+
+func scheduleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrScheduleNotActive):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrInvalidSchedule):
+		return http.StatusBadRequest
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+func scheduleIDFromPath(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(httputil.GetPathParam(r.Context(), "id"), 10, 64)
+	return uint(id), err
+}
+
+func (h *ScheduleHandler) HandleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var req CreateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	schedule := &models.Schedule{
+		ToUserID:        req.ToUserID,
+		Amount:          req.Amount,
+		Currency:        req.Currency,
+		Notes:           req.Notes,
+		Kind:            models.ScheduleKindOnce,
+		IntervalSeconds: req.IntervalSeconds,
+		CronExpr:        req.Cron,
+		EndAt:           req.EndAt,
+		FailurePolicy:   req.FailurePolicy,
+		MaxRetries:      req.MaxRetries,
+	}
+	switch {
+	case req.Cron != "":
+		schedule.Kind = models.ScheduleKindCron
+	case req.IntervalSeconds != 0:
+		schedule.Kind = models.ScheduleKindInterval
+	}
+	if req.StartAt != nil {
+		schedule.StartAt = *req.StartAt
+	}
+
+	if err := h.scheduleService.Create(withTOTPCode(r), schedule); err != nil {
+		h.logger.Error("failed to create schedule", "error", err)
+		http.Error(w, err.Error(), scheduleErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// HandleListSchedules lists the current user's schedules; staff who may read
+// any account may pass user_id. The status query parameter filters by status.
+func (h *ScheduleHandler) HandleListSchedules(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID := user.ID
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" && auth.HasPermission(r.Context(), models.PermissionAccountsRead) {
+		id, err := strconv.ParseUint(userIDStr, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = uint(id)
+	}
+
+	schedules, err := h.scheduleService.List(r.Context(), userID, models.ScheduleStatus(r.URL.Query().Get("status")))
+	if err != nil {
+		h.logger.Error("failed to list schedules", "error", err, "user_id", userID)
+		http.Error(w, "Failed to list schedules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+func (h *ScheduleHandler) HandleGetSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := scheduleIDFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := h.scheduleService.Get(r.Context(), scheduleID)
+	if err != nil {
+		http.Error(w, err.Error(), scheduleErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// HandleListRuns lists the schedule's recent runs, including failed attempts
+// and what the failure policy did with them.
+func (h *ScheduleHandler) HandleListRuns(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := scheduleIDFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	runs, err := h.scheduleService.ListRuns(r.Context(), scheduleID)
+	if err != nil {
+		http.Error(w, err.Error(), scheduleErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+func (h *ScheduleHandler) HandleCancelSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := scheduleIDFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := h.scheduleService.Cancel(r.Context(), scheduleID)
+	if err != nil {
+		h.logger.Error("failed to cancel schedule", "error", err, "schedule_id", scheduleID)
+		http.Error(w, err.Error(), scheduleErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+// statementBufferSize is how much of a statement is buffered before it is
+// sent. Statements that fail before the buffer first fills can still be
+// answered with an error status.
+const statementBufferSize = 32 * 1024
+
+type StatementHandler struct {
+	statementService models.StatementService
+	logger           *logger.Logger
+}
+
+func NewStatementHandler(statementService models.StatementService, logger *logger.Logger) *StatementHandler {
+	return &StatementHandler{
+		statementService: statementService,
+		logger:           logger,
+	}
+}
+
+func statementErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrInvalidInput):
+		return http.StatusBadRequest
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+// committedWriter records whether anything has reached the client yet.
+type committedWriter struct {
+	http.ResponseWriter
+	committed bool
+}
+
+func (c *committedWriter) Write(p []byte) (int, error) {
+	c.committed = true
+	return c.ResponseWriter.Write(p)
+}
+
+// HandleGetStatement streams the statement of the current user's balance in
+// currency (default USD) over from..to; staff who may read any statement may
+// pass user_id. Dates in to include that whole day.
+func (h *StatementHandler) HandleGetStatement(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	userID := user.ID
+	if userIDStr := query.Get("user_id"); userIDStr != "" && auth.HasPermission(r.Context(), models.PermissionStatementsRead) {
+		id, err := strconv.ParseUint(userIDStr, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = uint(id)
+	}
+
+	if query.Get("from") == "" || query.Get("to") == "" {
+		http.Error(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+	from, _, err := parseHistoryTime(query.Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	to, dateOnly, err := parseHistoryTime(query.Get("to"))
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	if dateOnly {
+		to = to.AddDate(0, 0, 1)
+	}
+
+	currency := query.Get("currency")
+	if currency == "" {
+		currency = models.DefaultCurrency
+	}
+
+	out := &committedWriter{ResponseWriter: w}
+	buf := bufio.NewWriterSize(out, statementBufferSize)
+	writer, contentType, ext, err := newStatementWriter(query.Get("format"), buf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%d-%s-%s.%s"`,
+		userID, models.NormalizeCurrency(currency), from.Format("20060102"), ext))
+
+	if err := h.statementService.Generate(r.Context(), userID, currency, from, to, writer); err != nil {
+		h.logger.Error("failed to generate statement", "error", err, "user_id", userID)
+		if !out.committed {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), statementErrorStatus(err))
+		}
+		// Otherwise the statement ends without its closing balance
+		buf.Flush()
+		return
+	}
+
+	if err := buf.Flush(); err != nil {
+		h.logger.Error("failed to send statement", "error", err, "user_id", userID)
+	}
+}
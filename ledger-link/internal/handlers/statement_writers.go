@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"ledger-link/internal/models"
+)
+
+// newStatementWriter returns the writer for format along with the content
+// type and file extension of its output.
+func newStatementWriter(format string, out io.Writer) (models.StatementWriter, string, string, error) {
+	switch format {
+	case "", "csv":
+		return &csvStatementWriter{w: csv.NewWriter(out)}, "text/csv", "csv", nil
+	case "json":
+		return &jsonStatementWriter{w: out}, "application/json", "json", nil
+	case "ofx":
+		return &ofxStatementWriter{w: out}, "application/x-ofx", "ofx", nil
+	default:
+		return nil, "", "", fmt.Errorf("unsupported statement format %q", format)
+	}
+}
+
+// csvStatementWriter writes one row per entry between an opening and a
+// closing balance row.
+type csvStatementWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvStatementWriter) Begin(s *models.Statement) error {
+	c.w.Write([]string{"posted_at", "transaction_id", "type", "counterparty_id", "description", "amount", "balance"})
+	return c.w.Write([]string{s.From.Format(time.RFC3339), "", "", "", "Opening balance", "", s.OpeningBalance.String()})
+}
+
+func (c *csvStatementWriter) Entry(e *models.StatementEntry) error {
+	var transactionID, counterpartyID string
+	if e.TransactionID != nil {
+		transactionID = strconv.FormatUint(uint64(*e.TransactionID), 10)
+	}
+	if e.CounterpartyID != 0 {
+		counterpartyID = strconv.FormatUint(uint64(e.CounterpartyID), 10)
+	}
+	return c.w.Write([]string{
+		e.PostedAt.Format(time.RFC3339Nano),
+		transactionID,
+		string(e.Type),
+		counterpartyID,
+		e.Description,
+		e.Amount.String(),
+		e.Balance.String(),
+	})
+}
+
+func (c *csvStatementWriter) End(s *models.Statement) error {
+	c.w.Write([]string{s.To.Format(time.RFC3339), "", "", "", "Closing balance", "", s.ClosingBalance.String()})
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonStatementWriter writes the statement as one JSON object whose entries
+// array is written element by element.
+type jsonStatementWriter struct {
+	w       io.Writer
+	entries int
+}
+
+func (j *jsonStatementWriter) Begin(s *models.Statement) error {
+	_, err := fmt.Fprintf(j.w, `{"user_id":%d,"currency":%q,"from":%q,"to":%q,"opening_balance":%q,"entries":[`,
+		s.UserID, s.Currency, s.From.Format(time.RFC3339Nano), s.To.Format(time.RFC3339Nano), s.OpeningBalance.String())
+	return err
+}
+
+func (j *jsonStatementWriter) Entry(e *models.StatementEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if j.entries > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.entries++
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonStatementWriter) End(s *models.Statement) error {
+	_, err := fmt.Fprintf(j.w, `],"closing_balance":%q}`+"\n", s.ClosingBalance.String())
+	return err
+}
+
+// ofxStatementWriter writes an OFX 2.2 bank statement. OFX has no opening
+// balance; the closing balance is reported as the ledger balance at To.
+type ofxStatementWriter struct {
+	w io.Writer
+}
+
+const ofxTime = "20060102150405"
+
+func (o *ofxStatementWriter) Begin(s *models.Statement) error {
+	_, err := fmt.Fprintf(o.w, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<BANKMSGSRSV1>
+<STMTTRNRS>
+<TRNUID>0</TRNUID>
+<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS>
+<CURDEF>%s</CURDEF>
+<BANKACCTFROM><BANKID>LEDGERLINK</BANKID><ACCTID>%d</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>%s</DTSTART>
+<DTEND>%s</DTEND>
+`, s.Currency, s.UserID, s.From.UTC().Format(ofxTime), s.To.UTC().Format(ofxTime))
+	return err
+}
+
+func (o *ofxStatementWriter) Entry(e *models.StatementEntry) error {
+	trnType := "CREDIT"
+	if e.Amount.IsNegative() {
+		trnType = "DEBIT"
+	}
+	fitID := fmt.Sprintf("H%d", e.PostedAt.UnixNano())
+	if e.TransactionID != nil {
+		fitID = fmt.Sprintf("T%d", *e.TransactionID)
+	}
+
+	if _, err := fmt.Fprintf(o.w, "<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%s</FITID><NAME>",
+		trnType, e.PostedAt.UTC().Format(ofxTime), e.Amount.String(), fitID); err != nil {
+		return err
+	}
+	if err := xml.EscapeText(o.w, []byte(truncate(e.Description, 32))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(o.w, "</NAME><MEMO>"); err != nil {
+		return err
+	}
+	if err := xml.EscapeText(o.w, []byte(e.Description)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(o.w, "</MEMO></STMTTRN>\n")
+	return err
+}
+
+func (o *ofxStatementWriter) End(s *models.Statement) error {
+	_, err := fmt.Fprintf(o.w, `</BANKTRANLIST>
+<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+</STMTRS>
+</STMTTRNRS>
+</BANKMSGSRSV1>
+</OFX>
+`, s.ClosingBalance.String(), s.To.UTC().Format(ofxTime))
+	return err
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
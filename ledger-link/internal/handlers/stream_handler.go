@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+const DefaultStreamHeartbeat = 15 * time.Second
+
+type StreamHandler struct {
+	broker    models.UpdateBroker
+	logger    *logger.Logger
+	heartbeat time.Duration
+}
+
+func NewStreamHandler(broker models.UpdateBroker, logger *logger.Logger, heartbeat time.Duration) *StreamHandler {
+	if heartbeat <= 0 {
+		heartbeat = DefaultStreamHeartbeat
+	}
+	return &StreamHandler{
+		broker:    broker,
+		logger:    logger,
+		heartbeat: heartbeat,
+	}
+}
+
+// HandleStream streams the current user's balance and transaction updates as
+// Server-Sent Events; staff who may read any account may pass user_id to
+// watch any user. A client reconnecting with Last-Event-ID first receives
+// what it missed, as far as the replay buffer reaches. Idle streams carry a
+// heartbeat comment.
+func (h *StreamHandler) HandleStream(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID := user.ID
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" {
+		id, err := strconv.ParseUint(userIDStr, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if uint(id) != user.ID && !auth.HasPermission(r.Context(), models.PermissionAccountsRead) {
+			http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		userID = uint(id)
+	}
+
+	updates, err := h.broker.Subscribe(r.Context(), userID, r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.logger.Error("failed to subscribe to updates", "error", err, "user_id", userID)
+		http.Error(w, "Failed to subscribe to updates", http.StatusInternalServerError)
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Error("failed to clear stream write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		h.logger.Error("streaming is not supported", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", update.ID, update.Type, update.Data)
+			heartbeat.Reset(h.heartbeat)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
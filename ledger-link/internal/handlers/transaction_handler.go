@@ -2,8 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"ledger-link/internal/models"
 	"ledger-link/pkg/auth"
@@ -13,12 +18,17 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// TransactionHandler serves the money movement endpoints. Debits and
+// transfers pass the X-TOTP-Code header on for the service to step up.
 type TransactionHandler struct {
 	transactionService models.TransactionService
 	logger             *logger.Logger
 }
 
-func NewTransactionHandler(transactionService models.TransactionService, logger *logger.Logger) *TransactionHandler {
+func NewTransactionHandler(
+	transactionService models.TransactionService,
+	logger *logger.Logger,
+) *TransactionHandler {
 	return &TransactionHandler{
 		transactionService: transactionService,
 		logger:             logger,
@@ -26,16 +36,37 @@ func NewTransactionHandler(transactionService models.TransactionService, logger
 }
 
 type TransactionRequest struct {
-	Amount decimal.Decimal `json:"amount"`
-	Notes  string          `json:"notes"`
+	Amount   decimal.Decimal `json:"amount"`
+	Currency string          `json:"currency"`
+	Notes    string          `json:"notes"`
 }
 
 type TransferRequest struct {
 	ToUserID uint            `json:"to_user_id"`
 	Amount   decimal.Decimal `json:"amount"`
+	Currency string          `json:"currency"`
 	Notes    string          `json:"notes"`
 }
 
+// transactionErrorStatus maps request validation errors to 400, a missing
+// or wrong step-up code to 403 and locked codes to 429, and anything else to
+// 500.
+func transactionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrInvalidAmount),
+		errors.Is(err, models.ErrUnsupportedCurrency),
+		errors.Is(err, models.ErrInvalidPrecision):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrTwoFactorRequired),
+		errors.Is(err, models.ErrInvalidTwoFactorCode):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrTwoFactorLocked):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (h *TransactionHandler) HandleCredit(w http.ResponseWriter, r *http.Request) {
 	user, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
@@ -49,9 +80,9 @@ func (h *TransactionHandler) HandleCredit(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if err := h.transactionService.Credit(r.Context(), user.ID, req.Amount, req.Notes); err != nil {
+	if err := h.transactionService.Credit(r.Context(), user.ID, req.Amount, req.Currency, req.Notes); err != nil {
 		h.logger.Error("failed to process credit", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), transactionErrorStatus(err))
 		return
 	}
 
@@ -72,9 +103,9 @@ func (h *TransactionHandler) HandleDebit(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := h.transactionService.Debit(r.Context(), user.ID, req.Amount, req.Notes); err != nil {
+	if err := h.transactionService.Debit(withTOTPCode(r), user.ID, req.Amount, req.Currency, req.Notes); err != nil {
 		h.logger.Error("failed to process debit", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeTransactionError(w, err)
 		return
 	}
 
@@ -95,9 +126,9 @@ func (h *TransactionHandler) HandleTransfer(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if err := h.transactionService.Transfer(r.Context(), user.ID, req.ToUserID, req.Amount, req.Notes); err != nil {
+	if err := h.transactionService.Transfer(withTOTPCode(r), user.ID, req.ToUserID, req.Amount, req.Currency, req.Notes); err != nil {
 		h.logger.Error("failed to process transfer", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeTransactionError(w, err)
 		return
 	}
 
@@ -113,7 +144,7 @@ func (h *TransactionHandler) HandleGetTransactionHistory(w http.ResponseWriter,
 	}
 
 	var userID uint
-	if user.Role == models.RoleAdmin {
+	if auth.HasPermission(r.Context(), models.PermissionAccountsRead) {
 		userIDStr := r.URL.Query().Get("user_id")
 		if userIDStr != "" {
 			id, err := strconv.ParseUint(userIDStr, 10, 32)
@@ -132,24 +163,124 @@ func (h *TransactionHandler) HandleGetTransactionHistory(w http.ResponseWriter,
 		return
 	}
 
-	transactions, err := h.transactionService.GetUserTransactions(r.Context(), userID)
+	filter, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.UserID = userID
+
+	page, err := h.transactionService.GetTransactionHistory(r.Context(), filter)
 	if err != nil {
 		h.logger.Error("failed to get transaction history", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), historyErrorStatus(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(transactions)
+	json.NewEncoder(w).Encode(page)
 }
 
-func (h *TransactionHandler) HandleGetTransaction(w http.ResponseWriter, r *http.Request) {
-	user, ok := auth.GetUserFromContext(r.Context())
-	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
+func historyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrInvalidInput),
+		errors.Is(err, models.ErrInvalidCursor),
+		errors.Is(err, models.ErrInvalidType),
+		errors.Is(err, models.ErrInvalidStatus):
+		return http.StatusBadRequest
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+// parseHistoryFilter reads the history query parameters: type and status
+// take comma separated lists, from and to take RFC 3339 times or dates (a
+// date in to includes that whole day), and cursor is the next_cursor of the
+// previous page.
+func parseHistoryFilter(query url.Values) (models.TransactionFilter, error) {
+	filter := models.TransactionFilter{
+		Sort:  models.SortOrder(query.Get("sort")),
+		Notes: query.Get("notes"),
+	}
+
+	for _, t := range splitList(query.Get("type")) {
+		filter.Types = append(filter.Types, models.TransactionType(t))
+	}
+	for _, s := range splitList(query.Get("status")) {
+		filter.Statuses = append(filter.Statuses, models.TransactionStatus(s))
+	}
+
+	if v := query.Get("from"); v != "" {
+		from, _, err := parseHistoryTime(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from: %w", err)
+		}
+		filter.From = &from
+	}
+	if v := query.Get("to"); v != "" {
+		to, dateOnly, err := parseHistoryTime(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to: %w", err)
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = &to
 	}
 
+	for name, target := range map[string]*decimal.NullDecimal{"min_amount": &filter.MinAmount, "max_amount": &filter.MaxAmount} {
+		if v := query.Get(name); v != "" {
+			amount, err := decimal.NewFromString(v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s", name)
+			}
+			*target = decimal.NewNullDecimal(amount)
+		}
+	}
+
+	if v := query.Get("counterparty_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return filter, errors.New("invalid counterparty_id")
+		}
+		filter.CounterpartyID = uint(id)
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return filter, errors.New("invalid limit")
+		}
+		filter.Limit = limit
+	}
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := models.DecodeHistoryCursor(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.Cursor = cursor
+	}
+	return filter, nil
+}
+
+func parseHistoryTime(v string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse(time.DateOnly, v); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, v)
+	return t, false, err
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (h *TransactionHandler) HandleGetTransaction(w http.ResponseWriter, r *http.Request) {
 	transIDStr := httputil.GetPathParam(r.Context(), "id")
 	if transIDStr == "" {
 		http.Error(w, "transaction ID is required", http.StatusBadRequest)
@@ -164,21 +295,15 @@ func (h *TransactionHandler) HandleGetTransaction(w http.ResponseWriter, r *http
 
 	transaction, err := h.transactionService.GetTransaction(r.Context(), uint(transID))
 	if err != nil {
-		if err == models.ErrNotFound {
+		switch {
+		case errors.Is(err, models.ErrUnauthorized):
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case errors.Is(err, models.ErrNotFound):
 			http.Error(w, "transaction not found", http.StatusNotFound)
-			return
+		default:
+			h.logger.Error("failed to get transaction", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-		h.logger.Error("failed to get transaction", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if user.Role != models.RoleAdmin && transaction.FromUserID != user.ID && transaction.ToUserID != user.ID {
-		h.logger.Error("unauthorized access to transaction",
-			"user_id", user.ID,
-			"transaction_from", transaction.FromUserID,
-			"transaction_to", transaction.ToUserID)
-		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
 
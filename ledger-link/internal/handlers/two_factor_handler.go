@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+// TOTPCodeHeader carries the fresh TOTP code that steps up a sensitive
+// request of a user with two-factor authentication on.
+const TOTPCodeHeader = "X-TOTP-Code"
+
+// withTOTPCode passes the request's step-up code on to the services that
+// move money, which decide whether the amount needs it.
+func withTOTPCode(r *http.Request) context.Context {
+	return auth.SetTOTPCodeInContext(r.Context(), r.Header.Get(TOTPCodeHeader))
+}
+
+type TwoFactorHandler struct {
+	twoFactorService models.TwoFactorService
+	logger           *logger.Logger
+}
+
+func NewTwoFactorHandler(twoFactorService models.TwoFactorService, logger *logger.Logger) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactorService: twoFactorService,
+		logger:           logger,
+	}
+}
+
+// TwoFactorCodeRequest carries a TOTP code, or where accepted, a recovery
+// code.
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodesResponse carries recovery codes, which are shown only once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func twoFactorErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrTwoFactorRequired),
+		errors.Is(err, models.ErrInvalidTwoFactorCode):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrTwoFactorLocked):
+		return http.StatusTooManyRequests
+	case errors.Is(err, models.ErrTwoFactorEnabled),
+		errors.Is(err, models.ErrTwoFactorNotEnabled),
+		errors.Is(err, models.ErrTwoFactorNotEnrolled):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *TwoFactorHandler) HandleEnroll(w http.ResponseWriter, r *http.Request) {
+	enrollment, err := h.twoFactorService.Enroll(r.Context())
+	if err != nil {
+		h.logger.Error("failed to enroll two-factor authentication", "error", err)
+		http.Error(w, err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func (h *TwoFactorHandler) HandleConfirm(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.twoFactorService.Confirm(r.Context(), req.Code)
+	if err != nil {
+		h.logger.Error("failed to confirm two-factor authentication", "error", err)
+		http.Error(w, err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *TwoFactorHandler) HandleDisable(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.twoFactorService.Disable(r.Context(), req.Code); err != nil {
+		h.logger.Error("failed to disable two-factor authentication", "error", err)
+		http.Error(w, err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TwoFactorHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(r.Context(), req.Code)
+	if err != nil {
+		h.logger.Error("failed to regenerate recovery codes", "error", err)
+		http.Error(w, err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *TwoFactorHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.twoFactorService.Status(r.Context())
+	if err != nil {
+		h.logger.Error("failed to get two-factor status", "error", err)
+		http.Error(w, err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
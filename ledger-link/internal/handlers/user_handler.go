@@ -45,8 +45,8 @@ func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
 	var users []*models.User
 	var err error
 
-	if user.Role == models.RoleAdmin {
-		// Admin gets all users
+	if auth.HasPermission(r.Context(), models.PermissionAccountsRead) {
+		// Admins and support get all users
 		users, err = h.userSvc.GetUsers(r.Context())
 	} else {
 		// Regular user only gets their own data
@@ -149,3 +149,49 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// ListLockouts lists the email addresses currently locked out after failed
+// logins.
+func (h *UserHandler) ListLockouts(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := h.userSvc.ListLockouts(r.Context())
+	if err != nil {
+		if err == models.ErrUnauthorized {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userErrors.WithLabelValues("list_lockouts", "internal_error").Inc()
+		h.logger.Error("failed to list lockouts", "error", err)
+		http.Error(w, "Failed to list lockouts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lockouts)
+}
+
+// Unlock lifts a lockout and clears its failed logins.
+func (h *UserHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	id, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid lockout ID", http.StatusBadRequest)
+		return
+	}
+
+	lockout, err := h.userSvc.Unlock(r.Context(), id)
+	if err != nil {
+		switch err {
+		case models.ErrUnauthorized:
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		case models.ErrNotFound:
+			http.Error(w, "Lockout not found", http.StatusNotFound)
+		default:
+			userErrors.WithLabelValues("unlock", "internal_error").Inc()
+			h.logger.Error("failed to unlock", "error", err, "lockout_id", id)
+			http.Error(w, "Failed to unlock", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lockout)
+}
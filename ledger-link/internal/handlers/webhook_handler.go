@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/httputil"
+	"ledger-link/pkg/logger"
+)
+
+type WebhookHandler struct {
+	webhookService models.WebhookService
+	logger         *logger.Logger
+}
+
+func NewWebhookHandler(webhookService models.WebhookService, logger *logger.Logger) *WebhookHandler {
+	return &WebhookHandler{
+		webhookService: webhookService,
+		logger:         logger,
+	}
+}
+
+// CreateWebhookRequest subscribes url to events, or to every event when
+// events is empty. Admins may set global to receive every user's events.
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Global bool     `json:"global"`
+}
+
+// CreateWebhookResponse is the only response that includes the secret
+// deliveries are signed with.
+type CreateWebhookResponse struct {
+	*models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+func webhookErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrInvalidWebhook):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrRedeliveryTooSoon):
+		return http.StatusTooManyRequests
+	default:
+		return transactionErrorStatus(err)
+	}
+}
+
+func (h *WebhookHandler) HandleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	subscription, err := h.webhookService.CreateSubscription(r.Context(), req.URL, req.Events, req.Global)
+	if err != nil {
+		h.logger.Error("failed to create webhook subscription", "error", err)
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateWebhookResponse{
+		WebhookSubscription: subscription,
+		Secret:              subscription.Secret,
+	})
+}
+
+// HandleListSubscriptions lists the current user's subscriptions; admins
+// also see the global ones.
+func (h *WebhookHandler) HandleListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := h.webhookService.ListSubscriptions(r.Context())
+	if err != nil {
+		h.logger.Error("failed to list webhook subscriptions", "error", err)
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscriptions)
+}
+
+func (h *WebhookHandler) HandleGetSubscription(w http.ResponseWriter, r *http.Request) {
+	id, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	subscription, err := h.webhookService.GetSubscription(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscription)
+}
+
+func (h *WebhookHandler) HandleDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	id, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.webhookService.DeleteSubscription(r.Context(), id); err != nil {
+		h.logger.Error("failed to delete webhook subscription", "error", err, "webhook_id", id)
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListDeliveries lists the subscription's recent deliveries; the
+// status query parameter filters them, e.g. status=dead for the dead
+// letters.
+func (h *WebhookHandler) HandleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	status := models.WebhookDeliveryStatus(r.URL.Query().Get("status"))
+	deliveries, err := h.webhookService.ListDeliveries(r.Context(), id, status)
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func (h *WebhookHandler) HandleRedeliver(w http.ResponseWriter, r *http.Request) {
+	id, err := idFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	deliveryID, err := strconv.ParseUint(httputil.GetPathParam(r.Context(), "delivery_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := h.webhookService.Redeliver(r.Context(), id, uint(deliveryID))
+	if err != nil {
+		h.logger.Error("failed to redeliver webhook", "error", err, "delivery_id", deliveryID)
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
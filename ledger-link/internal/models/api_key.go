@@ -0,0 +1,167 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Scopes an API key can be granted. A key acts as its user but only on the
+// routes its scopes cover.
+const (
+	ScopeTransactionsRead  = "transactions:read"
+	ScopeTransactionsWrite = "transactions:write"
+	ScopeBalancesRead      = "balances:read"
+	ScopeUsersRead         = "users:read"
+)
+
+// APIKeyPrefix starts every API key, so keys are recognisable in headers
+// and secret scanners.
+const APIKeyPrefix = "llk_"
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKeyScopes are the scopes a key may be granted.
+var APIKeyScopes = []string{ScopeTransactionsRead, ScopeTransactionsWrite, ScopeBalancesRead, ScopeUsersRead}
+
+// APIKey is a credential a machine client uses to act as UserID. The key is
+// "llk_<id>_<secret>": Prefix ("llk_<id>") identifies it in listings and
+// logs, and only the SHA-256 of the whole key is stored. An empty
+// AllowedIPs admits any address; otherwise the client's address must match
+// one of its IPs or CIDR ranges.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `gorm:"type:varchar(100);not null" json:"name"`
+	Prefix     string     `gorm:"type:varchar(20);not null;uniqueIndex" json:"prefix"`
+	KeyHash    string     `gorm:"type:char(64);not null" json:"-"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Scopes     []string   `gorm:"serializer:json;type:text" json:"scopes"`
+	AllowedIPs []string   `gorm:"serializer:json;type:text" json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `gorm:"type:varchar(45)" json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy  uint       `gorm:"not null" json:"created_by"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+func (k *APIKey) TableName() string {
+	return "api_keys"
+}
+
+func (k *APIKey) Validate() error {
+	if strings.TrimSpace(k.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidAPIKey)
+	}
+	if k.UserID == 0 {
+		return fmt.Errorf("%w: user is required", ErrInvalidAPIKey)
+	}
+	if len(k.Scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKey)
+	}
+	for _, scope := range k.Scopes {
+		if !containsString(APIKeyScopes, scope) {
+			return fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKey, scope)
+		}
+	}
+	for _, allowed := range k.AllowedIPs {
+		if _, err := parseIPRange(allowed); err != nil {
+			return fmt.Errorf("%w: invalid allowed IP %q", ErrInvalidAPIKey, allowed)
+		}
+	}
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: expiry must be in the future", ErrInvalidAPIKey)
+	}
+	return nil
+}
+
+// IsActive reports whether the key can still authenticate.
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// HasScope reports whether the key was granted scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return containsString(k.Scopes, scope)
+}
+
+// AllowsIP reports whether a client at ip may use the key.
+func (k *APIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range k.AllowedIPs {
+		prefix, err := parseIPRange(allowed)
+		if err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether key is this API key, comparing hashes in
+// constant time.
+func (k *APIKey) Matches(key string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashOpaqueToken(key)), []byte(k.KeyHash)) == 1
+}
+
+// NewAPIKey returns a new key, its prefix and the hash it is stored under.
+func NewAPIKey() (key, prefix, hash string, err error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	prefix = APIKeyPrefix + hex.EncodeToString(id)
+	key = prefix + "_" + hex.EncodeToString(secret)
+	return key, prefix, HashOpaqueToken(key), nil
+}
+
+// APIKeyPrefixOf returns the prefix a key is looked up by, or false when it
+// is not shaped like an API key.
+func APIKeyPrefixOf(key string) (string, bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	if !ok || id == "" || secret == "" {
+		return "", false
+	}
+	return APIKeyPrefix + id, true
+}
+
+// parseIPRange parses an IP address as a single-address range, or a CIDR.
+func parseIPRange(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
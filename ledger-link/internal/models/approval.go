@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+	ApprovalExpired  ApprovalStatus = "expired"
+	// ApprovalExecuted records a request within the threshold that ran at
+	// once without a second admin
+	ApprovalExecuted ApprovalStatus = "executed"
+)
+
+var (
+	ErrInvalidApproval     = errors.New("invalid approval request")
+	ErrApprovalResolved    = errors.New("approval has already been resolved")
+	ErrApprovalExpired     = errors.New("approval has expired")
+	ErrApprovalSameChecker = errors.New("approval needs a different admin than the requester")
+)
+
+// Approval is an adjustment or transfer an admin requested that is large
+// enough to need a second, different admin to approve it before it runs.
+// Until then it moves no funds and has no transaction; once approved,
+// TransactionID is the transaction that executed it. Requests that ran at
+// once are kept as executed approvals, so an admin's recent ones add up
+// toward the threshold.
+type Approval struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	Type          TransactionType `gorm:"type:varchar(20);not null" json:"type"`
+	FromUserID    uint            `gorm:"not null" json:"from_user_id"`
+	ToUserID      uint            `gorm:"not null" json:"to_user_id"`
+	Amount        decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"amount"`
+	Currency      string          `gorm:"type:char(3);not null" json:"currency"`
+	Debit         bool            `gorm:"not null;default:false" json:"debit,omitempty"`
+	Notes         string          `gorm:"type:text" json:"notes,omitempty"`
+	Status        ApprovalStatus  `gorm:"type:varchar(20);not null;index:idx_approvals_status" json:"status"`
+	RequestedBy   uint            `gorm:"not null" json:"requested_by"`
+	DecidedBy     *uint           `json:"decided_by,omitempty"`
+	DecidedAt     *time.Time      `json:"decided_at,omitempty"`
+	Reason        string          `gorm:"type:text" json:"reason,omitempty"`
+	TransactionID *uint           `json:"transaction_id,omitempty"`
+	ExpiresAt     time.Time       `gorm:"not null;index:idx_approvals_status" json:"expires_at"`
+	CreatedAt     time.Time       `gorm:"not null" json:"created_at"`
+	UpdatedAt     time.Time       `gorm:"not null" json:"updated_at"`
+}
+
+func (a *Approval) TableName() string {
+	return "approvals"
+}
+
+// NewAdjustment builds the adjustment an admin books on a user's balance: a
+// credit, or a charge when debit is set.
+func NewAdjustment(userID uint, amount decimal.Decimal, currency string, debit bool, notes string) *Transaction {
+	return &Transaction{
+		FromUserID: userID,
+		ToUserID:   userID,
+		Amount:     amount,
+		Currency:   NormalizeCurrency(currency),
+		Type:       TypeAdjustment,
+		Status:     StatusPending,
+		Notes:      notes,
+		Debit:      debit,
+	}
+}
+
+// Transaction builds the transaction that executes the approval.
+func (a *Approval) Transaction() *Transaction {
+	if a.Type == TypeAdjustment {
+		return NewAdjustment(a.ToUserID, a.Amount, a.Currency, a.Debit, a.Notes)
+	}
+	return &Transaction{
+		FromUserID: a.FromUserID,
+		ToUserID:   a.ToUserID,
+		Amount:     a.Amount,
+		Currency:   a.Currency,
+		Type:       a.Type,
+		Status:     StatusPending,
+		Notes:      a.Notes,
+	}
+}
+
+// Validate checks the operation requested; only adjustments and transfers
+// go through approval.
+func (a *Approval) Validate() error {
+	if a.Type != TypeAdjustment && a.Type != TypeTransfer {
+		return fmt.Errorf("%w: only adjustments and transfers need approval", ErrInvalidApproval)
+	}
+	if a.Type == TypeTransfer && a.FromUserID == a.ToUserID {
+		return fmt.Errorf("%w: cannot transfer to the same user", ErrInvalidApproval)
+	}
+	return a.Transaction().Validate()
+}
+
+// IsExpired reports whether a pending approval can no longer be approved.
+func (a *Approval) IsExpired(now time.Time) bool {
+	return a.Status == ApprovalPending && !now.Before(a.ExpiresAt)
+}
@@ -15,6 +15,18 @@ type LoginInput struct {
 	Password string `json:"password" validate:"required"`
 }
 
+// VerifyLoginInput completes a two-factor login challenge with a TOTP code
+// or a recovery code.
+type VerifyLoginInput struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required"`
+}
+
+type ChangePasswordInput struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8"`
+}
+
 type AuthResponse struct {
 	User         *User  `json:"user"`
 	Token        string `json:"token"`
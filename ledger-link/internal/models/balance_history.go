@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -9,13 +10,32 @@ import (
 
 type BalanceHistory struct {
 	ID        uint            `gorm:"primaryKey" json:"id"`
-	UserID    uint            `gorm:"index;not null" json:"user_id"`
+	UserID    uint            `gorm:"index;index:idx_balance_history_user_currency;not null" json:"user_id"`
+	Currency  string          `gorm:"type:char(3);not null;default:'USD';index:idx_balance_history_user_currency" json:"currency"`
 	OldAmount decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"old_amount"`
 	NewAmount decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"new_amount"`
 	CreatedAt time.Time       `gorm:"not null" json:"created_at"`
 	DeletedAt gorm.DeletedAt  `gorm:"index" json:"-"`
+
+	// TransactionID is the transaction that caused the change. It is empty
+	// for batched deposits, which change the balance once for several
+	// transactions, and for history recorded before it was tracked.
+	TransactionID *uint `gorm:"index" json:"transaction_id,omitempty"`
 }
 
 func (h *BalanceHistory) TableName() string {
 	return "balance_history"
 }
+
+type transactionIDKey struct{}
+
+// WithTransactionID marks balance changes made with ctx as caused by the
+// transaction, so that their history entries link back to it.
+func WithTransactionID(ctx context.Context, transactionID uint) context.Context {
+	return context.WithValue(ctx, transactionIDKey{}, transactionID)
+}
+
+func TransactionIDFromContext(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(transactionIDKey{}).(uint)
+	return id, ok && id != 0
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultCurrency is assumed when a request does not name a currency and is
+// the currency existing data was migrated to.
+const DefaultCurrency = "USD"
+
+var (
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	ErrInvalidPrecision    = errors.New("amount has more decimal places than the currency allows")
+)
+
+// Currency is an ISO 4217 currency with the number of digits after the
+// decimal separator of its minor unit.
+type Currency struct {
+	Code       string `json:"code"`
+	MinorUnits int32  `json:"minor_units"`
+}
+
+var currencies = map[string]Currency{
+	"USD": {Code: "USD", MinorUnits: 2},
+	"EUR": {Code: "EUR", MinorUnits: 2},
+	"TRY": {Code: "TRY", MinorUnits: 2},
+	"GBP": {Code: "GBP", MinorUnits: 2},
+	"CHF": {Code: "CHF", MinorUnits: 2},
+	"CAD": {Code: "CAD", MinorUnits: 2},
+	"AUD": {Code: "AUD", MinorUnits: 2},
+	"SEK": {Code: "SEK", MinorUnits: 2},
+	"NOK": {Code: "NOK", MinorUnits: 2},
+	"DKK": {Code: "DKK", MinorUnits: 2},
+	"PLN": {Code: "PLN", MinorUnits: 2},
+	"CNY": {Code: "CNY", MinorUnits: 2},
+	"JPY": {Code: "JPY", MinorUnits: 0},
+	"KRW": {Code: "KRW", MinorUnits: 0},
+	"KWD": {Code: "KWD", MinorUnits: 3},
+	"BHD": {Code: "BHD", MinorUnits: 3},
+}
+
+// NormalizeCurrency upper-cases a currency code and falls back to
+// DefaultCurrency when it is empty.
+func NormalizeCurrency(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return DefaultCurrency
+	}
+	return code
+}
+
+// LookupCurrency returns the supported currency with the given code.
+func LookupCurrency(code string) (Currency, error) {
+	currency, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, code)
+	}
+	return currency, nil
+}
+
+// SupportedCurrencies returns the codes of all supported currencies in
+// alphabetical order.
+func SupportedCurrencies() []string {
+	codes := make([]string, 0, len(currencies))
+	for code := range currencies {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// ValidateAmount rejects amounts that cannot be expressed in the currency's
+// minor unit, such as 0.001 USD or 1.5 JPY.
+func (c Currency) ValidateAmount(amount decimal.Decimal) error {
+	if !amount.Equal(amount.Truncate(c.MinorUnits)) {
+		return fmt.Errorf("%w: %s allows %d", ErrInvalidPrecision, c.Code, c.MinorUnits)
+	}
+	return nil
+}
+
+// ValidateMoney checks that code is a supported currency and that amount fits
+// its minor unit.
+func ValidateMoney(amount decimal.Decimal, code string) error {
+	currency, err := LookupCurrency(code)
+	if err != nil {
+		return err
+	}
+	return currency.ValidateAmount(amount)
+}
+
+// CurrencyAmounts holds an amount per currency, such as a threshold, since
+// one figure means very different sums in JPY and in USD.
+type CurrencyAmounts map[string]decimal.Decimal
+
+// Exceeds reports whether amount is over the currency's amount. A currency
+// without one allows nothing, so any amount in it exceeds it.
+func (a CurrencyAmounts) Exceeds(currency string, amount decimal.Decimal) bool {
+	limit, ok := a[NormalizeCurrency(currency)]
+	return !ok || amount.GreaterThan(limit)
+}
+
+// ParseCurrencyAmounts parses comma-separated CODE=AMOUNT pairs, such as
+// "USD=1000,JPY=150000", of supported currencies.
+func ParseCurrencyAmounts(s string) (CurrencyAmounts, error) {
+	amounts := make(CurrencyAmounts)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		code, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid currency amount %q", pair)
+		}
+		code = NormalizeCurrency(code)
+		if _, err := LookupCurrency(code); err != nil {
+			return nil, err
+		}
+		amount, err := decimal.NewFromString(strings.TrimSpace(value))
+		if err != nil || amount.IsNegative() {
+			return nil, fmt.Errorf("invalid %s amount %q", code, value)
+		}
+		amounts[code] = amount
+	}
+	return amounts, nil
+}
+
+// BalanceKey identifies a user's balance in one currency.
+type BalanceKey struct {
+	UserID   uint
+	Currency string
+}
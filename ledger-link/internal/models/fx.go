@@ -0,0 +1,61 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRateUnavailable = errors.New("exchange rate unavailable")
+	ErrQuoteExpired    = errors.New("quote has expired")
+	ErrQuoteUsed       = errors.New("quote has already been used")
+	ErrSameCurrency    = errors.New("source and target currency must differ")
+)
+
+// FXQuote is a conversion price offered to a user. It locks the rate until
+// ExpiresAt and can be executed once.
+type FXQuote struct {
+	ID            string          `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID        uint            `gorm:"index;not null" json:"user_id"`
+	FromCurrency  string          `gorm:"type:char(3);not null" json:"from_currency"`
+	ToCurrency    string          `gorm:"type:char(3);not null" json:"to_currency"`
+	SourceAmount  decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"source_amount"`
+	TargetAmount  decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"target_amount"`
+	MidRate       decimal.Decimal `gorm:"type:decimal(20,10);not null" json:"mid_rate"`
+	Rate          decimal.Decimal `gorm:"type:decimal(20,10);not null" json:"rate"`
+	Spread        decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"spread"`
+	ExpiresAt     time.Time       `gorm:"not null" json:"expires_at"`
+	UsedAt        *time.Time      `json:"used_at,omitempty"`
+	TransactionID *uint           `json:"transaction_id,omitempty"`
+	CreatedAt     time.Time       `gorm:"not null" json:"created_at"`
+}
+
+func (q *FXQuote) TableName() string {
+	return "fx_quotes"
+}
+
+func (q *FXQuote) BeforeCreate(tx *gorm.DB) error {
+	if q.ID == "" {
+		q.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (q *FXQuote) IsExpired() bool {
+	return time.Now().After(q.ExpiresAt)
+}
+
+// PriceConversion converts amount at the mid rate less a spread given in
+// basis points. The target amount is rounded down to the target currency's
+// minor unit; the spread is what the customer gives up against the mid rate,
+// in the target currency.
+func PriceConversion(amount, midRate decimal.Decimal, spreadBps int64, to Currency) (target, rate, spread decimal.Decimal) {
+	rate = midRate.Mul(decimal.NewFromInt(10000 - spreadBps)).Div(decimal.NewFromInt(10000)).Round(10)
+	target = amount.Mul(rate).RoundDown(to.MinorUnits)
+	spread = amount.Mul(midRate).Sub(target).Round(8)
+	return target, rate, spread
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type SortOrder string
+
+const (
+	SortNewestFirst SortOrder = "desc"
+	SortOldestFirst SortOrder = "asc"
+)
+
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 200
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TransactionFilter selects a page of a user's transaction history. Empty
+// fields do not filter. Pages are ordered by (created_at, id) in Sort order
+// and continue after Cursor.
+type TransactionFilter struct {
+	UserID         uint
+	Types          []TransactionType
+	Statuses       []TransactionStatus
+	From           *time.Time
+	To             *time.Time
+	MinAmount      decimal.NullDecimal
+	MaxAmount      decimal.NullDecimal
+	CounterpartyID uint
+	Notes          string
+	Sort           SortOrder
+	Cursor         *HistoryCursor
+	Limit          int
+}
+
+// Normalize applies the default sort and limit and checks the filter.
+func (f *TransactionFilter) Normalize() error {
+	switch f.Sort {
+	case "":
+		f.Sort = SortNewestFirst
+	case SortNewestFirst, SortOldestFirst:
+	default:
+		return fmt.Errorf("%w: unknown sort %q", ErrInvalidInput, f.Sort)
+	}
+
+	switch {
+	case f.Limit <= 0:
+		f.Limit = DefaultHistoryLimit
+	case f.Limit > MaxHistoryLimit:
+		f.Limit = MaxHistoryLimit
+	}
+
+	for _, t := range f.Types {
+		switch t {
+		case TypeTransfer, TypeDeposit, TypeWithdrawal, TypeAdjustment, TypeReversal:
+		default:
+			return fmt.Errorf("%w: %s", ErrInvalidType, t)
+		}
+	}
+	for _, s := range f.Statuses {
+		switch s {
+		case StatusPending, StatusCompleted, StatusFailed, StatusCancelled, StatusReview:
+		default:
+			return fmt.Errorf("%w: %s", ErrInvalidStatus, s)
+		}
+	}
+
+	if f.From != nil && f.To != nil && f.To.Before(*f.From) {
+		return fmt.Errorf("%w: date range ends before it starts", ErrInvalidInput)
+	}
+	if f.MinAmount.Valid && f.MaxAmount.Valid && f.MaxAmount.Decimal.LessThan(f.MinAmount.Decimal) {
+		return fmt.Errorf("%w: amount range ends below its start", ErrInvalidInput)
+	}
+	if f.Cursor != nil && f.Cursor.Sort != f.Sort {
+		return fmt.Errorf("%w: cursor was issued for a different sort", ErrInvalidCursor)
+	}
+	return nil
+}
+
+// HistoryCursor is the position after the last transaction of a page.
+type HistoryCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uint      `json:"id"`
+	Sort      SortOrder `json:"s"`
+}
+
+// Encode returns the cursor in the opaque form handed to clients.
+func (c HistoryCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeHistoryCursor(s string) (*HistoryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor HistoryCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// TransactionPage is one page of history. NextCursor is empty on the last
+// page.
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+}
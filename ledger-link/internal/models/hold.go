@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type HoldStatus string
+
+const (
+	HoldStatusActive   HoldStatus = "active"
+	HoldStatusCaptured HoldStatus = "captured"
+	HoldStatusVoided   HoldStatus = "voided"
+	HoldStatusExpired  HoldStatus = "expired"
+)
+
+var (
+	ErrInsufficientFunds  = errors.New("insufficient funds")
+	ErrHoldNotActive      = errors.New("hold is no longer active")
+	ErrCaptureExceedsHold = errors.New("capture exceeds the held amount")
+)
+
+// Hold reserves funds of a balance without moving them. Until it is captured,
+// voided or expires, the held amount is not available for debits. A capture
+// books a withdrawal of up to the held amount and releases the rest.
+type Hold struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	UserID         uint            `gorm:"not null;index:idx_holds_user_status" json:"user_id"`
+	Currency       string          `gorm:"type:char(3);not null;index:idx_holds_user_status" json:"currency"`
+	Amount         decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"amount"`
+	CapturedAmount decimal.Decimal `gorm:"type:decimal(20,8);not null;default:0" json:"captured_amount"`
+	Status         HoldStatus      `gorm:"type:varchar(10);not null;index:idx_holds_user_status" json:"status"`
+	Reference      string          `gorm:"type:varchar(255)" json:"reference,omitempty"`
+	Notes          string          `gorm:"type:text" json:"notes,omitempty"`
+	ExpiresAt      time.Time       `gorm:"not null;index" json:"expires_at"`
+	TransactionID  *uint           `json:"transaction_id,omitempty"`
+	CreatedAt      time.Time       `gorm:"not null" json:"created_at"`
+	UpdatedAt      time.Time       `gorm:"not null" json:"updated_at"`
+}
+
+func (h *Hold) TableName() string {
+	return "holds"
+}
+
+// IsActive reports whether the hold still reserves funds. A hold past its
+// expiry stops counting even before the sweeper marks it expired.
+func (h *Hold) IsActive() bool {
+	return h.Status == HoldStatusActive && time.Now().Before(h.ExpiresAt)
+}
+
+// CheckCapture reports whether amount can be captured from the hold.
+func (h *Hold) CheckCapture(amount decimal.Decimal) error {
+	if !h.IsActive() {
+		return fmt.Errorf("%w: hold is %s", ErrHoldNotActive, h.EffectiveStatus())
+	}
+	if !amount.IsPositive() {
+		return ErrInvalidAmount
+	}
+	if err := ValidateMoney(amount, h.Currency); err != nil {
+		return err
+	}
+	if amount.GreaterThan(h.Amount) {
+		return fmt.Errorf("%w: %s %s held", ErrCaptureExceedsHold, h.Amount, h.Currency)
+	}
+	return nil
+}
+
+// EffectiveStatus is the hold's status with a lapsed active hold reported as
+// expired.
+func (h *Hold) EffectiveStatus() HoldStatus {
+	if h.Status == HoldStatusActive && !time.Now().Before(h.ExpiresAt) {
+		return HoldStatusExpired
+	}
+	return h.Status
+}
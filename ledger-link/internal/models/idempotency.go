@@ -0,0 +1,31 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was used with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still in progress")
+)
+
+// IdempotencyRecord stores the first response produced for a user's
+// idempotency key so that retries of the same request can be answered
+// without executing it again.
+type IdempotencyRecord struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"not null;uniqueIndex:idx_idempotency_user_key" json:"user_id"`
+	Key          string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_idempotency_user_key" json:"key"`
+	RequestHash  string    `gorm:"type:char(64);not null" json:"request_hash"`
+	Completed    bool      `gorm:"not null;default:false" json:"completed"`
+	StatusCode   int       `json:"status_code"`
+	ContentType  string    `gorm:"type:varchar(255)" json:"content_type"`
+	ResponseBody []byte    `gorm:"type:blob" json:"response_body"`
+	CreatedAt    time.Time `gorm:"not null" json:"created_at"`
+	ExpiresAt    time.Time `gorm:"index;not null" json:"expires_at"`
+}
+
+func (r *IdempotencyRecord) IsExpired() bool {
+	return time.Now().After(r.ExpiresAt)
+}
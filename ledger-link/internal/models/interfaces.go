@@ -9,29 +9,168 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// UnitOfWork runs repository calls in one database transaction. Repository
+// calls made with the context passed to fn join the transaction.
+type UnitOfWork interface {
+	Do(ctx context.Context, fn func(ctx context.Context) error) error
+	InTransaction(ctx context.Context) bool
+	AfterCommit(ctx context.Context, fn func())
+}
+
 type UserRepository interface {
 	Create(ctx context.Context, user *User) error
 	GetByID(ctx context.Context, id uint) (*User, error)
+	GetByIDForUpdate(ctx context.Context, id uint) (*User, error)
 	GetByEmail(ctx context.Context, email string) (*User, error)
 	GetByUsername(ctx context.Context, username string) (*User, error)
 	GetUsers(ctx context.Context) ([]*User, error)
 	Update(ctx context.Context, user *User) error
+	UpdateRole(ctx context.Context, id uint, role string) error
+	MarkEmailVerified(ctx context.Context, id uint, at time.Time) error
+	Delete(ctx context.Context, id uint) error
+}
+
+type LockoutRepository interface {
+	GetByID(ctx context.Context, id uint) (*LoginLockout, error)
+	GetByEmail(ctx context.Context, email string) (*LoginLockout, error)
+	GetOrCreateForUpdate(ctx context.Context, email string) (*LoginLockout, error)
+	Save(ctx context.Context, lockout *LoginLockout) error
+	ListLocked(ctx context.Context, now time.Time) ([]LoginLockout, error)
 	Delete(ctx context.Context, id uint) error
+	DeleteByEmail(ctx context.Context, email string) error
+}
+
+type UserTokenRepository interface {
+	Create(ctx context.Context, token *UserToken) error
+	GetByHashForUpdate(ctx context.Context, hash string) (*UserToken, error)
+	GetLatest(ctx context.Context, userID uint, purpose string) (*UserToken, error)
+	Update(ctx context.Context, token *UserToken) error
+	InvalidateUnused(ctx context.Context, userID uint, purpose string, at time.Time) error
 }
 
 type TransactionRepository interface {
 	Create(ctx context.Context, tx *Transaction) error
 	GetByID(ctx context.Context, id uint) (*Transaction, error)
+	GetByIDForUpdate(ctx context.Context, id uint) (*Transaction, error)
 	GetByUserID(ctx context.Context, userID uint) ([]Transaction, error)
+	GetByIDs(ctx context.Context, ids []uint) ([]Transaction, error)
+	FindHistory(ctx context.Context, filter TransactionFilter) ([]Transaction, error)
 	Update(ctx context.Context, tx *Transaction) error
 }
 
 type BalanceRepository interface {
 	Create(ctx context.Context, balance *Balance) error
-	GetByUserID(ctx context.Context, userID uint) (*Balance, error)
+	GetByUserID(ctx context.Context, userID uint, currency string) (*Balance, error)
+	GetByUserIDForUpdate(ctx context.Context, userID uint, currency string) (*Balance, error)
+	ListByUserID(ctx context.Context, userID uint) ([]Balance, error)
+	GetAll(ctx context.Context) ([]Balance, error)
 	Update(ctx context.Context, balance *Balance) error
-	GetBalanceHistory(ctx context.Context, userID uint, limit int) ([]BalanceHistory, error)
+	GetBalanceHistory(ctx context.Context, userID uint, currency string, limit int) ([]BalanceHistory, error)
+	ListBalanceHistoryBetween(ctx context.Context, userID uint, currency string, from, to time.Time, afterID uint, limit int) ([]BalanceHistory, error)
+	ListAllBalanceHistoryBetween(ctx context.Context, from, to time.Time, afterID uint, limit int) ([]BalanceHistory, error)
 	CreateBalanceHistory(ctx context.Context, history *BalanceHistory) error
+	GetLatestSnapshot(ctx context.Context, userID uint, currency string, at time.Time) (*BalanceSnapshot, error)
+	LatestSnapshotTime(ctx context.Context, at time.Time) (time.Time, error)
+	ListSnapshotsAt(ctx context.Context, asOf time.Time) ([]BalanceSnapshot, error)
+	SaveSnapshots(ctx context.Context, snapshots []BalanceSnapshot) error
+}
+
+type JournalRepository interface {
+	CreatePostings(ctx context.Context, postings Postings) error
+	GetByTransactionID(ctx context.Context, transactionID uint) (Postings, error)
+	SumByAccount(ctx context.Context, accountType string, accountID uint, currency string) (decimal.Decimal, error)
+	SumUserAccounts(ctx context.Context) (map[BalanceKey]decimal.Decimal, error)
+	FindUnbalancedTransactions(ctx context.Context) ([]uint, error)
+}
+
+type WebhookRepository interface {
+	CreateSubscription(ctx context.Context, subscription *WebhookSubscription) error
+	GetSubscription(ctx context.Context, id uint) (*WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context, userID uint, includeGlobal bool) ([]WebhookSubscription, error)
+	ListSubscriptionsFor(ctx context.Context, userIDs []uint) ([]WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, id uint) error
+	CreateDeliveries(ctx context.Context, deliveries []WebhookDelivery) error
+	GetDelivery(ctx context.Context, id uint) (*WebhookDelivery, error)
+	ListDeliveries(ctx context.Context, subscriptionID uint, status WebhookDeliveryStatus, limit int) ([]WebhookDelivery, error)
+	ListDueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+}
+
+type LimitRepository interface {
+	Save(ctx context.Context, limit *SpendingLimit) error
+	Get(ctx context.Context, id uint) (*SpendingLimit, error)
+	GetByScope(ctx context.Context, role string, userID uint, txType TransactionType, currency string) (*SpendingLimit, error)
+	FindEffective(ctx context.Context, userID uint, role string, txType TransactionType, currency string) (*SpendingLimit, error)
+	List(ctx context.Context, role string, userID uint) ([]SpendingLimit, error)
+	Delete(ctx context.Context, id uint) error
+	SumSpending(ctx context.Context, userID uint, txType TransactionType, currency string, since time.Time) (LimitUsage, error)
+}
+
+type RiskRepository interface {
+	CreateReview(ctx context.Context, review *RiskReview) error
+	GetReviewForUpdate(ctx context.Context, id uint) (*RiskReview, error)
+	UpdateReview(ctx context.Context, review *RiskReview) error
+	ListReviews(ctx context.Context, status RiskReviewStatus) ([]RiskReview, error)
+	RecentTransactions(ctx context.Context, userID uint, since time.Time) ([]Transaction, error)
+}
+
+type ApprovalRepository interface {
+	Create(ctx context.Context, approval *Approval) error
+	GetByID(ctx context.Context, id uint) (*Approval, error)
+	GetForUpdate(ctx context.Context, id uint) (*Approval, error)
+	Update(ctx context.Context, approval *Approval) error
+	List(ctx context.Context, status ApprovalStatus) ([]Approval, error)
+	ListExpired(ctx context.Context, now time.Time) ([]Approval, error)
+	SumExecuted(ctx context.Context, requestedBy uint, currency string, since time.Time) (decimal.Decimal, error)
+}
+
+type OutboxRepository interface {
+	ListUnpublished(ctx context.Context, limit int) ([]OutboxEvent, error)
+	MarkPublished(ctx context.Context, ids []uint, at time.Time) error
+	DeletePublishedBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+type FXQuoteRepository interface {
+	Create(ctx context.Context, quote *FXQuote) error
+	GetByID(ctx context.Context, id string) (*FXQuote, error)
+	MarkUsed(ctx context.Context, id string, transactionID uint) error
+}
+
+type HoldRepository interface {
+	Create(ctx context.Context, hold *Hold) error
+	GetByID(ctx context.Context, id uint) (*Hold, error)
+	GetByIDForUpdate(ctx context.Context, id uint) (*Hold, error)
+	ListByUserID(ctx context.Context, userID uint, status HoldStatus) ([]Hold, error)
+	ListExpired(ctx context.Context, now time.Time, limit int) ([]Hold, error)
+	SumActive(ctx context.Context, userID uint, currency string) (decimal.Decimal, error)
+	Update(ctx context.Context, hold *Hold) error
+}
+
+type ScheduleRepository interface {
+	Create(ctx context.Context, schedule *Schedule) error
+	GetByID(ctx context.Context, id uint) (*Schedule, error)
+	GetByIDForUpdate(ctx context.Context, id uint) (*Schedule, error)
+	ListByUserID(ctx context.Context, userID uint, status ScheduleStatus) ([]Schedule, error)
+	ListDue(ctx context.Context, now time.Time, limit int) ([]Schedule, error)
+	Update(ctx context.Context, schedule *Schedule) error
+	CreateRun(ctx context.Context, run *ScheduleRun) error
+	ListRuns(ctx context.Context, scheduleID uint, limit int) ([]ScheduleRun, error)
+}
+
+// ReconciliationRepository stores reconciliation runs and reads the source
+// records balances are recomputed from. A userID of 0 reads every user.
+type ReconciliationRepository interface {
+	CreateRun(ctx context.Context, run *ReconciliationRun) error
+	GetRun(ctx context.Context, id uint) (*ReconciliationRun, error)
+	ListRuns(ctx context.Context, limit int) ([]ReconciliationRun, error)
+	GetDiscrepancyForUpdate(ctx context.Context, id uint) (*Discrepancy, error)
+	UpdateDiscrepancy(ctx context.Context, discrepancy *Discrepancy) error
+	ListCompletedTransactions(ctx context.Context, userID uint, afterID uint, limit int) ([]Transaction, error)
+	ListPostings(ctx context.Context, transactionIDs []uint) (Postings, error)
+	SumUserPostings(ctx context.Context, userID uint) (map[BalanceKey]decimal.Decimal, error)
+	LatestHistoryAmounts(ctx context.Context, userID uint) (map[BalanceKey]decimal.Decimal, error)
+	ListUnsettledPostings(ctx context.Context, userID uint) (Postings, error)
+	ListUnsettledHistory(ctx context.Context, userID uint) ([]BalanceHistory, error)
 }
 
 type AuditLogRepository interface {
@@ -39,6 +178,111 @@ type AuditLogRepository interface {
 	GetByEntityID(ctx context.Context, entityType string, entityID uint) ([]AuditLog, error)
 }
 
+// IdempotencyStore keeps one record per (user, key) until it expires.
+// Reserve must be atomic across replicas: it returns false when a live
+// record for the key already exists.
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, record *IdempotencyRecord) (bool, error)
+	Get(ctx context.Context, userID uint, key string) (*IdempotencyRecord, error)
+	Complete(ctx context.Context, record *IdempotencyRecord) error
+	Release(ctx context.Context, userID uint, key string) error
+}
+
+// RevocationStore keeps the IDs (jti) of revoked access tokens until the
+// tokens expire.
+type RevocationStore interface {
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *Session) error
+	GetForUpdate(ctx context.Context, id uint) (*Session, error)
+	Update(ctx context.Context, session *Session) error
+	ListActive(ctx context.Context, userID uint, now time.Time) ([]Session, error)
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	GetRefreshTokenForUpdate(ctx context.Context, hash string) (*RefreshToken, error)
+	UpdateRefreshToken(ctx context.Context, token *RefreshToken) error
+}
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *APIKey) error
+	GetByID(ctx context.Context, id uint) (*APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	Update(ctx context.Context, key *APIKey) error
+	List(ctx context.Context, userID uint) ([]APIKey, error)
+	TouchLastUsed(ctx context.Context, id uint, at time.Time, ip string) error
+}
+
+type TwoFactorRepository interface {
+	GetByUserID(ctx context.Context, userID uint) (*TwoFactor, error)
+	GetByUserIDForUpdate(ctx context.Context, userID uint) (*TwoFactor, error)
+	Save(ctx context.Context, credential *TwoFactor) error
+	Delete(ctx context.Context, userID uint) error
+	ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID uint, hash string, at time.Time) (bool, error)
+	CountUnusedRecoveryCodes(ctx context.Context, userID uint) (int, error)
+	CreateChallenge(ctx context.Context, challenge *LoginChallenge) error
+	GetChallengeForUpdate(ctx context.Context, hash string) (*LoginChallenge, error)
+	UpdateChallenge(ctx context.Context, challenge *LoginChallenge) error
+}
+
+type RoleRepository interface {
+	GetByName(ctx context.Context, name string) (*Role, error)
+	List(ctx context.Context) ([]Role, error)
+	Save(ctx context.Context, role *Role) error
+}
+
+// Lease is a named lock with a time limit, shared by all replicas. Acquire
+// returns false while another holder owns the lease and renews it for its
+// current holder.
+type Lease interface {
+	Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, name, holder string) error
+}
+
+// Mailer sends email, such as the links of email verification and
+// password reset.
+type Mailer interface {
+	Send(ctx context.Context, message *MailMessage) error
+}
+
+// SessionRevoker ends every active session of a user, as when their
+// password changes.
+type SessionRevoker interface {
+	RevokeUserSessions(ctx context.Context, userID uint) (int, error)
+}
+
+// EventSink publishes outbox events to downstream consumers. Publish
+// receives events in outbox order and returns nil only once all of them are
+// accepted; a failed batch is published again, so consumers may see an
+// event more than once.
+type EventSink interface {
+	Publish(ctx context.Context, events []OutboxEvent) error
+	Close() error
+}
+
+// UpdatePublisher pushes live updates to the clients watching a user, on
+// every replica. Publishing is best effort: the database stays the record.
+type UpdatePublisher interface {
+	Publish(ctx context.Context, update *Update) error
+}
+
+// UpdateBroker publishes live updates and streams them to subscribers.
+// Subscribe sends the updates published after lastID, or only new ones when
+// lastID is empty, followed by live updates until ctx is done, and then
+// closes the channel.
+type UpdateBroker interface {
+	UpdatePublisher
+	Subscribe(ctx context.Context, userID uint, lastID string) (<-chan Update, error)
+}
+
+// RateProvider returns the mid-market rate for converting one unit of from
+// into to.
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (decimal.Decimal, error)
+}
+
 type UserService interface {
 	Register(ctx context.Context, user *User) (*User, error)
 	Authenticate(ctx context.Context, email, password string) (*User, error)
@@ -55,21 +299,26 @@ type TransactionService interface {
 	CreateTransaction(ctx context.Context, tx *Transaction) error
 	ProcessTransaction(ctx context.Context, tx *Transaction) error
 	GetUserTransactions(ctx context.Context, userID uint) ([]Transaction, error)
+	GetTransactionHistory(ctx context.Context, filter TransactionFilter) (*TransactionPage, error)
 	GetTransaction(ctx context.Context, transactionID uint) (*Transaction, error)
 	SubmitTransaction(ctx context.Context, tx *Transaction) error
-	Credit(ctx context.Context, userID uint, amount decimal.Decimal, notes string) error
-	Debit(ctx context.Context, userID uint, amount decimal.Decimal, notes string) error
-	Transfer(ctx context.Context, fromUserID, toUserID uint, amount decimal.Decimal, notes string) error
+	Credit(ctx context.Context, userID uint, amount decimal.Decimal, currency, notes string) error
+	Debit(ctx context.Context, userID uint, amount decimal.Decimal, currency, notes string) error
+	Transfer(ctx context.Context, fromUserID, toUserID uint, amount decimal.Decimal, currency, notes string) error
+	ApproveReview(ctx context.Context, reviewID uint, note string) (*Transaction, error)
+	RejectReview(ctx context.Context, reviewID uint, note string) (*Transaction, error)
 	Start(ctx context.Context) error
 	Stop()
 }
 
 type BalanceService interface {
-	GetBalance(ctx context.Context, userID uint) (*Balance, error)
-	UpdateBalance(ctx context.Context, userID uint, amount decimal.Decimal) error
+	GetBalance(ctx context.Context, userID uint, currency string) (*Balance, error)
+	GetBalances(ctx context.Context, userID uint) ([]Balance, error)
+	UpdateBalance(ctx context.Context, userID uint, currency string, amount decimal.Decimal) error
 	LockBalance(ctx context.Context, userID uint) (*sync.Mutex, error)
-	GetBalanceHistory(ctx context.Context, userID uint, limit int) ([]BalanceHistory, error)
-	GetBalanceAtTime(ctx context.Context, userID uint, timestamp time.Time) (*Balance, error)
+	GetBalanceHistory(ctx context.Context, userID uint, currency string, limit int) ([]BalanceHistory, error)
+	GetBalanceAtTime(ctx context.Context, userID uint, currency string, timestamp time.Time) (*Balance, error)
+	GetBalancesAtTime(ctx context.Context, timestamp time.Time) ([]Balance, error)
 	CreateInitialBalance(ctx context.Context, balance *Balance) error
 }
 
@@ -78,6 +327,98 @@ type AuditService interface {
 	GetEntityAuditLog(ctx context.Context, entityType string, entityID uint) ([]AuditLog, error)
 }
 
+type JournalService interface {
+	VerifyTransaction(ctx context.Context, transactionID uint) error
+	VerifyAccount(ctx context.Context, userID uint, currency string) (*AccountCheck, error)
+	CheckInvariants(ctx context.Context) (*LedgerCheckReport, error)
+}
+
+type FXService interface {
+	CreateQuote(ctx context.Context, userID uint, from, to string, amount decimal.Decimal) (*FXQuote, error)
+	ExecuteQuote(ctx context.Context, userID uint, quoteID string, toUserID uint, notes string) (*Transaction, error)
+}
+
+type HoldService interface {
+	Place(ctx context.Context, userID uint, amount decimal.Decimal, currency, reference, notes string, ttl time.Duration) (*Hold, error)
+	Capture(ctx context.Context, holdID uint, amount decimal.Decimal, notes string) (*Transaction, error)
+	Void(ctx context.Context, holdID uint) (*Hold, error)
+	Get(ctx context.Context, holdID uint) (*Hold, error)
+	List(ctx context.Context, userID uint, status HoldStatus) ([]Hold, error)
+	ExpireHolds(ctx context.Context) (int, error)
+}
+
+type StatementService interface {
+	Generate(ctx context.Context, userID uint, currency string, from, to time.Time, w StatementWriter) error
+}
+
+type ScheduleService interface {
+	Create(ctx context.Context, schedule *Schedule) error
+	Get(ctx context.Context, scheduleID uint) (*Schedule, error)
+	List(ctx context.Context, userID uint, status ScheduleStatus) ([]Schedule, error)
+	Cancel(ctx context.Context, scheduleID uint) (*Schedule, error)
+	ListRuns(ctx context.Context, scheduleID uint) ([]ScheduleRun, error)
+	RunDue(ctx context.Context) (int, error)
+}
+
+type ReconciliationService interface {
+	Run(ctx context.Context, trigger string) (*ReconciliationRun, error)
+	GetRun(ctx context.Context, id uint) (*ReconciliationRun, error)
+	ListRuns(ctx context.Context, limit int) ([]ReconciliationRun, error)
+	Repair(ctx context.Context, discrepancyID uint) (*Discrepancy, error)
+}
+
+// TransactionEvents records the events raised when a transaction changes
+// status. It is called in the unit of work that stores the new status, so
+// the events commit or roll back with it.
+type TransactionEvents interface {
+	StatusChanged(ctx context.Context, tx *Transaction) error
+}
+
+type WebhookService interface {
+	CreateSubscription(ctx context.Context, url string, events []string, global bool) (*WebhookSubscription, error)
+	GetSubscription(ctx context.Context, id uint) (*WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, id uint) error
+	ListDeliveries(ctx context.Context, subscriptionID uint, status WebhookDeliveryStatus) ([]WebhookDelivery, error)
+	Redeliver(ctx context.Context, subscriptionID, deliveryID uint) (*WebhookDelivery, error)
+	DeliverDue(ctx context.Context) (int, error)
+}
+
+// LimitService enforces spending limits. Check returns a
+// *LimitExceededError when the amount does not fit the user's limits. Inside
+// a unit of work it locks the user's row first, so the user's other limited
+// transactions wait until it commits.
+type LimitService interface {
+	Check(ctx context.Context, userID uint, txType TransactionType, currency string, amount decimal.Decimal) error
+	SetLimit(ctx context.Context, limit *SpendingLimit) (*SpendingLimit, error)
+	ListLimits(ctx context.Context, role string, userID uint) ([]SpendingLimit, error)
+	DeleteLimit(ctx context.Context, id uint) error
+}
+
+// RiskService screens debits and transfers with a RiskEvaluator and keeps
+// the review queue. Hold and Resolve run inside the caller's unit of work,
+// next to the status change of the transaction.
+type RiskService interface {
+	Screen(ctx context.Context, tx *Transaction) (RiskAssessment, error)
+	Hold(ctx context.Context, tx *Transaction, assessment RiskAssessment) (*RiskReview, error)
+	Resolve(ctx context.Context, reviewID uint, status RiskReviewStatus, note string) (*RiskReview, error)
+	ListReviews(ctx context.Context, status RiskReviewStatus) ([]RiskReview, error)
+}
+
+// ApprovalService runs the adjustments and transfers admins request. Those
+// above the approval threshold wait for a second admin; Request returns
+// either the pending approval or the executed transaction.
+type ApprovalService interface {
+	Request(ctx context.Context, request *Approval) (*Approval, *Transaction, error)
+	Approve(ctx context.Context, id uint, reason string) (*Approval, error)
+	Reject(ctx context.Context, id uint, reason string) (*Approval, error)
+	ListApprovals(ctx context.Context, status ApprovalStatus) ([]Approval, error)
+}
+
+type ReversalService interface {
+	Reverse(ctx context.Context, transactionID uint, amount decimal.Decimal, notes string) (*Transaction, error)
+}
+
 type TransactionProcessor interface {
 	Start(ctx context.Context) error
 	Stop()
@@ -85,10 +426,54 @@ type TransactionProcessor interface {
 }
 
 type AuthService interface {
-	Login(ctx context.Context, email, password string) (string, error)
-	Register(ctx context.Context, email, password, username string) (string, error)
+	Login(ctx context.Context, email, password string) (*LoginResult, error)
+	VerifyLogin(ctx context.Context, challengeToken, code string) (*TokenPair, error)
+	Register(ctx context.Context, email, password, username string) (*TokenPair, error)
 	ValidateToken(ctx context.Context, token string) (*User, error)
-	RefreshToken(ctx context.Context, oldToken string) (string, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*TokenPair, error)
+}
+
+// APIKeyService manages the API keys machine clients authenticate with.
+// Create returns the key itself, which is not stored and cannot be shown
+// again.
+type APIKeyService interface {
+	Create(ctx context.Context, key *APIKey) (*APIKey, string, error)
+	List(ctx context.Context, userID uint) ([]APIKey, error)
+	Revoke(ctx context.Context, id uint) (*APIKey, error)
+	Authenticate(ctx context.Context, key, ipAddress string) (*User, *APIKey, error)
+}
+
+// TwoFactorService manages TOTP enrollment and recovery codes, and checks
+// the codes of two-step logins and of step-up for sensitive operations.
+type TwoFactorService interface {
+	Enroll(ctx context.Context) (*TwoFactorEnrollment, error)
+	Confirm(ctx context.Context, code string) ([]string, error)
+	Disable(ctx context.Context, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, code string) ([]string, error)
+	Status(ctx context.Context) (*TwoFactorStatus, error)
+	IsEnabled(ctx context.Context, userID uint) (bool, error)
+	StepUp(ctx context.Context, code string) error
+	Challenge(ctx context.Context, userID uint) (string, time.Time, error)
+	CompleteChallenge(ctx context.Context, challengeToken, code string) (uint, error)
+}
+
+// UserTokenService mails and redeems the single-use tokens that verify a
+// user's email address and reset their password.
+type UserTokenService interface {
+	SendEmailVerification(ctx context.Context, user *User) error
+	RequestEmailVerification(ctx context.Context) error
+	VerifyEmail(ctx context.Context, token string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, newPassword string) error
+}
+
+// RoleService resolves the permissions of a role, and lets admins change
+// them and assign roles to users.
+type RoleService interface {
+	Permissions(ctx context.Context, role string) ([]string, error)
+	ListRoles(ctx context.Context) ([]Role, error)
+	SetPermissions(ctx context.Context, role string, permissions []string) (*Role, error)
+	AssignRole(ctx context.Context, userID uint, role string) (*User, error)
 }
 
 type AuthHandler interface {
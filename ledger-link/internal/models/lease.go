@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// LeaseRecord is the database row behind a named lease. Whoever holds an
+// unexpired record owns the lease.
+type LeaseRecord struct {
+	Name      string    `gorm:"type:varchar(100);primaryKey" json:"name"`
+	Holder    string    `gorm:"type:varchar(100);not null" json:"holder"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+}
+
+func (l *LeaseRecord) TableName() string {
+	return "leases"
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnbalancedPostings = errors.New("postings do not sum to zero")
+	ErrNegativeBalance    = errors.New("balance cannot be negative")
+)
+
+// Ledger account types. Every user owns a wallet account; money entering or
+// leaving the ledger is booked against one of the system accounts. The fx
+// account takes the opposite side of both legs of a currency conversion.
+const (
+	AccountTypeUser       = "user"
+	AccountTypeExternal   = "external"
+	AccountTypeAdjustment = "adjustment"
+	AccountTypeFX         = "fx"
+)
+
+// Posting is a single journal line of a transaction. Amount is signed from the
+// account's point of view: a credit (positive) increases the account, a debit
+// (negative) decreases it. The postings of one transaction always sum to zero.
+type Posting struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	TransactionID uint            `gorm:"index;not null" json:"transaction_id"`
+	AccountType   string          `gorm:"type:varchar(20);not null;index:idx_postings_account" json:"account_type"`
+	AccountID     uint            `gorm:"not null;index:idx_postings_account" json:"account_id"`
+	Currency      string          `gorm:"type:char(3);not null;index:idx_postings_account" json:"currency"`
+	Amount        decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"amount"`
+	CreatedAt     time.Time       `gorm:"not null" json:"created_at"`
+}
+
+func (p *Posting) IsDebit() bool {
+	return p.Amount.IsNegative()
+}
+
+func (p *Posting) IsCredit() bool {
+	return p.Amount.IsPositive()
+}
+
+func (p *Posting) IsUserAccount() bool {
+	return p.AccountType == AccountTypeUser
+}
+
+func (p *Posting) BeforeCreate(tx *gorm.DB) error {
+	if p.TransactionID == 0 {
+		return errors.New("transaction ID is required")
+	}
+	if p.Amount.IsZero() {
+		return errors.New("posting amount cannot be zero")
+	}
+	if p.Currency == "" {
+		return errors.New("posting currency is required")
+	}
+	return nil
+}
+
+type Postings []Posting
+
+// Sum returns the net amount of all postings.
+func (ps Postings) Sum() decimal.Decimal {
+	sum := decimal.Zero
+	for _, p := range ps {
+		sum = sum.Add(p.Amount)
+	}
+	return sum
+}
+
+// SumByCurrency returns the net amount of the postings in each currency.
+func (ps Postings) SumByCurrency() map[string]decimal.Decimal {
+	sums := make(map[string]decimal.Decimal)
+	for _, p := range ps {
+		sums[p.Currency] = sums[p.Currency].Add(p.Amount)
+	}
+	return sums
+}
+
+// Validate enforces the double-entry invariant. Amounts in different
+// currencies cannot offset each other, so every currency must net to zero,
+// and a zero leg moves nothing on either side.
+func (ps Postings) Validate() error {
+	if len(ps) < 2 {
+		return fmt.Errorf("%w: a transaction needs at least two postings", ErrUnbalancedPostings)
+	}
+	for _, p := range ps {
+		if p.Amount.IsZero() {
+			return fmt.Errorf("%w: zero posting to %s account %d", ErrUnbalancedPostings, p.AccountType, p.AccountID)
+		}
+	}
+	for currency, sum := range ps.SumByCurrency() {
+		if !sum.IsZero() {
+			return fmt.Errorf("%w: net %s %s", ErrUnbalancedPostings, sum, currency)
+		}
+	}
+	return nil
+}
+
+// UserDeltas returns the net balance change per user account and currency.
+func (ps Postings) UserDeltas() map[BalanceKey]decimal.Decimal {
+	deltas := make(map[BalanceKey]decimal.Decimal)
+	for _, p := range ps {
+		if p.IsUserAccount() {
+			key := BalanceKey{UserID: p.AccountID, Currency: p.Currency}
+			deltas[key] = deltas[key].Add(p.Amount)
+		}
+	}
+	return deltas
+}
+
+// BuildPostings translates a transaction into its balanced journal lines.
+func BuildPostings(tx *Transaction) (Postings, error) {
+	if tx.ID == 0 {
+		return nil, errors.New("transaction must be persisted before posting")
+	}
+	if !tx.Amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+
+	if tx.IsFX() {
+		return buildFXPostings(tx)
+	}
+
+	var debit, credit Posting
+	switch tx.Type {
+	case TypeDeposit:
+		debit = Posting{AccountType: AccountTypeExternal}
+		credit = Posting{AccountType: AccountTypeUser, AccountID: tx.ToUserID}
+	case TypeWithdrawal:
+		debit = Posting{AccountType: AccountTypeUser, AccountID: tx.FromUserID}
+		credit = Posting{AccountType: AccountTypeExternal}
+	case TypeTransfer:
+		debit = Posting{AccountType: AccountTypeUser, AccountID: tx.FromUserID}
+		credit = Posting{AccountType: AccountTypeUser, AccountID: tx.ToUserID}
+	case TypeAdjustment:
+		debit = Posting{AccountType: AccountTypeAdjustment}
+		credit = Posting{AccountType: AccountTypeUser, AccountID: tx.ToUserID}
+		if tx.Debit {
+			debit = Posting{AccountType: AccountTypeUser, AccountID: tx.FromUserID}
+			credit = Posting{AccountType: AccountTypeAdjustment}
+		}
+	default:
+		return nil, ErrInvalidType
+	}
+
+	now := time.Now()
+	currency := NormalizeCurrency(tx.Currency)
+	debit.TransactionID, credit.TransactionID = tx.ID, tx.ID
+	debit.Currency, credit.Currency = currency, currency
+	debit.Amount, credit.Amount = tx.Amount.Neg(), tx.Amount
+	debit.CreatedAt, credit.CreatedAt = now, now
+
+	postings := Postings{debit, credit}
+	if err := postings.Validate(); err != nil {
+		return nil, err
+	}
+	return postings, nil
+}
+
+// buildFXPostings books a cross-currency transfer as two legs, each balanced
+// in its own currency: the sender pays the fx account in the source currency
+// and the fx account pays the recipient in the target currency.
+func buildFXPostings(tx *Transaction) (Postings, error) {
+	if tx.Type != TypeTransfer {
+		return nil, ErrInvalidType
+	}
+	if !tx.ToAmount.Valid || !tx.ToAmount.Decimal.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+
+	now := time.Now()
+	source := NormalizeCurrency(tx.Currency)
+	postings := Postings{
+		{TransactionID: tx.ID, AccountType: AccountTypeUser, AccountID: tx.FromUserID, Currency: source, Amount: tx.Amount.Neg(), CreatedAt: now},
+		{TransactionID: tx.ID, AccountType: AccountTypeFX, Currency: source, Amount: tx.Amount, CreatedAt: now},
+		{TransactionID: tx.ID, AccountType: AccountTypeFX, Currency: tx.ToCurrency, Amount: tx.ToAmount.Decimal.Neg(), CreatedAt: now},
+		{TransactionID: tx.ID, AccountType: AccountTypeUser, AccountID: tx.ToUserID, Currency: tx.ToCurrency, Amount: tx.ToAmount.Decimal, CreatedAt: now},
+	}
+	if err := postings.Validate(); err != nil {
+		return nil, err
+	}
+	return postings, nil
+}
+
+// AccountCheck compares a stored user balance with the sum of its postings
+// in the same currency.
+type AccountCheck struct {
+	UserID        uint            `json:"user_id"`
+	Currency      string          `json:"currency"`
+	StoredBalance decimal.Decimal `json:"stored_balance"`
+	PostedBalance decimal.Decimal `json:"posted_balance"`
+	Difference    decimal.Decimal `json:"difference"`
+}
+
+func (c *AccountCheck) Balanced() bool {
+	return c.Difference.IsZero()
+}
+
+// LedgerCheckReport is the result of a full journal invariant check.
+type LedgerCheckReport struct {
+	CheckedAt              time.Time      `json:"checked_at"`
+	UnbalancedTransactions []uint         `json:"unbalanced_transactions"`
+	MismatchedAccounts     []AccountCheck `json:"mismatched_accounts"`
+}
+
+func (r *LedgerCheckReport) OK() bool {
+	return len(r.UnbalancedTransactions) == 0 && len(r.MismatchedAccounts) == 0
+}
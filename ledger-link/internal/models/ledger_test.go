@@ -0,0 +1,142 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func posting(accountType string, accountID uint, currency string, amount int64) Posting {
+	return Posting{TransactionID: 1, AccountType: accountType, AccountID: accountID, Currency: currency, Amount: decimal.NewFromInt(amount)}
+}
+
+func TestPostingsValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		postings Postings
+		wantErr  bool
+	}{
+		{"balanced transfer", Postings{posting(AccountTypeUser, 1, "USD", -10), posting(AccountTypeUser, 2, "USD", 10)}, false},
+		{"balanced fx legs", Postings{
+			posting(AccountTypeUser, 1, "USD", -10),
+			posting(AccountTypeFX, 0, "USD", 10),
+			posting(AccountTypeFX, 0, "EUR", -9),
+			posting(AccountTypeUser, 2, "EUR", 9),
+		}, false},
+		{"no postings", nil, true},
+		{"single posting", Postings{posting(AccountTypeUser, 1, "USD", 10)}, true},
+		{"debits exceed credits", Postings{posting(AccountTypeUser, 1, "USD", -10), posting(AccountTypeUser, 2, "USD", 9)}, true},
+		{"credits exceed debits", Postings{posting(AccountTypeExternal, 0, "USD", -10), posting(AccountTypeUser, 2, "USD", 11)}, true},
+		{"currencies offset each other", Postings{posting(AccountTypeUser, 1, "USD", -10), posting(AccountTypeUser, 2, "EUR", 10)}, true},
+		{"one currency unbalanced", Postings{
+			posting(AccountTypeUser, 1, "USD", -10),
+			posting(AccountTypeFX, 0, "USD", 10),
+			posting(AccountTypeFX, 0, "EUR", -9),
+			posting(AccountTypeUser, 2, "EUR", 8),
+		}, true},
+		{"zero legs", Postings{posting(AccountTypeUser, 1, "USD", 0), posting(AccountTypeUser, 2, "USD", 0)}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.postings.Validate()
+			if !c.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrUnbalancedPostings)
+		})
+	}
+}
+
+func TestBuildPostings(t *testing.T) {
+	cases := []struct {
+		name    string
+		tx      Transaction
+		want    Postings
+		wantErr error
+	}{
+		{
+			name: "deposit",
+			tx:   Transaction{ID: 1, ToUserID: 2, Amount: decimal.NewFromInt(10), Currency: "usd", Type: TypeDeposit},
+			want: Postings{posting(AccountTypeExternal, 0, "USD", -10), posting(AccountTypeUser, 2, "USD", 10)},
+		},
+		{
+			name: "withdrawal",
+			tx:   Transaction{ID: 1, FromUserID: 1, Amount: decimal.NewFromInt(10), Currency: "USD", Type: TypeWithdrawal},
+			want: Postings{posting(AccountTypeUser, 1, "USD", -10), posting(AccountTypeExternal, 0, "USD", 10)},
+		},
+		{
+			name: "transfer",
+			tx:   Transaction{ID: 1, FromUserID: 1, ToUserID: 2, Amount: decimal.NewFromInt(10), Currency: "USD", Type: TypeTransfer},
+			want: Postings{posting(AccountTypeUser, 1, "USD", -10), posting(AccountTypeUser, 2, "USD", 10)},
+		},
+		{
+			name: "debit adjustment",
+			tx:   Transaction{ID: 1, FromUserID: 1, ToUserID: 1, Amount: decimal.NewFromInt(10), Currency: "USD", Type: TypeAdjustment, Debit: true},
+			want: Postings{posting(AccountTypeUser, 1, "USD", -10), posting(AccountTypeAdjustment, 0, "USD", 10)},
+		},
+		{
+			name: "fx transfer",
+			tx: Transaction{ID: 1, FromUserID: 1, ToUserID: 2, Amount: decimal.NewFromInt(10), Currency: "USD", Type: TypeTransfer,
+				ToCurrency: "EUR", ToAmount: decimal.NewNullDecimal(decimal.NewFromInt(9))},
+			want: Postings{
+				posting(AccountTypeUser, 1, "USD", -10),
+				posting(AccountTypeFX, 0, "USD", 10),
+				posting(AccountTypeFX, 0, "EUR", -9),
+				posting(AccountTypeUser, 2, "EUR", 9),
+			},
+		},
+		{
+			name:    "zero amount",
+			tx:      Transaction{ID: 1, FromUserID: 1, ToUserID: 2, Amount: decimal.Zero, Currency: "USD", Type: TypeTransfer},
+			wantErr: ErrInvalidAmount,
+		},
+		{
+			name:    "negative amount",
+			tx:      Transaction{ID: 1, FromUserID: 1, ToUserID: 2, Amount: decimal.NewFromInt(-10), Currency: "USD", Type: TypeTransfer},
+			wantErr: ErrInvalidAmount,
+		},
+		{
+			name: "fx without a target amount",
+			tx: Transaction{ID: 1, FromUserID: 1, ToUserID: 2, Amount: decimal.NewFromInt(10), Currency: "USD", Type: TypeTransfer,
+				ToCurrency: "EUR", ToAmount: decimal.NewNullDecimal(decimal.Zero)},
+			wantErr: ErrInvalidAmount,
+		},
+		{
+			name: "fx deposit",
+			tx: Transaction{ID: 1, ToUserID: 2, Amount: decimal.NewFromInt(10), Currency: "USD", Type: TypeDeposit,
+				ToCurrency: "EUR", ToAmount: decimal.NewNullDecimal(decimal.NewFromInt(9))},
+			wantErr: ErrInvalidType,
+		},
+		{
+			name:    "unknown type",
+			tx:      Transaction{ID: 1, FromUserID: 1, ToUserID: 2, Amount: decimal.NewFromInt(10), Currency: "USD", Type: "gift"},
+			wantErr: ErrInvalidType,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			postings, err := BuildPostings(&c.tx)
+			if c.wantErr != nil {
+				assert.ErrorIs(t, err, c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, postings, len(c.want))
+			for i, want := range c.want {
+				assert.Equal(t, want.AccountType, postings[i].AccountType, "posting %d", i)
+				assert.Equal(t, want.AccountID, postings[i].AccountID, "posting %d", i)
+				assert.Equal(t, want.Currency, postings[i].Currency, "posting %d", i)
+				assert.True(t, want.Amount.Equal(postings[i].Amount), "posting %d: %s", i, postings[i].Amount)
+			}
+			assert.NoError(t, postings.Validate())
+		})
+	}
+
+	_, err := BuildPostings(&Transaction{Amount: decimal.NewFromInt(10), Currency: "USD", Type: TypeDeposit})
+	assert.Error(t, err, "unsaved transaction posted")
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Windows spending is counted over. They are UTC calendar periods: the day,
+// the week starting on Monday and the month.
+const (
+	LimitWindowTransaction = "transaction"
+	LimitWindowDaily       = "daily"
+	LimitWindowWeekly      = "weekly"
+	LimitWindowMonthly     = "monthly"
+)
+
+// LimitWindows are the windows with caps, shortest first.
+var LimitWindows = []string{LimitWindowDaily, LimitWindowWeekly, LimitWindowMonthly}
+
+var (
+	ErrLimitExceeded = errors.New("spending limit exceeded")
+	ErrInvalidLimit  = errors.New("invalid spending limit")
+)
+
+// SpendingLimit caps what users may spend in one currency with one type of
+// transaction: debits (withdrawals) or transfers. A limit applies either to
+// every user with a role or, as an override, to one user; a user's override
+// replaces the limit of their role. Caps left empty do not apply.
+type SpendingLimit struct {
+	ID              uint                `gorm:"primaryKey" json:"id"`
+	Role            string              `gorm:"type:varchar(20);not null;default:'';uniqueIndex:idx_spending_limits_scope" json:"role,omitempty"`
+	UserID          uint                `gorm:"not null;default:0;uniqueIndex:idx_spending_limits_scope" json:"user_id,omitempty"`
+	TransactionType TransactionType     `gorm:"type:varchar(20);not null;uniqueIndex:idx_spending_limits_scope" json:"transaction_type"`
+	Currency        string              `gorm:"type:char(3);not null;uniqueIndex:idx_spending_limits_scope" json:"currency"`
+	PerTransaction  decimal.NullDecimal `gorm:"type:decimal(20,8)" json:"per_transaction"`
+	DailyAmount     decimal.NullDecimal `gorm:"type:decimal(20,8)" json:"daily_amount"`
+	WeeklyAmount    decimal.NullDecimal `gorm:"type:decimal(20,8)" json:"weekly_amount"`
+	MonthlyAmount   decimal.NullDecimal `gorm:"type:decimal(20,8)" json:"monthly_amount"`
+	DailyCount      *int                `json:"daily_count"`
+	WeeklyCount     *int                `json:"weekly_count"`
+	MonthlyCount    *int                `json:"monthly_count"`
+	CreatedBy       uint                `gorm:"not null" json:"created_by"`
+	CreatedAt       time.Time           `gorm:"not null" json:"created_at"`
+	UpdatedAt       time.Time           `gorm:"not null" json:"updated_at"`
+}
+
+func (l *SpendingLimit) TableName() string {
+	return "spending_limits"
+}
+
+func (l *SpendingLimit) Validate() error {
+	if (l.Role == "") == (l.UserID == 0) {
+		return fmt.Errorf("%w: set either a role or a user", ErrInvalidLimit)
+	}
+	if l.Role != "" && !IsValidRole(l.Role) {
+		return fmt.Errorf("%w: unknown role %q", ErrInvalidLimit, l.Role)
+	}
+	if l.TransactionType != TypeWithdrawal && l.TransactionType != TypeTransfer {
+		return fmt.Errorf("%w: only withdrawals and transfers are limited", ErrInvalidLimit)
+	}
+	if _, err := LookupCurrency(l.Currency); err != nil {
+		return err
+	}
+	for _, amount := range []decimal.NullDecimal{l.PerTransaction, l.DailyAmount, l.WeeklyAmount, l.MonthlyAmount} {
+		if amount.Valid && amount.Decimal.IsNegative() {
+			return fmt.Errorf("%w: amounts cannot be negative", ErrInvalidLimit)
+		}
+	}
+	for _, count := range []*int{l.DailyCount, l.WeeklyCount, l.MonthlyCount} {
+		if count != nil && *count < 0 {
+			return fmt.Errorf("%w: counts cannot be negative", ErrInvalidLimit)
+		}
+	}
+	return nil
+}
+
+// Caps returns the amount and count caps of a window.
+func (l *SpendingLimit) Caps(window string) (decimal.NullDecimal, *int) {
+	switch window {
+	case LimitWindowDaily:
+		return l.DailyAmount, l.DailyCount
+	case LimitWindowWeekly:
+		return l.WeeklyAmount, l.WeeklyCount
+	case LimitWindowMonthly:
+		return l.MonthlyAmount, l.MonthlyCount
+	}
+	return decimal.NullDecimal{}, nil
+}
+
+// LimitWindowBounds returns the start of the window containing now and the
+// start of the next one, in UTC.
+func LimitWindowBounds(window string, now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch window {
+	case LimitWindowWeekly:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case LimitWindowMonthly:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return day, day.AddDate(0, 0, 1)
+	}
+}
+
+// LimitUsage is what a user has spent in a window.
+type LimitUsage struct {
+	Amount decimal.Decimal
+	Count  int64
+}
+
+// CheckAmount rejects an amount above the per-transaction cap.
+func (l *SpendingLimit) CheckAmount(amount decimal.Decimal) error {
+	if l.PerTransaction.Valid && amount.GreaterThan(l.PerTransaction.Decimal) {
+		remaining := l.PerTransaction.Decimal
+		return &LimitExceededError{
+			Code:            "per_transaction_limit",
+			Window:          LimitWindowTransaction,
+			TransactionType: l.TransactionType,
+			Currency:        l.Currency,
+			RemainingAmount: &remaining,
+		}
+	}
+	return nil
+}
+
+// CheckWindow rejects amount when, on top of usage, it would exceed the
+// window's amount cap or the transaction would exceed its count cap.
+func (l *SpendingLimit) CheckWindow(window string, usage LimitUsage, amount decimal.Decimal, resetsAt time.Time) error {
+	amountCap, countCap := l.Caps(window)
+	if countCap != nil && usage.Count >= int64(*countCap) {
+		remaining := 0
+		return &LimitExceededError{
+			Code:            window + "_count_limit",
+			Window:          window,
+			TransactionType: l.TransactionType,
+			Currency:        l.Currency,
+			RemainingCount:  &remaining,
+			ResetsAt:        &resetsAt,
+		}
+	}
+	if amountCap.Valid && usage.Amount.Add(amount).GreaterThan(amountCap.Decimal) {
+		remaining := decimal.Max(amountCap.Decimal.Sub(usage.Amount), decimal.Zero)
+		return &LimitExceededError{
+			Code:            window + "_amount_limit",
+			Window:          window,
+			TransactionType: l.TransactionType,
+			Currency:        l.Currency,
+			RemainingAmount: &remaining,
+			ResetsAt:        &resetsAt,
+		}
+	}
+	return nil
+}
+
+// LimitExceededError is a transaction rejected by a spending limit. Code
+// names the cap that was hit, such as "daily_amount_limit", and the
+// remaining allowance is what the window still allows: the amount, or the
+// number of transactions for count caps.
+type LimitExceededError struct {
+	Code            string           `json:"code"`
+	Window          string           `json:"window"`
+	TransactionType TransactionType  `json:"transaction_type"`
+	Currency        string           `json:"currency"`
+	RemainingAmount *decimal.Decimal `json:"remaining_amount,omitempty"`
+	RemainingCount  *int             `json:"remaining_count,omitempty"`
+	ResetsAt        *time.Time       `json:"resets_at,omitempty"`
+}
+
+func (e *LimitExceededError) Error() string {
+	if e.RemainingAmount != nil {
+		return fmt.Sprintf("%s: %s (%s %s remaining)", ErrLimitExceeded, e.Code, e.RemainingAmount, e.Currency)
+	}
+	return fmt.Sprintf("%s: %s", ErrLimitExceeded, e.Code)
+}
+
+func (e *LimitExceededError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
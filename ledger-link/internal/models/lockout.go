@@ -0,0 +1,54 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrAccountLocked = errors.New("too many failed login attempts")
+
+// LoginLockout counts the failed logins of an email address, whether or not
+// an account has it, so lockouts say nothing about which accounts exist.
+// UserID is set when one does. Once FailedAttempts reaches the lockout
+// threshold, logins are refused until LockedUntil, for longer with each
+// further failure.
+type LoginLockout struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Email          string     `gorm:"type:varchar(255);not null;uniqueIndex" json:"email"`
+	UserID         *uint      `gorm:"index" json:"user_id,omitempty"`
+	FailedAttempts int        `gorm:"not null;default:0" json:"failed_attempts"`
+	LastFailedAt   *time.Time `json:"last_failed_at,omitempty"`
+	LockedUntil    *time.Time `gorm:"index" json:"locked_until,omitempty"`
+	CreatedAt      time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+func (l *LoginLockout) TableName() string {
+	return "login_lockouts"
+}
+
+// IsLocked reports whether logins are refused at now.
+func (l *LoginLockout) IsLocked(now time.Time) bool {
+	return l != nil && l.LockedUntil != nil && now.Before(*l.LockedUntil)
+}
+
+// AccountLockedError refuses a login while its email is locked out.
+type AccountLockedError struct {
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+func (e *AccountLockedError) Error() string {
+	return fmt.Sprintf("%s, try again after %s", ErrAccountLocked, e.LockedUntil.UTC().Format(time.RFC3339))
+}
+
+func (e *AccountLockedError) Is(target error) bool {
+	return target == ErrAccountLocked
+}
+
+// NormalizeEmail returns the form of an email address failed logins are
+// counted under, so changing its case does not start a new count.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
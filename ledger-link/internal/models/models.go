@@ -33,31 +33,51 @@ const (
 	StatusCompleted TransactionStatus = "completed"
 	StatusFailed    TransactionStatus = "failed"
 	StatusCancelled TransactionStatus = "cancelled"
+	StatusReview    TransactionStatus = "review"
 
 	TypeTransfer   TransactionType = "transfer"
 	TypeDeposit    TransactionType = "deposit"
 	TypeWithdrawal TransactionType = "withdrawal"
 	TypeAdjustment TransactionType = "adjustment"
+	TypeReversal   TransactionType = "reversal"
 
 	EntityTypeUser        = "user"
 	EntityTypeTransaction = "transaction"
 	EntityTypeBalance     = "balance"
-
-	ActionCreate = "create"
-	ActionUpdate = "update"
-	ActionDelete = "delete"
+	EntityTypeHold        = "hold"
+	EntityTypeSchedule    = "schedule"
+	EntityTypeDiscrepancy = "discrepancy"
+	EntityTypeWebhook     = "webhook"
+	EntityTypeLimit       = "spending_limit"
+	EntityTypeApproval    = "approval"
+	EntityTypeAPIKey      = "api_key"
+	EntityTypeRole        = "role"
+
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionReverse = "reverse"
+	ActionApprove = "approve"
+	ActionReject  = "reject"
 )
 
 type User struct {
-	ID           uint           `gorm:"primaryKey;autoIncrement" json:"id"`
-	Username     string         `gorm:"type:varchar(30);uniqueIndex;not null" json:"username"`
-	Email        string         `gorm:"type:varchar(255);uniqueIndex;not null" json:"email"`
-	PasswordHash string         `gorm:"not null" json:"-"`
-	Role         string         `gorm:"not null;default:'user'" json:"role"`
-	Balance      Balance        `gorm:"foreignKey:UserID" json:"balance"`
-	CreatedAt    time.Time      `gorm:"not null" json:"created_at"`
-	UpdatedAt    time.Time      `gorm:"not null" json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	ID              uint           `gorm:"primaryKey;autoIncrement" json:"id"`
+	Username        string         `gorm:"type:varchar(30);uniqueIndex;not null" json:"username"`
+	Email           string         `gorm:"type:varchar(255);uniqueIndex;not null" json:"email"`
+	PasswordHash    string         `gorm:"not null" json:"-"`
+	Role            string         `gorm:"not null;default:'user'" json:"role"`
+	Balances        []Balance      `gorm:"foreignKey:UserID" json:"balances"`
+	EmailVerifiedAt *time.Time     `json:"email_verified_at,omitempty"`
+	CreatedAt       time.Time      `gorm:"not null" json:"created_at"`
+	UpdatedAt       time.Time      `gorm:"not null" json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// IsEmailVerified reports whether the user proved they receive mail at
+// their email address; until then they cannot debit or transfer funds.
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
 }
 
 func (u *User) ValidateUsername() error {
@@ -81,12 +101,10 @@ func (u *User) ValidateEmail() error {
 }
 
 func (u *User) ValidateRole() error {
-	switch u.Role {
-	case RoleUser, RoleAdmin:
-		return nil
-	default:
+	if !IsValidRole(u.Role) {
 		return ErrInvalidRole
 	}
+	return nil
 }
 
 func (u *User) Validate() error {
@@ -122,19 +140,21 @@ func (u *User) BeforeUpdate(tx *gorm.DB) error {
 
 func (u *User) SafeCopy() *User {
 	copy := &User{
-		ID:        u.ID,
-		Username:  u.Username,
-		Email:     u.Email,
-		Role:      u.Role,
-		CreatedAt: u.CreatedAt,
-		UpdatedAt: u.UpdatedAt,
-	}
-	if u.Balance.UserID != 0 {
-		copy.Balance = Balance{
-			UserID:        u.Balance.UserID,
-			Amount:        u.Balance.SafeAmount(),
-			LastUpdatedAt: u.Balance.LastUpdatedAt,
-		}
+		ID:              u.ID,
+		Username:        u.Username,
+		Email:           u.Email,
+		Role:            u.Role,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+	}
+	for i := range u.Balances {
+		copy.Balances = append(copy.Balances, Balance{
+			UserID:        u.Balances[i].UserID,
+			Currency:      u.Balances[i].Currency,
+			Amount:        u.Balances[i].SafeAmount(),
+			LastUpdatedAt: u.Balances[i].LastUpdatedAt,
+		})
 	}
 	return copy
 }
@@ -162,18 +182,35 @@ type TransactionStatus string
 type TransactionType string
 
 type Transaction struct {
-	ID         uint              `gorm:"primaryKey" json:"id"`
-	FromUserID uint              `gorm:"index;not null" json:"from_user_id"`
-	FromUser   User              `gorm:"foreignKey:FromUserID" json:"from_user"`
-	ToUserID   uint              `gorm:"index;not null" json:"to_user_id"`
-	ToUser     User              `gorm:"foreignKey:ToUserID" json:"to_user"`
-	Amount     decimal.Decimal   `gorm:"type:decimal(20,8);not null" json:"amount"`
-	Type       TransactionType   `gorm:"not null" json:"type"`
-	Status     TransactionStatus `gorm:"not null" json:"status"`
-	Notes      string            `gorm:"type:text" json:"notes,omitempty"`
-	CreatedAt  time.Time         `gorm:"not null" json:"created_at"`
-	UpdatedAt  time.Time         `gorm:"not null" json:"updated_at"`
-	DeletedAt  gorm.DeletedAt    `gorm:"index" json:"-"`
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	FromUserID uint            `gorm:"index;index:idx_transactions_from_history,priority:1;not null" json:"from_user_id"`
+	FromUser   User            `gorm:"foreignKey:FromUserID" json:"from_user"`
+	ToUserID   uint            `gorm:"index;index:idx_transactions_to_history,priority:1;not null" json:"to_user_id"`
+	ToUser     User            `gorm:"foreignKey:ToUserID" json:"to_user"`
+	Amount     decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"amount"`
+	Currency   string          `gorm:"type:char(3);not null;default:'USD'" json:"currency"`
+	// Set on cross-currency transfers only
+	ToCurrency string              `gorm:"type:char(3)" json:"to_currency,omitempty"`
+	ToAmount   decimal.NullDecimal `gorm:"type:decimal(20,8)" json:"to_amount,omitempty"`
+	FXRate     decimal.NullDecimal `gorm:"type:decimal(20,10)" json:"fx_rate,omitempty"`
+	FXSpread   decimal.NullDecimal `gorm:"type:decimal(20,8)" json:"fx_spread,omitempty"`
+	FXQuoteID  *string             `gorm:"type:char(36);index" json:"fx_quote_id,omitempty"`
+	Type       TransactionType     `gorm:"not null" json:"type"`
+	Status     TransactionStatus   `gorm:"not null" json:"status"`
+	Notes      string              `gorm:"type:text" json:"notes,omitempty"`
+	CreatedAt  time.Time           `gorm:"not null;index:idx_transactions_from_history,priority:2;index:idx_transactions_to_history,priority:2" json:"created_at"`
+	UpdatedAt  time.Time           `gorm:"not null" json:"updated_at"`
+	DeletedAt  gorm.DeletedAt      `gorm:"index" json:"-"`
+
+	// ReversalOf links a reversal to the transaction it refunds; the original
+	// keeps a running total of what has been refunded.
+	ReversalOf     *uint           `gorm:"index" json:"reversal_of,omitempty"`
+	RefundedAmount decimal.Decimal `gorm:"type:decimal(20,8);not null;default:0" json:"refunded_amount"`
+	Reversals      []Transaction   `gorm:"foreignKey:ReversalOf" json:"reversals,omitempty"`
+
+	// Debit turns an adjustment into a charge against FromUserID instead of
+	// a credit to ToUserID.
+	Debit bool `gorm:"not null;default:false" json:"debit,omitempty"`
 }
 
 func (t *Transaction) Validate() error {
@@ -181,6 +218,10 @@ func (t *Transaction) Validate() error {
 		return ErrInvalidAmount
 	}
 
+	if err := ValidateMoney(t.Amount, t.Currency); err != nil {
+		return err
+	}
+
 	if !t.IsValidType(t.Type) {
 		return ErrInvalidType
 	}
@@ -193,12 +234,49 @@ func (t *Transaction) Validate() error {
 		return errors.New("transfer requires both from and to users")
 	}
 
+	if t.Debit && t.Type != TypeAdjustment {
+		return errors.New("only adjustments can be debits")
+	}
+
+	if t.Type == TypeReversal && t.ReversalOf == nil {
+		return errors.New("reversal requires the original transaction")
+	}
+
+	if t.IsFX() {
+		if t.Type != TypeTransfer && t.Type != TypeReversal {
+			return errors.New("only transfers can convert currency")
+		}
+		if t.ToCurrency == t.Currency {
+			return ErrSameCurrency
+		}
+		if !t.ToAmount.Valid || !t.ToAmount.Decimal.IsPositive() {
+			return ErrInvalidAmount
+		}
+		if err := ValidateMoney(t.ToAmount.Decimal, t.ToCurrency); err != nil {
+			return err
+		}
+		if !t.FXRate.Valid || !t.FXRate.Decimal.IsPositive() {
+			return errors.New("exchange rate is required")
+		}
+	}
+
+	return nil
+}
+
+// IsFX reports whether the recipient is credited in a different currency
+// than the sender is debited in.
+func (t *Transaction) IsFX() bool {
+	return t.ToCurrency != ""
+}
+
+func (t *Transaction) BeforeCreate(tx *gorm.DB) error {
+	t.Currency = NormalizeCurrency(t.Currency)
 	return nil
 }
 
 func (t *Transaction) IsValidType(txType TransactionType) bool {
 	switch txType {
-	case TypeTransfer, TypeDeposit, TypeWithdrawal, TypeAdjustment:
+	case TypeTransfer, TypeDeposit, TypeWithdrawal, TypeAdjustment, TypeReversal:
 		return true
 	default:
 		return false
@@ -207,7 +285,7 @@ func (t *Transaction) IsValidType(txType TransactionType) bool {
 
 func (t *Transaction) IsValidStatus(status TransactionStatus) bool {
 	switch status {
-	case StatusPending, StatusCompleted, StatusFailed, StatusCancelled:
+	case StatusPending, StatusCompleted, StatusFailed, StatusCancelled, StatusReview:
 		return true
 	default:
 		return false
@@ -265,7 +343,9 @@ func (t *Transaction) UnmarshalJSON(data []byte) error {
 
 type Balance struct {
 	UserID        uint            `gorm:"primaryKey" json:"user_id"`
+	Currency      string          `gorm:"primaryKey;type:char(3);default:'USD'" json:"currency"`
 	Amount        decimal.Decimal `gorm:"type:decimal(20,8);not null;default:0" json:"amount"`
+	Held          decimal.Decimal `gorm:"-" json:"held"`
 	LastUpdatedAt time.Time       `gorm:"not null" json:"last_updated_at"`
 	UpdatedAt     time.Time       `gorm:"not null" json:"updated_at"`
 	CreatedAt     time.Time       `gorm:"not null" json:"created_at"`
@@ -280,10 +360,14 @@ func (b *Balance) Validate() error {
 	if b.UserID == 0 {
 		return errors.New("user ID is required")
 	}
+	if _, err := LookupCurrency(b.Currency); err != nil {
+		return err
+	}
 	return nil
 }
 
 func (b *Balance) BeforeCreate(tx *gorm.DB) error {
+	b.Currency = NormalizeCurrency(b.Currency)
 	if err := b.Validate(); err != nil {
 		return err
 	}
@@ -305,6 +389,13 @@ func (b *Balance) SafeAmount() decimal.Decimal {
 	return b.Amount
 }
 
+// Available is the ledger amount less the funds reserved by active holds.
+func (b *Balance) Available() decimal.Decimal {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.Amount.Sub(b.Held)
+}
+
 func (b *Balance) UpdateAmount(amount decimal.Decimal) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -349,12 +440,14 @@ func (b *Balance) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
 		*Alias
 		Amount        string `json:"amount"`
+		Available     string `json:"available"`
 		LastUpdatedAt string `json:"last_updated_at"`
 		CreatedAt     string `json:"created_at"`
 		UpdatedAt     string `json:"updated_at"`
 	}{
 		Alias:         (*Alias)(b),
 		Amount:        b.SafeAmount().String(),
+		Available:     b.Available().String(),
 		LastUpdatedAt: b.LastUpdatedAt.Format(time.RFC3339),
 		CreatedAt:     b.CreatedAt.Format(time.RFC3339),
 		UpdatedAt:     b.UpdatedAt.Format(time.RFC3339),
@@ -429,14 +522,14 @@ func (a *AuditLog) Validate() error {
 	}
 
 	switch a.EntityType {
-	case EntityTypeUser, EntityTypeTransaction, EntityTypeBalance:
+	case EntityTypeUser, EntityTypeTransaction, EntityTypeBalance, EntityTypeHold, EntityTypeSchedule, EntityTypeDiscrepancy, EntityTypeWebhook, EntityTypeLimit, EntityTypeApproval, EntityTypeAPIKey, EntityTypeRole:
 		// valid entity type
 	default:
 		return errors.New("invalid entity type")
 	}
 
 	switch a.Action {
-	case ActionCreate, ActionUpdate, ActionDelete:
+	case ActionCreate, ActionUpdate, ActionDelete, ActionReverse, ActionApprove, ActionReject:
 		// valid action
 	default:
 		return errors.New("invalid action")
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Ledger mutations published on the event stream.
+const (
+	EventTransactionCreated = "transaction.created"
+	EventTransactionUpdated = "transaction.updated"
+	EventBalanceCreated     = "balance.created"
+	EventBalanceUpdated     = "balance.updated"
+	EventAuditLogCreated    = "audit_log.created"
+)
+
+// OutboxEvent is a ledger mutation waiting to be published. Events are
+// written by the hooks of Transaction, Balance and AuditLog in the database
+// transaction that makes the change, so they commit or roll back with it.
+// AccountID is the user the event belongs to and orders the stream: the
+// owner of a balance, the sender of a transaction and the actor of an audit
+// entry.
+type OutboxEvent struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	Type        string          `gorm:"type:varchar(50);not null" json:"type"`
+	AccountID   uint            `gorm:"not null;index" json:"account_id"`
+	EntityID    string          `gorm:"type:varchar(50);not null" json:"entity_id"`
+	Payload     json.RawMessage `gorm:"type:text;not null" json:"payload"`
+	CreatedAt   time.Time       `gorm:"not null" json:"created_at"`
+	PublishedAt *time.Time      `gorm:"index" json:"published_at,omitempty"`
+}
+
+func (e *OutboxEvent) TableName() string {
+	return "outbox_events"
+}
+
+// BalanceData is a balance as published to the event stream.
+type BalanceData struct {
+	UserID        uint            `json:"user_id"`
+	Currency      string          `json:"currency"`
+	Amount        decimal.Decimal `json:"amount"`
+	TransactionID *uint           `json:"transaction_id,omitempty"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// AuditLogData is an audit entry as published to the event stream.
+type AuditLogData struct {
+	ID         uint      `json:"id"`
+	EntityType string    `json:"entity_type"`
+	EntityID   uint      `json:"entity_id"`
+	Action     string    `json:"action"`
+	Details    string    `json:"details"`
+	UserID     uint      `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// appendOutbox records an event in the database transaction of the hook
+// that raised it.
+func appendOutbox(tx *gorm.DB, eventType string, accountID uint, entityID string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+
+	event := &OutboxEvent{
+		Type:      eventType,
+		AccountID: accountID,
+		EntityID:  entityID,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Session(&gorm.Session{NewDB: true}).Create(event).Error; err != nil {
+		return fmt.Errorf("failed to write %s event to the outbox: %w", eventType, err)
+	}
+	return nil
+}
+
+func (t *Transaction) AfterCreate(tx *gorm.DB) error {
+	return appendOutbox(tx, EventTransactionCreated, t.accountID(), fmt.Sprint(t.ID), NewTransactionData(t))
+}
+
+func (t *Transaction) AfterUpdate(tx *gorm.DB) error {
+	return appendOutbox(tx, EventTransactionUpdated, t.accountID(), fmt.Sprint(t.ID), NewTransactionData(t))
+}
+
+// accountID is the user whose stream a transaction is published on: the
+// sender, or the recipient of a deposit.
+func (t *Transaction) accountID() uint {
+	if t.FromUserID != 0 {
+		return t.FromUserID
+	}
+	return t.ToUserID
+}
+
+func (b *Balance) AfterCreate(tx *gorm.DB) error {
+	return appendOutbox(tx, EventBalanceCreated, b.UserID, b.entityID(), b.eventData(tx))
+}
+
+func (b *Balance) AfterUpdate(tx *gorm.DB) error {
+	return appendOutbox(tx, EventBalanceUpdated, b.UserID, b.entityID(), b.eventData(tx))
+}
+
+func (b *Balance) entityID() string {
+	return fmt.Sprintf("%d:%s", b.UserID, b.Currency)
+}
+
+func (b *Balance) eventData(tx *gorm.DB) BalanceData {
+	data := BalanceData{
+		UserID:    b.UserID,
+		Currency:  b.Currency,
+		Amount:    b.SafeAmount(),
+		UpdatedAt: b.LastUpdatedAt,
+	}
+	if transactionID, ok := TransactionIDFromContext(tx.Statement.Context); ok {
+		data.TransactionID = &transactionID
+	}
+	return data
+}
+
+func (a *AuditLog) AfterCreate(tx *gorm.DB) error {
+	return appendOutbox(tx, EventAuditLogCreated, a.UserID, fmt.Sprint(a.ID), AuditLogData{
+		ID:         a.ID,
+		EntityType: a.EntityType,
+		EntityID:   a.EntityID,
+		Action:     a.Action,
+		Details:    a.Details,
+		UserID:     a.UserID,
+		CreatedAt:  a.CreatedAt,
+	})
+}
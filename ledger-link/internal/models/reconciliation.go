@@ -0,0 +1,87 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type DiscrepancyStatus string
+
+const (
+	DiscrepancyOpen     DiscrepancyStatus = "open"
+	DiscrepancyRepaired DiscrepancyStatus = "repaired"
+)
+
+// What started a reconciliation run.
+const (
+	ReconciliationTriggerCLI       = "cli"
+	ReconciliationTriggerScheduled = "scheduled"
+	ReconciliationTriggerAPI       = "api"
+)
+
+var (
+	ErrDiscrepancyRepaired = errors.New("discrepancy has already been repaired")
+	ErrDiscrepancyChanged  = errors.New("balance no longer matches the discrepancy; run reconciliation again")
+)
+
+// ReconciliationRun is one pass recomputing every balance from the
+// completed transactions behind it.
+type ReconciliationRun struct {
+	ID              uint          `gorm:"primaryKey" json:"id"`
+	Trigger         string        `gorm:"type:varchar(20);not null" json:"trigger"`
+	StartedAt       time.Time     `gorm:"not null;index" json:"started_at"`
+	FinishedAt      time.Time     `gorm:"not null" json:"finished_at"`
+	BalancesChecked int           `gorm:"not null" json:"balances_checked"`
+	Discrepancies   []Discrepancy `gorm:"foreignKey:RunID" json:"discrepancies"`
+}
+
+func (r *ReconciliationRun) TableName() string {
+	return "reconciliation_runs"
+}
+
+// Discrepancy is a balance whose figures disagree. ComputedBalance is what
+// the completed transactions add up to; the stored balance, the user's
+// postings and the last history entry should all equal it. Offending
+// transactions are those whose postings differ from what the transaction
+// should have posted, and unfinished transactions that left postings or
+// balance history behind.
+type Discrepancy struct {
+	ID                    uint                `gorm:"primaryKey" json:"id"`
+	RunID                 uint                `gorm:"not null;index" json:"run_id"`
+	UserID                uint                `gorm:"not null;index" json:"user_id"`
+	Currency              string              `gorm:"type:char(3);not null" json:"currency"`
+	StoredBalance         decimal.Decimal     `gorm:"type:decimal(20,8);not null" json:"stored_balance"`
+	ComputedBalance       decimal.Decimal     `gorm:"type:decimal(20,8);not null" json:"computed_balance"`
+	PostedBalance         decimal.Decimal     `gorm:"type:decimal(20,8);not null" json:"posted_balance"`
+	HistoryBalance        decimal.NullDecimal `gorm:"type:decimal(20,8)" json:"history_balance"`
+	Difference            decimal.Decimal     `gorm:"type:decimal(20,8);not null" json:"difference"`
+	OffendingTransactions []uint              `gorm:"serializer:json;type:text" json:"offending_transactions"`
+	Status                DiscrepancyStatus   `gorm:"type:varchar(20);not null;index" json:"status"`
+	RepairTransactionID   *uint               `json:"repair_transaction_id,omitempty"`
+	RepairedBy            *uint               `json:"repaired_by,omitempty"`
+	RepairedAt            *time.Time          `json:"repaired_at,omitempty"`
+	CreatedAt             time.Time           `gorm:"not null" json:"created_at"`
+	UpdatedAt             time.Time           `gorm:"not null" json:"updated_at"`
+}
+
+func (d *Discrepancy) TableName() string {
+	return "reconciliation_discrepancies"
+}
+
+// HistoryDrift reports whether the last balance history entry disagrees
+// with the stored balance.
+func (d *Discrepancy) HistoryDrift() bool {
+	return d.HistoryBalance.Valid && !d.HistoryBalance.Decimal.Equal(d.StoredBalance)
+}
+
+// Matches reports whether other found the same drift in the same balance.
+func (d *Discrepancy) Matches(other *Discrepancy) bool {
+	return d.UserID == other.UserID &&
+		d.Currency == other.Currency &&
+		d.StoredBalance.Equal(other.StoredBalance) &&
+		d.ComputedBalance.Equal(other.ComputedBalance) &&
+		d.HistoryBalance.Valid == other.HistoryBalance.Valid &&
+		d.HistoryBalance.Decimal.Equal(other.HistoryBalance.Decimal)
+}
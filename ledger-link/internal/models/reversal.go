@@ -0,0 +1,120 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrNotReversible         = errors.New("transaction cannot be reversed")
+	ErrRefundExceedsOriginal = errors.New("refund exceeds the amount left to refund")
+	ErrReversalWindowClosed  = errors.New("reversal window has closed")
+)
+
+// RefundableAmount is what is left of the transaction after earlier refunds,
+// in the transaction's currency.
+func (t *Transaction) RefundableAmount() decimal.Decimal {
+	return t.Amount.Sub(t.RefundedAmount)
+}
+
+// CheckReversal reports whether amount, in the transaction's currency, can
+// still be refunded. Conversions are only reversed in full, at their original
+// rate.
+func (t *Transaction) CheckReversal(amount decimal.Decimal) error {
+	switch t.Type {
+	case TypeDeposit, TypeWithdrawal, TypeTransfer:
+	default:
+		return fmt.Errorf("%w: %s transactions are not reversible", ErrNotReversible, t.Type)
+	}
+	if t.Status != StatusCompleted {
+		return fmt.Errorf("%w: transaction is %s", ErrNotReversible, t.Status)
+	}
+	if !amount.IsPositive() {
+		return ErrInvalidAmount
+	}
+	if err := ValidateMoney(amount, t.Currency); err != nil {
+		return err
+	}
+	if amount.GreaterThan(t.RefundableAmount()) {
+		return fmt.Errorf("%w: %s %s left", ErrRefundExceedsOriginal, t.RefundableAmount(), t.Currency)
+	}
+	if t.IsFX() && !amount.Equal(t.Amount) {
+		return fmt.Errorf("%w: conversions can only be reversed in full", ErrNotReversible)
+	}
+	return nil
+}
+
+// NewReversal builds the compensating transaction that refunds amount of
+// original. Money flows back from the original recipient to the original
+// sender, so a conversion is unwound with its currencies swapped.
+func NewReversal(original *Transaction, amount decimal.Decimal, notes string) (*Transaction, error) {
+	if err := original.CheckReversal(amount); err != nil {
+		return nil, err
+	}
+
+	reversal := &Transaction{
+		FromUserID: original.ToUserID,
+		ToUserID:   original.FromUserID,
+		Amount:     amount,
+		Currency:   original.Currency,
+		Type:       TypeReversal,
+		Status:     StatusPending,
+		Notes:      notes,
+		ReversalOf: &original.ID,
+	}
+	if original.IsFX() {
+		reversal.Amount, reversal.Currency = original.ToAmount.Decimal, original.ToCurrency
+		reversal.ToAmount, reversal.ToCurrency = decimal.NewNullDecimal(original.Amount), original.Currency
+		reversal.FXRate = original.FXRate
+	}
+	return reversal, nil
+}
+
+// RefundAmount is the part of the original transaction, in the original's
+// currency, that the reversal gives back.
+func (t *Transaction) RefundAmount() decimal.Decimal {
+	if t.Type == TypeReversal && t.IsFX() {
+		return t.ToAmount.Decimal
+	}
+	return t.Amount
+}
+
+// BuildReversalPostings mirrors the postings of the original transaction.
+// Every line of a single-currency transaction carries the full amount, so a
+// partial refund mirrors each line at the refunded amount instead.
+func BuildReversalPostings(original Postings, reversal *Transaction) (Postings, error) {
+	if reversal.ID == 0 {
+		return nil, errors.New("transaction must be persisted before posting")
+	}
+	if reversal.Type != TypeReversal {
+		return nil, ErrInvalidType
+	}
+
+	now := time.Now()
+	postings := make(Postings, 0, len(original))
+	for _, p := range original {
+		amount := p.Amount.Neg()
+		if !reversal.IsFX() {
+			amount = reversal.Amount
+			if p.IsCredit() {
+				amount = amount.Neg()
+			}
+		}
+		postings = append(postings, Posting{
+			TransactionID: reversal.ID,
+			AccountType:   p.AccountType,
+			AccountID:     p.AccountID,
+			Currency:      p.Currency,
+			Amount:        amount,
+			CreatedAt:     now,
+		})
+	}
+
+	if err := postings.Validate(); err != nil {
+		return nil, err
+	}
+	return postings, nil
+}
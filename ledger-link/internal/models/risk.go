@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RiskDecision is what the risk rules make of a transaction.
+type RiskDecision string
+
+const (
+	DecisionAllow  RiskDecision = "allow"
+	DecisionReview RiskDecision = "review"
+	DecisionDeny   RiskDecision = "deny"
+)
+
+type RiskReviewStatus string
+
+const (
+	RiskReviewPending  RiskReviewStatus = "pending"
+	RiskReviewApproved RiskReviewStatus = "approved"
+	RiskReviewRejected RiskReviewStatus = "rejected"
+)
+
+var (
+	ErrRiskDenied       = errors.New("transaction denied by risk rules")
+	ErrHeldForReview    = errors.New("transaction held for review")
+	ErrReviewResolved   = errors.New("review has already been resolved")
+	ErrInvalidRiskRules = errors.New("invalid risk rules")
+)
+
+// RiskAssessment is the decision on a transaction and the reasons for it,
+// one for each rule that matched.
+type RiskAssessment struct {
+	Decision RiskDecision `json:"decision"`
+	Reasons  []string     `json:"reasons"`
+}
+
+// RiskHistory is what an evaluator knows of the sender: their account and
+// the debits and transfers they made since Since, newest first. Failed
+// transactions are left out.
+type RiskHistory struct {
+	Account *User
+	Recent  []Transaction
+	Since   time.Time
+}
+
+// RiskEvaluator decides whether a pending debit or transfer may run, is
+// denied, or waits for an admin to review it. HistoryWindow is how far back
+// the history it is given must reach.
+type RiskEvaluator interface {
+	Evaluate(ctx context.Context, tx *Transaction, history RiskHistory) (RiskAssessment, error)
+	HistoryWindow() time.Duration
+}
+
+// RiskReview is a transaction the risk rules held in review status until an
+// admin approves it, which executes it, or rejects it, which fails it.
+type RiskReview struct {
+	ID            uint             `gorm:"primaryKey" json:"id"`
+	TransactionID uint             `gorm:"not null;uniqueIndex" json:"transaction_id"`
+	UserID        uint             `gorm:"not null;index" json:"user_id"`
+	Reasons       []string         `gorm:"serializer:json;type:text" json:"reasons"`
+	Status        RiskReviewStatus `gorm:"type:varchar(20);not null;index" json:"status"`
+	ReviewedBy    *uint            `json:"reviewed_by,omitempty"`
+	ReviewedAt    *time.Time       `json:"reviewed_at,omitempty"`
+	Note          string           `gorm:"type:text" json:"note,omitempty"`
+	CreatedAt     time.Time        `gorm:"not null" json:"created_at"`
+	UpdatedAt     time.Time        `gorm:"not null" json:"updated_at"`
+}
+
+func (r *RiskReview) TableName() string {
+	return "risk_reviews"
+}
+
+// RiskError is a debit or transfer the risk rules stopped: denied and
+// recorded as failed, or held for review.
+type RiskError struct {
+	Decision      RiskDecision `json:"decision"`
+	TransactionID uint         `json:"transaction_id"`
+	Reasons       []string     `json:"reasons"`
+}
+
+func (e *RiskError) Error() string {
+	err := ErrRiskDenied
+	if e.Decision == DecisionReview {
+		err = ErrHeldForReview
+	}
+	return fmt.Sprintf("%s: %s", err, strings.Join(e.Reasons, "; "))
+}
+
+func (e *RiskError) Is(target error) bool {
+	if e.Decision == DecisionReview {
+		return target == ErrHeldForReview
+	}
+	return target == ErrRiskDenied
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Staff roles beside RoleUser and RoleAdmin. What they may do is set by the
+// permissions granted to them in role_permissions.
+const (
+	RoleSupport  = "support"
+	RoleAuditor  = "auditor"
+	RoleOperator = "operator"
+)
+
+// Permissions a role can be granted. Admins hold all of them.
+const (
+	// PermissionAccountsRead reads any account: its user, transactions,
+	// balances, holds and schedules
+	PermissionAccountsRead = "accounts:read"
+	// PermissionAccountsWrite captures and voids the holds and cancels the
+	// schedules of any account
+	PermissionAccountsWrite = "accounts:write"
+	// PermissionStatementsRead generates statements for any account
+	PermissionStatementsRead = "statements:read"
+	// PermissionAuditLogsRead reads the audit log
+	PermissionAuditLogsRead = "audit_logs:read"
+	// PermissionAdjustmentsCreate makes admin adjustments and transfers,
+	// subject to approval above the threshold
+	PermissionAdjustmentsCreate = "adjustments:create"
+	// PermissionTransactionsReverse reverses any transaction, outside the
+	// sender's reversal window
+	PermissionTransactionsReverse = "transactions:reverse"
+	// PermissionRolesManage assigns roles to users and permissions to roles
+	PermissionRolesManage = "roles:manage"
+	// PermissionReviewsDecide lists and decides transactions held for risk
+	// review
+	PermissionReviewsDecide = "reviews:decide"
+	// PermissionApprovalsDecide lists and decides pending adjustments and
+	// transfers; nobody decides their own
+	PermissionApprovalsDecide = "approvals:decide"
+	// PermissionLimitsManage sets and removes spending limits
+	PermissionLimitsManage = "limits:manage"
+	// PermissionLockoutsManage lists and lifts login lockouts
+	PermissionLockoutsManage = "lockouts:manage"
+	// PermissionReconciliationsManage runs reconciliations and repairs the
+	// discrepancies they find
+	PermissionReconciliationsManage = "reconciliations:manage"
+	// PermissionLedgerCheck checks the journal invariants
+	PermissionLedgerCheck = "ledger:check"
+)
+
+var ErrInvalidPermission = errors.New("invalid permission")
+
+// Roles are the roles a user can have.
+var Roles = []string{RoleUser, RoleAdmin, RoleSupport, RoleAuditor, RoleOperator}
+
+// Permissions are the permissions a role can be granted.
+var Permissions = []string{
+	PermissionAccountsRead,
+	PermissionAccountsWrite,
+	PermissionStatementsRead,
+	PermissionAuditLogsRead,
+	PermissionAdjustmentsCreate,
+	PermissionTransactionsReverse,
+	PermissionRolesManage,
+	PermissionReviewsDecide,
+	PermissionApprovalsDecide,
+	PermissionLimitsManage,
+	PermissionLockoutsManage,
+	PermissionReconciliationsManage,
+	PermissionLedgerCheck,
+}
+
+// DefaultRolePermissions are what each staff role holds until an admin
+// changes it.
+var DefaultRolePermissions = map[string][]string{
+	RoleUser:     {},
+	RoleSupport:  {PermissionAccountsRead, PermissionStatementsRead},
+	RoleAuditor:  {PermissionAuditLogsRead, PermissionStatementsRead},
+	RoleOperator: {PermissionAdjustmentsCreate, PermissionTransactionsReverse},
+}
+
+// Role holds the permissions granted to every user with the role. A role
+// without a row holds its DefaultRolePermissions.
+type Role struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"type:varchar(20);not null;uniqueIndex" json:"name"`
+	Permissions []string  `gorm:"serializer:json;type:text" json:"permissions"`
+	UpdatedBy   uint      `json:"updated_by,omitempty"`
+	CreatedAt   time.Time `gorm:"not null" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"not null" json:"updated_at"`
+}
+
+func (r *Role) TableName() string {
+	return "roles"
+}
+
+// HasPermission reports whether the role was granted permission.
+func (r *Role) HasPermission(permission string) bool {
+	return r.Name == RoleAdmin || containsString(r.Permissions, permission)
+}
+
+// IsValidRole reports whether role is one of Roles.
+func IsValidRole(role string) bool {
+	return containsString(Roles, role)
+}
+
+// ValidatePermissions checks that a non-admin role is granted only known
+// permissions. Admins hold every permission and cannot be changed.
+func ValidatePermissions(role string, permissions []string) error {
+	if !IsValidRole(role) {
+		return ErrInvalidRole
+	}
+	if role == RoleAdmin {
+		return fmt.Errorf("%w: admins hold every permission", ErrInvalidPermission)
+	}
+	for _, permission := range permissions {
+		if !containsString(Permissions, permission) {
+			return fmt.Errorf("%w: unknown permission %q", ErrInvalidPermission, permission)
+		}
+	}
+	return nil
+}
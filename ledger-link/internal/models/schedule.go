@@ -0,0 +1,256 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"ledger-link/pkg/cron"
+)
+
+type ScheduleKind string
+
+const (
+	ScheduleKindOnce     ScheduleKind = "once"
+	ScheduleKindInterval ScheduleKind = "interval"
+	ScheduleKindCron     ScheduleKind = "cron"
+)
+
+type ScheduleStatus string
+
+const (
+	ScheduleStatusActive    ScheduleStatus = "active"
+	ScheduleStatusCompleted ScheduleStatus = "completed"
+	ScheduleStatusCancelled ScheduleStatus = "cancelled"
+	ScheduleStatusFailed    ScheduleStatus = "failed"
+)
+
+// FailurePolicy decides what happens to an occurrence whose transfer fails.
+// Retry tries it again after a delay, up to MaxRetries times, and then skips
+// it; skip moves straight on to the next occurrence.
+type FailurePolicy string
+
+const (
+	FailurePolicyRetry FailurePolicy = "retry"
+	FailurePolicySkip  FailurePolicy = "skip"
+)
+
+// ScheduleRunStatus is the outcome of a run. A held run's transfer waits
+// for a risk review; the occurrence is done with either way.
+type ScheduleRunStatus string
+
+const (
+	ScheduleRunSucceeded ScheduleRunStatus = "succeeded"
+	ScheduleRunHeld      ScheduleRunStatus = "held"
+	ScheduleRunRetrying  ScheduleRunStatus = "retrying"
+	ScheduleRunSkipped   ScheduleRunStatus = "skipped"
+	ScheduleRunFailed    ScheduleRunStatus = "failed"
+)
+
+const (
+	MinScheduleInterval = time.Minute
+	MaxScheduleRetries  = 10
+)
+
+var (
+	ErrInvalidSchedule   = errors.New("invalid schedule")
+	ErrScheduleNotActive = errors.New("schedule is no longer active")
+)
+
+// Schedule is a standing order: a transfer from UserID to ToUserID that runs
+// once at StartAt, or repeatedly every IntervalSeconds or on CronExpr from
+// StartAt until EndAt. Cron expressions are evaluated in UTC.
+type Schedule struct {
+	ID              uint            `gorm:"primaryKey" json:"id"`
+	UserID          uint            `gorm:"not null;index" json:"user_id"`
+	ToUserID        uint            `gorm:"not null" json:"to_user_id"`
+	Amount          decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"amount"`
+	Currency        string          `gorm:"type:char(3);not null" json:"currency"`
+	Notes           string          `gorm:"type:text" json:"notes,omitempty"`
+	Kind            ScheduleKind    `gorm:"type:varchar(10);not null" json:"kind"`
+	IntervalSeconds int64           `json:"interval_seconds,omitempty"`
+	CronExpr        string          `gorm:"type:varchar(100)" json:"cron,omitempty"`
+	StartAt         time.Time       `gorm:"not null" json:"start_at"`
+	EndAt           *time.Time      `json:"end_at,omitempty"`
+	FailurePolicy   FailurePolicy   `gorm:"type:varchar(10);not null" json:"failure_policy"`
+	MaxRetries      int             `gorm:"not null;default:0" json:"max_retries"`
+	Status          ScheduleStatus  `gorm:"type:varchar(10);not null;index:idx_schedules_due" json:"status"`
+	NextRunAt       *time.Time      `gorm:"index:idx_schedules_due" json:"next_run_at,omitempty"`
+	Attempts        int             `gorm:"not null;default:0" json:"attempts"`
+	LastRunAt       *time.Time      `json:"last_run_at,omitempty"`
+	LastError       string          `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedAt       time.Time       `gorm:"not null" json:"created_at"`
+	UpdatedAt       time.Time       `gorm:"not null" json:"updated_at"`
+}
+
+func (s *Schedule) TableName() string {
+	return "schedules"
+}
+
+// ScheduleRun records one attempt at an occurrence of a schedule and what
+// the failure policy made of it.
+type ScheduleRun struct {
+	ID            uint              `gorm:"primaryKey" json:"id"`
+	ScheduleID    uint              `gorm:"not null;index" json:"schedule_id"`
+	ScheduledFor  time.Time         `gorm:"not null" json:"scheduled_for"`
+	Attempt       int               `gorm:"not null" json:"attempt"`
+	Status        ScheduleRunStatus `gorm:"type:varchar(10);not null" json:"status"`
+	TransactionID *uint             `json:"transaction_id,omitempty"`
+	Error         string            `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt     time.Time         `gorm:"not null" json:"created_at"`
+}
+
+func (r *ScheduleRun) TableName() string {
+	return "schedule_runs"
+}
+
+func (s *Schedule) Validate() error {
+	if s.UserID == 0 || s.ToUserID == 0 {
+		return fmt.Errorf("%w: sender and recipient are required", ErrInvalidSchedule)
+	}
+	if s.UserID == s.ToUserID {
+		return fmt.Errorf("%w: cannot schedule a transfer to yourself", ErrInvalidSchedule)
+	}
+	if !s.Amount.IsPositive() {
+		return ErrInvalidAmount
+	}
+	if err := ValidateMoney(s.Amount, s.Currency); err != nil {
+		return err
+	}
+
+	switch s.Kind {
+	case ScheduleKindOnce:
+		if s.IntervalSeconds != 0 || s.CronExpr != "" || s.EndAt != nil {
+			return fmt.Errorf("%w: one-off schedules take neither a recurrence nor an end date", ErrInvalidSchedule)
+		}
+	case ScheduleKindInterval:
+		if s.CronExpr != "" {
+			return fmt.Errorf("%w: use either an interval or a cron expression", ErrInvalidSchedule)
+		}
+		if time.Duration(s.IntervalSeconds)*time.Second < MinScheduleInterval {
+			return fmt.Errorf("%w: interval must be at least %s", ErrInvalidSchedule, MinScheduleInterval)
+		}
+	case ScheduleKindCron:
+		if s.IntervalSeconds != 0 {
+			return fmt.Errorf("%w: use either an interval or a cron expression", ErrInvalidSchedule)
+		}
+		if _, err := cron.Parse(s.CronExpr); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidSchedule, s.Kind)
+	}
+
+	if s.StartAt.IsZero() {
+		return fmt.Errorf("%w: start time is required", ErrInvalidSchedule)
+	}
+	if s.EndAt != nil && !s.EndAt.After(s.StartAt) {
+		return fmt.Errorf("%w: end date must be after the start", ErrInvalidSchedule)
+	}
+
+	switch s.FailurePolicy {
+	case FailurePolicyRetry, FailurePolicySkip:
+	default:
+		return fmt.Errorf("%w: unknown failure policy %q", ErrInvalidSchedule, s.FailurePolicy)
+	}
+	if s.MaxRetries < 0 || s.MaxRetries > MaxScheduleRetries {
+		return fmt.Errorf("%w: max_retries must be between 0 and %d", ErrInvalidSchedule, MaxScheduleRetries)
+	}
+	if s.FailurePolicy == FailurePolicySkip && s.MaxRetries != 0 {
+		return fmt.Errorf("%w: max_retries only applies to the retry policy", ErrInvalidSchedule)
+	}
+	return nil
+}
+
+// FirstRun is the first occurrence at or after StartAt, or nil when the
+// schedule has none before its end date.
+func (s *Schedule) FirstRun() *time.Time {
+	switch s.Kind {
+	case ScheduleKindOnce, ScheduleKindInterval:
+		return s.bounded(s.StartAt)
+	default:
+		return s.NextRunAfter(s.StartAt.Add(-time.Nanosecond))
+	}
+}
+
+// NextRunAfter is the first occurrence strictly after t, or nil when there
+// is none before the end date. Occurrences missed while t was in the past
+// are not made up for.
+func (s *Schedule) NextRunAfter(t time.Time) *time.Time {
+	switch s.Kind {
+	case ScheduleKindInterval:
+		interval := time.Duration(s.IntervalSeconds) * time.Second
+		if t.Before(s.StartAt) {
+			return s.bounded(s.StartAt)
+		}
+		n := t.Sub(s.StartAt)/interval + 1
+		return s.bounded(s.StartAt.Add(n * interval))
+	case ScheduleKindCron:
+		expr, err := cron.Parse(s.CronExpr)
+		if err != nil {
+			return nil
+		}
+		next := expr.Next(t.UTC())
+		if next.IsZero() {
+			return nil
+		}
+		return s.bounded(next)
+	default:
+		return nil
+	}
+}
+
+func (s *Schedule) bounded(t time.Time) *time.Time {
+	if s.EndAt != nil && t.After(*s.EndAt) {
+		return nil
+	}
+	return &t
+}
+
+// IsDue reports whether the occurrence at NextRunAt should run at now.
+func (s *Schedule) IsDue(now time.Time) bool {
+	return s.Status == ScheduleStatusActive && s.NextRunAt != nil && !s.NextRunAt.After(now)
+}
+
+// RecordSuccess moves the schedule on to its next occurrence after a
+// successful run at now.
+func (s *Schedule) RecordSuccess(now time.Time) {
+	s.Attempts = 0
+	s.LastRunAt = &now
+	s.LastError = ""
+	s.advance(now)
+}
+
+// RecordFailure applies the failure policy to a failed run at now and
+// returns the status of the run. A retry that would land after the next
+// regular occurrence is skipped instead.
+func (s *Schedule) RecordFailure(now time.Time, cause error, retryDelay time.Duration) ScheduleRunStatus {
+	s.Attempts++
+	s.LastRunAt = &now
+	s.LastError = cause.Error()
+
+	if s.FailurePolicy == FailurePolicyRetry && s.Attempts <= s.MaxRetries {
+		retryAt := now.Add(retryDelay * time.Duration(s.Attempts))
+		if next := s.NextRunAfter(now); next == nil || retryAt.Before(*next) {
+			s.NextRunAt = &retryAt
+			return ScheduleRunRetrying
+		}
+	}
+
+	s.Attempts = 0
+	s.advance(now)
+	if s.Status == ScheduleStatusCompleted && s.Kind == ScheduleKindOnce {
+		s.Status = ScheduleStatusFailed
+		return ScheduleRunFailed
+	}
+	return ScheduleRunSkipped
+}
+
+func (s *Schedule) advance(now time.Time) {
+	s.NextRunAt = s.NextRunAfter(now)
+	if s.NextRunAt == nil {
+		s.Status = ScheduleStatusCompleted
+	}
+}
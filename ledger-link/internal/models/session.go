@@ -0,0 +1,101 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+// TokenPair is what a login, registration or refresh hands the client: a
+// short-lived access token and the opaque refresh token that renews it.
+type TokenPair struct {
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	SessionID        uint      `json:"session_id"`
+}
+
+// Session is one login of a user. Its refresh token rotates on every
+// refresh; AccessJTI is the ID of the latest access token issued to it, so
+// revoking the session also revokes that token.
+type Session struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"not null;index" json:"user_id"`
+	UserAgent       string     `gorm:"type:varchar(255)" json:"user_agent,omitempty"`
+	IPAddress       string     `gorm:"type:varchar(45)" json:"ip_address,omitempty"`
+	AccessJTI       string     `gorm:"type:char(36)" json:"-"`
+	AccessExpiresAt time.Time  `json:"-"`
+	LastUsedAt      time.Time  `gorm:"not null" json:"last_used_at"`
+	ExpiresAt       time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt       time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"not null" json:"updated_at"`
+
+	// Current marks the session of the token the listing was made with
+	Current bool `gorm:"-" json:"current,omitempty"`
+}
+
+func (s *Session) TableName() string {
+	return "sessions"
+}
+
+// IsActive reports whether the session can still be refreshed.
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// RefreshToken is one refresh token of a session, stored by the SHA-256 of
+// its value. A token is used once; presenting a used token again means it
+// leaked, and its session is revoked.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	SessionID uint       `gorm:"not null;index" json:"session_id"`
+	TokenHash string     `gorm:"type:char(64);not null;uniqueIndex" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	CreatedAt time.Time  `gorm:"not null" json:"created_at"`
+}
+
+func (t *RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RevokedToken is an access token revoked before it expires, kept until it
+// would have expired anyway.
+type RevokedToken struct {
+	JTI       string    `gorm:"type:char(36);primaryKey" json:"jti"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
+
+func (t *RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// NewOpaqueToken returns a random URL-safe token and the hash it is stored
+// under.
+func NewOpaqueToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken returns the hex SHA-256 an opaque token is stored under.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// BalanceSnapshot is a user's balance in one currency as of the start of a
+// UTC day. Balances at other times are found by replaying the history
+// recorded after the latest snapshot.
+type BalanceSnapshot struct {
+	ID        uint            `gorm:"primarykey" json:"id"`
+	UserID    uint            `gorm:"not null;uniqueIndex:idx_balance_snapshots_balance" json:"user_id"`
+	Currency  string          `gorm:"type:char(3);not null;uniqueIndex:idx_balance_snapshots_balance" json:"currency"`
+	AsOf      time.Time       `gorm:"not null;index;uniqueIndex:idx_balance_snapshots_balance" json:"as_of"`
+	Amount    decimal.Decimal `gorm:"type:decimal(20,8);not null" json:"amount"`
+	CreatedAt time.Time       `gorm:"not null" json:"created_at"`
+}
+
+func (s *BalanceSnapshot) TableName() string {
+	return "balance_snapshots"
+}
+
+// SnapshotDay returns the start of the UTC day containing t, which is where
+// the snapshot covering t is taken.
+func SnapshotDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
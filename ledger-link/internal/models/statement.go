@@ -0,0 +1,42 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrStatementMismatch = errors.New("statement does not reconcile with the balance")
+
+// Statement summarizes one balance over the period (From, To]. The opening
+// balance is the balance at From and the closing balance the balance at To,
+// as reported by BalanceService.GetBalanceAtTime.
+type Statement struct {
+	UserID         uint            `json:"user_id"`
+	Currency       string          `json:"currency"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance decimal.Decimal `json:"opening_balance"`
+	ClosingBalance decimal.Decimal `json:"closing_balance"`
+}
+
+// StatementEntry is one change of the balance, in the order it was applied,
+// with the balance after it.
+type StatementEntry struct {
+	PostedAt       time.Time       `json:"posted_at"`
+	TransactionID  *uint           `json:"transaction_id,omitempty"`
+	Type           TransactionType `json:"type,omitempty"`
+	CounterpartyID uint            `json:"counterparty_id,omitempty"`
+	Description    string          `json:"description"`
+	Amount         decimal.Decimal `json:"amount"`
+	Balance        decimal.Decimal `json:"balance"`
+}
+
+// StatementWriter renders a statement as it is produced: Begin once, Entry
+// for every entry in order, then End.
+type StatementWriter interface {
+	Begin(statement *Statement) error
+	Entry(entry *StatementEntry) error
+	End(statement *Statement) error
+}
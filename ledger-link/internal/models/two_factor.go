@@ -0,0 +1,155 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTwoFactorRequired    = errors.New("two-factor code required")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrInvalidChallenge     = errors.New("invalid or expired login challenge")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor enrollment has not been started")
+	ErrTwoFactorLocked      = errors.New("too many invalid two-factor codes")
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time.
+const RecoveryCodeCount = 10
+
+// TwoFactor is a user's TOTP secret. It protects logins once ConfirmedAt is
+// set, after the user proved their authenticator app produces its codes.
+// LastUsedStep is the time step of the last accepted code, so no code is
+// accepted twice. FailedAttempts counts the wrong codes given in a row
+// outside logins; once it reaches the limit, codes are refused until
+// LockedUntil.
+type TwoFactor struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	UserID         uint       `gorm:"not null;uniqueIndex" json:"user_id"`
+	Secret         string     `gorm:"type:varchar(64);not null" json:"-"`
+	ConfirmedAt    *time.Time `json:"confirmed_at,omitempty"`
+	LastUsedStep   int64      `gorm:"not null;default:0" json:"-"`
+	FailedAttempts int        `gorm:"not null;default:0" json:"-"`
+	LockedUntil    *time.Time `json:"-"`
+	CreatedAt      time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+func (f *TwoFactor) TableName() string {
+	return "two_factor_credentials"
+}
+
+// IsEnabled reports whether the user confirmed enrollment.
+func (f *TwoFactor) IsEnabled() bool {
+	return f != nil && f.ConfirmedAt != nil
+}
+
+// IsLocked reports whether codes are refused at now.
+func (f *TwoFactor) IsLocked(now time.Time) bool {
+	return f.LockedUntil != nil && now.Before(*f.LockedUntil)
+}
+
+// TwoFactorLockedError refuses a code while the user's codes are locked
+// after too many wrong ones.
+type TwoFactorLockedError struct {
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+func (e *TwoFactorLockedError) Error() string {
+	return fmt.Sprintf("%s, try again after %s", ErrTwoFactorLocked, e.LockedUntil.UTC().Format(time.RFC3339))
+}
+
+func (e *TwoFactorLockedError) Is(target error) bool {
+	return target == ErrTwoFactorLocked
+}
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when the
+// user has lost their authenticator. Only its SHA-256 is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"type:char(64);not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `gorm:"not null" json:"created_at"`
+}
+
+func (c *RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// LoginChallenge is the second step of a login by a user with two-factor
+// authentication on. Its opaque token, stored by SHA-256, is exchanged with
+// a code for the session's tokens; it is refused once used, expired or
+// tried too often.
+type LoginChallenge struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"type:char(64);not null;uniqueIndex" json:"-"`
+	Attempts  int        `gorm:"not null;default:0" json:"attempts"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `gorm:"not null" json:"created_at"`
+}
+
+func (c *LoginChallenge) TableName() string {
+	return "login_challenges"
+}
+
+// TwoFactorEnrollment is what the user adds to their authenticator app.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorStatus tells a user whether two-factor authentication is on and
+// how many recovery codes they have left.
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	ConfirmedAt            *time.Time `json:"confirmed_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// LoginResult answers a password login: the session's tokens, or when the
+// user has two-factor authentication on, a challenge to complete with a
+// code.
+type LoginResult struct {
+	*TokenPair
+	TwoFactorRequired  bool       `json:"two_factor_required,omitempty"`
+	ChallengeToken     string     `json:"challenge_token,omitempty"`
+	ChallengeExpiresAt *time.Time `json:"challenge_expires_at,omitempty"`
+}
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewRecoveryCodes returns RecoveryCodeCount codes shaped "xxxxx-xxxxx" and
+// the hashes they are stored under.
+func NewRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < RecoveryCodeCount; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))[:10]
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code as typed, ignoring case, spaces
+// and dashes.
+func HashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+	return HashOpaqueToken(normalized)
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Kinds of live updates pushed to the clients watching a user.
+const (
+	UpdateBalance     = "balance"
+	UpdateTransaction = "transaction"
+)
+
+// Update is a change pushed to the clients watching a user: a new balance or
+// a transaction status change. ID is assigned when the update is published;
+// it orders the updates of one user and is what clients resume after.
+type Update struct {
+	ID     string          `json:"id"`
+	UserID uint            `json:"user_id"`
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data"`
+}
+
+func NewBalanceUpdate(userID uint, data BalanceData) (*Update, error) {
+	return newUpdate(userID, UpdateBalance, data)
+}
+
+func NewTransactionUpdate(userID uint, tx *Transaction) (*Update, error) {
+	return newUpdate(userID, UpdateTransaction, NewTransactionData(tx))
+}
+
+func newUpdate(userID uint, updateType string, data interface{}) (*Update, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s update: %w", updateType, err)
+	}
+	return &Update{
+		UserID: userID,
+		Type:   updateType,
+		Data:   payload,
+	}, nil
+}
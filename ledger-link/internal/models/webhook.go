@@ -0,0 +1,264 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Transaction lifecycle events sent to webhook subscribers.
+const (
+	EventTransactionCompleted = "transaction.completed"
+	EventTransactionFailed    = "transaction.failed"
+)
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "delivered"
+	WebhookDeliveryDead      WebhookDeliveryStatus = "dead"
+)
+
+// Headers of a webhook request. The signature header carries the time the
+// request was signed and the hex HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with the subscription's secret, as "t=<unix seconds>,v1=<signature>".
+const (
+	WebhookEventHeader     = "X-Ledger-Event"
+	WebhookDeliveryHeader  = "X-Ledger-Delivery"
+	WebhookSignatureHeader = "X-Ledger-Signature"
+)
+
+var (
+	ErrInvalidWebhook    = errors.New("invalid webhook subscription")
+	ErrRedeliveryTooSoon = errors.New("delivery is queued or was attempted too recently to redeliver")
+)
+
+// WebhookEvents are the events a subscription may filter on.
+var WebhookEvents = []string{EventTransactionCompleted, EventTransactionFailed}
+
+// EventForStatus returns the webhook event raised when a transaction reaches
+// status, or "" when the status raises none.
+func EventForStatus(status TransactionStatus) string {
+	switch status {
+	case StatusCompleted:
+		return EventTransactionCompleted
+	case StatusFailed:
+		return EventTransactionFailed
+	default:
+		return ""
+	}
+}
+
+// WebhookSubscription sends the events of UserID's transactions to URL. A
+// global subscription has no user and receives the events of every
+// transaction; only admins create them. An empty event list subscribes to
+// every event.
+type WebhookSubscription struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	UserID    *uint          `gorm:"index" json:"user_id,omitempty"`
+	URL       string         `gorm:"type:varchar(2048);not null" json:"url"`
+	Secret    string         `gorm:"type:varchar(100);not null" json:"-"`
+	Events    []string       `gorm:"serializer:json;type:text" json:"events"`
+	CreatedBy uint           `gorm:"not null" json:"created_by"`
+	CreatedAt time.Time      `gorm:"not null" json:"created_at"`
+	UpdatedAt time.Time      `gorm:"not null" json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (s *WebhookSubscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+func (s *WebhookSubscription) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	for _, event := range s.Events {
+		known := false
+		for _, e := range WebhookEvents {
+			if event == e {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, event)
+		}
+	}
+	return nil
+}
+
+// IsGlobal reports whether the subscription receives every user's events.
+func (s *WebhookSubscription) IsGlobal() bool {
+	return s.UserID == nil
+}
+
+// Wants reports whether the subscription filters in event.
+func (s *WebhookSubscription) Wants(event string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is the body of a webhook request.
+type WebhookEvent struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      TransactionData `json:"data"`
+}
+
+func NewWebhookEvent(id, eventType string, tx *Transaction, now time.Time) WebhookEvent {
+	return WebhookEvent{
+		ID:        id,
+		Type:      eventType,
+		CreatedAt: now,
+		Data:      NewTransactionData(tx),
+	}
+}
+
+// TransactionData is a transaction as published to webhooks and the event
+// stream, without the users it belongs to.
+type TransactionData struct {
+	ID         uint                `json:"id"`
+	Type       TransactionType     `json:"type"`
+	Status     TransactionStatus   `json:"status"`
+	FromUserID uint                `json:"from_user_id"`
+	ToUserID   uint                `json:"to_user_id"`
+	Amount     decimal.Decimal     `json:"amount"`
+	Currency   string              `json:"currency"`
+	ToCurrency string              `json:"to_currency,omitempty"`
+	ToAmount   decimal.NullDecimal `json:"to_amount,omitempty"`
+	ReversalOf *uint               `json:"reversal_of,omitempty"`
+	Notes      string              `json:"notes,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+}
+
+func NewTransactionData(tx *Transaction) TransactionData {
+	return TransactionData{
+		ID:         tx.ID,
+		Type:       tx.Type,
+		Status:     tx.Status,
+		FromUserID: tx.FromUserID,
+		ToUserID:   tx.ToUserID,
+		Amount:     tx.Amount,
+		Currency:   tx.Currency,
+		ToCurrency: tx.ToCurrency,
+		ToAmount:   tx.ToAmount,
+		ReversalOf: tx.ReversalOf,
+		Notes:      tx.Notes,
+		CreatedAt:  tx.CreatedAt,
+		UpdatedAt:  tx.UpdatedAt,
+	}
+}
+
+// WebhookDelivery is one event on its way to one subscription. Deliveries
+// are written in the unit of work of the status change that raised the
+// event, so they commit together with it, and are sent afterwards until the
+// endpoint accepts them or they run out of attempts.
+type WebhookDelivery struct {
+	ID             uint                  `gorm:"primaryKey" json:"id"`
+	SubscriptionID uint                  `gorm:"not null;index" json:"subscription_id"`
+	EventID        string                `gorm:"type:char(36);not null;index" json:"event_id"`
+	EventType      string                `gorm:"type:varchar(50);not null" json:"event_type"`
+	TransactionID  uint                  `gorm:"not null;index" json:"transaction_id"`
+	Payload        string                `gorm:"type:text;not null" json:"payload"`
+	Status         WebhookDeliveryStatus `gorm:"type:varchar(10);not null;index:idx_webhook_deliveries_due" json:"status"`
+	Attempts       int                   `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  *time.Time            `gorm:"index:idx_webhook_deliveries_due" json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time            `json:"last_attempt_at,omitempty"`
+	LastStatusCode int                   `json:"last_status_code,omitempty"`
+	LastError      string                `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time             `gorm:"not null" json:"created_at"`
+	UpdatedAt      time.Time             `gorm:"not null" json:"updated_at"`
+}
+
+func (d *WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// RecordSuccess marks the delivery as accepted by the endpoint at now.
+func (d *WebhookDelivery) RecordSuccess(now time.Time, statusCode int) {
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.LastStatusCode = statusCode
+	d.LastError = ""
+	d.Status = WebhookDeliveryDelivered
+	d.DeliveredAt = &now
+	d.NextAttemptAt = nil
+}
+
+// RecordFailure schedules the next attempt with exponential backoff from
+// baseDelay, capped at maxDelay, or moves the delivery to the dead letters
+// once maxAttempts have failed.
+func (d *WebhookDelivery) RecordFailure(now time.Time, statusCode int, cause string, maxAttempts int, baseDelay, maxDelay time.Duration) {
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.LastStatusCode = statusCode
+	d.LastError = cause
+
+	if d.Attempts >= maxAttempts {
+		d.Status = WebhookDeliveryDead
+		d.NextAttemptAt = nil
+		return
+	}
+
+	delay := baseDelay
+	for i := 1; i < d.Attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	next := now.Add(delay)
+	d.NextAttemptAt = &next
+}
+
+// Redeliver queues the delivery to be sent again at now with a fresh set of
+// attempts.
+func (d *WebhookDelivery) Redeliver(now time.Time) {
+	d.Status = WebhookDeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = &now
+}
+
+// Redact replaces the cause of the last failure, which can carry the
+// endpoint's response body or details of the network it sits on, with the
+// status code it answered.
+func (d *WebhookDelivery) Redact() {
+	switch {
+	case d.LastError == "":
+	case d.LastStatusCode != 0:
+		d.LastError = fmt.Sprintf("endpoint responded %d", d.LastStatusCode)
+	default:
+		d.LastError = "endpoint unreachable"
+	}
+}
+
+// SignWebhook returns the signature header value for body sent at
+// timestamp.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
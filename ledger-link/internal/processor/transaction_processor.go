@@ -30,8 +30,12 @@ func DefaultBatchConfig() BatchConfig {
 
 type TransactionProcessor struct {
 	repo        models.TransactionRepository
+	journal     models.JournalRepository
+	uow         models.UnitOfWork
 	balanceSvc  models.BalanceService
 	auditSvc    models.AuditService
+	events      models.TransactionEvents
+	updates     models.UpdatePublisher
 	logger      *logger.Logger
 	locks       sync.Map
 	batchConfig BatchConfig
@@ -42,15 +46,23 @@ type TransactionProcessor struct {
 
 func NewTransactionProcessor(
 	repo models.TransactionRepository,
+	journal models.JournalRepository,
+	uow models.UnitOfWork,
 	balanceSvc models.BalanceService,
 	auditSvc models.AuditService,
+	events models.TransactionEvents,
+	updates models.UpdatePublisher,
 	logger *logger.Logger,
 ) *TransactionProcessor {
 	config := DefaultBatchConfig()
 	return &TransactionProcessor{
 		repo:        repo,
+		journal:     journal,
+		uow:         uow,
 		balanceSvc:  balanceSvc,
 		auditSvc:    auditSvc,
+		events:      events,
+		updates:     updates,
 		logger:      logger,
 		batchConfig: config,
 		txQueue:     make(chan *models.Transaction, config.QueueBufferSize),
@@ -58,31 +70,51 @@ func NewTransactionProcessor(
 	}
 }
 
-func (p *TransactionProcessor) ProcessTransaction(ctx context.Context, tx *models.Transaction) error {
-	var err error
-
+// ProcessTransaction applies tx and marks it completed in a single unit of
+// work: the balances, their history, the postings, the audit entries and the
+// final status commit together or not at all. Extra steps run first in the
+// same unit of work, so they can claim what the posting depends on, such as
+// an FX quote or the funds of a hold. A failed transaction is marked failed
+// afterwards, outside the rolled back unit of work. Both status changes
+// record their events with them.
+func (p *TransactionProcessor) ProcessTransaction(ctx context.Context, tx *models.Transaction, steps ...func(ctx context.Context) error) error {
 	switch tx.Type {
-	case models.TypeDeposit:
-		err = p.processDeposit(ctx, tx)
-	case models.TypeWithdrawal:
-		err = p.processWithdrawal(ctx, tx)
-	case models.TypeTransfer:
-		err = p.processTransfer(ctx, tx)
+	case models.TypeDeposit, models.TypeWithdrawal, models.TypeTransfer, models.TypeAdjustment:
 	default:
 		return fmt.Errorf("unsupported transaction type: %s", tx.Type)
 	}
 
-	if err != nil {
-		tx.Status = models.StatusFailed
-		if updateErr := p.repo.Update(ctx, tx); updateErr != nil {
-			p.logger.Error("failed to update transaction status", "error", updateErr)
+	err := p.uow.Do(ctx, func(ctx context.Context) error {
+		for _, step := range steps {
+			if err := step(ctx); err != nil {
+				return err
+			}
 		}
-		return err
-	}
 
-	tx.Status = models.StatusCompleted
-	if err := p.repo.Update(ctx, tx); err != nil {
-		p.logger.Error("failed to update transaction status", "error", err)
+		var err error
+		switch tx.Type {
+		case models.TypeDeposit:
+			err = p.processDeposit(ctx, tx)
+		case models.TypeWithdrawal:
+			err = p.processWithdrawal(ctx, tx)
+		case models.TypeTransfer:
+			err = p.processTransfer(ctx, tx)
+		case models.TypeAdjustment:
+			err = p.processAdjustment(ctx, tx)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := p.setStatus(ctx, tx, models.StatusCompleted); err != nil {
+			return fmt.Errorf("failed to update transaction status: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		p.markFailed(ctx, tx)
+		return err
 	}
 
 	return nil
@@ -93,19 +125,13 @@ func (p *TransactionProcessor) processDeposit(ctx context.Context, tx *models.Tr
 	lock.Lock()
 	defer lock.Unlock()
 
-	balance, err := p.balanceSvc.GetBalance(ctx, tx.ToUserID)
-	if err != nil {
-		return fmt.Errorf("failed to get balance: %w", err)
-	}
-
-	newAmount := balance.SafeAmount().Add(tx.Amount)
-	if err := p.balanceSvc.UpdateBalance(ctx, tx.ToUserID, newAmount); err != nil {
+	if err := p.postTransaction(ctx, tx); err != nil {
 		return fmt.Errorf("failed to process deposit: %w", err)
 	}
 
-	details := fmt.Sprintf("Processed deposit of %s", tx.Amount)
+	details := fmt.Sprintf("Processed deposit of %s %s", tx.Amount, tx.Currency)
 	if err := p.auditSvc.LogAction(ctx, models.EntityTypeTransaction, tx.ID, models.ActionUpdate, details); err != nil {
-		p.logger.Error("failed to log deposit", "error", err)
+		return fmt.Errorf("failed to log deposit: %w", err)
 	}
 
 	return nil
@@ -116,23 +142,36 @@ func (p *TransactionProcessor) processWithdrawal(ctx context.Context, tx *models
 	lock.Lock()
 	defer lock.Unlock()
 
-	balance, err := p.balanceSvc.GetBalance(ctx, tx.FromUserID)
-	if err != nil {
-		return fmt.Errorf("failed to get balance: %w", err)
+	if err := p.postTransaction(ctx, tx); err != nil {
+		return fmt.Errorf("failed to process withdrawal: %w", err)
 	}
 
-	if balance.SafeAmount().LessThan(tx.Amount) {
-		return fmt.Errorf("insufficient funds: available %s, required %s", balance.SafeAmount(), tx.Amount)
+	details := fmt.Sprintf("Processed withdrawal of %s %s", tx.Amount, tx.Currency)
+	if err := p.auditSvc.LogAction(ctx, models.EntityTypeTransaction, tx.ID, models.ActionUpdate, details); err != nil {
+		return fmt.Errorf("failed to log withdrawal: %w", err)
 	}
 
-	newAmount := balance.SafeAmount().Sub(tx.Amount)
-	if err := p.balanceSvc.UpdateBalance(ctx, tx.FromUserID, newAmount); err != nil {
-		return fmt.Errorf("failed to process withdrawal: %w", err)
+	return nil
+}
+
+// processAdjustment books an admin's credit to, or charge against, a user's
+// balance against the adjustment account.
+func (p *TransactionProcessor) processAdjustment(ctx context.Context, tx *models.Transaction) error {
+	lock := p.getBalanceLock(tx.ToUserID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := p.postTransaction(ctx, tx); err != nil {
+		return fmt.Errorf("failed to process adjustment: %w", err)
 	}
 
-	details := fmt.Sprintf("Processed withdrawal of %s", tx.Amount)
+	direction := "credit to"
+	if tx.Debit {
+		direction = "charge against"
+	}
+	details := fmt.Sprintf("Processed adjustment of %s %s as a %s user %d", tx.Amount, tx.Currency, direction, tx.ToUserID)
 	if err := p.auditSvc.LogAction(ctx, models.EntityTypeTransaction, tx.ID, models.ActionUpdate, details); err != nil {
-		p.logger.Error("failed to log withdrawal", "error", err)
+		return fmt.Errorf("failed to log adjustment: %w", err)
 	}
 
 	return nil
@@ -145,108 +184,224 @@ func (p *TransactionProcessor) processTransfer(ctx context.Context, tx *models.T
 		"to_user", tx.ToUserID,
 		"amount", tx.Amount)
 
-	fromLock := p.getBalanceLock(tx.FromUserID)
-	toLock := p.getBalanceLock(tx.ToUserID)
-
-	// Acquire locks in a consistent order to prevent deadlocks
-	if tx.FromUserID < tx.ToUserID {
-		p.logger.Debug("Acquiring locks in order: from -> to")
-		fromLock.Lock()
-		toLock.Lock()
-	} else {
-		p.logger.Debug("Acquiring locks in order: to -> from")
-		toLock.Lock()
-		fromLock.Lock()
-	}
-	defer func() {
-		if tx.FromUserID < tx.ToUserID {
-			toLock.Unlock()
-			fromLock.Unlock()
-		} else {
-			fromLock.Unlock()
-			toLock.Unlock()
+	unlock := p.lockPair(tx.FromUserID, tx.ToUserID)
+	defer unlock()
+
+	if err := p.postTransaction(ctx, tx); err != nil {
+		p.logger.Error("Failed to post transfer",
+			"error", err,
+			"transaction_id", tx.ID)
+		return err
+	}
+
+	details := fmt.Sprintf("Processed transfer of %s %s from %d to %d", tx.Amount, tx.Currency, tx.FromUserID, tx.ToUserID)
+	if tx.IsFX() {
+		details = fmt.Sprintf("Processed transfer of %s %s from %d to %d as %s %s at rate %s",
+			tx.Amount, tx.Currency, tx.FromUserID, tx.ToUserID, tx.ToAmount.Decimal, tx.ToCurrency, tx.FXRate.Decimal)
+	}
+	if err := p.auditSvc.LogAction(ctx, models.EntityTypeTransaction, tx.ID, models.ActionUpdate, details); err != nil {
+		p.logger.Error("Failed to log transfer audit", "error", err)
+		return fmt.Errorf("failed to log transfer: %w", err)
+	}
+
+	p.logger.Info("Transfer completed successfully",
+		"transaction_id", tx.ID,
+		"from_user", tx.FromUserID,
+		"to_user", tx.ToUserID,
+		"amount", tx.Amount)
+
+	return nil
+}
+
+// ReverseTransaction books reversal as the compensating entry of the
+// transaction it refunds and marks both in a single unit of work. It takes
+// the same balance locks as a transfer and re-reads the original under a row
+// lock, so concurrent refunds cannot exceed the original amount and cannot
+// race with new debits. A fully refunded original is marked cancelled.
+func (p *TransactionProcessor) ReverseTransaction(ctx context.Context, reversal *models.Transaction) error {
+	if reversal.Type != models.TypeReversal || reversal.ReversalOf == nil {
+		return fmt.Errorf("unsupported transaction type: %s", reversal.Type)
+	}
+
+	unlock := p.lockPair(reversal.FromUserID, reversal.ToUserID)
+	defer unlock()
+
+	err := p.uow.Do(ctx, func(ctx context.Context) error {
+		original, err := p.repo.GetByIDForUpdate(ctx, *reversal.ReversalOf)
+		if err != nil {
+			return fmt.Errorf("failed to get original transaction: %w", err)
+		}
+		refund := reversal.RefundAmount()
+		if err := original.CheckReversal(refund); err != nil {
+			return err
+		}
+
+		originalPostings, err := p.journal.GetByTransactionID(ctx, original.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get original postings: %w", err)
+		}
+		postings, err := models.BuildReversalPostings(originalPostings, reversal)
+		if err != nil {
+			return fmt.Errorf("failed to build postings: %w", err)
+		}
+		if err := p.applyPostings(ctx, postings); err != nil {
+			return fmt.Errorf("failed to process reversal: %w", err)
 		}
-		p.logger.Debug("Released all locks")
-	}()
 
-	// Get sender's balance
-	fromBalance, err := p.balanceSvc.GetBalance(ctx, tx.FromUserID)
+		original.RefundedAmount = original.RefundedAmount.Add(refund)
+		if original.RefundableAmount().IsZero() {
+			original.Status = models.StatusCancelled
+		}
+		if err := p.repo.Update(ctx, original); err != nil {
+			return fmt.Errorf("failed to update original transaction: %w", err)
+		}
+
+		details := fmt.Sprintf("Reversed %s %s of %s %s by transaction %d",
+			refund, original.Currency, original.Amount, original.Currency, reversal.ID)
+		if err := p.auditSvc.LogAction(ctx, models.EntityTypeTransaction, original.ID, models.ActionReverse, details); err != nil {
+			return fmt.Errorf("failed to log reversal: %w", err)
+		}
+		details = fmt.Sprintf("Processed reversal of transaction %d: %s %s from %d to %d",
+			original.ID, reversal.Amount, reversal.Currency, reversal.FromUserID, reversal.ToUserID)
+		if err := p.auditSvc.LogAction(ctx, models.EntityTypeTransaction, reversal.ID, models.ActionUpdate, details); err != nil {
+			return fmt.Errorf("failed to log reversal: %w", err)
+		}
+
+		if err := p.setStatus(ctx, reversal, models.StatusCompleted); err != nil {
+			return fmt.Errorf("failed to update transaction status: %w", err)
+		}
+		return nil
+	})
+
 	if err != nil {
-		p.logger.Error("Failed to get sender balance",
-			"error", err,
-			"user_id", tx.FromUserID)
-		return fmt.Errorf("failed to get sender balance: %w", err)
+		p.markFailed(ctx, reversal)
+		return err
+	}
+
+	return nil
+}
+
+// setStatus stores the new status of tx and records the events it raises.
+// Once the unit of work commits, the change is pushed to the clients
+// watching either side. It must run inside a unit of work.
+func (p *TransactionProcessor) setStatus(ctx context.Context, tx *models.Transaction, status models.TransactionStatus) error {
+	tx.Status = status
+	if err := p.repo.Update(ctx, tx); err != nil {
+		return err
+	}
+	if p.updates != nil {
+		snapshot := *tx
+		p.uow.AfterCommit(ctx, func() {
+			p.publishTransaction(&snapshot)
+		})
 	}
-	p.logger.Info("Got sender balance",
-		"user_id", tx.FromUserID,
-		"current_balance", fromBalance.SafeAmount())
+	if p.events == nil {
+		return nil
+	}
+	return p.events.StatusChanged(ctx, tx)
+}
+
+// SetStatus stores a status change that posts nothing, such as a transaction
+// held for review or rejected by its reviewer, with the events it raises. It
+// must run inside a unit of work.
+func (p *TransactionProcessor) SetStatus(ctx context.Context, tx *models.Transaction, status models.TransactionStatus) error {
+	return p.setStatus(ctx, tx, status)
+}
 
-	// Check if sender has sufficient funds
-	if fromBalance.SafeAmount().LessThan(tx.Amount) {
-		p.logger.Error("Insufficient funds",
-			"available", fromBalance.SafeAmount(),
-			"required", tx.Amount)
-		return fmt.Errorf("insufficient funds: available %s, required %s", fromBalance.SafeAmount(), tx.Amount)
+// publishTransaction pushes a committed status change to the sender and the
+// recipient of tx.
+func (p *TransactionProcessor) publishTransaction(tx *models.Transaction) {
+	userIDs := []uint{tx.FromUserID}
+	if tx.ToUserID != tx.FromUserID {
+		userIDs = append(userIDs, tx.ToUserID)
+	}
+	for _, userID := range userIDs {
+		if userID == 0 {
+			continue
+		}
+		update, err := models.NewTransactionUpdate(userID, tx)
+		if err == nil {
+			err = p.updates.Publish(context.Background(), update)
+		}
+		if err != nil {
+			p.logger.Error("failed to publish transaction update",
+				"error", err,
+				"tx_id", tx.ID,
+				"user_id", userID)
+		}
 	}
+}
 
-	// Get receiver's balance
-	toBalance, err := p.balanceSvc.GetBalance(ctx, tx.ToUserID)
+// markFailed marks tx failed in a unit of work of its own, after the one
+// that failed has been rolled back.
+func (p *TransactionProcessor) markFailed(ctx context.Context, tx *models.Transaction) {
+	err := p.uow.Do(ctx, func(ctx context.Context) error {
+		return p.setStatus(ctx, tx, models.StatusFailed)
+	})
 	if err != nil {
-		p.logger.Error("Failed to get receiver balance",
-			"error", err,
-			"user_id", tx.ToUserID)
-		return fmt.Errorf("failed to get receiver balance: %w", err)
-	}
-	p.logger.Info("Got receiver balance",
-		"user_id", tx.ToUserID,
-		"current_balance", toBalance.SafeAmount())
-
-	// Calculate new balances
-	newFromAmount := fromBalance.SafeAmount().Sub(tx.Amount)
-	newToAmount := toBalance.SafeAmount().Add(tx.Amount)
-
-	p.logger.Info("Calculated new balances",
-		"sender_old_balance", fromBalance.SafeAmount(),
-		"sender_new_balance", newFromAmount,
-		"receiver_old_balance", toBalance.SafeAmount(),
-		"receiver_new_balance", newToAmount)
-
-	// Update sender's balance in DB
-	if err := p.balanceSvc.UpdateBalance(ctx, tx.FromUserID, newFromAmount); err != nil {
-		p.logger.Error("Failed to update sender balance",
+		p.logger.Error("failed to mark transaction as failed",
 			"error", err,
-			"user_id", tx.FromUserID,
-			"new_amount", newFromAmount)
-		return fmt.Errorf("failed to update sender balance: %w", err)
+			"tx_id", tx.ID)
 	}
-	p.logger.Info("Updated sender balance successfully")
+}
 
-	// Update receiver's balance in DB
-	if err := p.balanceSvc.UpdateBalance(ctx, tx.ToUserID, newToAmount); err != nil {
-		// Rollback sender's balance
-		p.logger.Error("Failed to update receiver balance, rolling back sender's balance",
-			"error", err,
-			"user_id", tx.ToUserID,
-			"new_amount", newToAmount)
-		if rbErr := p.balanceSvc.UpdateBalance(ctx, tx.FromUserID, fromBalance.SafeAmount()); rbErr != nil {
-			p.logger.Error("Failed to rollback sender balance",
-				"error", rbErr,
-				"user_id", tx.FromUserID)
+// postTransaction books the journal postings of tx and applies them to the
+// balances of the user accounts involved. Callers must hold the balance locks
+// and run it inside a unit of work.
+func (p *TransactionProcessor) postTransaction(ctx context.Context, tx *models.Transaction) error {
+	postings, err := models.BuildPostings(tx)
+	if err != nil {
+		return fmt.Errorf("failed to build postings: %w", err)
+	}
+	return p.applyPostings(ctx, postings)
+}
+
+// applyPostings applies balanced postings to the user balances they touch
+// and records them. A debit may not dip into funds reserved by active holds.
+func (p *TransactionProcessor) applyPostings(ctx context.Context, postings models.Postings) error {
+	type balanceChange struct {
+		key       models.BalanceKey
+		newAmount decimal.Decimal
+	}
+
+	if len(postings) > 0 {
+		ctx = models.WithTransactionID(ctx, postings[0].TransactionID)
+	}
+
+	deltas := postings.UserDeltas()
+	changes := make([]balanceChange, 0, len(deltas))
+	for _, posting := range postings {
+		key := models.BalanceKey{UserID: posting.AccountID, Currency: posting.Currency}
+		delta, ok := deltas[key]
+		if !posting.IsUserAccount() || !ok {
+			continue
+		}
+		delete(deltas, key)
+
+		balance, err := p.balanceSvc.GetBalance(ctx, key.UserID, key.Currency)
+		if err != nil {
+			return fmt.Errorf("failed to get balance: %w", err)
+		}
+
+		newAmount := balance.SafeAmount().Add(delta)
+		if delta.IsNegative() && newAmount.LessThan(balance.Held) {
+			return fmt.Errorf("%w: available %s %s, required %s", models.ErrInsufficientFunds, balance.Available(), key.Currency, delta.Neg())
 		}
-		return fmt.Errorf("failed to update receiver balance: %w", err)
+		changes = append(changes, balanceChange{
+			key:       key,
+			newAmount: newAmount,
+		})
 	}
-	p.logger.Info("Updated receiver balance successfully")
 
-	details := fmt.Sprintf("Processed transfer of %s from %d to %d", tx.Amount, tx.FromUserID, tx.ToUserID)
-	if err := p.auditSvc.LogAction(ctx, models.EntityTypeTransaction, tx.ID, models.ActionUpdate, details); err != nil {
-		p.logger.Error("Failed to log transfer audit", "error", err)
+	for _, change := range changes {
+		if err := p.balanceSvc.UpdateBalance(ctx, change.key.UserID, change.key.Currency, change.newAmount); err != nil {
+			return fmt.Errorf("failed to update %s balance of user %d: %w", change.key.Currency, change.key.UserID, err)
+		}
 	}
 
-	p.logger.Info("Transfer completed successfully",
-		"transaction_id", tx.ID,
-		"from_user", tx.FromUserID,
-		"to_user", tx.ToUserID,
-		"amount", tx.Amount)
+	if err := p.journal.CreatePostings(ctx, postings); err != nil {
+		return fmt.Errorf("failed to record postings: %w", err)
+	}
 
 	return nil
 }
@@ -256,6 +411,25 @@ func (p *TransactionProcessor) getBalanceLock(userID uint) *sync.Mutex {
 	return lock.(*sync.Mutex)
 }
 
+// lockPair takes the balance locks of two users in a consistent order to
+// prevent deadlocks, and the lock only once when both are the same user.
+func (p *TransactionProcessor) lockPair(a, b uint) (unlock func()) {
+	if b < a {
+		a, b = b, a
+	}
+	first := p.getBalanceLock(a)
+	first.Lock()
+	if a == b {
+		return first.Unlock
+	}
+	second := p.getBalanceLock(b)
+	second.Lock()
+	return func() {
+		second.Unlock()
+		first.Unlock()
+	}
+}
+
 func (p *TransactionProcessor) Start(ctx context.Context) error {
 	p.logger.Info("starting transaction processor")
 
@@ -329,65 +503,79 @@ func (p *TransactionProcessor) processBatch(ctx context.Context, batch []*models
 
 	p.logger.Info("processing batch", "size", len(batch))
 
-	userTxs := make(map[uint][]*models.Transaction)
+	userTxs := make(map[models.BalanceKey][]*models.Transaction)
 	for _, tx := range batch {
-		userTxs[tx.ToUserID] = append(userTxs[tx.ToUserID], tx)
+		key := models.BalanceKey{UserID: tx.ToUserID, Currency: models.NormalizeCurrency(tx.Currency)}
+		userTxs[key] = append(userTxs[key], tx)
 	}
 
-	for userID, txs := range userTxs {
-		lock := p.getBalanceLock(userID)
+	for key, txs := range userTxs {
+		lock := p.getBalanceLock(key.UserID)
 		lock.Lock()
 
-		balance, err := p.balanceSvc.GetBalance(ctx, userID)
-		if err != nil {
-			p.logger.Error("failed to get balance for batch processing",
+		if err := p.uow.Do(ctx, func(ctx context.Context) error {
+			return p.processUserBatch(ctx, key, txs)
+		}); err != nil {
+			p.logger.Error("failed to process batch deposits",
 				"error", err,
-				"user_id", userID)
+				"user_id", key.UserID,
+				"currency", key.Currency)
 			p.markTransactionsFailed(ctx, txs)
-			lock.Unlock()
-			continue
 		}
 
-		var totalAmount decimal.Decimal
-		for _, tx := range txs {
-			totalAmount = totalAmount.Add(tx.Amount)
-		}
+		lock.Unlock()
+	}
+}
 
-		newAmount := balance.SafeAmount().Add(totalAmount)
-		if err := p.balanceSvc.UpdateBalance(ctx, userID, newAmount); err != nil {
-			p.logger.Error("failed to process batch deposits",
-				"error", err,
-				"user_id", userID)
-			p.markTransactionsFailed(ctx, txs)
-			lock.Unlock()
-			continue
+// processUserBatch applies the deposits of one user in one currency with a
+// single balance update. It must run inside a unit of work.
+func (p *TransactionProcessor) processUserBatch(ctx context.Context, key models.BalanceKey, txs []*models.Transaction) error {
+	balance, err := p.balanceSvc.GetBalance(ctx, key.UserID, key.Currency)
+	if err != nil {
+		return fmt.Errorf("failed to get balance for batch processing: %w", err)
+	}
+
+	var totalAmount decimal.Decimal
+	var postings models.Postings
+	for _, tx := range txs {
+		txPostings, err := models.BuildPostings(tx)
+		if err != nil {
+			return fmt.Errorf("failed to build postings for transaction %d: %w", tx.ID, err)
 		}
+		postings = append(postings, txPostings...)
+		totalAmount = totalAmount.Add(txPostings.UserDeltas()[key])
+	}
 
-		for _, tx := range txs {
-			tx.Status = models.StatusCompleted
-			if err := p.repo.Update(ctx, tx); err != nil {
-				p.logger.Error("failed to update transaction status",
-					"error", err,
-					"tx_id", tx.ID)
-			}
+	// A batch of one deposit can still be traced to its transaction
+	if len(txs) == 1 {
+		ctx = models.WithTransactionID(ctx, txs[0].ID)
+	}
 
-			details := fmt.Sprintf("Processed batch deposit of %s", tx.Amount)
-			if err := p.auditSvc.LogAction(ctx, models.EntityTypeTransaction, tx.ID, models.ActionUpdate, details); err != nil {
-				p.logger.Error("failed to log batch deposit", "error", err)
-			}
+	newAmount := balance.SafeAmount().Add(totalAmount)
+	if err := p.balanceSvc.UpdateBalance(ctx, key.UserID, key.Currency, newAmount); err != nil {
+		return err
+	}
+
+	if err := p.journal.CreatePostings(ctx, postings); err != nil {
+		return fmt.Errorf("failed to record batch deposit postings: %w", err)
+	}
+
+	for _, tx := range txs {
+		if err := p.setStatus(ctx, tx, models.StatusCompleted); err != nil {
+			return fmt.Errorf("failed to update status of transaction %d: %w", tx.ID, err)
 		}
 
-		lock.Unlock()
+		details := fmt.Sprintf("Processed batch deposit of %s %s", tx.Amount, tx.Currency)
+		if err := p.auditSvc.LogAction(ctx, models.EntityTypeTransaction, tx.ID, models.ActionUpdate, details); err != nil {
+			return fmt.Errorf("failed to log batch deposit: %w", err)
+		}
 	}
+
+	return nil
 }
 
 func (p *TransactionProcessor) markTransactionsFailed(ctx context.Context, txs []*models.Transaction) {
 	for _, tx := range txs {
-		tx.Status = models.StatusFailed
-		if err := p.repo.Update(ctx, tx); err != nil {
-			p.logger.Error("failed to mark transaction as failed",
-				"error", err,
-				"tx_id", tx.ID)
-		}
+		p.markFailed(ctx, tx)
 	}
 }
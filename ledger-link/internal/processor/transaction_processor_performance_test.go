@@ -16,11 +16,14 @@ import (
 // setupTestProcessor creates a processor for performance testing
 func setupTestProcessor(useBatch bool) (*TransactionProcessor, *MockTransactionRepo, *MockBalanceService, *MockAuditService) {
 	repo := new(MockTransactionRepo)
+	journal := new(MockJournalRepo)
 	balanceSvc := new(MockBalanceService)
 	auditSvc := new(MockAuditService)
 	logger := logger.New("error") // Use error level to reduce noise
 
-	processor := NewTransactionProcessor(repo, balanceSvc, auditSvc, logger)
+	journal.On("CreatePostings", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	processor := NewTransactionProcessor(repo, journal, new(MockUnitOfWork), balanceSvc, auditSvc, nil, nil, logger)
 	if useBatch {
 		processor.batchConfig = BatchConfig{
 			MaxBatchSize:    100,
@@ -42,12 +45,12 @@ func setupTestMocks(repo *MockTransactionRepo, balanceSvc *MockBalanceService, a
 
 	// Simulate database/network latency if requested
 	if simulateLatency {
-		balanceSvc.On("GetBalance", mock.Anything, uint(1)).Return(balance, nil).
+		balanceSvc.On("GetBalance", mock.Anything, uint(1), models.DefaultCurrency).Return(balance, nil).
 			Run(func(args mock.Arguments) {
 				time.Sleep(5 * time.Millisecond) // Simulate DB read latency
 			}).Maybe()
 
-		balanceSvc.On("UpdateBalance", mock.Anything, uint(1), mock.Anything).Return(nil).
+		balanceSvc.On("UpdateBalance", mock.Anything, uint(1), models.DefaultCurrency, mock.Anything).Return(nil).
 			Run(func(args mock.Arguments) {
 				time.Sleep(10 * time.Millisecond) // Simulate DB write latency
 			}).Maybe()
@@ -62,8 +65,8 @@ func setupTestMocks(repo *MockTransactionRepo, balanceSvc *MockBalanceService, a
 				time.Sleep(2 * time.Millisecond) // Simulate audit log write
 			}).Maybe()
 	} else {
-		balanceSvc.On("GetBalance", mock.Anything, uint(1)).Return(balance, nil).Maybe()
-		balanceSvc.On("UpdateBalance", mock.Anything, uint(1), mock.Anything).Return(nil).Maybe()
+		balanceSvc.On("GetBalance", mock.Anything, uint(1), models.DefaultCurrency).Return(balance, nil).Maybe()
+		balanceSvc.On("UpdateBalance", mock.Anything, uint(1), models.DefaultCurrency, mock.Anything).Return(nil).Maybe()
 		repo.On("Update", mock.Anything, mock.Anything).Return(nil).Maybe()
 		auditSvc.On("LogAction", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
 	}
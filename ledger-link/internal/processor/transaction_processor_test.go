@@ -34,22 +34,86 @@ func (m *MockTransactionRepo) GetByID(ctx context.Context, id uint) (*models.Tra
 	return args.Get(0).(*models.Transaction), args.Error(1)
 }
 
+func (m *MockTransactionRepo) GetByIDForUpdate(ctx context.Context, id uint) (*models.Transaction, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Transaction), args.Error(1)
+}
+
 func (m *MockTransactionRepo) GetByUserID(ctx context.Context, userID uint) ([]models.Transaction, error) {
 	args := m.Called(ctx, userID)
 	return args.Get(0).([]models.Transaction), args.Error(1)
 }
 
+func (m *MockTransactionRepo) GetByIDs(ctx context.Context, ids []uint) ([]models.Transaction, error) {
+	args := m.Called(ctx, ids)
+	return args.Get(0).([]models.Transaction), args.Error(1)
+}
+
+func (m *MockTransactionRepo) FindHistory(ctx context.Context, filter models.TransactionFilter) ([]models.Transaction, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).([]models.Transaction), args.Error(1)
+}
+
+type MockJournalRepo struct {
+	mock.Mock
+}
+
+func (m *MockJournalRepo) CreatePostings(ctx context.Context, postings models.Postings) error {
+	args := m.Called(ctx, postings)
+	return args.Error(0)
+}
+
+func (m *MockJournalRepo) GetByTransactionID(ctx context.Context, transactionID uint) (models.Postings, error) {
+	args := m.Called(ctx, transactionID)
+	return args.Get(0).(models.Postings), args.Error(1)
+}
+
+func (m *MockJournalRepo) SumByAccount(ctx context.Context, accountType string, accountID uint, currency string) (decimal.Decimal, error) {
+	args := m.Called(ctx, accountType, accountID, currency)
+	return args.Get(0).(decimal.Decimal), args.Error(1)
+}
+
+func (m *MockJournalRepo) SumUserAccounts(ctx context.Context) (map[models.BalanceKey]decimal.Decimal, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(map[models.BalanceKey]decimal.Decimal), args.Error(1)
+}
+
+func (m *MockJournalRepo) FindUnbalancedTransactions(ctx context.Context) ([]uint, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]uint), args.Error(1)
+}
+
+// MockUnitOfWork runs the work inline; the mocks have nothing to roll back.
+type MockUnitOfWork struct{}
+
+func (m *MockUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (m *MockUnitOfWork) InTransaction(ctx context.Context) bool {
+	return false
+}
+
+func (m *MockUnitOfWork) AfterCommit(ctx context.Context, fn func()) {
+	fn()
+}
+
 type MockBalanceService struct {
 	mock.Mock
 }
 
-func (m *MockBalanceService) GetBalance(ctx context.Context, userID uint) (*models.Balance, error) {
-	args := m.Called(ctx, userID)
+func (m *MockBalanceService) GetBalance(ctx context.Context, userID uint, currency string) (*models.Balance, error) {
+	args := m.Called(ctx, userID, currency)
 	return args.Get(0).(*models.Balance), args.Error(1)
 }
 
-func (m *MockBalanceService) UpdateBalance(ctx context.Context, userID uint, amount decimal.Decimal) error {
-	args := m.Called(ctx, userID, amount)
+func (m *MockBalanceService) GetBalances(ctx context.Context, userID uint) ([]models.Balance, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]models.Balance), args.Error(1)
+}
+
+func (m *MockBalanceService) UpdateBalance(ctx context.Context, userID uint, currency string, amount decimal.Decimal) error {
+	args := m.Called(ctx, userID, currency, amount)
 	return args.Error(0)
 }
 
@@ -58,16 +122,21 @@ func (m *MockBalanceService) LockBalance(ctx context.Context, userID uint) (*syn
 	return args.Get(0).(*sync.Mutex), args.Error(1)
 }
 
-func (m *MockBalanceService) GetBalanceHistory(ctx context.Context, userID uint, limit int) ([]models.BalanceHistory, error) {
-	args := m.Called(ctx, userID, limit)
+func (m *MockBalanceService) GetBalanceHistory(ctx context.Context, userID uint, currency string, limit int) ([]models.BalanceHistory, error) {
+	args := m.Called(ctx, userID, currency, limit)
 	return args.Get(0).([]models.BalanceHistory), args.Error(1)
 }
 
-func (m *MockBalanceService) GetBalanceAtTime(ctx context.Context, userID uint, timestamp time.Time) (*models.Balance, error) {
-	args := m.Called(ctx, userID, timestamp)
+func (m *MockBalanceService) GetBalanceAtTime(ctx context.Context, userID uint, currency string, timestamp time.Time) (*models.Balance, error) {
+	args := m.Called(ctx, userID, currency, timestamp)
 	return args.Get(0).(*models.Balance), args.Error(1)
 }
 
+func (m *MockBalanceService) GetBalancesAtTime(ctx context.Context, timestamp time.Time) ([]models.Balance, error) {
+	args := m.Called(ctx, timestamp)
+	return args.Get(0).([]models.Balance), args.Error(1)
+}
+
 func (m *MockBalanceService) CreateInitialBalance(ctx context.Context, balance *models.Balance) error {
 	args := m.Called(ctx, balance)
 	return args.Error(0)
@@ -90,12 +159,15 @@ func (m *MockAuditService) GetEntityAuditLog(ctx context.Context, entityType str
 func TestBatchProcessing(t *testing.T) {
 	// Create mocks
 	repo := new(MockTransactionRepo)
+	journal := new(MockJournalRepo)
 	balanceSvc := new(MockBalanceService)
 	auditSvc := new(MockAuditService)
 	logger := logger.New("info")
 
+	journal.On("CreatePostings", mock.Anything, mock.Anything).Return(nil)
+
 	// Create processor with test configuration
-	processor := NewTransactionProcessor(repo, balanceSvc, auditSvc, logger)
+	processor := NewTransactionProcessor(repo, journal, new(MockUnitOfWork), balanceSvc, auditSvc, nil, nil, logger)
 	processor.batchConfig = BatchConfig{
 		MaxBatchSize:    5,
 		BatchTimeout:    100 * time.Millisecond,
@@ -116,7 +188,7 @@ func TestBatchProcessing(t *testing.T) {
 
 		// Setup mock expectations
 		balance := &models.Balance{UserID: userID, Amount: initialBalance}
-		balanceSvc.On("GetBalance", mock.Anything, userID).Return(balance, nil).Times(2) // Expect multiple calls
+		balanceSvc.On("GetBalance", mock.Anything, userID, models.DefaultCurrency).Return(balance, nil).Times(2) // Expect multiple calls
 
 		// Calculate expected final balance
 		expectedTotal := initialBalance
@@ -145,7 +217,7 @@ func TestBatchProcessing(t *testing.T) {
 
 			// For each transaction, expect a potential balance update
 			runningBalance = runningBalance.Add(amount)
-			balanceSvc.On("UpdateBalance", mock.Anything, userID, mock.MatchedBy(func(amount decimal.Decimal) bool {
+			balanceSvc.On("UpdateBalance", mock.Anything, userID, models.DefaultCurrency, mock.MatchedBy(func(amount decimal.Decimal) bool {
 				return amount.GreaterThanOrEqual(initialBalance) && amount.LessThanOrEqual(expectedTotal)
 			})).Return(nil).Maybe()
 		}
@@ -183,7 +255,7 @@ func TestBatchProcessing(t *testing.T) {
 		// Setup expectations for each user
 		for _, userID := range users {
 			balance := &models.Balance{UserID: userID, Amount: initialBalance}
-			balanceSvc.On("GetBalance", mock.Anything, userID).Return(balance, nil)
+			balanceSvc.On("GetBalance", mock.Anything, userID, models.DefaultCurrency).Return(balance, nil)
 
 			amount := decimal.NewFromInt(500)
 			expectedTotal := initialBalance.Add(amount)
@@ -197,7 +269,7 @@ func TestBatchProcessing(t *testing.T) {
 			}
 
 			// Expect balance update
-			balanceSvc.On("UpdateBalance", mock.Anything, userID, expectedTotal).Return(nil)
+			balanceSvc.On("UpdateBalance", mock.Anything, userID, models.DefaultCurrency, expectedTotal).Return(nil)
 
 			// Expect transaction update
 			repo.On("Update", mock.Anything, mock.MatchedBy(func(t *models.Transaction) bool {
@@ -225,7 +297,7 @@ func TestBatchProcessing(t *testing.T) {
 		balance := &models.Balance{UserID: userID, Amount: initialBalance}
 
 		// Setup mock expectations
-		balanceSvc.On("GetBalance", mock.Anything, userID).Return(balance, nil)
+		balanceSvc.On("GetBalance", mock.Anything, userID, models.DefaultCurrency).Return(balance, nil)
 
 		amount := decimal.NewFromInt(100)
 		expectedTotal := initialBalance.Add(amount)
@@ -239,7 +311,7 @@ func TestBatchProcessing(t *testing.T) {
 		}
 
 		// Expect balance update
-		balanceSvc.On("UpdateBalance", mock.Anything, userID, expectedTotal).Return(nil)
+		balanceSvc.On("UpdateBalance", mock.Anything, userID, models.DefaultCurrency, expectedTotal).Return(nil)
 
 		// Expect transaction update
 		repo.On("Update", mock.Anything, mock.MatchedBy(func(t *models.Transaction) bool {
@@ -266,7 +338,7 @@ func TestBatchProcessing(t *testing.T) {
 		balance := &models.Balance{UserID: userID, Amount: initialBalance}
 
 		// Setup mock expectations
-		balanceSvc.On("GetBalance", mock.Anything, userID).Return(balance, nil)
+		balanceSvc.On("GetBalance", mock.Anything, userID, models.DefaultCurrency).Return(balance, nil)
 
 		amount := decimal.NewFromInt(100)
 		expectedTotal := initialBalance.Sub(amount)
@@ -280,7 +352,7 @@ func TestBatchProcessing(t *testing.T) {
 		}
 
 		// Expect balance update
-		balanceSvc.On("UpdateBalance", mock.Anything, userID, expectedTotal).Return(nil)
+		balanceSvc.On("UpdateBalance", mock.Anything, userID, models.DefaultCurrency, expectedTotal).Return(nil)
 
 		// Expect transaction update
 		repo.On("Update", mock.Anything, mock.MatchedBy(func(t *models.Transaction) bool {
@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"ledger-link/internal/models"
+)
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{
+		db: db,
+	}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	if err := conn(ctx, r.db).Create(key).Error; err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) GetByID(ctx context.Context, id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := conn(ctx, r.db).First(&key, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := conn(ctx, r.db).Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) Update(ctx context.Context, key *models.APIKey) error {
+	if err := conn(ctx, r.db).Save(key).Error; err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	return nil
+}
+
+// List returns the keys of the user, or of every user when userID is 0,
+// oldest first.
+func (r *APIKeyRepository) List(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	query := conn(ctx, r.db)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var keys []models.APIKey
+	if err := query.Order("id").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// TouchLastUsed records when and from where the key was last used, without
+// touching updated_at.
+func (r *APIKeyRepository) TouchLastUsed(ctx context.Context, id uint, at time.Time, ip string) error {
+	if err := conn(ctx, r.db).Model(&models.APIKey{}).
+		Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{"last_used_at": at, "last_used_ip": ip}).Error; err != nil {
+		return fmt.Errorf("failed to record API key use: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ledger-link/internal/models"
+)
+
+type ApprovalRepository struct {
+	db *gorm.DB
+}
+
+func NewApprovalRepository(db *gorm.DB) *ApprovalRepository {
+	return &ApprovalRepository{
+		db: db,
+	}
+}
+
+func (r *ApprovalRepository) Create(ctx context.Context, approval *models.Approval) error {
+	if err := conn(ctx, r.db).Create(approval).Error; err != nil {
+		return fmt.Errorf("failed to create approval: %w", err)
+	}
+	return nil
+}
+
+func (r *ApprovalRepository) GetByID(ctx context.Context, id uint) (*models.Approval, error) {
+	var approval models.Approval
+	if err := conn(ctx, r.db).First(&approval, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get approval: %w", err)
+	}
+	return &approval, nil
+}
+
+// GetForUpdate reads the approval and locks its row until the surrounding
+// unit of work ends.
+func (r *ApprovalRepository) GetForUpdate(ctx context.Context, id uint) (*models.Approval, error) {
+	var approval models.Approval
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&approval, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get approval: %w", err)
+	}
+	return &approval, nil
+}
+
+func (r *ApprovalRepository) Update(ctx context.Context, approval *models.Approval) error {
+	if err := conn(ctx, r.db).Save(approval).Error; err != nil {
+		return fmt.Errorf("failed to update approval: %w", err)
+	}
+	return nil
+}
+
+// List returns the approvals with the given status, or all of them, oldest
+// first.
+func (r *ApprovalRepository) List(ctx context.Context, status models.ApprovalStatus) ([]models.Approval, error) {
+	query := conn(ctx, r.db)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var approvals []models.Approval
+	if err := query.Order("id").Find(&approvals).Error; err != nil {
+		return nil, fmt.Errorf("failed to list approvals: %w", err)
+	}
+	return approvals, nil
+}
+
+// ListExpired returns the pending approvals that expired by now.
+func (r *ApprovalRepository) ListExpired(ctx context.Context, now time.Time) ([]models.Approval, error) {
+	var approvals []models.Approval
+	if err := conn(ctx, r.db).
+		Where("status = ? AND expires_at <= ?", models.ApprovalPending, now).
+		Order("id").
+		Find(&approvals).Error; err != nil {
+		return nil, fmt.Errorf("failed to list expired approvals: %w", err)
+	}
+	return approvals, nil
+}
+
+// SumExecuted returns the amount in currency of the requests the admin ran
+// at once since the given time.
+func (r *ApprovalRepository) SumExecuted(ctx context.Context, requestedBy uint, currency string, since time.Time) (decimal.Decimal, error) {
+	var sum decimal.NullDecimal
+	if err := conn(ctx, r.db).
+		Model(&models.Approval{}).
+		Select("SUM(amount)").
+		Where("requested_by = ? AND currency = ? AND status = ? AND created_at >= ?", requestedBy, currency, models.ApprovalExecuted, since).
+		Scan(&sum).Error; err != nil {
+		return decimal.Zero, fmt.Errorf("failed to sum executed approvals: %w", err)
+	}
+	return sum.Decimal, nil
+}
@@ -17,17 +17,17 @@ func NewAuditLogRepository(db *gorm.DB) *AuditLogRepository {
 }
 
 func (r *AuditLogRepository) Create(ctx context.Context, log *models.AuditLog) error {
-	return r.db.WithContext(ctx).Create(log).Error
+	return conn(ctx, r.db).Create(log).Error
 }
 
 func (r *AuditLogRepository) GetByUserID(ctx context.Context, userID uint) ([]models.AuditLog, error) {
 	var logs []models.AuditLog
-	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&logs).Error
+	err := conn(ctx, r.db).Where("user_id = ?", userID).Find(&logs).Error
 	return logs, err
 }
 
 func (r *AuditLogRepository) GetByEntityID(ctx context.Context, entityType string, entityID uint) ([]models.AuditLog, error) {
 	var logs []models.AuditLog
-	err := r.db.WithContext(ctx).Where("entity_type = ? AND entity_id = ?", entityType, entityID).Find(&logs).Error
+	err := conn(ctx, r.db).Where("entity_type = ? AND entity_id = ?", entityType, entityID).Order("id").Find(&logs).Error
 	return logs, err
 }
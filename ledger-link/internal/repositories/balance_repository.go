@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"ledger-link/internal/models"
 )
@@ -20,9 +21,9 @@ func NewBalanceRepository(db *gorm.DB) *BalanceRepository {
 	}
 }
 
-func (r *BalanceRepository) GetByUserID(ctx context.Context, userID uint) (*models.Balance, error) {
+func (r *BalanceRepository) GetByUserID(ctx context.Context, userID uint, currency string) (*models.Balance, error) {
 	var balance models.Balance
-	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&balance).Error; err != nil {
+	if err := conn(ctx, r.db).Where("user_id = ? AND currency = ?", userID, currency).First(&balance).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, models.ErrNotFound
 		}
@@ -31,42 +32,167 @@ func (r *BalanceRepository) GetByUserID(ctx context.Context, userID uint) (*mode
 	return &balance, nil
 }
 
+// GetByUserIDForUpdate reads the balance and locks its row until the
+// surrounding unit of work ends.
+func (r *BalanceRepository) GetByUserIDForUpdate(ctx context.Context, userID uint, currency string) (*models.Balance, error) {
+	var balance models.Balance
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND currency = ?", userID, currency).
+		First(&balance).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get balance: %w", err)
+	}
+	return &balance, nil
+}
+
+// ListByUserID returns the user's balances in every currency they hold.
+func (r *BalanceRepository) ListByUserID(ctx context.Context, userID uint) ([]models.Balance, error) {
+	var balances []models.Balance
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).Order("currency ASC").Find(&balances).Error; err != nil {
+		return nil, fmt.Errorf("failed to get balances: %w", err)
+	}
+	return balances, nil
+}
+
+func (r *BalanceRepository) GetAll(ctx context.Context) ([]models.Balance, error) {
+	var balances []models.Balance
+	if err := conn(ctx, r.db).Order("user_id ASC, currency ASC").Find(&balances).Error; err != nil {
+		return nil, fmt.Errorf("failed to get balances: %w", err)
+	}
+	return balances, nil
+}
+
 func (r *BalanceRepository) Create(ctx context.Context, balance *models.Balance) error {
-	if err := r.db.WithContext(ctx).Create(balance).Error; err != nil {
+	if err := conn(ctx, r.db).Create(balance).Error; err != nil {
 		return fmt.Errorf("failed to create balance: %w", err)
 	}
 	return nil
 }
 
 func (r *BalanceRepository) Update(ctx context.Context, balance *models.Balance) error {
-	if err := r.db.WithContext(ctx).Save(balance).Error; err != nil {
+	if err := conn(ctx, r.db).Save(balance).Error; err != nil {
 		return fmt.Errorf("failed to update balance: %w", err)
 	}
 	return nil
 }
 
-func (r *BalanceRepository) GetBalanceHistory(ctx context.Context, userID uint, limit int) ([]models.BalanceHistory, error) {
+// GetBalanceHistory returns the newest history entries of the user, limited
+// to one currency unless currency is empty.
+func (r *BalanceRepository) GetBalanceHistory(ctx context.Context, userID uint, currency string, limit int) ([]models.BalanceHistory, error) {
 	var history []models.BalanceHistory
-	err := r.db.WithContext(ctx).
-		Where("user_id = ?", userID).
+	query := conn(ctx, r.db).Where("user_id = ?", userID)
+	if currency != "" {
+		query = query.Where("currency = ?", currency)
+	}
+	err := query.
 		Order("created_at DESC").
 		Limit(limit).
 		Find(&history).Error
 	return history, err
 }
 
-func (r *BalanceRepository) GetBalanceHistoryAfterTime(ctx context.Context, userID uint, timestamp time.Time) ([]models.BalanceHistory, error) {
+func (r *BalanceRepository) GetBalanceHistoryAfterTime(ctx context.Context, userID uint, currency string, timestamp time.Time) ([]models.BalanceHistory, error) {
 	var history []models.BalanceHistory
-	err := r.db.WithContext(ctx).
-		Where("user_id = ? AND created_at >= ?", userID, timestamp).
+	err := conn(ctx, r.db).
+		Where("user_id = ? AND currency = ? AND created_at >= ?", userID, currency, timestamp).
 		Order("created_at ASC").
 		Find(&history).Error
 	return history, err
 }
 
+// ListBalanceHistoryBetween returns up to limit history entries of one
+// balance created in (from, to] with an ID above afterID, in the order they
+// were applied. Changes to a balance are serialized by its row lock, so ID
+// order is application order.
+func (r *BalanceRepository) ListBalanceHistoryBetween(ctx context.Context, userID uint, currency string, from, to time.Time, afterID uint, limit int) ([]models.BalanceHistory, error) {
+	var history []models.BalanceHistory
+	if err := conn(ctx, r.db).
+		Where("user_id = ? AND currency = ? AND created_at > ? AND created_at <= ? AND id > ?", userID, currency, from, to, afterID).
+		Order("id").
+		Limit(limit).
+		Find(&history).Error; err != nil {
+		return nil, fmt.Errorf("failed to list balance history: %w", err)
+	}
+	return history, nil
+}
+
 func (r *BalanceRepository) CreateBalanceHistory(ctx context.Context, history *models.BalanceHistory) error {
-	if err := r.db.WithContext(ctx).Create(history).Error; err != nil {
+	if err := conn(ctx, r.db).Create(history).Error; err != nil {
 		return fmt.Errorf("failed to create balance history: %w", err)
 	}
 	return nil
 }
+
+// ListAllBalanceHistoryBetween is ListBalanceHistoryBetween across every
+// balance.
+func (r *BalanceRepository) ListAllBalanceHistoryBetween(ctx context.Context, from, to time.Time, afterID uint, limit int) ([]models.BalanceHistory, error) {
+	var history []models.BalanceHistory
+	if err := conn(ctx, r.db).
+		Where("created_at > ? AND created_at <= ? AND id > ?", from, to, afterID).
+		Order("id").
+		Limit(limit).
+		Find(&history).Error; err != nil {
+		return nil, fmt.Errorf("failed to list balance history: %w", err)
+	}
+	return history, nil
+}
+
+// GetLatestSnapshot returns the newest snapshot of the balance taken at or
+// before at.
+func (r *BalanceRepository) GetLatestSnapshot(ctx context.Context, userID uint, currency string, at time.Time) (*models.BalanceSnapshot, error) {
+	var snapshot models.BalanceSnapshot
+	if err := conn(ctx, r.db).
+		Where("user_id = ? AND currency = ? AND as_of <= ?", userID, currency, at).
+		Order("as_of DESC").
+		First(&snapshot).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get balance snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// LatestSnapshotTime returns the newest snapshot time at or before at.
+func (r *BalanceRepository) LatestSnapshotTime(ctx context.Context, at time.Time) (time.Time, error) {
+	var snapshot models.BalanceSnapshot
+	if err := conn(ctx, r.db).
+		Select("as_of").
+		Where("as_of <= ?", at).
+		Order("as_of DESC").
+		First(&snapshot).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return time.Time{}, models.ErrNotFound
+		}
+		return time.Time{}, fmt.Errorf("failed to get balance snapshot: %w", err)
+	}
+	return snapshot.AsOf, nil
+}
+
+func (r *BalanceRepository) ListSnapshotsAt(ctx context.Context, asOf time.Time) ([]models.BalanceSnapshot, error) {
+	var snapshots []models.BalanceSnapshot
+	if err := conn(ctx, r.db).Where("as_of = ?", asOf).Find(&snapshots).Error; err != nil {
+		return nil, fmt.Errorf("failed to list balance snapshots: %w", err)
+	}
+	return snapshots, nil
+}
+
+// SaveSnapshots stores the snapshots, replacing the amount of any snapshot
+// already taken of the same balance at the same time.
+func (r *BalanceRepository) SaveSnapshots(ctx context.Context, snapshots []models.BalanceSnapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	if err := conn(ctx, r.db).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "currency"}, {Name: "as_of"}},
+			DoUpdates: clause.AssignmentColumns([]string{"amount"}),
+		}).
+		CreateInBatches(snapshots, 500).Error; err != nil {
+		return fmt.Errorf("failed to save balance snapshots: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"ledger-link/internal/models"
+)
+
+type FXQuoteRepository struct {
+	db *gorm.DB
+}
+
+func NewFXQuoteRepository(db *gorm.DB) *FXQuoteRepository {
+	return &FXQuoteRepository{
+		db: db,
+	}
+}
+
+func (r *FXQuoteRepository) Create(ctx context.Context, quote *models.FXQuote) error {
+	if err := conn(ctx, r.db).Create(quote).Error; err != nil {
+		return fmt.Errorf("failed to create quote: %w", err)
+	}
+	return nil
+}
+
+func (r *FXQuoteRepository) GetByID(ctx context.Context, id string) (*models.FXQuote, error) {
+	var quote models.FXQuote
+	if err := conn(ctx, r.db).Where("id = ?", id).First(&quote).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get quote: %w", err)
+	}
+	return &quote, nil
+}
+
+// MarkUsed consumes the quote for transactionID. The conditional update makes
+// concurrent executions of the same quote race on a single row, and only one
+// of them wins.
+func (r *FXQuoteRepository) MarkUsed(ctx context.Context, id string, transactionID uint) error {
+	now := time.Now()
+	result := conn(ctx, r.db).
+		Model(&models.FXQuote{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", id, now).
+		Updates(map[string]interface{}{
+			"used_at":        now,
+			"transaction_id": transactionID,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark quote used: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		quote, err := r.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if quote.UsedAt != nil {
+			return models.ErrQuoteUsed
+		}
+		return models.ErrQuoteExpired
+	}
+	return nil
+}
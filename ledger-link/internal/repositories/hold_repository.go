@@ -0,0 +1,105 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ledger-link/internal/models"
+)
+
+type HoldRepository struct {
+	db *gorm.DB
+}
+
+func NewHoldRepository(db *gorm.DB) *HoldRepository {
+	return &HoldRepository{
+		db: db,
+	}
+}
+
+func (r *HoldRepository) Create(ctx context.Context, hold *models.Hold) error {
+	if err := conn(ctx, r.db).Create(hold).Error; err != nil {
+		return fmt.Errorf("failed to create hold: %w", err)
+	}
+	return nil
+}
+
+func (r *HoldRepository) GetByID(ctx context.Context, id uint) (*models.Hold, error) {
+	var hold models.Hold
+	if err := conn(ctx, r.db).First(&hold, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get hold: %w", err)
+	}
+	return &hold, nil
+}
+
+// GetByIDForUpdate reads the hold and locks its row until the surrounding
+// unit of work ends.
+func (r *HoldRepository) GetByIDForUpdate(ctx context.Context, id uint) (*models.Hold, error) {
+	var hold models.Hold
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&hold, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get hold: %w", err)
+	}
+	return &hold, nil
+}
+
+// ListByUserID returns the user's holds, newest first, optionally filtered
+// by status.
+func (r *HoldRepository) ListByUserID(ctx context.Context, userID uint, status models.HoldStatus) ([]models.Hold, error) {
+	query := conn(ctx, r.db).Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var holds []models.Hold
+	if err := query.Order("created_at desc").Find(&holds).Error; err != nil {
+		return nil, fmt.Errorf("failed to list holds: %w", err)
+	}
+	return holds, nil
+}
+
+// ListExpired returns active holds whose expiry has passed.
+func (r *HoldRepository) ListExpired(ctx context.Context, now time.Time, limit int) ([]models.Hold, error) {
+	var holds []models.Hold
+	if err := conn(ctx, r.db).
+		Where("status = ? AND expires_at <= ?", models.HoldStatusActive, now).
+		Order("expires_at").
+		Limit(limit).
+		Find(&holds).Error; err != nil {
+		return nil, fmt.Errorf("failed to list expired holds: %w", err)
+	}
+	return holds, nil
+}
+
+// SumActive returns the funds reserved by the user's unexpired active holds
+// in currency.
+func (r *HoldRepository) SumActive(ctx context.Context, userID uint, currency string) (decimal.Decimal, error) {
+	var sum decimal.NullDecimal
+	if err := conn(ctx, r.db).
+		Model(&models.Hold{}).
+		Select("SUM(amount)").
+		Where("user_id = ? AND currency = ? AND status = ? AND expires_at > ?", userID, currency, models.HoldStatusActive, time.Now()).
+		Scan(&sum).Error; err != nil {
+		return decimal.Zero, fmt.Errorf("failed to sum active holds: %w", err)
+	}
+	return sum.Decimal, nil
+}
+
+func (r *HoldRepository) Update(ctx context.Context, hold *models.Hold) error {
+	if err := conn(ctx, r.db).Save(hold).Error; err != nil {
+		return fmt.Errorf("failed to update hold: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"ledger-link/internal/models"
+)
+
+// IdempotencyRepository is the database backed models.IdempotencyStore. The
+// unique (user_id, key) index makes Reserve atomic across replicas.
+type IdempotencyRepository struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyRepository(db *gorm.DB) *IdempotencyRepository {
+	return &IdempotencyRepository{
+		db: db,
+	}
+}
+
+func (r *IdempotencyRepository) Reserve(ctx context.Context, record *models.IdempotencyRecord) (bool, error) {
+	// An expired record no longer guards its key
+	if err := conn(ctx, r.db).
+		Where("user_id = ? AND `key` = ? AND expires_at < ?", record.UserID, record.Key, time.Now()).
+		Delete(&models.IdempotencyRecord{}).Error; err != nil {
+		return false, fmt.Errorf("failed to purge expired idempotency key: %w", err)
+	}
+
+	if err := conn(ctx, r.db).Create(record).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return false, nil
+		}
+		if _, getErr := r.Get(ctx, record.UserID, record.Key); getErr == nil {
+			// Drivers without error translation report duplicates as plain errors
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	return true, nil
+}
+
+func (r *IdempotencyRepository) Get(ctx context.Context, userID uint, key string) (*models.IdempotencyRecord, error) {
+	var record models.IdempotencyRecord
+	if err := conn(ctx, r.db).
+		Where("user_id = ? AND `key` = ? AND expires_at >= ?", userID, key, time.Now()).
+		First(&record).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	return &record, nil
+}
+
+func (r *IdempotencyRepository) Complete(ctx context.Context, record *models.IdempotencyRecord) error {
+	if err := conn(ctx, r.db).
+		Model(&models.IdempotencyRecord{}).
+		Where("user_id = ? AND `key` = ?", record.UserID, record.Key).
+		Updates(map[string]interface{}{
+			"completed":     true,
+			"status_code":   record.StatusCode,
+			"content_type":  record.ContentType,
+			"response_body": record.ResponseBody,
+		}).Error; err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (r *IdempotencyRepository) Release(ctx context.Context, userID uint, key string) error {
+	if err := conn(ctx, r.db).
+		Where("user_id = ? AND `key` = ?", userID, key).
+		Delete(&models.IdempotencyRecord{}).Error; err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
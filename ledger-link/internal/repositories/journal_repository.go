@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"ledger-link/internal/models"
+)
+
+type JournalRepository struct {
+	db *gorm.DB
+}
+
+func NewJournalRepository(db *gorm.DB) *JournalRepository {
+	return &JournalRepository{
+		db: db,
+	}
+}
+
+func (r *JournalRepository) CreatePostings(ctx context.Context, postings models.Postings) error {
+	if err := conn(ctx, r.db).Create(&postings).Error; err != nil {
+		return fmt.Errorf("failed to create postings: %w", err)
+	}
+	return nil
+}
+
+func (r *JournalRepository) GetByTransactionID(ctx context.Context, transactionID uint) (models.Postings, error) {
+	var postings models.Postings
+	if err := conn(ctx, r.db).
+		Where("transaction_id = ?", transactionID).
+		Order("id ASC").
+		Find(&postings).Error; err != nil {
+		return nil, fmt.Errorf("failed to get postings: %w", err)
+	}
+	return postings, nil
+}
+
+func (r *JournalRepository) SumByAccount(ctx context.Context, accountType string, accountID uint, currency string) (decimal.Decimal, error) {
+	var sum decimal.NullDecimal
+	if err := conn(ctx, r.db).
+		Model(&models.Posting{}).
+		Select("SUM(amount)").
+		Where("account_type = ? AND account_id = ? AND currency = ?", accountType, accountID, currency).
+		Scan(&sum).Error; err != nil {
+		return decimal.Zero, fmt.Errorf("failed to sum postings: %w", err)
+	}
+	return sum.Decimal, nil
+}
+
+func (r *JournalRepository) SumUserAccounts(ctx context.Context) (map[models.BalanceKey]decimal.Decimal, error) {
+	var rows []struct {
+		AccountID uint
+		Currency  string
+		Total     decimal.Decimal
+	}
+	if err := conn(ctx, r.db).
+		Model(&models.Posting{}).
+		Select("account_id, currency, SUM(amount) AS total").
+		Where("account_type = ?", models.AccountTypeUser).
+		Group("account_id, currency").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to sum user postings: %w", err)
+	}
+
+	sums := make(map[models.BalanceKey]decimal.Decimal, len(rows))
+	for _, row := range rows {
+		sums[models.BalanceKey{UserID: row.AccountID, Currency: row.Currency}] = row.Total
+	}
+	return sums, nil
+}
+
+func (r *JournalRepository) FindUnbalancedTransactions(ctx context.Context) ([]uint, error) {
+	// Each currency of a transaction has to balance on its own
+	var ids []uint
+	if err := conn(ctx, r.db).
+		Model(&models.Posting{}).
+		Distinct("transaction_id").
+		Where("transaction_id IN (?)", conn(ctx, r.db).
+			Model(&models.Posting{}).
+			Select("transaction_id").
+			Group("transaction_id, currency").
+			Having("SUM(amount) <> 0 OR COUNT(*) < 2")).
+		Pluck("transaction_id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to find unbalanced transactions: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"ledger-link/internal/models"
+)
+
+// LeaseRepository is the database backed models.Lease. The lease row is
+// taken over with a conditional update, so only one replica can win it.
+type LeaseRepository struct {
+	db *gorm.DB
+}
+
+func NewLeaseRepository(db *gorm.DB) *LeaseRepository {
+	return &LeaseRepository{
+		db: db,
+	}
+}
+
+func (r *LeaseRepository) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	// Renew our own lease or take over one that has lapsed
+	result := conn(ctx, r.db).
+		Model(&models.LeaseRecord{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", name, holder, now).
+		Updates(map[string]interface{}{
+			"holder":     holder,
+			"expires_at": now.Add(ttl),
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+
+	record := &models.LeaseRecord{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)}
+	if err := conn(ctx, r.db).Create(record).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return false, nil
+		}
+		var existing models.LeaseRecord
+		if getErr := conn(ctx, r.db).Where("name = ?", name).First(&existing).Error; getErr == nil {
+			// Drivers without error translation report duplicates as plain errors
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return true, nil
+}
+
+func (r *LeaseRepository) Release(ctx context.Context, name, holder string) error {
+	if err := conn(ctx, r.db).
+		Where("name = ? AND holder = ?", name, holder).
+		Delete(&models.LeaseRecord{}).Error; err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
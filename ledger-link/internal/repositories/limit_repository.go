@@ -0,0 +1,121 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"ledger-link/internal/models"
+)
+
+type LimitRepository struct {
+	db *gorm.DB
+}
+
+func NewLimitRepository(db *gorm.DB) *LimitRepository {
+	return &LimitRepository{
+		db: db,
+	}
+}
+
+// Save creates the limit, or updates it when it has an ID.
+func (r *LimitRepository) Save(ctx context.Context, limit *models.SpendingLimit) error {
+	if err := conn(ctx, r.db).Save(limit).Error; err != nil {
+		return fmt.Errorf("failed to save spending limit: %w", err)
+	}
+	return nil
+}
+
+func (r *LimitRepository) Get(ctx context.Context, id uint) (*models.SpendingLimit, error) {
+	var limit models.SpendingLimit
+	if err := conn(ctx, r.db).First(&limit, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get spending limit: %w", err)
+	}
+	return &limit, nil
+}
+
+// GetByScope returns the limit of a role, or of a user when role is empty,
+// for one transaction type and currency.
+func (r *LimitRepository) GetByScope(ctx context.Context, role string, userID uint, txType models.TransactionType, currency string) (*models.SpendingLimit, error) {
+	var limit models.SpendingLimit
+	if err := conn(ctx, r.db).
+		Where("role = ? AND user_id = ? AND transaction_type = ? AND currency = ?", role, userID, txType, currency).
+		First(&limit).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get spending limit: %w", err)
+	}
+	return &limit, nil
+}
+
+// FindEffective returns the user's override for the transaction type and
+// currency, or else the limit of the user's role.
+func (r *LimitRepository) FindEffective(ctx context.Context, userID uint, role string, txType models.TransactionType, currency string) (*models.SpendingLimit, error) {
+	var limits []models.SpendingLimit
+	if err := conn(ctx, r.db).
+		Where("((role = '' AND user_id = ?) OR (role = ? AND user_id = 0)) AND transaction_type = ? AND currency = ?", userID, role, txType, currency).
+		Order("user_id DESC").
+		Limit(1).
+		Find(&limits).Error; err != nil {
+		return nil, fmt.Errorf("failed to find spending limit: %w", err)
+	}
+	if len(limits) == 0 {
+		return nil, models.ErrNotFound
+	}
+	return &limits[0], nil
+}
+
+// List returns the limits of a role, of a user, or all of them when neither
+// is given.
+func (r *LimitRepository) List(ctx context.Context, role string, userID uint) ([]models.SpendingLimit, error) {
+	query := conn(ctx, r.db)
+	if role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var limits []models.SpendingLimit
+	if err := query.Order("role, user_id, transaction_type, currency").Find(&limits).Error; err != nil {
+		return nil, fmt.Errorf("failed to list spending limits: %w", err)
+	}
+	return limits, nil
+}
+
+func (r *LimitRepository) Delete(ctx context.Context, id uint) error {
+	result := conn(ctx, r.db).Delete(&models.SpendingLimit{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete spending limit: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// SumSpending totals the user's completed transactions of one type and
+// currency since the given time, along with those held for review, which
+// were let through the limits and may still complete.
+func (r *LimitRepository) SumSpending(ctx context.Context, userID uint, txType models.TransactionType, currency string, since time.Time) (models.LimitUsage, error) {
+	var row struct {
+		Amount decimal.NullDecimal
+		Count  int64
+	}
+	if err := conn(ctx, r.db).
+		Model(&models.Transaction{}).
+		Select("SUM(amount) AS amount, COUNT(*) AS count").
+		Where("from_user_id = ? AND type = ? AND currency = ? AND status IN ? AND created_at >= ?",
+			userID, txType, currency, []models.TransactionStatus{models.StatusCompleted, models.StatusReview}, since).
+		Scan(&row).Error; err != nil {
+		return models.LimitUsage{}, fmt.Errorf("failed to sum spending: %w", err)
+	}
+	return models.LimitUsage{Amount: row.Amount.Decimal, Count: row.Count}, nil
+}
@@ -0,0 +1,96 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ledger-link/internal/models"
+)
+
+type LockoutRepository struct {
+	db *gorm.DB
+}
+
+func NewLockoutRepository(db *gorm.DB) *LockoutRepository {
+	return &LockoutRepository{
+		db: db,
+	}
+}
+
+func (r *LockoutRepository) GetByID(ctx context.Context, id uint) (*models.LoginLockout, error) {
+	var lockout models.LoginLockout
+	if err := conn(ctx, r.db).First(&lockout, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get login lockout: %w", err)
+	}
+	return &lockout, nil
+}
+
+func (r *LockoutRepository) GetByEmail(ctx context.Context, email string) (*models.LoginLockout, error) {
+	var lockout models.LoginLockout
+	if err := conn(ctx, r.db).Where("email = ?", email).First(&lockout).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get login lockout: %w", err)
+	}
+	return &lockout, nil
+}
+
+// GetOrCreateForUpdate returns the email's lockout, created if it has none,
+// with its row locked so concurrent failures are all counted.
+func (r *LockoutRepository) GetOrCreateForUpdate(ctx context.Context, email string) (*models.LoginLockout, error) {
+	db := conn(ctx, r.db)
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.LoginLockout{Email: email}).Error; err != nil {
+		return nil, fmt.Errorf("failed to create login lockout: %w", err)
+	}
+
+	var lockout models.LoginLockout
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("email = ?", email).
+		First(&lockout).Error; err != nil {
+		return nil, fmt.Errorf("failed to get login lockout: %w", err)
+	}
+	return &lockout, nil
+}
+
+func (r *LockoutRepository) Save(ctx context.Context, lockout *models.LoginLockout) error {
+	if err := conn(ctx, r.db).Save(lockout).Error; err != nil {
+		return fmt.Errorf("failed to save login lockout: %w", err)
+	}
+	return nil
+}
+
+// ListLocked returns the lockouts still refusing logins at now, the
+// latest first.
+func (r *LockoutRepository) ListLocked(ctx context.Context, now time.Time) ([]models.LoginLockout, error) {
+	var lockouts []models.LoginLockout
+	if err := conn(ctx, r.db).
+		Where("locked_until > ?", now).
+		Order("locked_until DESC").
+		Find(&lockouts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list login lockouts: %w", err)
+	}
+	return lockouts, nil
+}
+
+func (r *LockoutRepository) Delete(ctx context.Context, id uint) error {
+	if err := conn(ctx, r.db).Delete(&models.LoginLockout{}, id).Error; err != nil {
+		return fmt.Errorf("failed to delete login lockout: %w", err)
+	}
+	return nil
+}
+
+func (r *LockoutRepository) DeleteByEmail(ctx context.Context, email string) error {
+	if err := conn(ctx, r.db).Where("email = ?", email).Delete(&models.LoginLockout{}).Error; err != nil {
+		return fmt.Errorf("failed to delete login lockout: %w", err)
+	}
+	return nil
+}
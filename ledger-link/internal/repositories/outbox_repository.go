@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"ledger-link/internal/models"
+)
+
+type OutboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) *OutboxRepository {
+	return &OutboxRepository{
+		db: db,
+	}
+}
+
+// ListUnpublished returns the oldest events not yet published, in the order
+// they were written.
+func (r *OutboxRepository) ListUnpublished(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	if err := conn(ctx, r.db).
+		Where("published_at IS NULL").
+		Order("id asc").
+		Limit(limit).
+		Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to list unpublished outbox events: %w", err)
+	}
+	return events, nil
+}
+
+func (r *OutboxRepository) MarkPublished(ctx context.Context, ids []uint, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := conn(ctx, r.db).
+		Model(&models.OutboxEvent{}).
+		Where("id IN ?", ids).
+		Update("published_at", at).Error; err != nil {
+		return fmt.Errorf("failed to mark outbox events published: %w", err)
+	}
+	return nil
+}
+
+// DeletePublishedBefore removes events published before the given time and
+// returns how many were removed.
+func (r *OutboxRepository) DeletePublishedBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := conn(ctx, r.db).
+		Where("published_at IS NOT NULL AND published_at < ?", before).
+		Delete(&models.OutboxEvent{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete published outbox events: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,189 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ledger-link/internal/models"
+)
+
+type ReconciliationRepository struct {
+	db *gorm.DB
+}
+
+func NewReconciliationRepository(db *gorm.DB) *ReconciliationRepository {
+	return &ReconciliationRepository{
+		db: db,
+	}
+}
+
+// CreateRun stores the run together with its discrepancies.
+func (r *ReconciliationRepository) CreateRun(ctx context.Context, run *models.ReconciliationRun) error {
+	if err := conn(ctx, r.db).Create(run).Error; err != nil {
+		return fmt.Errorf("failed to create reconciliation run: %w", err)
+	}
+	return nil
+}
+
+func (r *ReconciliationRepository) GetRun(ctx context.Context, id uint) (*models.ReconciliationRun, error) {
+	var run models.ReconciliationRun
+	if err := conn(ctx, r.db).
+		Preload("Discrepancies", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		First(&run, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get reconciliation run: %w", err)
+	}
+	return &run, nil
+}
+
+// ListRuns returns the latest runs, newest first, without their
+// discrepancies.
+func (r *ReconciliationRepository) ListRuns(ctx context.Context, limit int) ([]models.ReconciliationRun, error) {
+	var runs []models.ReconciliationRun
+	if err := conn(ctx, r.db).Order("id DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list reconciliation runs: %w", err)
+	}
+	return runs, nil
+}
+
+// GetDiscrepancyForUpdate reads the discrepancy and locks its row until the
+// surrounding unit of work ends.
+func (r *ReconciliationRepository) GetDiscrepancyForUpdate(ctx context.Context, id uint) (*models.Discrepancy, error) {
+	var discrepancy models.Discrepancy
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&discrepancy, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get discrepancy: %w", err)
+	}
+	return &discrepancy, nil
+}
+
+func (r *ReconciliationRepository) UpdateDiscrepancy(ctx context.Context, discrepancy *models.Discrepancy) error {
+	if err := conn(ctx, r.db).Save(discrepancy).Error; err != nil {
+		return fmt.Errorf("failed to update discrepancy: %w", err)
+	}
+	return nil
+}
+
+// ListCompletedTransactions returns up to limit completed transactions with
+// an ID above afterID, in ID order.
+func (r *ReconciliationRepository) ListCompletedTransactions(ctx context.Context, userID uint, afterID uint, limit int) ([]models.Transaction, error) {
+	query := conn(ctx, r.db).Where("status = ? AND id > ?", models.StatusCompleted, afterID)
+	if userID != 0 {
+		query = query.Where("(from_user_id = ? OR to_user_id = ?)", userID, userID)
+	}
+
+	var transactions []models.Transaction
+	if err := query.Order("id ASC").Limit(limit).Find(&transactions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list completed transactions: %w", err)
+	}
+	return transactions, nil
+}
+
+func (r *ReconciliationRepository) ListPostings(ctx context.Context, transactionIDs []uint) (models.Postings, error) {
+	var postings models.Postings
+	if len(transactionIDs) == 0 {
+		return postings, nil
+	}
+	if err := conn(ctx, r.db).
+		Where("transaction_id IN ?", transactionIDs).
+		Order("id ASC").
+		Find(&postings).Error; err != nil {
+		return nil, fmt.Errorf("failed to list postings: %w", err)
+	}
+	return postings, nil
+}
+
+func (r *ReconciliationRepository) SumUserPostings(ctx context.Context, userID uint) (map[models.BalanceKey]decimal.Decimal, error) {
+	query := conn(ctx, r.db).
+		Model(&models.Posting{}).
+		Select("account_id, currency, SUM(amount) AS total").
+		Where("account_type = ?", models.AccountTypeUser)
+	if userID != 0 {
+		query = query.Where("account_id = ?", userID)
+	}
+
+	var rows []struct {
+		AccountID uint
+		Currency  string
+		Total     decimal.Decimal
+	}
+	if err := query.Group("account_id, currency").Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to sum user postings: %w", err)
+	}
+
+	sums := make(map[models.BalanceKey]decimal.Decimal, len(rows))
+	for _, row := range rows {
+		sums[models.BalanceKey{UserID: row.AccountID, Currency: row.Currency}] = row.Total
+	}
+	return sums, nil
+}
+
+// LatestHistoryAmounts returns the new amount of the last history entry of
+// each balance.
+func (r *ReconciliationRepository) LatestHistoryAmounts(ctx context.Context, userID uint) (map[models.BalanceKey]decimal.Decimal, error) {
+	latest := conn(ctx, r.db).
+		Model(&models.BalanceHistory{}).
+		Select("MAX(id)").
+		Group("user_id, currency")
+	if userID != 0 {
+		latest = latest.Where("user_id = ?", userID)
+	}
+
+	var history []models.BalanceHistory
+	if err := conn(ctx, r.db).Where("id IN (?)", latest).Find(&history).Error; err != nil {
+		return nil, fmt.Errorf("failed to get latest balance history: %w", err)
+	}
+
+	amounts := make(map[models.BalanceKey]decimal.Decimal, len(history))
+	for _, entry := range history {
+		amounts[models.BalanceKey{UserID: entry.UserID, Currency: entry.Currency}] = entry.NewAmount
+	}
+	return amounts, nil
+}
+
+// ListUnsettledPostings returns user postings of transactions that are
+// missing or did not complete.
+func (r *ReconciliationRepository) ListUnsettledPostings(ctx context.Context, userID uint) (models.Postings, error) {
+	query := conn(ctx, r.db).
+		Model(&models.Posting{}).
+		Joins("LEFT JOIN transactions ON transactions.id = postings.transaction_id AND transactions.deleted_at IS NULL").
+		Where("postings.account_type = ?", models.AccountTypeUser).
+		Where("transactions.id IS NULL OR transactions.status <> ?", models.StatusCompleted)
+	if userID != 0 {
+		query = query.Where("postings.account_id = ?", userID)
+	}
+
+	var postings models.Postings
+	if err := query.Find(&postings).Error; err != nil {
+		return nil, fmt.Errorf("failed to list unsettled postings: %w", err)
+	}
+	return postings, nil
+}
+
+// ListUnsettledHistory returns history entries caused by transactions that
+// are missing or did not complete.
+func (r *ReconciliationRepository) ListUnsettledHistory(ctx context.Context, userID uint) ([]models.BalanceHistory, error) {
+	query := conn(ctx, r.db).
+		Joins("LEFT JOIN transactions ON transactions.id = balance_history.transaction_id AND transactions.deleted_at IS NULL").
+		Where("balance_history.transaction_id IS NOT NULL").
+		Where("transactions.id IS NULL OR transactions.status <> ?", models.StatusCompleted)
+	if userID != 0 {
+		query = query.Where("balance_history.user_id = ?", userID)
+	}
+
+	var history []models.BalanceHistory
+	if err := query.Find(&history).Error; err != nil {
+		return nil, fmt.Errorf("failed to list unsettled balance history: %w", err)
+	}
+	return history, nil
+}
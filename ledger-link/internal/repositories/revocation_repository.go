@@ -0,0 +1,51 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ledger-link/internal/models"
+)
+
+// RevocationRepository is the database backed models.RevocationStore.
+// Expired entries are removed as new ones are added.
+type RevocationRepository struct {
+	db *gorm.DB
+}
+
+func NewRevocationRepository(db *gorm.DB) *RevocationRepository {
+	return &RevocationRepository{
+		db: db,
+	}
+}
+
+func (r *RevocationRepository) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	now := time.Now()
+	if !expiresAt.After(now) {
+		return nil
+	}
+
+	db := conn(ctx, r.db)
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error; err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	if err := db.Where("expires_at <= ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		return fmt.Errorf("failed to remove expired revocations: %w", err)
+	}
+	return nil
+}
+
+func (r *RevocationRepository) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	if err := conn(ctx, r.db).Model(&models.RevokedToken{}).
+		Where("jti = ? AND expires_at > ?", jti, time.Now()).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return count > 0, nil
+}
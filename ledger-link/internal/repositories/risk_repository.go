@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ledger-link/internal/models"
+)
+
+// maxRiskHistory bounds the transactions handed to the risk rules.
+const maxRiskHistory = 1000
+
+type RiskRepository struct {
+	db *gorm.DB
+}
+
+func NewRiskRepository(db *gorm.DB) *RiskRepository {
+	return &RiskRepository{
+		db: db,
+	}
+}
+
+func (r *RiskRepository) CreateReview(ctx context.Context, review *models.RiskReview) error {
+	if err := conn(ctx, r.db).Create(review).Error; err != nil {
+		return fmt.Errorf("failed to create risk review: %w", err)
+	}
+	return nil
+}
+
+// GetReviewForUpdate reads the review and locks its row until the
+// surrounding unit of work ends.
+func (r *RiskRepository) GetReviewForUpdate(ctx context.Context, id uint) (*models.RiskReview, error) {
+	var review models.RiskReview
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&review, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get risk review: %w", err)
+	}
+	return &review, nil
+}
+
+func (r *RiskRepository) UpdateReview(ctx context.Context, review *models.RiskReview) error {
+	if err := conn(ctx, r.db).Save(review).Error; err != nil {
+		return fmt.Errorf("failed to update risk review: %w", err)
+	}
+	return nil
+}
+
+// ListReviews returns the reviews with the given status, or all of them,
+// oldest first.
+func (r *RiskRepository) ListReviews(ctx context.Context, status models.RiskReviewStatus) ([]models.RiskReview, error) {
+	query := conn(ctx, r.db)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var reviews []models.RiskReview
+	if err := query.Order("id").Find(&reviews).Error; err != nil {
+		return nil, fmt.Errorf("failed to list risk reviews: %w", err)
+	}
+	return reviews, nil
+}
+
+// RecentTransactions returns the debits and transfers the user sent since
+// the given time that have not failed, newest first.
+func (r *RiskRepository) RecentTransactions(ctx context.Context, userID uint, since time.Time) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	if err := conn(ctx, r.db).
+		Where("from_user_id = ? AND type IN ? AND status <> ? AND created_at >= ?",
+			userID, []models.TransactionType{models.TypeWithdrawal, models.TypeTransfer}, models.StatusFailed, since).
+		Order("created_at DESC, id DESC").
+		Limit(maxRiskHistory).
+		Find(&transactions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get recent transactions: %w", err)
+	}
+	return transactions, nil
+}
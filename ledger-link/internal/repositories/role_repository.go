@@ -0,0 +1,49 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"ledger-link/internal/models"
+)
+
+type RoleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) *RoleRepository {
+	return &RoleRepository{
+		db: db,
+	}
+}
+
+func (r *RoleRepository) GetByName(ctx context.Context, name string) (*models.Role, error) {
+	var role models.Role
+	if err := conn(ctx, r.db).Where("name = ?", name).First(&role).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	return &role, nil
+}
+
+// List returns the roles that have a row; roles without one hold their
+// defaults.
+func (r *RoleRepository) List(ctx context.Context) ([]models.Role, error) {
+	var roles []models.Role
+	if err := conn(ctx, r.db).Order("id").Find(&roles).Error; err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	return roles, nil
+}
+
+// Save creates the role's row, or updates it when it has an ID.
+func (r *RoleRepository) Save(ctx context.Context, role *models.Role) error {
+	if err := conn(ctx, r.db).Save(role).Error; err != nil {
+		return fmt.Errorf("failed to save role: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ledger-link/internal/models"
+)
+
+type ScheduleRepository struct {
+	db *gorm.DB
+}
+
+func NewScheduleRepository(db *gorm.DB) *ScheduleRepository {
+	return &ScheduleRepository{
+		db: db,
+	}
+}
+
+func (r *ScheduleRepository) Create(ctx context.Context, schedule *models.Schedule) error {
+	if err := conn(ctx, r.db).Create(schedule).Error; err != nil {
+		return fmt.Errorf("failed to create schedule: %w", err)
+	}
+	return nil
+}
+
+func (r *ScheduleRepository) GetByID(ctx context.Context, id uint) (*models.Schedule, error) {
+	var schedule models.Schedule
+	if err := conn(ctx, r.db).First(&schedule, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+// GetByIDForUpdate reads the schedule and locks its row until the
+// surrounding unit of work ends.
+func (r *ScheduleRepository) GetByIDForUpdate(ctx context.Context, id uint) (*models.Schedule, error) {
+	var schedule models.Schedule
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&schedule, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+// ListByUserID returns the user's schedules, newest first, optionally
+// filtered by status.
+func (r *ScheduleRepository) ListByUserID(ctx context.Context, userID uint, status models.ScheduleStatus) ([]models.Schedule, error) {
+	query := conn(ctx, r.db).Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var schedules []models.Schedule
+	if err := query.Order("created_at desc").Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// ListDue returns active schedules whose next run is at or before now,
+// oldest first.
+func (r *ScheduleRepository) ListDue(ctx context.Context, now time.Time, limit int) ([]models.Schedule, error) {
+	var schedules []models.Schedule
+	if err := conn(ctx, r.db).
+		Where("status = ? AND next_run_at <= ?", models.ScheduleStatusActive, now).
+		Order("next_run_at").
+		Limit(limit).
+		Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to list due schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+func (r *ScheduleRepository) Update(ctx context.Context, schedule *models.Schedule) error {
+	if err := conn(ctx, r.db).Save(schedule).Error; err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+	return nil
+}
+
+func (r *ScheduleRepository) CreateRun(ctx context.Context, run *models.ScheduleRun) error {
+	if err := conn(ctx, r.db).Create(run).Error; err != nil {
+		return fmt.Errorf("failed to record schedule run: %w", err)
+	}
+	return nil
+}
+
+// ListRuns returns the schedule's most recent runs, newest first.
+func (r *ScheduleRepository) ListRuns(ctx context.Context, scheduleID uint, limit int) ([]models.ScheduleRun, error) {
+	var runs []models.ScheduleRun
+	if err := conn(ctx, r.db).
+		Where("schedule_id = ?", scheduleID).
+		Order("id desc").
+		Limit(limit).
+		Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list schedule runs: %w", err)
+	}
+	return runs, nil
+}
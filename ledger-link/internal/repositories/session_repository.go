@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ledger-link/internal/models"
+)
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{
+		db: db,
+	}
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	if err := conn(ctx, r.db).Create(session).Error; err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// GetForUpdate reads the session and locks its row until the surrounding
+// unit of work ends.
+func (r *SessionRepository) GetForUpdate(ctx context.Context, id uint) (*models.Session, error) {
+	var session models.Session
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&session, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return &session, nil
+}
+
+func (r *SessionRepository) Update(ctx context.Context, session *models.Session) error {
+	if err := conn(ctx, r.db).Save(session).Error; err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+// ListActive returns the user's sessions that are neither revoked nor
+// expired, most recently used first.
+func (r *SessionRepository) ListActive(ctx context.Context, userID uint, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	if err := conn(ctx, r.db).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_used_at DESC, id DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+func (r *SessionRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	if err := conn(ctx, r.db).Create(token).Error; err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	return nil
+}
+
+// GetRefreshTokenForUpdate finds a refresh token by its hash and locks its
+// row, so two refreshes with the same token cannot both succeed.
+func (r *SessionRepository) GetRefreshTokenForUpdate(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", hash).
+		First(&token).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	return &token, nil
+}
+
+func (r *SessionRepository) UpdateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	if err := conn(ctx, r.db).Save(token).Error; err != nil {
+		return fmt.Errorf("failed to update refresh token: %w", err)
+	}
+	return nil
+}
@@ -3,8 +3,10 @@ package repositories
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"ledger-link/internal/models"
 )
@@ -20,7 +22,7 @@ func NewTransactionRepository(db *gorm.DB) *TransactionRepository {
 }
 
 func (r *TransactionRepository) Create(ctx context.Context, tx *models.Transaction) error {
-	if err := r.db.WithContext(ctx).Create(tx).Error; err != nil {
+	if err := conn(ctx, r.db).Create(tx).Error; err != nil {
 		return fmt.Errorf("failed to create transaction: %w", err)
 	}
 	return nil
@@ -28,11 +30,27 @@ func (r *TransactionRepository) Create(ctx context.Context, tx *models.Transacti
 
 func (r *TransactionRepository) GetByID(ctx context.Context, id uint) (*models.Transaction, error) {
 	var transaction models.Transaction
-	if err := r.db.WithContext(ctx).
+	if err := conn(ctx, r.db).
 		Preload("FromUser").
 		Preload("ToUser").
-		Preload("FromUser.Balance").
-		Preload("ToUser.Balance").
+		Preload("FromUser.Balances").
+		Preload("ToUser.Balances").
+		Preload("Reversals").
+		First(&transaction, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+	return &transaction, nil
+}
+
+// GetByIDForUpdate reads the transaction without its associations and locks
+// its row until the surrounding unit of work ends.
+func (r *TransactionRepository) GetByIDForUpdate(ctx context.Context, id uint) (*models.Transaction, error) {
+	var transaction models.Transaction
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
 		First(&transaction, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, models.ErrNotFound
@@ -44,11 +62,11 @@ func (r *TransactionRepository) GetByID(ctx context.Context, id uint) (*models.T
 
 func (r *TransactionRepository) GetByUserID(ctx context.Context, userID uint) ([]models.Transaction, error) {
 	var transactions []models.Transaction
-	if err := r.db.WithContext(ctx).
+	if err := conn(ctx, r.db).
 		Preload("FromUser").
 		Preload("ToUser").
-		Preload("FromUser.Balance").
-		Preload("ToUser.Balance").
+		Preload("FromUser.Balances").
+		Preload("ToUser.Balances").
 		Where("from_user_id = ? OR to_user_id = ?", userID, userID).
 		Order("created_at desc").
 		Find(&transactions).Error; err != nil {
@@ -57,8 +75,81 @@ func (r *TransactionRepository) GetByUserID(ctx context.Context, userID uint) ([
 	return transactions, nil
 }
 
+// GetByIDs returns the transactions with the given IDs, without their
+// associations, in no particular order.
+func (r *TransactionRepository) GetByIDs(ctx context.Context, ids []uint) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	if len(ids) == 0 {
+		return transactions, nil
+	}
+	if err := conn(ctx, r.db).Where("id IN ?", ids).Find(&transactions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+	return transactions, nil
+}
+
+// likeEscaper escapes LIKE wildcards with "!", which unlike a backslash
+// needs no quoting in either MySQL or SQLite.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// FindHistory returns up to filter.Limit of the user's transactions matching
+// the filter, ordered by (created_at, id) and starting after the cursor.
+func (r *TransactionRepository) FindHistory(ctx context.Context, filter models.TransactionFilter) ([]models.Transaction, error) {
+	query := conn(ctx, r.db).
+		Preload("FromUser").
+		Preload("ToUser").
+		Preload("FromUser.Balances").
+		Preload("ToUser.Balances").
+		Where("(from_user_id = ? OR to_user_id = ?)", filter.UserID, filter.UserID)
+
+	if filter.CounterpartyID != 0 {
+		query = query.Where("((from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?))",
+			filter.UserID, filter.CounterpartyID, filter.CounterpartyID, filter.UserID)
+	}
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.MinAmount.Valid {
+		query = query.Where("amount >= ?", filter.MinAmount.Decimal)
+	}
+	if filter.MaxAmount.Valid {
+		query = query.Where("amount <= ?", filter.MaxAmount.Decimal)
+	}
+	if filter.Notes != "" {
+		query = query.Where("notes LIKE ? ESCAPE '!'", "%"+likeEscaper.Replace(filter.Notes)+"%")
+	}
+
+	order, cmp := "desc", "<"
+	if filter.Sort == models.SortOldestFirst {
+		order, cmp = "asc", ">"
+	}
+	if filter.Cursor != nil {
+		query = query.Where("(created_at "+cmp+" ? OR (created_at = ? AND id "+cmp+" ?))",
+			filter.Cursor.CreatedAt, filter.Cursor.CreatedAt, filter.Cursor.ID)
+	}
+
+	var transactions []models.Transaction
+	if err := query.
+		Order("created_at " + order).
+		Order("id " + order).
+		Limit(filter.Limit).
+		Find(&transactions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get transaction history: %w", err)
+	}
+	return transactions, nil
+}
+
 func (r *TransactionRepository) Update(ctx context.Context, tx *models.Transaction) error {
-	result := r.db.WithContext(ctx).Save(tx)
+	result := conn(ctx, r.db).Save(tx)
 	if result.Error != nil {
 		return fmt.Errorf("failed to update transaction: %w", result.Error)
 	}
@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ledger-link/internal/models"
+)
+
+type TwoFactorRepository struct {
+	db *gorm.DB
+}
+
+func NewTwoFactorRepository(db *gorm.DB) *TwoFactorRepository {
+	return &TwoFactorRepository{
+		db: db,
+	}
+}
+
+func (r *TwoFactorRepository) GetByUserID(ctx context.Context, userID uint) (*models.TwoFactor, error) {
+	var credential models.TwoFactor
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).First(&credential).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get two-factor credential: %w", err)
+	}
+	return &credential, nil
+}
+
+// GetByUserIDForUpdate reads the user's credential and locks its row, so
+// two requests cannot both accept the same code.
+func (r *TwoFactorRepository) GetByUserIDForUpdate(ctx context.Context, userID uint) (*models.TwoFactor, error) {
+	var credential models.TwoFactor
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userID).
+		First(&credential).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get two-factor credential: %w", err)
+	}
+	return &credential, nil
+}
+
+func (r *TwoFactorRepository) Save(ctx context.Context, credential *models.TwoFactor) error {
+	if err := conn(ctx, r.db).Save(credential).Error; err != nil {
+		return fmt.Errorf("failed to save two-factor credential: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the user's credential and recovery codes.
+func (r *TwoFactorRepository) Delete(ctx context.Context, userID uint) error {
+	db := conn(ctx, r.db)
+	if err := db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if err := db.Where("user_id = ?", userID).Delete(&models.TwoFactor{}).Error; err != nil {
+		return fmt.Errorf("failed to delete two-factor credential: %w", err)
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes drops the user's recovery codes, used or not, for
+// new ones.
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error {
+	db := conn(ctx, r.db)
+	if err := db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	codes := make([]models.RecoveryCode, len(hashes))
+	for i, hash := range hashes {
+		codes[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+	}
+	if err := db.Create(&codes).Error; err != nil {
+		return fmt.Errorf("failed to create recovery codes: %w", err)
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the user's unused code with the hash as used and
+// reports whether there was one.
+func (r *TwoFactorRepository) UseRecoveryCode(ctx context.Context, userID uint, hash string, at time.Time) (bool, error) {
+	result := conn(ctx, r.db).Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", at)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *TwoFactorRepository) CountUnusedRecoveryCodes(ctx context.Context, userID uint) (int, error) {
+	var count int64
+	if err := conn(ctx, r.db).Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return int(count), nil
+}
+
+func (r *TwoFactorRepository) CreateChallenge(ctx context.Context, challenge *models.LoginChallenge) error {
+	if err := conn(ctx, r.db).Create(challenge).Error; err != nil {
+		return fmt.Errorf("failed to create login challenge: %w", err)
+	}
+	return nil
+}
+
+// GetChallengeForUpdate finds a login challenge by its hash and locks its
+// row, so it can only be completed once.
+func (r *TwoFactorRepository) GetChallengeForUpdate(ctx context.Context, hash string) (*models.LoginChallenge, error) {
+	var challenge models.LoginChallenge
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", hash).
+		First(&challenge).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get login challenge: %w", err)
+	}
+	return &challenge, nil
+}
+
+func (r *TwoFactorRepository) UpdateChallenge(ctx context.Context, challenge *models.LoginChallenge) error {
+	if err := conn(ctx, r.db).Save(challenge).Error; err != nil {
+		return fmt.Errorf("failed to update login challenge: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type txContextKey struct{}
+
+type txState struct {
+	db          *gorm.DB
+	afterCommit []func()
+}
+
+// UnitOfWork groups repository calls into a single database transaction.
+// Repositories look the transaction up in the context, so any repository call
+// made with the context handed to Do joins it.
+type UnitOfWork struct {
+	db *gorm.DB
+}
+
+func NewUnitOfWork(db *gorm.DB) *UnitOfWork {
+	return &UnitOfWork{
+		db: db,
+	}
+}
+
+// Do runs fn inside a transaction that is committed when fn returns nil and
+// rolled back otherwise. Nested calls join the outermost transaction.
+func (u *UnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txContextKey{}).(*txState); ok {
+		return fn(ctx)
+	}
+
+	state := &txState{}
+	err := u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		state.db = tx
+		return fn(context.WithValue(ctx, txContextKey{}, state))
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range state.afterCommit {
+		hook()
+	}
+	return nil
+}
+
+// InTransaction reports whether ctx belongs to a running unit of work.
+func (u *UnitOfWork) InTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txContextKey{}).(*txState)
+	return ok
+}
+
+// AfterCommit defers fn until the surrounding unit of work has committed. It
+// runs fn immediately when ctx is not part of a unit of work. Hooks are
+// dropped when the transaction rolls back.
+func (u *UnitOfWork) AfterCommit(ctx context.Context, fn func()) {
+	if state, ok := ctx.Value(txContextKey{}).(*txState); ok {
+		state.afterCommit = append(state.afterCommit, fn)
+		return
+	}
+	fn()
+}
+
+// conn returns the transaction bound to ctx, or db when there is none.
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if state, ok := ctx.Value(txContextKey{}).(*txState); ok {
+		return state.db.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
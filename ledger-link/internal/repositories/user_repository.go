@@ -3,8 +3,10 @@ package repositories
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"ledger-link/internal/models"
 )
@@ -20,7 +22,7 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 }
 
 func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
-	result := r.db.Create(user)
+	result := conn(ctx, r.db).Create(user)
 	if result.Error != nil {
 		return fmt.Errorf("failed to create user: %w", result.Error)
 	}
@@ -30,7 +32,22 @@ func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 
 func (r *UserRepository) GetByID(ctx context.Context, id uint) (*models.User, error) {
 	var user models.User
-	if err := r.db.WithContext(ctx).Preload("Balance").First(&user, id).Error; err != nil {
+	if err := conn(ctx, r.db).Preload("Balances").First(&user, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+// GetByIDForUpdate reads the user without their balances and locks the row
+// until the surrounding unit of work ends.
+func (r *UserRepository) GetByIDForUpdate(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&user, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, models.ErrNotFound
 		}
@@ -41,7 +58,7 @@ func (r *UserRepository) GetByID(ctx context.Context, id uint) (*models.User, er
 
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	if err := r.db.WithContext(ctx).Preload("Balance").Where("email = ?", email).First(&user).Error; err != nil {
+	if err := conn(ctx, r.db).Preload("Balances").Where("email = ?", email).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, models.ErrNotFound
 		}
@@ -52,7 +69,7 @@ func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.
 
 func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
 	var user models.User
-	if err := r.db.WithContext(ctx).Preload("Balance").Where("username = ?", username).First(&user).Error; err != nil {
+	if err := conn(ctx, r.db).Preload("Balances").Where("username = ?", username).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, models.ErrNotFound
 		}
@@ -62,14 +79,37 @@ func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*m
 }
 
 func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
-	if err := r.db.WithContext(ctx).Save(user).Error; err != nil {
+	if err := conn(ctx, r.db).Save(user).Error; err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
 	return nil
 }
 
+// UpdateRole changes the user's role without touching the rest of the row.
+func (r *UserRepository) UpdateRole(ctx context.Context, id uint, role string) error {
+	result := conn(ctx, r.db).Model(&models.User{}).Where("id = ?", id).UpdateColumn("role", role)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update user role: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id uint, at time.Time) error {
+	result := conn(ctx, r.db).Model(&models.User{}).Where("id = ?", id).UpdateColumn("email_verified_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark email verified: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
 func (r *UserRepository) Delete(ctx context.Context, id uint) error {
-	if err := r.db.WithContext(ctx).Delete(&models.User{}, "id = ?", id).Error; err != nil {
+	if err := conn(ctx, r.db).Delete(&models.User{}, "id = ?", id).Error; err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
 	return nil
@@ -77,7 +117,7 @@ func (r *UserRepository) Delete(ctx context.Context, id uint) error {
 
 func (r *UserRepository) GetUsers(ctx context.Context) ([]*models.User, error) {
 	var users []*models.User
-	result := r.db.WithContext(ctx).Preload("Balance").Find(&users)
+	result := conn(ctx, r.db).Preload("Balances").Find(&users)
 	if result.Error != nil {
 		return nil, fmt.Errorf("failed to get users: %w", result.Error)
 	}
@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ledger-link/internal/models"
+)
+
+type UserTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewUserTokenRepository(db *gorm.DB) *UserTokenRepository {
+	return &UserTokenRepository{
+		db: db,
+	}
+}
+
+func (r *UserTokenRepository) Create(ctx context.Context, token *models.UserToken) error {
+	if err := conn(ctx, r.db).Create(token).Error; err != nil {
+		return fmt.Errorf("failed to create user token: %w", err)
+	}
+	return nil
+}
+
+// GetByHashForUpdate finds a token by its hash and locks its row, so it
+// can only be redeemed once.
+func (r *UserTokenRepository) GetByHashForUpdate(ctx context.Context, hash string) (*models.UserToken, error) {
+	var token models.UserToken
+	if err := conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", hash).
+		First(&token).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get user token: %w", err)
+	}
+	return &token, nil
+}
+
+// GetLatest returns the user's latest token for the purpose.
+func (r *UserTokenRepository) GetLatest(ctx context.Context, userID uint, purpose string) (*models.UserToken, error) {
+	var token models.UserToken
+	if err := conn(ctx, r.db).
+		Where("user_id = ? AND purpose = ?", userID, purpose).
+		Order("id DESC").
+		First(&token).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get user token: %w", err)
+	}
+	return &token, nil
+}
+
+func (r *UserTokenRepository) Update(ctx context.Context, token *models.UserToken) error {
+	if err := conn(ctx, r.db).Save(token).Error; err != nil {
+		return fmt.Errorf("failed to update user token: %w", err)
+	}
+	return nil
+}
+
+// InvalidateUnused spends the user's unused tokens for the purpose, as when
+// a newer one is issued or one of them is redeemed.
+func (r *UserTokenRepository) InvalidateUnused(ctx context.Context, userID uint, purpose string, at time.Time) error {
+	if err := conn(ctx, r.db).Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", at).Error; err != nil {
+		return fmt.Errorf("failed to invalidate user tokens: %w", err)
+	}
+	return nil
+}
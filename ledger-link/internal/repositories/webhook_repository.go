@@ -0,0 +1,131 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"ledger-link/internal/models"
+)
+
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{
+		db: db,
+	}
+}
+
+func (r *WebhookRepository) CreateSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	if err := conn(ctx, r.db).Create(subscription).Error; err != nil {
+		return fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+	return nil
+}
+
+func (r *WebhookRepository) GetSubscription(ctx context.Context, id uint) (*models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+	if err := conn(ctx, r.db).First(&subscription, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	return &subscription, nil
+}
+
+// ListSubscriptions returns the user's subscriptions, and the global ones
+// when includeGlobal is set, newest first.
+func (r *WebhookRepository) ListSubscriptions(ctx context.Context, userID uint, includeGlobal bool) ([]models.WebhookSubscription, error) {
+	query := conn(ctx, r.db).Where("user_id = ?", userID)
+	if includeGlobal {
+		query = conn(ctx, r.db).Where("user_id = ? OR user_id IS NULL", userID)
+	}
+
+	var subscriptions []models.WebhookSubscription
+	if err := query.Order("id desc").Find(&subscriptions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// ListSubscriptionsFor returns the subscriptions of the given users together
+// with the global ones.
+func (r *WebhookRepository) ListSubscriptionsFor(ctx context.Context, userIDs []uint) ([]models.WebhookSubscription, error) {
+	var subscriptions []models.WebhookSubscription
+	if err := conn(ctx, r.db).
+		Where("user_id IN ? OR user_id IS NULL", userIDs).
+		Order("id").
+		Find(&subscriptions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+func (r *WebhookRepository) DeleteSubscription(ctx context.Context, id uint) error {
+	if err := conn(ctx, r.db).Delete(&models.WebhookSubscription{}, id).Error; err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	return nil
+}
+
+func (r *WebhookRepository) CreateDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := conn(ctx, r.db).Create(&deliveries).Error; err != nil {
+		return fmt.Errorf("failed to queue webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+func (r *WebhookRepository) GetDelivery(ctx context.Context, id uint) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := conn(ctx, r.db).First(&delivery, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+	return &delivery, nil
+}
+
+// ListDeliveries returns the subscription's most recent deliveries, newest
+// first, optionally filtered by status.
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, subscriptionID uint, status models.WebhookDeliveryStatus, limit int) ([]models.WebhookDelivery, error) {
+	query := conn(ctx, r.db).Where("subscription_id = ?", subscriptionID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Order("id desc").Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// ListDueDeliveries returns pending deliveries whose next attempt is at or
+// before now, in the order they were queued.
+func (r *WebhookRepository) ListDueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	if err := conn(ctx, r.db).
+		Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, now).
+		Order("id").
+		Limit(limit).
+		Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("failed to list due webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (r *WebhookRepository) UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if err := conn(ctx, r.db).Save(delivery).Error; err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	return nil
+}
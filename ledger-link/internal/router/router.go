@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"ledger-link/internal/handlers"
+	"ledger-link/internal/models"
 	"ledger-link/pkg/auth"
 	"ledger-link/pkg/httputil"
 	"ledger-link/pkg/middleware"
@@ -30,8 +31,26 @@ func NewRouter(
 	userHandler *handlers.UserHandler,
 	transactionHandler *handlers.TransactionHandler,
 	balanceHandler *handlers.BalanceHandler,
+	ledgerHandler *handlers.LedgerHandler,
+	fxHandler *handlers.FXHandler,
+	reversalHandler *handlers.ReversalHandler,
+	holdHandler *handlers.HoldHandler,
+	scheduleHandler *handlers.ScheduleHandler,
+	statementHandler *handlers.StatementHandler,
+	reconciliationHandler *handlers.ReconciliationHandler,
+	webhookHandler *handlers.WebhookHandler,
+	streamHandler *handlers.StreamHandler,
+	limitHandler *handlers.LimitHandler,
+	riskHandler *handlers.RiskHandler,
+	approvalHandler *handlers.ApprovalHandler,
+	jwksHandler *handlers.JWKSHandler,
+	apiKeyHandler *handlers.APIKeyHandler,
+	roleHandler *handlers.RoleHandler,
+	auditHandler *handlers.AuditHandler,
+	twoFactorHandler *handlers.TwoFactorHandler,
 	authMiddleware *middleware.AuthMiddleware,
 	rbacMiddleware *middleware.RBACMiddleware,
+	idempotencyMiddleware *middleware.IdempotencyMiddleware,
 	rateLimiter *ratelimit.RateLimiter,
 ) http.Handler {
 	mux := http.NewServeMux()
@@ -40,6 +59,15 @@ func NewRouter(
 	// Add metrics endpoint first
 	mux.Handle("/metrics", promhttp.Handler())
 
+	// Keys other services verify our access tokens with
+	mux.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		jwksHandler.HandleJWKS(w, r)
+	})
+
 	// Auth routes with rate limiting
 	mux.HandleFunc("/api/v1/auth/register", func(w http.ResponseWriter, r *http.Request) {
 		rateMiddleware.RegisterLimit(http.HandlerFunc(authHandler.Register)).ServeHTTP(w, r)
@@ -48,6 +76,15 @@ func NewRouter(
 		rateMiddleware.LoginLimit(http.HandlerFunc(authHandler.Login)).ServeHTTP(w, r)
 	})
 
+	// The second step of a login with two-factor authentication on
+	mux.HandleFunc("/api/v1/auth/login/verify", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rateMiddleware.LoginLimit(http.HandlerFunc(authHandler.VerifyLogin)).ServeHTTP(w, r)
+	})
+
 	// Transaction routes with rate limiting
 	mux.HandleFunc("/api/v1/transactions/transfer", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -55,8 +92,14 @@ func NewRouter(
 			return
 		}
 		authMiddleware.Authenticate(
-			rateMiddleware.TransactionLimit(
-				http.HandlerFunc(transactionHandler.HandleTransfer),
+			rbacMiddleware.RequireScope(models.ScopeTransactionsWrite)(
+				rbacMiddleware.RequireVerifiedEmail(
+					rateMiddleware.TransactionLimit(
+						idempotencyMiddleware.Handle(
+							http.HandlerFunc(transactionHandler.HandleTransfer),
+						),
+					),
+				),
 			),
 		).ServeHTTP(w, r)
 	})
@@ -68,8 +111,25 @@ func NewRouter(
 			return
 		}
 		authMiddleware.Authenticate(
-			rateMiddleware.BalanceLimit(
-				http.HandlerFunc(balanceHandler.GetCurrentBalance),
+			rbacMiddleware.RequireScope(models.ScopeBalancesRead)(
+				rateMiddleware.BalanceLimit(
+					http.HandlerFunc(balanceHandler.GetCurrentBalance),
+				),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	// Live balance and transaction updates as Server-Sent Events
+	mux.HandleFunc("/api/v1/updates", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequireScope(models.ScopeBalancesRead)(
+				rateMiddleware.BalanceLimit(
+					http.HandlerFunc(streamHandler.HandleStream),
+				),
 			),
 		).ServeHTTP(w, r)
 	})
@@ -77,20 +137,150 @@ func NewRouter(
 	// User operations with rate limiting
 	mux.HandleFunc("/api/v1/users", func(w http.ResponseWriter, r *http.Request) {
 		authMiddleware.Authenticate(
-			rateMiddleware.UserOperationLimit(
-				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					switch r.Method {
-					case http.MethodGet:
-						userHandler.GetUsers(w, r)
-					default:
-						http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-					}
-				}),
+			rbacMiddleware.RequireScope(models.ScopeUsersRead)(
+				rateMiddleware.UserOperationLimit(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.Method {
+						case http.MethodGet:
+							userHandler.GetUsers(w, r)
+						default:
+							http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+						}
+					}),
+				),
 			),
 		).ServeHTTP(w, r)
 	})
 
-	mux.HandleFunc("/api/v1/auth/refresh", authHandler.RefreshToken)
+	mux.HandleFunc("/api/v1/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rateMiddleware.LoginLimit(http.HandlerFunc(authHandler.RefreshToken)).ServeHTTP(w, r)
+	})
+
+	// Logout ends the current session; logout-all ends every session of the
+	// user
+	mux.HandleFunc("/api/v1/auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequireAccessToken(http.HandlerFunc(authHandler.Logout))).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/auth/logout-all", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequireAccessToken(http.HandlerFunc(authHandler.LogoutAll))).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/auth/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequireAccessToken(http.HandlerFunc(authHandler.ListSessions))).ServeHTTP(w, r)
+	})
+
+	// DELETE /api/v1/auth/sessions/{id}
+	mux.HandleFunc("/api/v1/auth/sessions/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/auth/sessions/")
+		if id == "" || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": id})
+		authMiddleware.Authenticate(rbacMiddleware.RequireAccessToken(http.HandlerFunc(authHandler.RevokeSession))).ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	mux.HandleFunc("/api/v1/auth/password", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequireAccessToken(
+				rateMiddleware.LoginLimit(http.HandlerFunc(authHandler.ChangePassword)),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	// Password reset: the forgot route mails a link, whose token the reset
+	// route takes with the new password
+	mux.HandleFunc("/api/v1/auth/password/forgot", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rateMiddleware.LoginLimit(http.HandlerFunc(authHandler.ForgotPassword)).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/auth/password/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rateMiddleware.LoginLimit(http.HandlerFunc(authHandler.ResetPassword)).ServeHTTP(w, r)
+	})
+
+	// Email verification: the link mailed at registration, or sent again on
+	// request, carries the token the verify route takes
+	mux.HandleFunc("/api/v1/auth/email/verify", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rateMiddleware.LoginLimit(http.HandlerFunc(authHandler.VerifyEmail)).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/auth/email/verification", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequireAccessToken(http.HandlerFunc(authHandler.RequestEmailVerification))).ServeHTTP(w, r)
+	})
+
+	// Two-factor authentication of the calling user: enroll, then confirm
+	// with a first code
+	mux.HandleFunc("/api/v1/auth/2fa", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequireAccessToken(http.HandlerFunc(twoFactorHandler.HandleStatus))).ServeHTTP(w, r)
+	})
+
+	// POST /api/v1/auth/2fa/{enroll,confirm,disable,recovery-codes}
+	mux.HandleFunc("/api/v1/auth/2fa/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var handler http.HandlerFunc
+		switch strings.TrimPrefix(r.URL.Path, "/api/v1/auth/2fa/") {
+		case "enroll":
+			handler = twoFactorHandler.HandleEnroll
+		case "confirm":
+			handler = twoFactorHandler.HandleConfirm
+		case "disable":
+			handler = twoFactorHandler.HandleDisable
+		case "recovery-codes":
+			handler = twoFactorHandler.HandleRegenerateRecoveryCodes
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequireAccessToken(rateMiddleware.LoginLimit(handler))).ServeHTTP(w, r)
+	})
 
 	mux.HandleFunc("/api/v1/users/me", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -99,19 +289,21 @@ func NewRouter(
 		}
 
 		authMiddleware.Authenticate(
-			rbacMiddleware.RequireUser(
-				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					user, ok := auth.GetUserFromContext(r.Context())
-					if !ok {
-						http.Error(w, "Unauthorized", http.StatusUnauthorized)
-						return
-					}
+			rbacMiddleware.RequireScope(models.ScopeUsersRead)(
+				rbacMiddleware.RequireUser(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						user, ok := auth.GetUserFromContext(r.Context())
+						if !ok {
+							http.Error(w, "Unauthorized", http.StatusUnauthorized)
+							return
+						}
 
-					ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": fmt.Sprint(user.ID)})
-					r = r.WithContext(ctx)
+						ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": fmt.Sprint(user.ID)})
+						r = r.WithContext(ctx)
 
-					userHandler.GetUser(w, r)
-				}),
+						userHandler.GetUser(w, r)
+					}),
+				),
 			),
 		).ServeHTTP(w, r)
 	})
@@ -126,24 +318,37 @@ func NewRouter(
 		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": id})
 		r = r.WithContext(ctx)
 
+		userID := func(r *http.Request) uint {
+			idStr := httputil.GetPathParam(r.Context(), "id")
+			id, _ := strconv.ParseUint(idStr, 10, 32)
+			return uint(id)
+		}
+
+		// API keys may read users but not change them; staff who may read
+		// any account may read any user
+		requireScope := rbacMiddleware.RequireScope(models.ScopeUsersRead)
+		requireOwner := rbacMiddleware.RequireOwnerOrPermission(models.PermissionAccountsRead, userID)
+		if r.Method != http.MethodGet {
+			requireScope = rbacMiddleware.RequireAccessToken
+			requireOwner = rbacMiddleware.RequireOwnerOrAdmin(userID)
+		}
+
 		authMiddleware.Authenticate(
-			rbacMiddleware.RequireOwnerOrAdmin(func(r *http.Request) uint {
-				idStr := httputil.GetPathParam(r.Context(), "id")
-				id, _ := strconv.ParseUint(idStr, 10, 32)
-				return uint(id)
-			})(
-				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					switch r.Method {
-					case http.MethodGet:
-						userHandler.GetUser(w, r)
-					case http.MethodPut:
-						userHandler.UpdateUser(w, r)
-					case http.MethodDelete:
-						userHandler.DeleteUser(w, r)
-					default:
-						http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-					}
-				}),
+			requireScope(
+				requireOwner(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.Method {
+						case http.MethodGet:
+							userHandler.GetUser(w, r)
+						case http.MethodPut:
+							userHandler.UpdateUser(w, r)
+						case http.MethodDelete:
+							userHandler.DeleteUser(w, r)
+						default:
+							http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+						}
+					}),
+				),
 			),
 		).ServeHTTP(w, r)
 	})
@@ -154,7 +359,9 @@ func NewRouter(
 			return
 		}
 		authMiddleware.Authenticate(
-			http.HandlerFunc(transactionHandler.HandleGetTransactionHistory),
+			rbacMiddleware.RequireScope(models.ScopeTransactionsRead)(
+				http.HandlerFunc(transactionHandler.HandleGetTransactionHistory),
+			),
 		).ServeHTTP(w, r)
 	})
 
@@ -164,7 +371,11 @@ func NewRouter(
 			return
 		}
 		authMiddleware.Authenticate(
-			http.HandlerFunc(transactionHandler.HandleCredit),
+			rbacMiddleware.RequireScope(models.ScopeTransactionsWrite)(
+				idempotencyMiddleware.Handle(
+					http.HandlerFunc(transactionHandler.HandleCredit),
+				),
+			),
 		).ServeHTTP(w, r)
 	})
 
@@ -174,11 +385,39 @@ func NewRouter(
 			return
 		}
 		authMiddleware.Authenticate(
-			http.HandlerFunc(transactionHandler.HandleDebit),
+			rbacMiddleware.RequireScope(models.ScopeTransactionsWrite)(
+				rbacMiddleware.RequireVerifiedEmail(
+					idempotencyMiddleware.Handle(
+						http.HandlerFunc(transactionHandler.HandleDebit),
+					),
+				),
+			),
 		).ServeHTTP(w, r)
 	})
 
 	mux.HandleFunc("/api/v1/transactions/", func(w http.ResponseWriter, r *http.Request) {
+		// POST /api/v1/transactions/{id}/reverse
+		if strings.HasSuffix(r.URL.Path, "/reverse") {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			id := getIDFromPath(strings.TrimSuffix(r.URL.Path, "/reverse"))
+			ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": id})
+			r = r.WithContext(ctx)
+
+			authMiddleware.Authenticate(
+				rbacMiddleware.RequireScope(models.ScopeTransactionsWrite)(
+					rateMiddleware.TransactionLimit(
+						idempotencyMiddleware.Handle(
+							http.HandlerFunc(reversalHandler.HandleReverse),
+						),
+					),
+				),
+			).ServeHTTP(w, r)
+			return
+		}
+
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -193,7 +432,23 @@ func NewRouter(
 		r = r.WithContext(ctx)
 
 		authMiddleware.Authenticate(
-			http.HandlerFunc(transactionHandler.HandleGetTransaction),
+			rbacMiddleware.RequireScope(models.ScopeTransactionsRead)(
+				http.HandlerFunc(transactionHandler.HandleGetTransaction),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/balances", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequireScope(models.ScopeBalancesRead)(
+				rateMiddleware.BalanceLimit(
+					http.HandlerFunc(balanceHandler.ListBalances),
+				),
+			),
 		).ServeHTTP(w, r)
 	})
 
@@ -203,7 +458,523 @@ func NewRouter(
 			return
 		}
 		authMiddleware.Authenticate(
-			http.HandlerFunc(balanceHandler.GetBalanceHistory),
+			rbacMiddleware.RequireScope(models.ScopeBalancesRead)(
+				http.HandlerFunc(balanceHandler.GetBalanceHistory),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/balances/at", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequireScope(models.ScopeBalancesRead)(
+				rateMiddleware.BalanceLimit(
+					http.HandlerFunc(balanceHandler.GetBalanceAtTime),
+				),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/admin/balances/at", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequireAdmin(
+				http.HandlerFunc(balanceHandler.ListBalancesAtTime),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/admin/ledger/check", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionLedgerCheck)(
+				http.HandlerFunc(ledgerHandler.HandleCheckInvariants),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/admin/reconciliations", func(w http.ResponseWriter, r *http.Request) {
+		var handler http.Handler
+		switch r.Method {
+		case http.MethodGet:
+			handler = http.HandlerFunc(reconciliationHandler.HandleListRuns)
+		case http.MethodPost:
+			handler = http.HandlerFunc(reconciliationHandler.HandleRun)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequirePermission(models.PermissionReconciliationsManage)(handler)).ServeHTTP(w, r)
+	})
+
+	// GET /api/v1/admin/reconciliations/{id}
+	mux.HandleFunc("/api/v1/admin/reconciliations/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/reconciliations/"), "/")
+		if len(parts) != 1 || r.Method != http.MethodGet {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": parts[0]})
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionReconciliationsManage)(
+				http.HandlerFunc(reconciliationHandler.HandleGetRun),
+			),
+		).ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	mux.HandleFunc("/api/v1/admin/limits", func(w http.ResponseWriter, r *http.Request) {
+		var handler http.Handler
+		switch r.Method {
+		case http.MethodGet:
+			handler = http.HandlerFunc(limitHandler.HandleListLimits)
+		case http.MethodPut:
+			handler = http.HandlerFunc(limitHandler.HandleSetLimit)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequirePermission(models.PermissionLimitsManage)(handler)).ServeHTTP(w, r)
+	})
+
+	// DELETE /api/v1/admin/limits/{id}
+	mux.HandleFunc("/api/v1/admin/limits/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/limits/"), "/")
+		if len(parts) != 1 || r.Method != http.MethodDelete {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": parts[0]})
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionLimitsManage)(
+				http.HandlerFunc(limitHandler.HandleDeleteLimit),
+			),
+		).ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	mux.HandleFunc("/api/v1/admin/reviews", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionReviewsDecide)(
+				http.HandlerFunc(riskHandler.HandleListReviews),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	// POST /api/v1/admin/reviews/{id}/approve and /reject
+	mux.HandleFunc("/api/v1/admin/reviews/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/reviews/"), "/")
+		if len(parts) != 2 || r.Method != http.MethodPost {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		var handler http.HandlerFunc
+		switch parts[1] {
+		case "approve":
+			handler = riskHandler.HandleApproveReview
+		case "reject":
+			handler = riskHandler.HandleRejectReview
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": parts[0]})
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionReviewsDecide)(handler),
+		).ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	mux.HandleFunc("/api/v1/admin/adjustments", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionAdjustmentsCreate)(
+				idempotencyMiddleware.Handle(
+					http.HandlerFunc(approvalHandler.HandleAdjustment),
+				),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/admin/transfers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionAdjustmentsCreate)(
+				idempotencyMiddleware.Handle(
+					http.HandlerFunc(approvalHandler.HandleTransfer),
+				),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/admin/approvals", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionApprovalsDecide)(
+				http.HandlerFunc(approvalHandler.HandleListApprovals),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	// POST /api/v1/admin/approvals/{id}/approve and /reject
+	mux.HandleFunc("/api/v1/admin/approvals/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/approvals/"), "/")
+		if len(parts) != 2 || r.Method != http.MethodPost {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		var handler http.HandlerFunc
+		switch parts[1] {
+		case "approve":
+			handler = approvalHandler.HandleApprove
+		case "reject":
+			handler = approvalHandler.HandleReject
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": parts[0]})
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionApprovalsDecide)(handler),
+		).ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	mux.HandleFunc("/api/v1/admin/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		var handler http.Handler
+		switch r.Method {
+		case http.MethodGet:
+			handler = http.HandlerFunc(apiKeyHandler.HandleListAPIKeys)
+		case http.MethodPost:
+			handler = http.HandlerFunc(apiKeyHandler.HandleCreateAPIKey)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequireAdmin(handler)).ServeHTTP(w, r)
+	})
+
+	// DELETE /api/v1/admin/api-keys/{id}
+	mux.HandleFunc("/api/v1/admin/api-keys/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/api-keys/"), "/")
+		if len(parts) != 1 || r.Method != http.MethodDelete {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": parts[0]})
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequireAdmin(
+				http.HandlerFunc(apiKeyHandler.HandleRevokeAPIKey),
+			),
+		).ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	// Email addresses locked out after failed logins
+	mux.HandleFunc("/api/v1/admin/lockouts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequirePermission(models.PermissionLockoutsManage)(http.HandlerFunc(userHandler.ListLockouts))).ServeHTTP(w, r)
+	})
+
+	// DELETE /api/v1/admin/lockouts/{id}
+	mux.HandleFunc("/api/v1/admin/lockouts/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/lockouts/"), "/")
+		if len(parts) != 1 || r.Method != http.MethodDelete {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": parts[0]})
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionLockoutsManage)(
+				http.HandlerFunc(userHandler.Unlock),
+			),
+		).ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	mux.HandleFunc("/api/v1/admin/roles", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionRolesManage)(
+				http.HandlerFunc(roleHandler.HandleListRoles),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	// PUT /api/v1/admin/roles/{role}
+	mux.HandleFunc("/api/v1/admin/roles/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/roles/"), "/")
+		if len(parts) != 1 || r.Method != http.MethodPut {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"role": parts[0]})
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionRolesManage)(
+				http.HandlerFunc(roleHandler.HandleSetPermissions),
+			),
+		).ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	// PUT /api/v1/admin/users/{id}/role
+	mux.HandleFunc("/api/v1/admin/users/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/users/"), "/")
+		if len(parts) != 2 || parts[1] != "role" || r.Method != http.MethodPut {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": parts[0]})
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionRolesManage)(
+				http.HandlerFunc(roleHandler.HandleAssignRole),
+			),
+		).ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	mux.HandleFunc("/api/v1/admin/audit-logs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionAuditLogsRead)(
+				http.HandlerFunc(auditHandler.HandleListAuditLogs),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	// POST /api/v1/admin/discrepancies/{id}/repair
+	mux.HandleFunc("/api/v1/admin/discrepancies/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/discrepancies/"), "/")
+		if len(parts) != 2 || parts[1] != "repair" || r.Method != http.MethodPost {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": parts[0]})
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequirePermission(models.PermissionReconciliationsManage)(
+				http.HandlerFunc(reconciliationHandler.HandleRepair),
+			),
+		).ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	mux.HandleFunc("/api/v1/fx/quotes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequireScope(models.ScopeTransactionsWrite)(
+				rateMiddleware.TransactionLimit(
+					http.HandlerFunc(fxHandler.HandleCreateQuote),
+				),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/fx/transfers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequireScope(models.ScopeTransactionsWrite)(
+				rbacMiddleware.RequireVerifiedEmail(
+					rateMiddleware.TransactionLimit(
+						idempotencyMiddleware.Handle(
+							http.HandlerFunc(fxHandler.HandleExecuteQuote),
+						),
+					),
+				),
+			),
+		).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/holds", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authMiddleware.Authenticate(
+				rbacMiddleware.RequireScope(models.ScopeTransactionsRead)(
+					http.HandlerFunc(holdHandler.HandleListHolds),
+				),
+			).ServeHTTP(w, r)
+		case http.MethodPost:
+			authMiddleware.Authenticate(
+				rbacMiddleware.RequireScope(models.ScopeTransactionsWrite)(
+					rbacMiddleware.RequireVerifiedEmail(
+						rateMiddleware.TransactionLimit(
+							idempotencyMiddleware.Handle(
+								http.HandlerFunc(holdHandler.HandlePlaceHold),
+							),
+						),
+					),
+				),
+			).ServeHTTP(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// GET /api/v1/holds/{id}, POST /api/v1/holds/{id}/capture and /void
+	mux.HandleFunc("/api/v1/holds/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/holds/"), "/")
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": parts[0]})
+		r = r.WithContext(ctx)
+
+		var handler http.Handler
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			handler = http.HandlerFunc(holdHandler.HandleGetHold)
+		case len(parts) == 2 && parts[1] == "capture" && r.Method == http.MethodPost:
+			handler = rbacMiddleware.RequireVerifiedEmail(
+				rateMiddleware.TransactionLimit(
+					idempotencyMiddleware.Handle(
+						http.HandlerFunc(holdHandler.HandleCaptureHold),
+					),
+				),
+			)
+		case len(parts) == 2 && parts[1] == "void" && r.Method == http.MethodPost:
+			handler = http.HandlerFunc(holdHandler.HandleVoidHold)
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		requireScope := rbacMiddleware.RequireScope(models.ScopeTransactionsWrite)
+		if r.Method == http.MethodGet {
+			requireScope = rbacMiddleware.RequireScope(models.ScopeTransactionsRead)
+		}
+		authMiddleware.Authenticate(requireScope(handler)).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/schedules", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authMiddleware.Authenticate(
+				rbacMiddleware.RequireScope(models.ScopeTransactionsRead)(
+					http.HandlerFunc(scheduleHandler.HandleListSchedules),
+				),
+			).ServeHTTP(w, r)
+		case http.MethodPost:
+			authMiddleware.Authenticate(
+				rbacMiddleware.RequireScope(models.ScopeTransactionsWrite)(
+					rbacMiddleware.RequireVerifiedEmail(
+						rateMiddleware.TransactionLimit(
+							idempotencyMiddleware.Handle(
+								http.HandlerFunc(scheduleHandler.HandleCreateSchedule),
+							),
+						),
+					),
+				),
+			).ServeHTTP(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// GET /api/v1/schedules/{id} and /runs, POST /api/v1/schedules/{id}/cancel
+	mux.HandleFunc("/api/v1/schedules/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/schedules/"), "/")
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, map[string]string{"id": parts[0]})
+		r = r.WithContext(ctx)
+
+		var handler http.Handler
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			handler = http.HandlerFunc(scheduleHandler.HandleGetSchedule)
+		case len(parts) == 2 && parts[1] == "runs" && r.Method == http.MethodGet:
+			handler = http.HandlerFunc(scheduleHandler.HandleListRuns)
+		case len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+			handler = http.HandlerFunc(scheduleHandler.HandleCancelSchedule)
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		requireScope := rbacMiddleware.RequireScope(models.ScopeTransactionsWrite)
+		if r.Method == http.MethodGet {
+			requireScope = rbacMiddleware.RequireScope(models.ScopeTransactionsRead)
+		}
+		authMiddleware.Authenticate(requireScope(handler)).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		var handler http.Handler
+		switch r.Method {
+		case http.MethodGet:
+			handler = http.HandlerFunc(webhookHandler.HandleListSubscriptions)
+		case http.MethodPost:
+			handler = http.HandlerFunc(webhookHandler.HandleCreateSubscription)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authMiddleware.Authenticate(rbacMiddleware.RequireAccessToken(handler)).ServeHTTP(w, r)
+	})
+
+	// GET and DELETE /api/v1/webhooks/{id}, GET /api/v1/webhooks/{id}/deliveries,
+	// POST /api/v1/webhooks/{id}/deliveries/{delivery_id}/redeliver
+	mux.HandleFunc("/api/v1/webhooks/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/webhooks/"), "/")
+		params := map[string]string{"id": parts[0]}
+		if len(parts) == 4 {
+			params["delivery_id"] = parts[2]
+		}
+		ctx := context.WithValue(r.Context(), httputil.PathParamsKey, params)
+		r = r.WithContext(ctx)
+
+		var handler http.Handler
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			handler = http.HandlerFunc(webhookHandler.HandleGetSubscription)
+		case len(parts) == 1 && r.Method == http.MethodDelete:
+			handler = http.HandlerFunc(webhookHandler.HandleDeleteSubscription)
+		case len(parts) == 2 && parts[1] == "deliveries" && r.Method == http.MethodGet:
+			handler = http.HandlerFunc(webhookHandler.HandleListDeliveries)
+		case len(parts) == 4 && parts[1] == "deliveries" && parts[3] == "redeliver" && r.Method == http.MethodPost:
+			handler = rateMiddleware.RedeliverLimit(http.HandlerFunc(webhookHandler.HandleRedeliver))
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		authMiddleware.Authenticate(rbacMiddleware.RequireAccessToken(handler)).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/statements", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		authMiddleware.Authenticate(
+			rbacMiddleware.RequireScope(models.ScopeTransactionsRead)(
+				rateMiddleware.TransactionLimit(
+					http.HandlerFunc(statementHandler.HandleGetStatement),
+				),
+			),
 		).ServeHTTP(w, r)
 	})
 
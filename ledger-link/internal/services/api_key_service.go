@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+// apiKeyTouchInterval is how stale a key's last-used time may get before a
+// request records it again, so busy clients do not write on every call
+const apiKeyTouchInterval = time.Minute
+
+var apiKeyAuthentications = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_api_key_authentications_total",
+		Help: "Total number of API key authentication attempts by outcome",
+	},
+	[]string{"outcome"},
+)
+
+// APIKeyService lets admins issue API keys that act as a user within their
+// scopes, and authenticates the requests made with them.
+type APIKeyService struct {
+	repo     models.APIKeyRepository
+	users    models.UserRepository
+	uow      models.UnitOfWork
+	auditSvc models.AuditService
+	logger   *logger.Logger
+}
+
+func NewAPIKeyService(
+	repo models.APIKeyRepository,
+	users models.UserRepository,
+	uow models.UnitOfWork,
+	auditSvc models.AuditService,
+	logger *logger.Logger,
+) *APIKeyService {
+	return &APIKeyService{
+		repo:     repo,
+		users:    users,
+		uow:      uow,
+		auditSvc: auditSvc,
+		logger:   logger,
+	}
+}
+
+// Create issues a key for the user with the requested name, scopes, allowed
+// IPs and expiry on behalf of the calling admin. The returned key is shown
+// once; only its hash is kept.
+func (s *APIKeyService) Create(ctx context.Context, request *models.APIKey) (*models.APIKey, string, error) {
+	admin, ok := auth.GetUserFromContext(ctx)
+	if !ok || admin.Role != models.RoleAdmin {
+		return nil, "", models.ErrUnauthorized
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if err := request.Validate(); err != nil {
+		return nil, "", err
+	}
+	if _, err := s.users.GetByID(ctx, request.UserID); err != nil {
+		return nil, "", fmt.Errorf("failed to get user: %w", err)
+	}
+
+	secret, prefix, hash, err := models.NewAPIKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	key := &models.APIKey{
+		Name:       request.Name,
+		Prefix:     prefix,
+		KeyHash:    hash,
+		UserID:     request.UserID,
+		Scopes:     request.Scopes,
+		AllowedIPs: request.AllowedIPs,
+		ExpiresAt:  request.ExpiresAt,
+		CreatedBy:  admin.ID,
+	}
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, key); err != nil {
+			return err
+		}
+		details := fmt.Sprintf("Issued API key %s (%s) for user %d with scopes %s",
+			key.Prefix, key.Name, key.UserID, strings.Join(key.Scopes, ", "))
+		if err := s.auditSvc.LogAction(ctx, models.EntityTypeAPIKey, key.ID, models.ActionCreate, details); err != nil {
+			return fmt.Errorf("failed to log API key creation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.logger.Info("API key issued", "key_id", key.ID, "prefix", key.Prefix, "user_id", key.UserID, "created_by", admin.ID)
+	return key, secret, nil
+}
+
+// List returns the keys of the user, or of every user when userID is 0.
+func (s *APIKeyService) List(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	admin, ok := auth.GetUserFromContext(ctx)
+	if !ok || admin.Role != models.RoleAdmin {
+		return nil, models.ErrUnauthorized
+	}
+	return s.repo.List(ctx, userID)
+}
+
+// Revoke stops the key from authenticating; revoking it again is a no-op.
+func (s *APIKeyService) Revoke(ctx context.Context, id uint) (*models.APIKey, error) {
+	admin, ok := auth.GetUserFromContext(ctx)
+	if !ok || admin.Role != models.RoleAdmin {
+		return nil, models.ErrUnauthorized
+	}
+
+	key, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return key, nil
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, key); err != nil {
+			return err
+		}
+		details := fmt.Sprintf("Revoked API key %s (%s)", key.Prefix, key.Name)
+		if err := s.auditSvc.LogAction(ctx, models.EntityTypeAPIKey, key.ID, models.ActionDelete, details); err != nil {
+			return fmt.Errorf("failed to log API key revocation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Authenticate returns the user an API key acts as, and the key. Unknown,
+// revoked and expired keys and keys used from outside their allowlist all
+// fail with ErrInvalidAPIKey.
+func (s *APIKeyService) Authenticate(ctx context.Context, secret, ipAddress string) (*models.User, *models.APIKey, error) {
+	key, err := s.lookup(ctx, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case !key.IsActive(now):
+		apiKeyAuthentications.WithLabelValues("inactive").Inc()
+		return nil, nil, models.ErrInvalidAPIKey
+	case !key.AllowsIP(ipAddress):
+		apiKeyAuthentications.WithLabelValues("ip_denied").Inc()
+		s.logger.Warn("API key used from outside its allowlist", "prefix", key.Prefix, "ip", ipAddress)
+		return nil, nil, models.ErrInvalidAPIKey
+	}
+
+	user, err := s.users.GetByID(ctx, key.UserID)
+	if err != nil {
+		apiKeyAuthentications.WithLabelValues("error").Inc()
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval || key.LastUsedIP != ipAddress {
+		if err := s.repo.TouchLastUsed(ctx, key.ID, now, ipAddress); err != nil {
+			s.logger.Error("failed to record API key use", "error", err, "prefix", key.Prefix)
+		} else {
+			key.LastUsedAt, key.LastUsedIP = &now, ipAddress
+		}
+	}
+
+	apiKeyAuthentications.WithLabelValues("success").Inc()
+	return user, key, nil
+}
+
+func (s *APIKeyService) lookup(ctx context.Context, secret string) (*models.APIKey, error) {
+	prefix, ok := models.APIKeyPrefixOf(secret)
+	if !ok {
+		apiKeyAuthentications.WithLabelValues("malformed").Inc()
+		return nil, models.ErrInvalidAPIKey
+	}
+	key, err := s.repo.GetByPrefix(ctx, prefix)
+	if errors.Is(err, models.ErrNotFound) {
+		apiKeyAuthentications.WithLabelValues("unknown").Inc()
+		return nil, models.ErrInvalidAPIKey
+	}
+	if err != nil {
+		apiKeyAuthentications.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	if !key.Matches(secret) {
+		apiKeyAuthentications.WithLabelValues("unknown").Inc()
+		return nil, models.ErrInvalidAPIKey
+	}
+	return key, nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/logger"
+)
+
+func newTestAPIKeys(ledger *testLedger) *APIKeyService {
+	log := logger.New("error")
+	return NewAPIKeyService(repositories.NewAPIKeyRepository(ledger.db), repositories.NewUserRepository(ledger.db),
+		repositories.NewUnitOfWork(ledger.db), NewAuditService(repositories.NewAuditLogRepository(ledger.db), log), log)
+}
+
+func TestAPIKeysAuthenticateWithinAllowlistAndExpiry(t *testing.T) {
+	ledger := newTestLedger(t)
+	apiKeys := newTestAPIKeys(ledger)
+	admin := asUser(10, models.RoleAdmin)
+	ctx := context.Background()
+
+	_, _, err := apiKeys.Create(asUser(1, models.RoleUser), &models.APIKey{Name: "payouts", UserID: 1, Scopes: []string{models.ScopeBalancesRead}})
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+	_, _, err = apiKeys.Create(admin, &models.APIKey{Name: "payouts", UserID: 1, Scopes: []string{"balances:write"}})
+	assert.ErrorIs(t, err, models.ErrInvalidAPIKey)
+	_, _, err = apiKeys.Create(admin, &models.APIKey{Name: "payouts", UserID: 1, Scopes: []string{models.ScopeBalancesRead}, AllowedIPs: []string{"10.0.0.0/33"}})
+	assert.ErrorIs(t, err, models.ErrInvalidAPIKey)
+
+	expiresAt := time.Now().Add(time.Hour)
+	key, secret, err := apiKeys.Create(admin, &models.APIKey{
+		Name:       "payouts",
+		UserID:     1,
+		Scopes:     []string{models.ScopeTransactionsWrite, models.ScopeBalancesRead},
+		AllowedIPs: []string{"10.0.0.0/8", "192.168.1.7"},
+		ExpiresAt:  &expiresAt,
+	})
+	require.NoError(t, err)
+	assert.Regexp(t, `^llk_[0-9a-f]{12}_[0-9a-f]{64}$`, secret)
+	assert.Equal(t, secret[:len(key.Prefix)], key.Prefix)
+	assert.Equal(t, models.HashOpaqueToken(secret), key.KeyHash)
+	assert.True(t, key.HasScope(models.ScopeBalancesRead))
+	assert.False(t, key.HasScope(models.ScopeUsersRead))
+
+	user, used, err := apiKeys.Authenticate(ctx, secret, "10.1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), user.ID)
+	assert.Equal(t, key.ID, used.ID)
+	_, _, err = apiKeys.Authenticate(ctx, secret, "::ffff:192.168.1.7")
+	require.NoError(t, err)
+
+	stored, err := repositories.NewAPIKeyRepository(ledger.db).GetByID(ctx, key.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.LastUsedAt)
+	assert.Equal(t, "::ffff:192.168.1.7", stored.LastUsedIP)
+
+	// Outside the allowlist, with a wrong secret or an unknown prefix, the
+	// key is rejected the same way
+	for _, attempt := range []struct{ secret, ip string }{
+		{secret, "192.168.1.8"},
+		{key.Prefix + "_" + "00", "10.1.2.3"},
+		{"llk_000000000000_00", "10.1.2.3"},
+		{"not-a-key", "10.1.2.3"},
+	} {
+		_, _, err = apiKeys.Authenticate(ctx, attempt.secret, attempt.ip)
+		assert.ErrorIs(t, err, models.ErrInvalidAPIKey, attempt.secret)
+	}
+
+	// Expired and revoked keys stop working
+	past := time.Now().Add(-time.Minute)
+	require.NoError(t, ledger.db.Model(&models.APIKey{}).Where("id = ?", key.ID).Update("expires_at", past).Error)
+	_, _, err = apiKeys.Authenticate(ctx, secret, "10.1.2.3")
+	assert.ErrorIs(t, err, models.ErrInvalidAPIKey)
+
+	other, otherSecret, err := apiKeys.Create(admin, &models.APIKey{Name: "reports", UserID: 2, Scopes: []string{models.ScopeTransactionsRead}})
+	require.NoError(t, err)
+	_, _, err = apiKeys.Authenticate(ctx, otherSecret, "203.0.113.9")
+	require.NoError(t, err)
+	revoked, err := apiKeys.Revoke(admin, other.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+	_, _, err = apiKeys.Authenticate(ctx, otherSecret, "203.0.113.9")
+	assert.ErrorIs(t, err, models.ErrInvalidAPIKey)
+
+	keys, err := apiKeys.List(admin, 2)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	trail := ledger.auditTrail(t, models.EntityTypeAPIKey, other.ID)
+	require.Len(t, trail, 2)
+	assert.Equal(t, models.ActionDelete, trail[1].Action)
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+const (
+	DefaultApprovalTTL = 48 * time.Hour
+
+	// approvalWindow is how far back an admin's requests that ran at once
+	// count toward the threshold
+	approvalWindow = 24 * time.Hour
+)
+
+var approvalDecisions = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_approvals_total",
+		Help: "Total number of adjustments and transfers requested by admins by outcome",
+	},
+	[]string{"type", "outcome"},
+)
+
+// ApprovalService lets admins book adjustments and move funds between users
+// under maker-checker control. A request that, together with what the same
+// admin ran at once in the currency over the last day, exceeds the
+// currency's threshold waits for a second admin, who approves it within the
+// TTL or rejects it; smaller ones run at once. Currencies without a
+// threshold always wait. Every step is written to the audit log by the admin
+// who took it.
+type ApprovalService struct {
+	repo       models.ApprovalRepository
+	txSvc      *TransactionService
+	uow        models.UnitOfWork
+	auditSvc   models.AuditService
+	logger     *logger.Logger
+	thresholds models.CurrencyAmounts
+	ttl        time.Duration
+}
+
+func NewApprovalService(
+	repo models.ApprovalRepository,
+	txSvc *TransactionService,
+	uow models.UnitOfWork,
+	auditSvc models.AuditService,
+	logger *logger.Logger,
+	thresholds models.CurrencyAmounts,
+	ttl time.Duration,
+) *ApprovalService {
+	if ttl <= 0 {
+		ttl = DefaultApprovalTTL
+	}
+	return &ApprovalService{
+		repo:       repo,
+		txSvc:      txSvc,
+		uow:        uow,
+		auditSvc:   auditSvc,
+		logger:     logger,
+		thresholds: thresholds,
+		ttl:        ttl,
+	}
+}
+
+// Request runs the adjustment or transfer on behalf of the caller, an admin
+// or a holder of PermissionAdjustmentsCreate, when it keeps the caller
+// within the currency's threshold, and otherwise queues it for an admin's
+// approval.
+func (s *ApprovalService) Request(ctx context.Context, request *models.Approval) (*models.Approval, *models.Transaction, error) {
+	admin, ok := auth.GetUserFromContext(ctx)
+	if !ok || !auth.HasPermission(ctx, models.PermissionAdjustmentsCreate) {
+		return nil, nil, models.ErrUnauthorized
+	}
+
+	request.Currency = models.NormalizeCurrency(request.Currency)
+	if request.Type == models.TypeAdjustment {
+		request.FromUserID = request.ToUserID
+	}
+	if err := request.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	executed, err := s.repo.SumExecuted(ctx, admin.ID, request.Currency, now.Add(-approvalWindow))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.thresholds.Exceeds(request.Currency, executed.Add(request.Amount)) {
+		tx := request.Transaction()
+		err := s.execute(ctx, tx, func(ctx context.Context) error {
+			return s.recordExecution(ctx, request, admin, tx, now)
+		})
+		if err != nil {
+			approvalDecisions.WithLabelValues(string(request.Type), "failed").Inc()
+			return nil, nil, err
+		}
+		approvalDecisions.WithLabelValues(string(request.Type), "executed").Inc()
+		return nil, tx, nil
+	}
+
+	approval := &models.Approval{
+		Type:        request.Type,
+		FromUserID:  request.FromUserID,
+		ToUserID:    request.ToUserID,
+		Amount:      request.Amount,
+		Currency:    request.Currency,
+		Debit:       request.Debit,
+		Notes:       request.Notes,
+		Status:      models.ApprovalPending,
+		RequestedBy: admin.ID,
+		ExpiresAt:   now.Add(s.ttl),
+	}
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, approval); err != nil {
+			return err
+		}
+		details := fmt.Sprintf("Requested %s of %s %s (%s) for approval until %s",
+			approval.Type, approval.Amount, approval.Currency, describeApproval(approval), approval.ExpiresAt.Format(time.RFC3339))
+		if err := s.auditSvc.LogAction(ctx, models.EntityTypeApproval, approval.ID, models.ActionCreate, details); err != nil {
+			return fmt.Errorf("failed to log approval request: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	approvalDecisions.WithLabelValues(string(approval.Type), "requested").Inc()
+	s.logger.Info("approval requested",
+		"approval_id", approval.ID,
+		"type", approval.Type,
+		"amount", approval.Amount,
+		"currency", approval.Currency,
+		"requested_by", admin.ID)
+	return approval, nil, nil
+}
+
+// recordExecution keeps the request that runs at once as tx as an executed
+// approval and audits it. It must run in the unit of work that posts tx.
+func (s *ApprovalService) recordExecution(ctx context.Context, request *models.Approval, admin *models.User, tx *models.Transaction, now time.Time) error {
+	approval := &models.Approval{
+		Type:          request.Type,
+		FromUserID:    request.FromUserID,
+		ToUserID:      request.ToUserID,
+		Amount:        request.Amount,
+		Currency:      request.Currency,
+		Debit:         request.Debit,
+		Notes:         request.Notes,
+		Status:        models.ApprovalExecuted,
+		RequestedBy:   admin.ID,
+		TransactionID: &tx.ID,
+		ExpiresAt:     now,
+	}
+	if err := s.repo.Create(ctx, approval); err != nil {
+		return err
+	}
+	details := fmt.Sprintf("Executed %s of %s %s (%s) within the threshold as transaction %d",
+		approval.Type, approval.Amount, approval.Currency, describeApproval(approval), tx.ID)
+	if err := s.auditSvc.LogAction(ctx, models.EntityTypeApproval, approval.ID, models.ActionCreate, details); err != nil {
+		return fmt.Errorf("failed to log approval execution: %w", err)
+	}
+	return nil
+}
+
+// Approve executes a pending approval on behalf of the calling admin, who
+// must not be the one who requested it. The approval is marked approved in
+// the unit of work that posts its transaction; if the transaction fails, the
+// approval stays pending.
+func (s *ApprovalService) Approve(ctx context.Context, id uint, reason string) (*models.Approval, error) {
+	admin, ok := auth.GetUserFromContext(ctx)
+	if !ok || !auth.HasPermission(ctx, models.PermissionApprovalsDecide) {
+		return nil, models.ErrUnauthorized
+	}
+
+	approval, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecision(approval, admin); err != nil {
+		return nil, s.expireOn(ctx, id, err)
+	}
+
+	tx := approval.Transaction()
+	err = s.execute(ctx, tx, func(ctx context.Context) error {
+		locked, err := s.repo.GetForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkDecision(locked, admin); err != nil {
+			return err
+		}
+		approval = locked
+		return s.decide(ctx, approval, admin, models.ApprovalApproved, reason, &tx.ID)
+	})
+	if err != nil {
+		approvalDecisions.WithLabelValues(string(approval.Type), "failed").Inc()
+		return nil, s.expireOn(ctx, id, err)
+	}
+
+	approvalDecisions.WithLabelValues(string(approval.Type), "approved").Inc()
+	s.logger.Info("approval executed",
+		"approval_id", approval.ID,
+		"tx_id", tx.ID,
+		"requested_by", approval.RequestedBy,
+		"approved_by", admin.ID)
+	return approval, nil
+}
+
+// Reject closes a pending approval without moving any funds.
+func (s *ApprovalService) Reject(ctx context.Context, id uint, reason string) (*models.Approval, error) {
+	admin, ok := auth.GetUserFromContext(ctx)
+	if !ok || !auth.HasPermission(ctx, models.PermissionApprovalsDecide) {
+		return nil, models.ErrUnauthorized
+	}
+
+	var approval *models.Approval
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		approval, err = s.repo.GetForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkDecision(approval, admin); err != nil {
+			return err
+		}
+		return s.decide(ctx, approval, admin, models.ApprovalRejected, reason, nil)
+	})
+	if err != nil {
+		return nil, s.expireOn(ctx, id, err)
+	}
+
+	approvalDecisions.WithLabelValues(string(approval.Type), "rejected").Inc()
+	return approval, nil
+}
+
+// ListApprovals expires the pending approvals past their TTL and returns
+// those with the status, or all of them.
+func (s *ApprovalService) ListApprovals(ctx context.Context, status models.ApprovalStatus) ([]models.Approval, error) {
+	if err := s.ExpireApprovals(ctx); err != nil {
+		return nil, err
+	}
+	return s.repo.List(ctx, status)
+}
+
+// ExpireApprovals marks the pending approvals past their TTL expired.
+func (s *ApprovalService) ExpireApprovals(ctx context.Context) error {
+	expired, err := s.repo.ListExpired(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range expired {
+		if err := s.expire(ctx, expired[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireOn marks the approval expired when err says it has, and returns err.
+func (s *ApprovalService) expireOn(ctx context.Context, id uint, err error) error {
+	if errors.Is(err, models.ErrApprovalExpired) {
+		if expireErr := s.expire(ctx, id); expireErr != nil {
+			s.logger.Error("failed to expire approval", "error", expireErr, "approval_id", id)
+		}
+	}
+	return err
+}
+
+func (s *ApprovalService) expire(ctx context.Context, id uint) error {
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		approval, err := s.repo.GetForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !approval.IsExpired(time.Now()) {
+			return nil
+		}
+		approval.Status = models.ApprovalExpired
+		if err := s.repo.Update(ctx, approval); err != nil {
+			return err
+		}
+		approvalDecisions.WithLabelValues(string(approval.Type), "expired").Inc()
+		details := fmt.Sprintf("Expired unapproved at %s", approval.ExpiresAt.Format(time.RFC3339))
+		if err := s.auditSvc.LogAction(ctx, models.EntityTypeApproval, approval.ID, models.ActionUpdate, details); err != nil {
+			return fmt.Errorf("failed to log approval expiry: %w", err)
+		}
+		return nil
+	})
+}
+
+// checkDecision reports whether admin may decide the approval now.
+func checkDecision(approval *models.Approval, admin *models.User) error {
+	if approval.IsExpired(time.Now()) {
+		return models.ErrApprovalExpired
+	}
+	switch approval.Status {
+	case models.ApprovalPending:
+	case models.ApprovalExpired:
+		return models.ErrApprovalExpired
+	default:
+		return models.ErrApprovalResolved
+	}
+	if approval.RequestedBy == admin.ID {
+		return models.ErrApprovalSameChecker
+	}
+	return nil
+}
+
+// decide records the admin's decision on the approval and audits it. It
+// must run inside a unit of work.
+func (s *ApprovalService) decide(ctx context.Context, approval *models.Approval, admin *models.User, status models.ApprovalStatus, reason string, txID *uint) error {
+	now := time.Now()
+	approval.Status = status
+	approval.DecidedBy = &admin.ID
+	approval.DecidedAt = &now
+	approval.Reason = reason
+	approval.TransactionID = txID
+	if err := s.repo.Update(ctx, approval); err != nil {
+		return err
+	}
+
+	action, details := models.ActionApprove, fmt.Sprintf("Approved %s requested by admin %d", approval.Type, approval.RequestedBy)
+	if status == models.ApprovalRejected {
+		action, details = models.ActionReject, fmt.Sprintf("Rejected %s requested by admin %d", approval.Type, approval.RequestedBy)
+	}
+	if txID != nil {
+		details += fmt.Sprintf(" as transaction %d", *txID)
+	}
+	if reason != "" {
+		details += ": " + reason
+	}
+	if err := s.auditSvc.LogAction(ctx, models.EntityTypeApproval, approval.ID, action, details); err != nil {
+		return fmt.Errorf("failed to log approval decision: %w", err)
+	}
+	return nil
+}
+
+// execute records tx and posts it; steps run in the unit of work of the
+// postings, ahead of them.
+func (s *ApprovalService) execute(ctx context.Context, tx *models.Transaction, steps ...func(ctx context.Context) error) error {
+	if err := tx.Validate(); err != nil {
+		return fmt.Errorf("invalid transaction: %w", err)
+	}
+	if err := s.txSvc.CreateTransaction(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := s.txSvc.processor.ProcessTransaction(ctx, tx, steps...); err != nil {
+		transactionErrors.WithLabelValues(string(tx.Type), "processing").Inc()
+		return fmt.Errorf("failed to process %s: %w", tx.Type, err)
+	}
+
+	transactionCounter.WithLabelValues(string(tx.Type), "success").Inc()
+	return nil
+}
+
+func describeApproval(approval *models.Approval) string {
+	if approval.Type == models.TypeTransfer {
+		return fmt.Sprintf("from user %d to user %d", approval.FromUserID, approval.ToUserID)
+	}
+	if approval.Debit {
+		return fmt.Sprintf("charge against user %d", approval.ToUserID)
+	}
+	return fmt.Sprintf("credit to user %d", approval.ToUserID)
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/logger"
+)
+
+func newTestApprovals(ledger *testLedger) *ApprovalService {
+	return NewApprovalService(repositories.NewApprovalRepository(ledger.db), ledger.txSvc, repositories.NewUnitOfWork(ledger.db),
+		NewAuditService(repositories.NewAuditLogRepository(ledger.db), logger.New("error")), logger.New("error"), models.CurrencyAmounts{"USD": decimal.NewFromInt(20)}, time.Hour)
+}
+
+func (l *testLedger) auditTrail(t *testing.T, entityType string, entityID uint) []models.AuditLog {
+	t.Helper()
+	var logs []models.AuditLog
+	require.NoError(t, l.db.Where("entity_type = ? AND entity_id = ?", entityType, entityID).Order("id").Find(&logs).Error)
+	return logs
+}
+
+func TestApprovalsNeedASecondAdminAboveThreshold(t *testing.T) {
+	ledger := newTestLedger(t)
+	approvals := newTestApprovals(ledger)
+	maker, checker := asUser(10, models.RoleAdmin), asUser(11, models.RoleAdmin)
+
+	// Within the threshold an adjustment runs at once
+	approval, tx, err := approvals.Request(maker, &models.Approval{Type: models.TypeAdjustment, ToUserID: 1, Amount: decimal.NewFromInt(20), Currency: "usd"})
+	require.NoError(t, err)
+	assert.Nil(t, approval)
+	assert.Equal(t, models.StatusCompleted, tx.Status)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(120)))
+
+	approval, tx, err = approvals.Request(maker, &models.Approval{Type: models.TypeAdjustment, ToUserID: 2, Amount: decimal.NewFromInt(40), Currency: "USD", Debit: true, Notes: "chargeback"})
+	require.NoError(t, err)
+	assert.Nil(t, tx)
+	assert.Equal(t, models.ApprovalPending, approval.Status)
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(50)), "pending adjustment moved funds")
+
+	_, err = approvals.Approve(maker, approval.ID, "")
+	assert.ErrorIs(t, err, models.ErrApprovalSameChecker)
+	_, err = approvals.Approve(asUser(11, models.RoleUser), approval.ID, "")
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+
+	approved, err := approvals.Approve(checker, approval.ID, "matches the card network notice")
+	require.NoError(t, err)
+	assert.Equal(t, models.ApprovalApproved, approved.Status)
+	assert.Equal(t, uint(11), *approved.DecidedBy)
+	require.NotNil(t, approved.TransactionID)
+	assert.Equal(t, models.StatusCompleted, ledger.transaction(t, *approved.TransactionID).Status)
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(10)))
+
+	_, err = approvals.Reject(checker, approval.ID, "")
+	assert.ErrorIs(t, err, models.ErrApprovalResolved)
+
+	trail := ledger.auditTrail(t, models.EntityTypeApproval, approval.ID)
+	require.Len(t, trail, 2)
+	assert.Equal(t, models.ActionCreate, trail[0].Action)
+	assert.Equal(t, uint(10), trail[0].UserID)
+	assert.Equal(t, models.ActionApprove, trail[1].Action)
+	assert.Equal(t, uint(11), trail[1].UserID)
+	assert.Contains(t, trail[1].Details, "requested by admin 10")
+
+	pending, err := approvals.ListApprovals(context.Background(), models.ApprovalPending)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestApprovalsRejectAndExpire(t *testing.T) {
+	ledger := newTestLedger(t)
+	approvals := newTestApprovals(ledger)
+	maker, checker := asUser(10, models.RoleAdmin), asUser(11, models.RoleAdmin)
+
+	approval, _, err := approvals.Request(maker, &models.Approval{Type: models.TypeTransfer, FromUserID: 1, ToUserID: 2, Amount: decimal.NewFromInt(60), Currency: "USD"})
+	require.NoError(t, err)
+	rejected, err := approvals.Reject(checker, approval.ID, "no ticket")
+	require.NoError(t, err)
+	assert.Equal(t, models.ApprovalRejected, rejected.Status)
+	assert.Nil(t, rejected.TransactionID)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)))
+
+	approval, _, err = approvals.Request(maker, &models.Approval{Type: models.TypeTransfer, FromUserID: 1, ToUserID: 2, Amount: decimal.NewFromInt(60), Currency: "USD"})
+	require.NoError(t, err)
+	require.NoError(t, ledger.db.Model(&models.Approval{}).Where("id = ?", approval.ID).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+	_, err = approvals.Approve(checker, approval.ID, "")
+	assert.ErrorIs(t, err, models.ErrApprovalExpired)
+	expired, err := approvals.ListApprovals(context.Background(), models.ApprovalExpired)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, approval.ID, expired[0].ID)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)))
+
+	_, _, err = approvals.Request(maker, &models.Approval{Type: models.TypeWithdrawal, FromUserID: 1, ToUserID: 1, Amount: decimal.NewFromInt(5), Currency: "USD"})
+	assert.ErrorIs(t, err, models.ErrInvalidApproval)
+}
+
+func TestApprovalsCountRecentExecutionsTowardThePerCurrencyThreshold(t *testing.T) {
+	ledger := newTestLedger(t)
+	approvals := newTestApprovals(ledger)
+	maker, other := asUser(10, models.RoleAdmin), asUser(11, models.RoleAdmin)
+
+	// Splitting 25 into adjustments of 15 and 10 still needs approval
+	approval, tx, err := approvals.Request(maker, &models.Approval{Type: models.TypeAdjustment, ToUserID: 1, Amount: decimal.NewFromInt(15), Currency: "USD"})
+	require.NoError(t, err)
+	assert.Nil(t, approval)
+	require.NotNil(t, tx)
+	approval, tx, err = approvals.Request(maker, &models.Approval{Type: models.TypeAdjustment, ToUserID: 1, Amount: decimal.NewFromInt(10), Currency: "USD"})
+	require.NoError(t, err)
+	assert.Nil(t, tx)
+	assert.Equal(t, models.ApprovalPending, approval.Status)
+
+	// Another admin has a threshold of their own
+	approval, tx, err = approvals.Request(other, &models.Approval{Type: models.TypeAdjustment, ToUserID: 1, Amount: decimal.NewFromInt(10), Currency: "USD"})
+	require.NoError(t, err)
+	assert.Nil(t, approval)
+	require.NotNil(t, tx)
+
+	// Currencies without a threshold always wait
+	approval, tx, err = approvals.Request(other, &models.Approval{Type: models.TypeAdjustment, ToUserID: 1, Amount: decimal.NewFromInt(1), Currency: "EUR"})
+	require.NoError(t, err)
+	assert.Nil(t, tx)
+	assert.Equal(t, models.ApprovalPending, approval.Status)
+
+	// Executions older than a day no longer count
+	require.NoError(t, ledger.db.Model(&models.Approval{}).Where("status = ?", models.ApprovalExecuted).Update("created_at", time.Now().Add(-approvalWindow-time.Minute)).Error)
+	approval, tx, err = approvals.Request(maker, &models.Approval{Type: models.TypeAdjustment, ToUserID: 1, Amount: decimal.NewFromInt(10), Currency: "USD"})
+	require.NoError(t, err)
+	assert.Nil(t, approval)
+	require.NotNil(t, tx)
+
+	executed, err := approvals.ListApprovals(context.Background(), models.ApprovalExecuted)
+	require.NoError(t, err)
+	require.Len(t, executed, 3)
+	assert.Equal(t, uint(10), executed[0].RequestedBy)
+	require.NotNil(t, executed[0].TransactionID)
+	assert.Nil(t, executed[0].DecidedBy)
+
+	trail := ledger.auditTrail(t, models.EntityTypeApproval, executed[0].ID)
+	require.Len(t, trail, 1)
+	assert.Equal(t, uint(10), trail[0].UserID)
+	assert.Contains(t, trail[0].Details, "within the threshold")
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -16,7 +17,8 @@ import (
 )
 
 const (
-	defaultTokenDuration = 24 * time.Hour
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
 )
 
 var (
@@ -53,24 +55,58 @@ var (
 	)
 )
 
+// AuthService issues short-lived access tokens and rotating refresh tokens.
+// Each login opens a session that is refreshed with a single-use opaque
+// token; presenting a used refresh token again revokes the session. The
+// access tokens of revoked sessions are put on the revocation list until
+// they expire. Users with two-factor authentication on log in in two steps:
+// the password gets a login challenge, and the challenge with a code gets
+// the session. New users are mailed a link to verify their email address.
 type AuthService struct {
-	userSvc    models.UserService
-	tokenMaker auth.TokenMaker
-	logger     *logger.Logger
-	balanceSvc *BalanceService
+	userSvc     models.UserService
+	tokenMaker  auth.TokenMaker
+	sessions    models.SessionRepository
+	uow         models.UnitOfWork
+	revocations models.RevocationStore
+	twoFactor   models.TwoFactorService
+	userTokens  models.UserTokenService
+	logger      *logger.Logger
+	balanceSvc  *BalanceService
+	accessTTL   time.Duration
+	refreshTTL  time.Duration
 }
 
 func NewAuthService(
 	userSvc models.UserService,
 	tokenMaker auth.TokenMaker,
+	sessions models.SessionRepository,
+	uow models.UnitOfWork,
+	revocations models.RevocationStore,
+	twoFactor models.TwoFactorService,
+	userTokens models.UserTokenService,
 	logger *logger.Logger,
 	balanceSvc *BalanceService,
+	accessTTL time.Duration,
+	refreshTTL time.Duration,
 ) *AuthService {
+	if accessTTL <= 0 {
+		accessTTL = DefaultAccessTokenTTL
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTokenTTL
+	}
 	svc := &AuthService{
-		userSvc:    userSvc,
-		tokenMaker: tokenMaker,
-		logger:     logger,
-		balanceSvc: balanceSvc,
+		userSvc:     userSvc,
+		tokenMaker:  tokenMaker,
+		sessions:    sessions,
+		uow:         uow,
+		revocations: revocations,
+		twoFactor:   twoFactor,
+		userTokens:  userTokens,
+		logger:      logger,
+		balanceSvc:  balanceSvc,
+		accessTTL:   accessTTL,
+		refreshTTL:  refreshTTL,
 	}
 
 	// Initialize active users count
@@ -95,7 +131,10 @@ func (s *AuthService) InitializeActiveUsers(ctx context.Context) error {
 	return nil
 }
 
-func (s *AuthService) Login(ctx context.Context, email, password string) (string, error) {
+// Login checks the user's password and starts their session, unless they
+// have two-factor authentication on: then it returns a login challenge to
+// complete with VerifyLogin.
+func (s *AuthService) Login(ctx context.Context, email, password string) (*models.LoginResult, error) {
 	timer := prometheus.NewTimer(authDuration.WithLabelValues("login"))
 	defer timer.ObserveDuration()
 
@@ -105,29 +144,98 @@ func (s *AuthService) Login(ctx context.Context, email, password string) (string
 	if err != nil {
 		if err == models.ErrInvalidCredentials {
 			authErrors.WithLabelValues("login", "invalid_credentials").Inc()
+		} else if errors.Is(err, models.ErrAccountLocked) {
+			authErrors.WithLabelValues("login", "account_locked").Inc()
 		} else {
 			authErrors.WithLabelValues("login", "internal_error").Inc()
 		}
 		authAttempts.WithLabelValues("login", "failure").Inc()
-		return "", err
+		return nil, err
 	}
 
 	userJSON, _ := json.Marshal(user)
 	s.logger.Info("User authenticated", "user", string(userJSON))
 
-	token, err := s.tokenMaker.CreateToken(user.ID, user.Role, defaultTokenDuration)
+	enabled, err := s.twoFactor.IsEnabled(ctx, user.ID)
+	if err != nil {
+		authErrors.WithLabelValues("login", "internal_error").Inc()
+		return nil, err
+	}
+	if enabled {
+		challenge, expiresAt, err := s.twoFactor.Challenge(ctx, user.ID)
+		if err != nil {
+			authErrors.WithLabelValues("login", "challenge_creation").Inc()
+			return nil, err
+		}
+		s.logger.Info("Two-factor code required", "user_id", user.ID)
+		authAttempts.WithLabelValues("login", "challenge").Inc()
+		return &models.LoginResult{
+			TwoFactorRequired:  true,
+			ChallengeToken:     challenge,
+			ChallengeExpiresAt: &expiresAt,
+		}, nil
+	}
+
+	tokens, err := s.startSession(ctx, user)
 	if err != nil {
 		authErrors.WithLabelValues("login", "token_creation").Inc()
-		return "", err
+		return nil, err
 	}
 
 	s.logger.Info("Token created successfully", "user_id", user.ID)
-	activeUsers.Inc()
 	authAttempts.WithLabelValues("login", "success").Inc()
-	return token, nil
+	return &models.LoginResult{TokenPair: tokens}, nil
+}
+
+// VerifyLogin completes a login challenge with a TOTP code or a recovery
+// code and starts the user's session.
+func (s *AuthService) VerifyLogin(ctx context.Context, challengeToken, code string) (*models.TokenPair, error) {
+	timer := prometheus.NewTimer(authDuration.WithLabelValues("login_verify"))
+	defer timer.ObserveDuration()
+
+	userID, err := s.twoFactor.CompleteChallenge(ctx, challengeToken, code)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidTwoFactorCode) || errors.Is(err, models.ErrInvalidChallenge) {
+			authErrors.WithLabelValues("login_verify", "invalid_code").Inc()
+		} else if errors.Is(err, models.ErrTwoFactorLocked) {
+			authErrors.WithLabelValues("login_verify", "locked").Inc()
+		} else {
+			authErrors.WithLabelValues("login_verify", "internal_error").Inc()
+		}
+		authAttempts.WithLabelValues("login_verify", "failure").Inc()
+		return nil, err
+	}
+
+	user, err := s.userSvc.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.startSession(ctx, user)
+	if err != nil {
+		authErrors.WithLabelValues("login_verify", "token_creation").Inc()
+		return nil, err
+	}
+
+	s.logger.Info("Two-factor login completed", "user_id", user.ID)
+	authAttempts.WithLabelValues("login_verify", "success").Inc()
+	return tokens, nil
+}
+
+// ChangePassword changes the calling user's password. Users with
+// two-factor authentication on must also give a fresh TOTP code. Every
+// session of the user ends, so a stolen token stops working.
+func (s *AuthService) ChangePassword(ctx context.Context, oldPassword, newPassword, code string) error {
+	userID := auth.GetUserIDFromContext(ctx)
+	if userID == 0 {
+		return models.ErrUnauthorized
+	}
+	if err := s.twoFactor.StepUp(ctx, code); err != nil {
+		return err
+	}
+	return s.userSvc.ChangePassword(ctx, userID, oldPassword, newPassword)
 }
 
-func (s *AuthService) Register(ctx context.Context, email, password, username string) (string, error) {
+func (s *AuthService) Register(ctx context.Context, email, password, username string) (*models.TokenPair, error) {
 	s.logger.Info("Registration attempt", "email", email, "username", username)
 
 	user := &models.User{
@@ -138,27 +246,28 @@ func (s *AuthService) Register(ctx context.Context, email, password, username st
 
 	if err := user.SetPassword(password); err != nil {
 		s.logger.Error("Password hashing failed", "error", err)
-		return "", fmt.Errorf("failed to set password: %w", err)
+		return nil, fmt.Errorf("failed to set password: %w", err)
 	}
 
 	user, err := s.userSvc.Register(ctx, user)
 	if err != nil {
 		s.logger.Error("Registration failed", "error", err)
-		return "", fmt.Errorf("failed to register user: %w", err)
+		return nil, fmt.Errorf("failed to register user: %w", err)
 	}
 
 	userJSON, _ := json.Marshal(user)
 	s.logger.Info("User registered", "user", string(userJSON))
 
-	token, err := s.tokenMaker.CreateToken(user.ID, user.Role, defaultTokenDuration)
+	tokens, err := s.startSession(ctx, user)
 	if err != nil {
 		s.logger.Error("Token creation failed", "error", err)
-		return "", fmt.Errorf("failed to create token: %w", err)
+		return nil, fmt.Errorf("failed to create token: %w", err)
 	}
 
 	// Create initial balance for the user
 	balance := &models.Balance{
 		UserID:        user.ID,
+		Currency:      models.DefaultCurrency,
 		Amount:        decimal.NewFromInt(0),
 		LastUpdatedAt: time.Now(),
 	}
@@ -167,8 +276,14 @@ func (s *AuthService) Register(ctx context.Context, email, password, username st
 		s.logger.Error("failed to create initial balance", "error", err, "userID", user.ID)
 	}
 
+	// The user can ask for another link, so a mail failure does not fail
+	// the registration
+	if err := s.userTokens.SendEmailVerification(ctx, user); err != nil {
+		s.logger.Error("failed to send email verification", "error", err, "user_id", user.ID)
+	}
+
 	s.logger.Info("Token created successfully", "user_id", user.ID)
-	return token, nil
+	return tokens, nil
 }
 
 func (s *AuthService) ValidateToken(ctx context.Context, token string) (*models.User, error) {
@@ -186,6 +301,21 @@ func (s *AuthService) ValidateToken(ctx context.Context, token string) (*models.
 
 	s.logger.Info("Token claims", "user_id", claims.UserID, "role", claims.Role)
 
+	// Tokens without an ID predate revocation and cannot be revoked
+	if claims.ID == "" {
+		authErrors.WithLabelValues("validate", "invalid_token").Inc()
+		return nil, fmt.Errorf("failed to verify token: %w", auth.ErrInvalidToken)
+	}
+	revoked, err := s.revocations.IsRevoked(ctx, claims.ID)
+	if err != nil {
+		authErrors.WithLabelValues("validate", "revocation_check").Inc()
+		return nil, err
+	}
+	if revoked {
+		authErrors.WithLabelValues("validate", "revoked_token").Inc()
+		return nil, fmt.Errorf("failed to verify token: %w", auth.ErrRevokedToken)
+	}
+
 	// Get user with balance preloaded
 	user, err := s.userSvc.GetByID(ctx, claims.UserID)
 	if err != nil {
@@ -193,26 +323,19 @@ func (s *AuthService) ValidateToken(ctx context.Context, token string) (*models.
 		return nil, err
 	}
 
-	// Ensure role from token matches user's role
+	// The stored role wins, so role changes apply before the token expires
 	if user.Role != claims.Role {
-		s.logger.Error("Role mismatch", "token_role", claims.Role, "user_role", user.Role)
-		user.Role = claims.Role // Use role from token as it's more up to date
+		s.logger.Info("Role changed since token was issued", "token_role", claims.Role, "user_role", user.Role)
 	}
 
-	// Ensure balance is loaded
-	if user.Balance.UserID == 0 {
-		balance, err := s.balanceSvc.GetBalance(ctx, user.ID)
+	// Ensure balances are loaded
+	if len(user.Balances) == 0 {
+		balances, err := s.balanceSvc.GetBalances(ctx, user.ID)
 		if err != nil {
-			s.logger.Error("Failed to get user balance", "error", err)
+			s.logger.Error("Failed to get user balances", "error", err)
 			return nil, err
 		}
-		user.Balance = models.Balance{
-			UserID:        balance.UserID,
-			Amount:        balance.Amount,
-			LastUpdatedAt: balance.LastUpdatedAt,
-			UpdatedAt:     balance.UpdatedAt,
-			CreatedAt:     balance.CreatedAt,
-		}
+		user.Balances = balances
 	}
 
 	userJSON, _ := json.Marshal(user)
@@ -221,43 +344,277 @@ func (s *AuthService) ValidateToken(ctx context.Context, token string) (*models.
 	return user, nil
 }
 
-func (s *AuthService) RefreshToken(ctx context.Context, oldToken string) (string, error) {
-	s.logger.Info("Token refresh attempt")
+// RefreshToken rotates a refresh token: it is spent, and the session gets
+// a new access token and a new refresh token. The previous access token of
+// the session is revoked. Presenting a spent refresh token revokes the
+// session, since either the client or whoever stole the token has already
+// used it.
+func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*models.TokenPair, error) {
+	timer := prometheus.NewTimer(authDuration.WithLabelValues("refresh"))
+	defer timer.ObserveDuration()
 
-	user, err := s.ValidateToken(ctx, oldToken)
-	if err != nil {
-		s.logger.Error("Token validation failed", "error", err)
-		return "", fmt.Errorf("failed to validate token: %w", err)
-	}
+	var tokens *models.TokenPair
+	var reused *models.Session
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		stored, err := s.sessions.GetRefreshTokenForUpdate(ctx, models.HashOpaqueToken(refreshToken))
+		if errors.Is(err, models.ErrNotFound) {
+			return models.ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+		session, err := s.sessions.GetForUpdate(ctx, stored.SessionID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if !session.IsActive(now) {
+			return models.ErrSessionRevoked
+		}
+		if stored.UsedAt != nil {
+			reused = session
+			return s.revokeSession(ctx, session, now)
+		}
+		if !now.Before(stored.ExpiresAt) {
+			return models.ErrRefreshTokenExpired
+		}
 
-	token, err := s.tokenMaker.CreateToken(user.ID, user.Role, defaultTokenDuration)
+		stored.UsedAt = &now
+		if err := s.sessions.UpdateRefreshToken(ctx, stored); err != nil {
+			return err
+		}
+		user, err := s.userSvc.GetByID(ctx, session.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		if err := s.revokeAccessToken(ctx, session, now); err != nil {
+			return err
+		}
+		tokens, err = s.issue(ctx, session, user)
+		return err
+	})
 	if err != nil {
-		s.logger.Error("Token creation failed", "error", err)
-		return "", fmt.Errorf("failed to create token: %w", err)
+		authErrors.WithLabelValues("refresh", "invalid_refresh_token").Inc()
+		authAttempts.WithLabelValues("refresh", "failure").Inc()
+		return nil, err
+	}
+	if reused != nil {
+		authErrors.WithLabelValues("refresh", "reused_refresh_token").Inc()
+		authAttempts.WithLabelValues("refresh", "failure").Inc()
+		s.logger.Warn("refresh token reused, session revoked", "user_id", reused.UserID, "session_id", reused.ID)
+		return nil, models.ErrRefreshTokenReused
 	}
 
-	s.logger.Info("Token refreshed successfully", "user_id", user.ID)
-	return token, nil
+	authAttempts.WithLabelValues("refresh", "success").Inc()
+	s.logger.Info("Token refreshed successfully", "session_id", tokens.SessionID)
+	return tokens, nil
 }
 
-func (s *AuthService) Logout(ctx context.Context) error {
+// Logout ends the session the access token belongs to and revokes the
+// token.
+func (s *AuthService) Logout(ctx context.Context, accessToken string) error {
 	timer := prometheus.NewTimer(authDuration.WithLabelValues("logout"))
 	defer timer.ObserveDuration()
 
-	if err := s.invalidateSession(ctx); err != nil {
+	claims, err := s.tokenMaker.VerifyToken(accessToken)
+	if err != nil {
+		authErrors.WithLabelValues("logout", "invalid_token").Inc()
+		return fmt.Errorf("failed to verify token: %w", err)
+	}
+
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		if claims.ExpiresAt != nil {
+			if err := s.revocations.Revoke(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+				return err
+			}
+		}
+		if claims.SessionID == 0 {
+			return nil
+		}
+		session, err := s.sessions.GetForUpdate(ctx, claims.SessionID)
+		if err != nil {
+			return err
+		}
+		if session.UserID != claims.UserID || session.RevokedAt != nil {
+			return nil
+		}
+		return s.revokeSession(ctx, session, time.Now())
+	})
+	if err != nil {
 		authErrors.WithLabelValues("logout", "session_invalidation").Inc()
 		return err
 	}
-
-	activeUsers.Dec()
 	return nil
 }
 
-func (s *AuthService) invalidateSession(ctx context.Context) error {
+// LogoutAll ends every session of the calling user and returns how many
+// were active.
+func (s *AuthService) LogoutAll(ctx context.Context) (int, error) {
+	userID := auth.GetUserIDFromContext(ctx)
+	if userID == 0 {
+		return 0, models.ErrUnauthorized
+	}
+	return s.RevokeUserSessions(ctx, userID)
+}
+
+// RevokeUserSessions ends every active session of the user, as when their
+// password changes.
+func (s *AuthService) RevokeUserSessions(ctx context.Context, userID uint) (int, error) {
+	var revoked int
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		now := time.Now()
+		sessions, err := s.sessions.ListActive(ctx, userID, now)
+		if err != nil {
+			return err
+		}
+		for i := range sessions {
+			if err := s.revokeSession(ctx, &sessions[i], now); err != nil {
+				return err
+			}
+		}
+		revoked = len(sessions)
+		return nil
+	})
+	if err != nil {
+		authErrors.WithLabelValues("logout", "session_invalidation").Inc()
+		return 0, err
+	}
+	return revoked, nil
+}
+
+// RevokeSession ends one session of the calling user.
+func (s *AuthService) RevokeSession(ctx context.Context, sessionID uint) error {
 	userID := auth.GetUserIDFromContext(ctx)
 	if userID == 0 {
-		return fmt.Errorf("no user in context")
+		return models.ErrUnauthorized
+	}
+
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		session, err := s.sessions.GetForUpdate(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		if session.UserID != userID {
+			return models.ErrNotFound
+		}
+		if session.RevokedAt != nil {
+			return nil
+		}
+		return s.revokeSession(ctx, session, time.Now())
+	})
+}
+
+// ListSessions returns the active sessions of the user the access token
+// belongs to, marking the token's own session as current.
+func (s *AuthService) ListSessions(ctx context.Context, accessToken string) ([]models.Session, error) {
+	claims, err := s.tokenMaker.VerifyToken(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify token: %w", err)
 	}
 
+	sessions, err := s.sessions.ListActive(ctx, claims.UserID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+	return sessions, nil
+}
+
+// startSession opens a session for the user and issues its first tokens.
+func (s *AuthService) startSession(ctx context.Context, user *models.User) (*models.TokenPair, error) {
+	var tokens *models.TokenPair
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		now := time.Now()
+		userAgent, ipAddress := auth.GetClientFromContext(ctx)
+		session := &models.Session{
+			UserID:     user.ID,
+			UserAgent:  truncate(userAgent, 255),
+			IPAddress:  truncate(ipAddress, 45),
+			LastUsedAt: now,
+			ExpiresAt:  now.Add(s.refreshTTL),
+		}
+		if err := s.sessions.Create(ctx, session); err != nil {
+			return err
+		}
+
+		var err error
+		tokens, err = s.issue(ctx, session, user)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	activeUsers.Inc()
+	return tokens, nil
+}
+
+// issue signs a new access token for the session and stores a new refresh
+// token, which extends the session. It must run inside a unit of work.
+func (s *AuthService) issue(ctx context.Context, session *models.Session, user *models.User) (*models.TokenPair, error) {
+	token, claims, err := s.tokenMaker.CreateToken(user.ID, user.Role, session.ID, s.accessTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token: %w", err)
+	}
+	refreshToken, hash, err := models.NewOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	now := time.Now()
+	stored := &models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: hash,
+		ExpiresAt: now.Add(s.refreshTTL),
+	}
+	if err := s.sessions.CreateRefreshToken(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	session.AccessJTI = claims.ID
+	session.AccessExpiresAt = claims.ExpiresAt.Time
+	session.LastUsedAt = now
+	session.ExpiresAt = stored.ExpiresAt
+	if err := s.sessions.Update(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return &models.TokenPair{
+		Token:            token,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: stored.ExpiresAt,
+		SessionID:        session.ID,
+	}, nil
+}
+
+// revokeSession ends the session and revokes its latest access token. It
+// must run inside a unit of work.
+func (s *AuthService) revokeSession(ctx context.Context, session *models.Session, now time.Time) error {
+	session.RevokedAt = &now
+	if err := s.sessions.Update(ctx, session); err != nil {
+		return err
+	}
+	if err := s.revokeAccessToken(ctx, session, now); err != nil {
+		return err
+	}
+	activeUsers.Dec()
 	return nil
 }
+
+func (s *AuthService) revokeAccessToken(ctx context.Context, session *models.Session, now time.Time) error {
+	if session.AccessJTI == "" || !session.AccessExpiresAt.After(now) {
+		return nil
+	}
+	return s.revocations.Revoke(ctx, session.AccessJTI, session.AccessExpiresAt)
+}
+
+func truncate(value string, max int) string {
+	if len(value) > max {
+		return value[:max]
+	}
+	return value
+}
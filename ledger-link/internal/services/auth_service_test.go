@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/cache"
+	"ledger-link/pkg/logger"
+)
+
+// newTestAuth wires the auth service against the ledger's database, with
+// revocations in a Redis the test can stop and mail kept in a testMailer.
+func newTestAuth(t *testing.T, ledger *testLedger) (*AuthService, *miniredis.Miniredis) {
+	t.Helper()
+	log := logger.New("error")
+	redisServer := miniredis.RunT(t)
+	cacheService := cache.NewCacheService(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}))
+	auditSvc := NewAuditService(repositories.NewAuditLogRepository(ledger.db), log)
+	userSvc := NewUserService(repositories.NewUserRepository(ledger.db), repositories.NewLockoutRepository(ledger.db),
+		repositories.NewUnitOfWork(ledger.db), ledger.balanceSvc, auditSvc, log, DefaultLockoutPolicy)
+	userTokens := NewUserTokenService(repositories.NewUserTokenRepository(ledger.db), repositories.NewUserRepository(ledger.db), userSvc,
+		repositories.NewUnitOfWork(ledger.db), &testMailer{}, auditSvc, log, "https://app.example.com", 0, 0)
+	revocations := NewRevocationList(cache.NewRevocationStore(cacheService), repositories.NewRevocationRepository(ledger.db), log)
+	authSvc := NewAuthService(userSvc, auth.NewJWTMaker("test-secret"), repositories.NewSessionRepository(ledger.db),
+		repositories.NewUnitOfWork(ledger.db), revocations, newTestTwoFactor(ledger), userTokens, log, ledger.balanceSvc, time.Minute, time.Hour)
+	userSvc.SetSessionRevoker(authSvc)
+	return authSvc, redisServer
+}
+
+func TestRefreshTokensRotateAndDetectReuse(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	ctx := context.Background()
+
+	first, err := authSvc.Register(ctx, "carol@example.com", "correct-horse-battery", "carol")
+	require.NoError(t, err)
+	_, err = authSvc.ValidateToken(ctx, first.Token)
+	require.NoError(t, err)
+
+	// Refreshing spends the refresh token and revokes the old access token
+	second, err := authSvc.RefreshToken(ctx, first.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, first.SessionID, second.SessionID)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	_, err = authSvc.ValidateToken(ctx, first.Token)
+	assert.ErrorIs(t, err, auth.ErrRevokedToken)
+	_, err = authSvc.ValidateToken(ctx, second.Token)
+	require.NoError(t, err)
+
+	// Replaying the spent token revokes the whole session
+	_, err = authSvc.RefreshToken(ctx, first.RefreshToken)
+	assert.ErrorIs(t, err, models.ErrRefreshTokenReused)
+	_, err = authSvc.ValidateToken(ctx, second.Token)
+	assert.ErrorIs(t, err, auth.ErrRevokedToken)
+	_, err = authSvc.RefreshToken(ctx, second.RefreshToken)
+	assert.ErrorIs(t, err, models.ErrSessionRevoked)
+
+	_, err = authSvc.RefreshToken(ctx, "not-a-refresh-token")
+	assert.ErrorIs(t, err, models.ErrInvalidRefreshToken)
+}
+
+func TestLogoutRevokesTokensAcrossRedisOutage(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, redisServer := newTestAuth(t, ledger)
+	ctx := context.Background()
+
+	laptop, err := authSvc.Register(ctx, "carol@example.com", "correct-horse-battery", "carol")
+	require.NoError(t, err)
+	phone, err := authSvc.Login(auth.SetClientInContext(ctx, "phone", "10.0.0.2"), "carol@example.com", "correct-horse-battery")
+	require.NoError(t, err)
+
+	sessions, err := authSvc.ListSessions(ctx, phone.Token)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	for _, session := range sessions {
+		assert.Equal(t, session.ID == phone.SessionID, session.Current)
+	}
+
+	require.NoError(t, authSvc.Logout(ctx, laptop.Token))
+	_, err = authSvc.ValidateToken(ctx, laptop.Token)
+	assert.ErrorIs(t, err, auth.ErrRevokedToken)
+	_, err = authSvc.RefreshToken(ctx, laptop.RefreshToken)
+	assert.ErrorIs(t, err, models.ErrSessionRevoked)
+
+	// With Redis down, revocations are still written to and read from the
+	// database
+	user, err := authSvc.ValidateToken(ctx, phone.Token)
+	require.NoError(t, err)
+	redisServer.Close()
+	revoked, err := authSvc.LogoutAll(asUser(user.ID, models.RoleUser))
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	_, err = authSvc.ValidateToken(ctx, phone.Token)
+	assert.ErrorIs(t, err, auth.ErrRevokedToken)
+}
@@ -43,44 +43,75 @@ var (
 	)
 )
 
+const historyReplayChunkSize = 1000
+
+// endOfTime bounds history replays that run up to the present.
+var endOfTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
 type BalanceService struct {
 	repo     models.BalanceRepository
+	holds    models.HoldRepository
+	uow      models.UnitOfWork
 	auditSvc models.AuditService
 	logger   *logger.Logger
 	cache    *cache.CacheService
+	updates  models.UpdatePublisher
 	locks    sync.Map
 }
 
 func NewBalanceService(
 	repo models.BalanceRepository,
+	holds models.HoldRepository,
+	uow models.UnitOfWork,
 	auditSvc models.AuditService,
 	logger *logger.Logger,
 	cache *cache.CacheService,
+	updates models.UpdatePublisher,
 ) *BalanceService {
 	return &BalanceService{
 		repo:     repo,
+		holds:    holds,
+		uow:      uow,
 		auditSvc: auditSvc,
 		logger:   logger,
 		cache:    cache,
+		updates:  updates,
 	}
 }
 
-func (s *BalanceService) GetBalance(ctx context.Context, userID uint) (*models.Balance, error) {
+// GetBalance returns the user's balance in currency, creating an empty one
+// the first time the user touches that currency.
+func (s *BalanceService) GetBalance(ctx context.Context, userID uint, currency string) (*models.Balance, error) {
 	timer := prometheus.NewTimer(balanceUpdateDuration.WithLabelValues("get"))
 	defer timer.ObserveDuration()
 
-	cacheKey := cache.BuildKey(cache.KeyBalance, userID)
+	currency = models.NormalizeCurrency(currency)
+	if _, err := models.LookupCurrency(currency); err != nil {
+		return nil, err
+	}
+
+	// Inside a unit of work the balance is read from the database and its row
+	// stays locked until commit, so concurrent writers serialize on it.
+	if s.uow.InTransaction(ctx) {
+		return s.getBalanceForUpdate(ctx, userID, currency)
+	}
+
+	cacheKey := balanceCacheKey(userID, currency)
 	var balance *models.Balance
 
-	s.logger.Debug("Attempting to get balance from cache", "user_id", userID)
+	s.logger.Debug("Attempting to get balance from cache", "user_id", userID, "currency", currency)
 	if err := s.cache.Get(ctx, cacheKey, &balance); err == nil && balance != nil {
 		if time.Since(balance.LastUpdatedAt) <= 5*time.Minute {
 			s.logger.Debug("Got balance from cache",
 				"user_id", userID,
+				"currency", currency,
 				"amount", balance.SafeAmount(),
 				"last_updated", balance.LastUpdatedAt)
 			balanceOperations.WithLabelValues("get", "cache_hit").Inc()
 			balanceDistribution.WithLabelValues("current").Observe(balance.SafeAmount().InexactFloat64())
+			if err := s.loadHeld(ctx, balance); err != nil {
+				return nil, err
+			}
 			return balance, nil
 		}
 		s.logger.Debug("Cache entry expired",
@@ -88,13 +119,14 @@ func (s *BalanceService) GetBalance(ctx context.Context, userID uint) (*models.B
 			"last_updated", balance.LastUpdatedAt)
 	}
 
-	s.logger.Debug("Getting balance from database", "user_id", userID)
-	balance, err := s.repo.GetByUserID(ctx, userID)
+	s.logger.Debug("Getting balance from database", "user_id", userID, "currency", currency)
+	balance, err := s.repo.GetByUserID(ctx, userID, currency)
 	if err != nil {
 		if err == models.ErrNotFound {
-			s.logger.Info("Creating initial balance for user", "user_id", userID)
+			s.logger.Info("Creating initial balance for user", "user_id", userID, "currency", currency)
 			balance = &models.Balance{
 				UserID:        userID,
+				Currency:      currency,
 				Amount:        decimal.NewFromInt(0),
 				LastUpdatedAt: time.Now(),
 			}
@@ -118,89 +150,197 @@ func (s *BalanceService) GetBalance(ctx context.Context, userID uint) (*models.B
 
 	balanceOperations.WithLabelValues("get", "db_hit").Inc()
 	balanceDistribution.WithLabelValues("current").Observe(balance.SafeAmount().InexactFloat64())
+	if err := s.loadHeld(ctx, balance); err != nil {
+		return nil, err
+	}
 	return balance, nil
 }
 
-func (s *BalanceService) UpdateBalance(ctx context.Context, userID uint, amount decimal.Decimal) error {
+// GetBalances returns the user's balances in every currency they hold. A
+// user without any balance gets an empty one in the default currency.
+func (s *BalanceService) GetBalances(ctx context.Context, userID uint) ([]models.Balance, error) {
+	balances, err := s.repo.ListByUserID(ctx, userID)
+	if err != nil {
+		balanceOperations.WithLabelValues("list", "failure").Inc()
+		return nil, fmt.Errorf("failed to get balances: %w", err)
+	}
+
+	if len(balances) == 0 {
+		if _, err := s.GetBalance(ctx, userID, models.DefaultCurrency); err != nil {
+			return nil, err
+		}
+		if balances, err = s.repo.ListByUserID(ctx, userID); err != nil {
+			balanceOperations.WithLabelValues("list", "failure").Inc()
+			return nil, fmt.Errorf("failed to get balances: %w", err)
+		}
+	}
+
+	for i := range balances {
+		if err := s.loadHeld(ctx, &balances[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	balanceOperations.WithLabelValues("list", "success").Inc()
+	return balances, nil
+}
+
+func (s *BalanceService) getBalanceForUpdate(ctx context.Context, userID uint, currency string) (*models.Balance, error) {
+	balance, err := s.repo.GetByUserIDForUpdate(ctx, userID, currency)
+	if err == models.ErrNotFound {
+		s.logger.Info("Creating initial balance for user", "user_id", userID, "currency", currency)
+		initial := &models.Balance{
+			UserID:        userID,
+			Currency:      currency,
+			Amount:        decimal.NewFromInt(0),
+			LastUpdatedAt: time.Now(),
+		}
+		if err := s.CreateInitialBalance(ctx, initial); err != nil {
+			balanceOperations.WithLabelValues("get", "failure").Inc()
+			return nil, fmt.Errorf("failed to create initial balance: %w", err)
+		}
+		balance, err = s.repo.GetByUserIDForUpdate(ctx, userID, currency)
+	}
+	if err != nil {
+		balanceOperations.WithLabelValues("get", "failure").Inc()
+		return nil, fmt.Errorf("failed to get balance: %w", err)
+	}
+
+	balanceOperations.WithLabelValues("get", "db_hit").Inc()
+	if err := s.loadHeld(ctx, balance); err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+// loadHeld fills in the funds reserved by active holds. It is never cached:
+// holds expire on their own, without a balance write to invalidate the cache.
+func (s *BalanceService) loadHeld(ctx context.Context, balance *models.Balance) error {
+	held, err := s.holds.SumActive(ctx, balance.UserID, balance.Currency)
+	if err != nil {
+		return fmt.Errorf("failed to get held funds: %w", err)
+	}
+	balance.Held = held
+	return nil
+}
+
+// UpdateBalance sets the user's balance in currency and records its history
+// and audit entry in one unit of work. When ctx already belongs to a unit of
+// work the writes join it and commit with the caller.
+func (s *BalanceService) UpdateBalance(ctx context.Context, userID uint, currency string, amount decimal.Decimal) error {
 	timer := prometheus.NewTimer(balanceUpdateDuration.WithLabelValues("update"))
 	defer timer.ObserveDuration()
 
+	currency = models.NormalizeCurrency(currency)
+	if _, err := models.LookupCurrency(currency); err != nil {
+		balanceOperations.WithLabelValues("update", "failure").Inc()
+		return err
+	}
+
 	lock := s.getLock(userID)
 	lock.Lock()
 	defer lock.Unlock()
 
 	s.logger.Info("Starting balance update",
 		"user_id", userID,
+		"currency", currency,
 		"new_amount", amount)
 
 	// Always invalidate cache on write operations
-	cacheKey := cache.BuildKey(cache.KeyBalance, userID)
+	cacheKey := balanceCacheKey(userID, currency)
 	if err := s.cache.Delete(ctx, cacheKey); err != nil {
 		s.logger.Error("Failed to invalidate balance cache", "error", err)
 	} else {
 		s.logger.Debug("Successfully invalidated cache", "user_id", userID)
 	}
 
-	s.logger.Debug("Getting current balance from database", "user_id", userID)
-	balance, err := s.repo.GetByUserID(ctx, userID)
-	if err != nil {
-		balanceOperations.WithLabelValues("update", "failure").Inc()
-		return err
-	}
-
-	oldAmount := balance.SafeAmount()
-	newAmount := amount
-
-	if newAmount.IsNegative() {
-		s.logger.Error("Attempted negative balance update",
-			"user_id", userID,
-			"amount", newAmount)
-		balanceOperations.WithLabelValues("update", "failure").Inc()
-		return fmt.Errorf("balance cannot be negative")
-	}
+	var balance *models.Balance
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		s.logger.Debug("Getting current balance from database", "user_id", userID)
+		var err error
+		balance, err = s.repo.GetByUserIDForUpdate(ctx, userID, currency)
+		if err != nil {
+			return err
+		}
 
-	s.logger.Info("Updating balance",
-		"user_id", userID,
-		"old_amount", oldAmount,
-		"new_amount", newAmount)
+		oldAmount := balance.SafeAmount()
+		newAmount := amount
 
-	balance.UpdateAmount(newAmount)
-	balance.LastUpdatedAt = time.Now()
+		if newAmount.IsNegative() {
+			s.logger.Error("Attempted negative balance update",
+				"user_id", userID,
+				"amount", newAmount)
+			return models.ErrNegativeBalance
+		}
 
-	if err := s.repo.Update(ctx, balance); err != nil {
-		s.logger.Error("Failed to update balance in database",
-			"error", err,
+		s.logger.Info("Updating balance",
 			"user_id", userID,
 			"old_amount", oldAmount,
 			"new_amount", newAmount)
-		balance.UpdateAmount(oldAmount)
-		balanceOperations.WithLabelValues("update", "failure").Inc()
-		return fmt.Errorf("failed to update balance: %w", err)
-	}
 
-	s.logger.Info("Successfully updated balance in database",
-		"user_id", userID,
-		"old_amount", oldAmount,
-		"new_amount", newAmount)
+		balance.UpdateAmount(newAmount)
+		balance.LastUpdatedAt = time.Now()
 
-	balanceOperations.WithLabelValues("update", "success").Inc()
-	balanceDistribution.WithLabelValues("current").Observe(balance.SafeAmount().InexactFloat64())
+		if err := s.repo.Update(ctx, balance); err != nil {
+			s.logger.Error("Failed to update balance in database",
+				"error", err,
+				"user_id", userID,
+				"old_amount", oldAmount,
+				"new_amount", newAmount)
+			return fmt.Errorf("failed to update balance: %w", err)
+		}
 
-	history := &models.BalanceHistory{
-		UserID:    userID,
-		OldAmount: oldAmount,
-		NewAmount: newAmount,
-		CreatedAt: time.Now(),
-	}
-	if err := s.createBalanceHistory(ctx, history); err != nil {
-		s.logger.Error("Failed to create balance history", "error", err)
-	}
+		history := &models.BalanceHistory{
+			UserID:    userID,
+			Currency:  currency,
+			OldAmount: oldAmount,
+			NewAmount: newAmount,
+			CreatedAt: time.Now(),
+		}
+		transactionID, traced := models.TransactionIDFromContext(ctx)
+		if traced {
+			history.TransactionID = &transactionID
+		}
+		if err := s.createBalanceHistory(ctx, history); err != nil {
+			return fmt.Errorf("failed to create balance history: %w", err)
+		}
+
+		details := fmt.Sprintf("%s balance updated from %s to %s", currency, oldAmount, newAmount)
+		if err := s.auditSvc.LogAction(ctx, models.EntityTypeBalance, userID, models.ActionUpdate, details); err != nil {
+			return fmt.Errorf("failed to log balance update: %w", err)
+		}
 
-	details := fmt.Sprintf("Balance updated from %s to %s", oldAmount, newAmount)
-	if err := s.auditSvc.LogAction(ctx, models.EntityTypeBalance, userID, models.ActionUpdate, details); err != nil {
-		s.logger.Error("Failed to log balance update", "error", err)
+		// Readers may have cached the old amount while the transaction was open
+		s.uow.AfterCommit(ctx, func() {
+			if err := s.cache.Delete(context.Background(), cacheKey); err != nil {
+				s.logger.Error("Failed to invalidate balance cache", "error", err)
+			}
+			balanceOperations.WithLabelValues("update", "success").Inc()
+			balanceDistribution.WithLabelValues("current").Observe(newAmount.InexactFloat64())
+
+			update := models.BalanceData{
+				UserID:    userID,
+				Currency:  currency,
+				Amount:    newAmount,
+				UpdatedAt: history.CreatedAt,
+			}
+			if traced {
+				update.TransactionID = &transactionID
+			}
+			s.publishBalance(update)
+		})
+
+		return nil
+	})
+	if err != nil {
+		balanceOperations.WithLabelValues("update", "failure").Inc()
+		return err
 	}
 
+	s.logger.Info("Successfully updated balance",
+		"user_id", userID,
+		"new_amount", amount)
+
 	return nil
 }
 
@@ -208,8 +348,10 @@ func (s *BalanceService) LockBalance(ctx context.Context, userID uint) (*sync.Mu
 	return s.getLock(userID), nil
 }
 
-func (s *BalanceService) GetBalanceHistory(ctx context.Context, userID uint, limit int) ([]models.BalanceHistory, error) {
-	history, err := s.repo.GetBalanceHistory(ctx, userID, limit)
+// GetBalanceHistory returns the user's history in one currency, or in all
+// currencies when currency is empty.
+func (s *BalanceService) GetBalanceHistory(ctx context.Context, userID uint, currency string, limit int) ([]models.BalanceHistory, error) {
+	history, err := s.repo.GetBalanceHistory(ctx, userID, currency, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get balance history: %w", err)
 	}
@@ -226,33 +368,174 @@ func (s *BalanceService) getLock(userID uint) *sync.Mutex {
 	return lock.(*sync.Mutex)
 }
 
-func (s *BalanceService) GetBalanceAtTime(ctx context.Context, userID uint, timestamp time.Time) (*models.Balance, error) {
-	currentBalance, err := s.GetBalance(ctx, userID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current balance: %w", err)
+// GetBalanceAtTime returns the user's balance in currency as it stood at
+// timestamp. It starts from the latest daily snapshot taken at or before
+// timestamp and replays the history recorded since. Without such a snapshot
+// it starts from the current balance and undoes the history recorded after
+// timestamp. Either way every change is counted, however old or busy the
+// balance is.
+func (s *BalanceService) GetBalanceAtTime(ctx context.Context, userID uint, currency string, timestamp time.Time) (*models.Balance, error) {
+	currency = models.NormalizeCurrency(currency)
+	if _, err := models.LookupCurrency(currency); err != nil {
+		return nil, err
 	}
 
 	balance := &models.Balance{
-		UserID:        currentBalance.UserID,
-		Amount:        currentBalance.SafeAmount(),
-		LastUpdatedAt: currentBalance.LastUpdatedAt,
+		UserID:        userID,
+		Currency:      currency,
+		LastUpdatedAt: timestamp,
 	}
 
-	history, err := s.repo.GetBalanceHistory(ctx, userID, 1000)
+	// One unit of work reads the balance and its history consistently
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		snapshot, err := s.repo.GetLatestSnapshot(ctx, userID, currency, timestamp)
+		if err == nil {
+			change, err := s.sumHistory(ctx, userID, currency, snapshot.AsOf, timestamp)
+			if err != nil {
+				return err
+			}
+			balance.Amount = snapshot.Amount.Add(change)
+			return nil
+		} else if err != models.ErrNotFound {
+			return err
+		}
+
+		current := decimal.Zero
+		if existing, err := s.repo.GetByUserID(ctx, userID, currency); err == nil {
+			current = existing.SafeAmount()
+		} else if err != models.ErrNotFound {
+			return err
+		}
+		change, err := s.sumHistory(ctx, userID, currency, timestamp, endOfTime)
+		if err != nil {
+			return err
+		}
+		balance.Amount = current.Sub(change)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get balance history: %w", err)
+		balanceOperations.WithLabelValues("get_at_time", "failure").Inc()
+		return nil, fmt.Errorf("failed to get balance at time: %w", err)
 	}
 
-	for i := len(history) - 1; i >= 0; i-- {
-		if history[i].CreatedAt.After(timestamp) {
-			balance.Amount = history[i].OldAmount
-			balance.LastUpdatedAt = history[i].CreatedAt
-		} else {
-			break
+	balanceOperations.WithLabelValues("get_at_time", "success").Inc()
+	return balance, nil
+}
+
+// GetBalancesAtTime returns every balance that existed at timestamp as it
+// stood then, for end-of-day reports. It works like GetBalanceAtTime over all
+// balances at once: those in the latest snapshot replay the history since,
+// and the rest undo the history after timestamp from their current amount.
+func (s *BalanceService) GetBalancesAtTime(ctx context.Context, timestamp time.Time) ([]models.Balance, error) {
+	var result []models.Balance
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		balances, err := s.repo.GetAll(ctx)
+		if err != nil {
+			return err
+		}
+
+		amounts := make(map[models.BalanceKey]decimal.Decimal, len(balances))
+		snapshotted := make(map[models.BalanceKey]bool)
+		asOf, err := s.repo.LatestSnapshotTime(ctx, timestamp)
+		if err == nil {
+			snapshots, err := s.repo.ListSnapshotsAt(ctx, asOf)
+			if err != nil {
+				return err
+			}
+			for _, snapshot := range snapshots {
+				key := models.BalanceKey{UserID: snapshot.UserID, Currency: snapshot.Currency}
+				amounts[key] = snapshot.Amount
+				snapshotted[key] = true
+			}
+			if err := s.replayAllHistory(ctx, asOf, timestamp, func(key models.BalanceKey, change decimal.Decimal) {
+				if snapshotted[key] {
+					amounts[key] = amounts[key].Add(change)
+				}
+			}); err != nil {
+				return err
+			}
+		} else if err != models.ErrNotFound {
+			return err
+		}
+
+		unsnapshotted := false
+		for i := range balances {
+			key := models.BalanceKey{UserID: balances[i].UserID, Currency: balances[i].Currency}
+			if !snapshotted[key] {
+				amounts[key] = balances[i].SafeAmount()
+				unsnapshotted = true
+			}
+		}
+		if unsnapshotted {
+			if err := s.replayAllHistory(ctx, timestamp, endOfTime, func(key models.BalanceKey, change decimal.Decimal) {
+				if !snapshotted[key] {
+					amounts[key] = amounts[key].Sub(change)
+				}
+			}); err != nil {
+				return err
+			}
+		}
+
+		result = make([]models.Balance, 0, len(balances))
+		for i := range balances {
+			if balances[i].CreatedAt.After(timestamp) {
+				continue
+			}
+			key := models.BalanceKey{UserID: balances[i].UserID, Currency: balances[i].Currency}
+			result = append(result, models.Balance{
+				UserID:        key.UserID,
+				Currency:      key.Currency,
+				Amount:        amounts[key],
+				LastUpdatedAt: timestamp,
+			})
 		}
+		return nil
+	})
+	if err != nil {
+		balanceOperations.WithLabelValues("list_at_time", "failure").Inc()
+		return nil, fmt.Errorf("failed to get balances at time: %w", err)
 	}
 
-	return balance, nil
+	balanceOperations.WithLabelValues("list_at_time", "success").Inc()
+	return result, nil
+}
+
+// sumHistory adds up the changes to one balance recorded in (from, to].
+func (s *BalanceService) sumHistory(ctx context.Context, userID uint, currency string, from, to time.Time) (decimal.Decimal, error) {
+	total := decimal.Zero
+	var afterID uint
+	for {
+		history, err := s.repo.ListBalanceHistoryBetween(ctx, userID, currency, from, to, afterID, historyReplayChunkSize)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		for i := range history {
+			total = total.Add(history[i].NewAmount.Sub(history[i].OldAmount))
+		}
+		if len(history) < historyReplayChunkSize {
+			return total, nil
+		}
+		afterID = history[len(history)-1].ID
+	}
+}
+
+// replayAllHistory hands every balance change recorded in (from, to] to fn
+// in the order the changes were applied.
+func (s *BalanceService) replayAllHistory(ctx context.Context, from, to time.Time, fn func(key models.BalanceKey, change decimal.Decimal)) error {
+	var afterID uint
+	for {
+		history, err := s.repo.ListAllBalanceHistoryBetween(ctx, from, to, afterID, historyReplayChunkSize)
+		if err != nil {
+			return err
+		}
+		for i := range history {
+			fn(models.BalanceKey{UserID: history[i].UserID, Currency: history[i].Currency}, history[i].NewAmount.Sub(history[i].OldAmount))
+		}
+		if len(history) < historyReplayChunkSize {
+			return nil
+		}
+		afterID = history[len(history)-1].ID
+	}
 }
 
 func (s *BalanceService) CreateInitialBalance(ctx context.Context, balance *models.Balance) error {
@@ -260,26 +543,51 @@ func (s *BalanceService) CreateInitialBalance(ctx context.Context, balance *mode
 	lock.Lock()
 	defer lock.Unlock()
 
-	_, err := s.repo.GetByUserID(ctx, balance.UserID)
-	if err == nil {
+	balance.Currency = models.NormalizeCurrency(balance.Currency)
+
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		_, err := s.repo.GetByUserID(ctx, balance.UserID, balance.Currency)
+		if err == nil {
+			return nil
+		} else if err != models.ErrNotFound {
+			return fmt.Errorf("failed to check existing balance: %w", err)
+		}
+
+		if err := s.repo.Create(ctx, balance); err != nil {
+			return fmt.Errorf("failed to create initial balance: %w", err)
+		}
+
+		details := fmt.Sprintf("Initial %s balance created with amount %s", balance.Currency, balance.Amount)
+		if err := s.auditSvc.LogAction(ctx, models.EntityTypeBalance, balance.UserID, models.ActionCreate, details); err != nil {
+			return fmt.Errorf("failed to log initial balance creation: %w", err)
+		}
+
+		s.uow.AfterCommit(ctx, func() {
+			cacheKey := balanceCacheKey(balance.UserID, balance.Currency)
+			if err := s.cache.Set(context.Background(), cacheKey, balance, cache.MediumTerm); err != nil {
+				s.logger.Error("failed to cache initial balance", "error", err)
+			}
+		})
+
 		return nil
-	} else if err != models.ErrNotFound {
-		return fmt.Errorf("failed to check existing balance: %w", err)
-	}
+	})
+}
 
-	if err := s.repo.Create(ctx, balance); err != nil {
-		return fmt.Errorf("failed to create initial balance: %w", err)
+// publishBalance pushes a committed balance change to the clients watching
+// its owner.
+func (s *BalanceService) publishBalance(data models.BalanceData) {
+	if s.updates == nil {
+		return
 	}
-
-	cacheKey := cache.BuildKey(cache.KeyBalance, balance.UserID)
-	if err := s.cache.Set(ctx, cacheKey, balance, cache.MediumTerm); err != nil {
-		s.logger.Error("failed to cache initial balance", "error", err)
+	update, err := models.NewBalanceUpdate(data.UserID, data)
+	if err == nil {
+		err = s.updates.Publish(context.Background(), update)
 	}
-
-	details := fmt.Sprintf("Initial balance created with amount %s", balance.Amount)
-	if err := s.auditSvc.LogAction(ctx, models.EntityTypeBalance, balance.UserID, models.ActionCreate, details); err != nil {
-		s.logger.Error("failed to log initial balance creation", "error", err)
+	if err != nil {
+		s.logger.Error("failed to publish balance update", "error", err, "user_id", data.UserID)
 	}
+}
 
-	return nil
+func balanceCacheKey(userID uint, currency string) string {
+	return fmt.Sprintf("%s:%s", cache.BuildKey(cache.KeyBalance, userID), currency)
 }
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+)
+
+func nextUpdate(t *testing.T, updates <-chan models.Update) models.Update {
+	t.Helper()
+	select {
+	case update, ok := <-updates:
+		require.True(t, ok, "update stream closed")
+		return update
+	case <-time.After(2 * time.Second):
+		t.Fatal("no update received")
+		return models.Update{}
+	}
+}
+
+func TestUpdatesStreamCommittedChangesAndResume(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	updates, err := ledger.updates.Subscribe(watchCtx, 1, "")
+	require.NoError(t, err)
+
+	// A transfer that rolls back pushes its failure but no balance change
+	ledger.failOn(t, "create", "postings", 1)
+	require.Error(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(99), "USD", ""))
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(30), "USD", ""))
+
+	first := nextUpdate(t, updates)
+	assert.Equal(t, models.UpdateTransaction, first.Type, "rolled back balance change pushed")
+	var failed models.TransactionData
+	require.NoError(t, json.Unmarshal(first.Data, &failed))
+	assert.Equal(t, models.StatusFailed, failed.Status)
+
+	balanceUpdate := nextUpdate(t, updates)
+	require.Equal(t, models.UpdateBalance, balanceUpdate.Type)
+	var balance models.BalanceData
+	require.NoError(t, json.Unmarshal(balanceUpdate.Data, &balance))
+	assert.True(t, balance.Amount.Equal(decimal.NewFromInt(70)), "pushed %s", balance.Amount)
+
+	completed := nextUpdate(t, updates)
+	require.Equal(t, models.UpdateTransaction, completed.Type)
+	stopWatching()
+
+	// Reconnecting after the balance update replays what came after it
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(20), "USD", ""))
+	resumed, err := ledger.updates.Subscribe(ctx, 1, balanceUpdate.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, completed.ID, nextUpdate(t, resumed).ID)
+	require.NoError(t, json.Unmarshal(nextUpdate(t, resumed).Data, &balance))
+	assert.True(t, balance.Amount.Equal(decimal.NewFromInt(50)), "pushed %s", balance.Amount)
+	assert.Equal(t, models.UpdateTransaction, nextUpdate(t, resumed).Type)
+
+	_, err = ledger.updates.Subscribe(ctx, 1, "not-an-id")
+	assert.ErrorIs(t, err, models.ErrInvalidInput)
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/shopspring/decimal"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+var fxOperations = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_fx_operations_total",
+		Help: "Total number of FX quotes and conversions by currency pair and status",
+	},
+	[]string{"operation", "pair", "status"},
+)
+
+const (
+	DefaultFXSpreadBps = 50
+	DefaultFXQuoteTTL  = 30 * time.Second
+)
+
+// FXService prices cross-currency transfers and executes them from a quote.
+type FXService struct {
+	quoteRepo models.FXQuoteRepository
+	rates     models.RateProvider
+	txSvc     *TransactionService
+	logger    *logger.Logger
+	spreadBps int64
+	quoteTTL  time.Duration
+}
+
+func NewFXService(
+	quoteRepo models.FXQuoteRepository,
+	rates models.RateProvider,
+	txSvc *TransactionService,
+	logger *logger.Logger,
+	spreadBps int64,
+	quoteTTL time.Duration,
+) *FXService {
+	if quoteTTL <= 0 {
+		quoteTTL = DefaultFXQuoteTTL
+	}
+	return &FXService{
+		quoteRepo: quoteRepo,
+		rates:     rates,
+		txSvc:     txSvc,
+		logger:    logger,
+		spreadBps: spreadBps,
+		quoteTTL:  quoteTTL,
+	}
+}
+
+// CreateQuote prices the conversion of amount from one currency to another
+// and holds the price until the quote expires.
+func (s *FXService) CreateQuote(ctx context.Context, userID uint, from, to string, amount decimal.Decimal) (*models.FXQuote, error) {
+	from, to = models.NormalizeCurrency(from), models.NormalizeCurrency(to)
+	pair := from + "/" + to
+
+	if !amount.IsPositive() {
+		return nil, models.ErrInvalidAmount
+	}
+	if from == to {
+		return nil, models.ErrSameCurrency
+	}
+	if err := models.ValidateMoney(amount, from); err != nil {
+		return nil, err
+	}
+	target, err := models.LookupCurrency(to)
+	if err != nil {
+		return nil, err
+	}
+
+	midRate, err := s.rates.Rate(ctx, from, to)
+	if err != nil {
+		fxOperations.WithLabelValues("quote", pair, "failure").Inc()
+		return nil, err
+	}
+
+	targetAmount, rate, spread := models.PriceConversion(amount, midRate, s.spreadBps, target)
+	if !targetAmount.IsPositive() {
+		return nil, fmt.Errorf("%w: converts to less than one %s minor unit", models.ErrInvalidAmount, to)
+	}
+
+	now := time.Now()
+	quote := &models.FXQuote{
+		UserID:       userID,
+		FromCurrency: from,
+		ToCurrency:   to,
+		SourceAmount: amount,
+		TargetAmount: targetAmount,
+		MidRate:      midRate,
+		Rate:         rate,
+		Spread:       spread,
+		ExpiresAt:    now.Add(s.quoteTTL),
+		CreatedAt:    now,
+	}
+	if err := s.quoteRepo.Create(ctx, quote); err != nil {
+		fxOperations.WithLabelValues("quote", pair, "failure").Inc()
+		return nil, err
+	}
+
+	fxOperations.WithLabelValues("quote", pair, "success").Inc()
+	return quote, nil
+}
+
+// ExecuteQuote transfers the quoted source amount from the quote's owner and
+// credits toUserID with the quoted target amount. Both legs, the quote's
+// consumption and the transaction status commit in one unit of work.
+func (s *FXService) ExecuteQuote(ctx context.Context, userID uint, quoteID string, toUserID uint, notes string) (*models.Transaction, error) {
+	quote, err := s.quoteRepo.GetByID(ctx, quoteID)
+	if err != nil {
+		return nil, err
+	}
+	// Quotes of other users are reported as missing
+	if quote.UserID != userID {
+		return nil, models.ErrNotFound
+	}
+	if quote.UsedAt != nil {
+		return nil, models.ErrQuoteUsed
+	}
+	if quote.IsExpired() {
+		return nil, models.ErrQuoteExpired
+	}
+
+	pair := quote.FromCurrency + "/" + quote.ToCurrency
+	tx := &models.Transaction{
+		FromUserID: userID,
+		ToUserID:   toUserID,
+		Amount:     quote.SourceAmount,
+		Currency:   quote.FromCurrency,
+		ToCurrency: quote.ToCurrency,
+		ToAmount:   decimal.NewNullDecimal(quote.TargetAmount),
+		FXRate:     decimal.NewNullDecimal(quote.Rate),
+		FXSpread:   decimal.NewNullDecimal(quote.Spread),
+		FXQuoteID:  &quote.ID,
+		Type:       models.TypeTransfer,
+		Status:     models.StatusPending,
+		Notes:      notes,
+	}
+	if err := tx.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid transaction: %w", err)
+	}
+
+	markUsed := func(ctx context.Context) error {
+		return s.quoteRepo.MarkUsed(ctx, quote.ID, tx.ID)
+	}
+
+	if err := s.txSvc.stepUp(ctx, tx.Currency, tx.Amount); err != nil {
+		return nil, err
+	}
+	if err := s.txSvc.withinLimits(ctx, tx); err != nil {
+		return nil, err
+	}
+	// A transfer held for review uses up the quote; it converts at the
+	// quoted rate if approved
+	if err := s.txSvc.screen(ctx, tx, markUsed); err != nil {
+		return nil, err
+	}
+
+	if err := s.txSvc.CreateTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := s.txSvc.processor.ProcessTransaction(ctx, tx, markUsed, s.txSvc.limitStep(tx)); err != nil {
+		fxOperations.WithLabelValues("execute", pair, "failure").Inc()
+		return nil, fmt.Errorf("failed to process conversion: %w", err)
+	}
+
+	s.logger.Info("FX transfer completed",
+		"transaction_id", tx.ID,
+		"quote_id", quote.ID,
+		"from_user", userID,
+		"to_user", toUserID,
+		"source", quote.SourceAmount.String()+" "+quote.FromCurrency,
+		"target", quote.TargetAmount.String()+" "+quote.ToCurrency)
+
+	fxOperations.WithLabelValues("execute", pair, "success").Inc()
+	return tx, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/logger"
+)
+
+func newTestFX(ledger *testLedger) *FXService {
+	rates := NewStaticRateProvider(map[string]decimal.Decimal{"USD/EUR": decimal.RequireFromString("0.92")})
+	return NewFXService(repositories.NewFXQuoteRepository(ledger.db), rates, ledger.txSvc, logger.New("error"), 50, time.Minute)
+}
+
+func TestFXTransferConvertsAtQuotedRate(t *testing.T) {
+	ledger := newTestLedger(t)
+	fx := newTestFX(ledger)
+	ctx := context.Background()
+
+	quote, err := fx.CreateQuote(ctx, 1, "usd", "eur", decimal.NewFromInt(10))
+	require.NoError(t, err)
+	assert.True(t, quote.Rate.Equal(decimal.RequireFromString("0.9154")))
+	assert.True(t, quote.TargetAmount.Equal(decimal.RequireFromString("9.15")))
+
+	tx, err := fx.ExecuteQuote(ctx, 1, quote.ID, 2, "holiday")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusCompleted, ledger.lastStatus(t))
+	assert.Equal(t, "EUR", tx.ToCurrency)
+
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(90)))
+	assert.True(t, ledger.balanceIn(t, 2, "EUR").Equal(decimal.RequireFromString("9.15")))
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(50)), "USD balance changed")
+
+	report, err := ledger.journalSvc.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "journal invariants violated: %+v", report)
+
+	_, err = fx.ExecuteQuote(ctx, 1, quote.ID, 2, "again")
+	assert.ErrorIs(t, err, models.ErrQuoteUsed)
+
+	// Quotes belong to the user who requested them
+	other, err := fx.CreateQuote(ctx, 1, "EUR", "USD", decimal.NewFromInt(1))
+	require.NoError(t, err)
+	_, err = fx.ExecuteQuote(ctx, 2, other.ID, 1, "")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func TestFXQuoteRules(t *testing.T) {
+	ledger := newTestLedger(t)
+	fx := newTestFX(ledger)
+	ctx := context.Background()
+
+	_, err := fx.CreateQuote(ctx, 1, "USD", "USD", decimal.NewFromInt(10))
+	assert.ErrorIs(t, err, models.ErrSameCurrency)
+
+	_, err = fx.CreateQuote(ctx, 1, "USD", "GBP", decimal.NewFromInt(10))
+	assert.ErrorIs(t, err, models.ErrRateUnavailable)
+
+	quote, err := fx.CreateQuote(ctx, 1, "USD", "EUR", decimal.NewFromInt(10))
+	require.NoError(t, err)
+	require.NoError(t, ledger.db.Model(quote).Update("expires_at", time.Now().Add(-time.Second)).Error)
+
+	_, err = fx.ExecuteQuote(ctx, 1, quote.ID, 2, "")
+	assert.ErrorIs(t, err, models.ErrQuoteExpired)
+	assert.Zero(t, ledger.count(t, &models.Transaction{}))
+}
+
+func TestFXTransferRollsBackQuoteOnFailure(t *testing.T) {
+	ledger := newTestLedger(t)
+	fx := newTestFX(ledger)
+	ctx := context.Background()
+
+	quote, err := fx.CreateQuote(ctx, 1, "USD", "EUR", decimal.NewFromInt(10))
+	require.NoError(t, err)
+
+	ledger.failOn(t, "create", "postings", 1)
+	_, err = fx.ExecuteQuote(ctx, 1, quote.ID, 2, "")
+	require.ErrorIs(t, err, errInjected)
+
+	ledger.assertNothingPartial(t)
+	stored, err := repositories.NewFXQuoteRepository(ledger.db).GetByID(ctx, quote.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.UsedAt, "quote consumed by a failed transfer")
+}
+
+func TestFXTransferRespectsSpendingLimits(t *testing.T) {
+	ledger := newTestLedger(t)
+	fx := newTestFX(ledger)
+	ctx := context.Background()
+
+	_, err := ledger.limitSvc.SetLimit(asUser(2, models.RoleAdmin), &models.SpendingLimit{
+		Role:            models.RoleUser,
+		TransactionType: models.TypeTransfer,
+		Currency:        "USD",
+		PerTransaction:  decimal.NewNullDecimal(decimal.NewFromInt(5)),
+	})
+	require.NoError(t, err)
+
+	quote, err := fx.CreateQuote(ctx, 1, "USD", "EUR", decimal.NewFromInt(10))
+	require.NoError(t, err)
+	_, err = fx.ExecuteQuote(ctx, 1, quote.ID, 2, "")
+	assert.Equal(t, "per_transaction_limit", limitError(t, err).Code)
+
+	assert.Zero(t, ledger.count(t, &models.Transaction{}), "rejected transfer recorded")
+	stored, err := repositories.NewFXQuoteRepository(ledger.db).GetByID(ctx, quote.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.UsedAt, "quote consumed by a rejected transfer")
+}
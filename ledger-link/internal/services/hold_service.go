@@ -0,0 +1,345 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/shopspring/decimal"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+var holdOperations = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_hold_operations_total",
+		Help: "Total number of hold operations by kind and status",
+	},
+	[]string{"operation", "status"},
+)
+
+const (
+	DefaultHoldTTL           = 7 * 24 * time.Hour
+	DefaultHoldSweepInterval = time.Minute
+
+	holdSweepBatchSize = 100
+)
+
+// HoldService places authorizations against balances and settles them. A
+// placed hold only reduces the available balance; a capture books a
+// withdrawal of up to the held amount and releases the rest.
+type HoldService struct {
+	repo          models.HoldRepository
+	uow           models.UnitOfWork
+	balanceSvc    models.BalanceService
+	txSvc         *TransactionService
+	auditSvc      models.AuditService
+	logger        *logger.Logger
+	defaultTTL    time.Duration
+	sweepInterval time.Duration
+	stopChan      chan struct{}
+	wg            sync.WaitGroup
+}
+
+func NewHoldService(
+	repo models.HoldRepository,
+	uow models.UnitOfWork,
+	balanceSvc models.BalanceService,
+	txSvc *TransactionService,
+	auditSvc models.AuditService,
+	logger *logger.Logger,
+	defaultTTL time.Duration,
+	sweepInterval time.Duration,
+) *HoldService {
+	if defaultTTL <= 0 {
+		defaultTTL = DefaultHoldTTL
+	}
+	if sweepInterval <= 0 {
+		sweepInterval = DefaultHoldSweepInterval
+	}
+	return &HoldService{
+		repo:          repo,
+		uow:           uow,
+		balanceSvc:    balanceSvc,
+		txSvc:         txSvc,
+		auditSvc:      auditSvc,
+		logger:        logger,
+		defaultTTL:    defaultTTL,
+		sweepInterval: sweepInterval,
+		stopChan:      make(chan struct{}),
+	}
+}
+
+// Place reserves amount of the user's available balance until the hold is
+// settled or ttl passes; a zero ttl uses the default.
+func (s *HoldService) Place(ctx context.Context, userID uint, amount decimal.Decimal, currency, reference, notes string, ttl time.Duration) (*models.Hold, error) {
+	currency = models.NormalizeCurrency(currency)
+	if !amount.IsPositive() {
+		return nil, models.ErrInvalidAmount
+	}
+	if err := models.ValidateMoney(amount, currency); err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = s.defaultTTL
+	}
+
+	hold := &models.Hold{
+		UserID:    userID,
+		Currency:  currency,
+		Amount:    amount,
+		Status:    models.HoldStatusActive,
+		Reference: reference,
+		Notes:     notes,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	// Reading the balance inside the unit of work locks its row, so the
+	// available check cannot interleave with a debit or another hold.
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		balance, err := s.balanceSvc.GetBalance(ctx, userID, currency)
+		if err != nil {
+			return fmt.Errorf("failed to get balance: %w", err)
+		}
+		if balance.Available().LessThan(amount) {
+			return fmt.Errorf("%w: available %s %s, required %s", models.ErrInsufficientFunds, balance.Available(), currency, amount)
+		}
+
+		if err := s.repo.Create(ctx, hold); err != nil {
+			return err
+		}
+
+		details := fmt.Sprintf("Placed hold of %s %s until %s", amount, currency, hold.ExpiresAt.Format(time.RFC3339))
+		return s.auditSvc.LogAction(ctx, models.EntityTypeHold, hold.ID, models.ActionCreate, details)
+	})
+	if err != nil {
+		holdOperations.WithLabelValues("place", "failure").Inc()
+		return nil, err
+	}
+
+	holdOperations.WithLabelValues("place", "success").Inc()
+	return hold, nil
+}
+
+// Capture settles the hold by withdrawing amount, or the full held amount
+// when amount is zero. Whatever is not captured is released. The hold is
+// claimed in the same unit of work as the withdrawal, before it is posted, so
+// the withdrawal can use the funds the hold reserved.
+func (s *HoldService) Capture(ctx context.Context, holdID uint, amount decimal.Decimal, notes string) (*models.Transaction, error) {
+	hold, err := s.owned(ctx, holdID)
+	if err != nil {
+		return nil, err
+	}
+	if amount.IsZero() {
+		amount = hold.Amount
+	}
+	if err := hold.CheckCapture(amount); err != nil {
+		return nil, err
+	}
+
+	tx := &models.Transaction{
+		FromUserID: hold.UserID,
+		ToUserID:   hold.UserID,
+		Amount:     amount,
+		Currency:   hold.Currency,
+		Type:       models.TypeWithdrawal,
+		Status:     models.StatusPending,
+		Notes:      notes,
+	}
+	if err := tx.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid transaction: %w", err)
+	}
+
+	claim := func(ctx context.Context) error {
+		hold, err := s.repo.GetByIDForUpdate(ctx, holdID)
+		if err != nil {
+			return err
+		}
+		if err := hold.CheckCapture(amount); err != nil {
+			return err
+		}
+
+		hold.Status = models.HoldStatusCaptured
+		hold.CapturedAmount = amount
+		hold.TransactionID = &tx.ID
+		if err := s.repo.Update(ctx, hold); err != nil {
+			return err
+		}
+
+		details := fmt.Sprintf("Captured %s of %s %s by transaction %d", amount, hold.Amount, hold.Currency, tx.ID)
+		return s.auditSvc.LogAction(ctx, models.EntityTypeHold, hold.ID, models.ActionUpdate, details)
+	}
+
+	if err := s.txSvc.stepUp(ctx, tx.Currency, tx.Amount); err != nil {
+		return nil, err
+	}
+	if err := s.txSvc.withinLimits(ctx, tx); err != nil {
+		return nil, err
+	}
+	// A capture held for review claims the hold at once, so it cannot be
+	// captured twice while the withdrawal waits for a decision
+	if err := s.txSvc.screen(ctx, tx, claim); err != nil {
+		return nil, err
+	}
+	if err := s.txSvc.CreateTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := s.txSvc.processor.ProcessTransaction(ctx, tx, claim, s.txSvc.limitStep(tx)); err != nil {
+		holdOperations.WithLabelValues("capture", "failure").Inc()
+		return nil, fmt.Errorf("failed to capture hold: %w", err)
+	}
+
+	holdOperations.WithLabelValues("capture", "success").Inc()
+	return tx, nil
+}
+
+// Void releases the hold without moving any funds.
+func (s *HoldService) Void(ctx context.Context, holdID uint) (*models.Hold, error) {
+	if _, err := s.owned(ctx, holdID); err != nil {
+		return nil, err
+	}
+
+	hold, err := s.release(ctx, holdID, models.HoldStatusVoided)
+	if err != nil {
+		holdOperations.WithLabelValues("void", "failure").Inc()
+		return nil, err
+	}
+
+	holdOperations.WithLabelValues("void", "success").Inc()
+	return hold, nil
+}
+
+// Get returns the hold if the user in ctx owns it or may read any account.
+// Other users' holds are reported as missing.
+func (s *HoldService) Get(ctx context.Context, holdID uint) (*models.Hold, error) {
+	return s.get(ctx, holdID, models.PermissionAccountsRead)
+}
+
+// owned returns the hold if the user in ctx owns it or may change any
+// account, for changes to it.
+func (s *HoldService) owned(ctx context.Context, holdID uint) (*models.Hold, error) {
+	return s.get(ctx, holdID, models.PermissionAccountsWrite)
+}
+
+// get returns the hold if the user in ctx owns it or holds permission.
+func (s *HoldService) get(ctx context.Context, holdID uint, permission string) (*models.Hold, error) {
+	user, ok := auth.GetUserFromContext(ctx)
+	if !ok {
+		return nil, models.ErrUnauthorized
+	}
+
+	hold, err := s.repo.GetByID(ctx, holdID)
+	if err != nil {
+		return nil, err
+	}
+	if hold.UserID == user.ID || auth.HasPermission(ctx, permission) {
+		return hold, nil
+	}
+	return nil, models.ErrNotFound
+}
+
+func (s *HoldService) List(ctx context.Context, userID uint, status models.HoldStatus) ([]models.Hold, error) {
+	return s.repo.ListByUserID(ctx, userID, status)
+}
+
+// ExpireHolds marks active holds past their expiry as expired and returns
+// how many it released.
+func (s *HoldService) ExpireHolds(ctx context.Context) (int, error) {
+	expired, err := s.repo.ListExpired(ctx, time.Now(), holdSweepBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, hold := range expired {
+		// The sweeper acts on behalf of the hold's owner in the audit log
+		ctx := auth.SetUserInContext(ctx, &models.User{ID: hold.UserID, Role: models.RoleUser})
+		if _, err := s.release(ctx, hold.ID, models.HoldStatusExpired); err != nil {
+			s.logger.Error("failed to expire hold", "error", err, "hold_id", hold.ID)
+			continue
+		}
+		released++
+	}
+
+	if released > 0 {
+		holdOperations.WithLabelValues("expire", "success").Add(float64(released))
+	}
+	return released, nil
+}
+
+// release moves an active hold to a final status. Expiring only applies to
+// holds past their expiry; voiding only to holds that have not expired yet.
+func (s *HoldService) release(ctx context.Context, holdID uint, status models.HoldStatus) (*models.Hold, error) {
+	var hold *models.Hold
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		hold, err = s.repo.GetByIDForUpdate(ctx, holdID)
+		if err != nil {
+			return err
+		}
+
+		if status == models.HoldStatusExpired && hold.Status == models.HoldStatusActive && hold.IsActive() {
+			return fmt.Errorf("hold %d has not expired yet", hold.ID)
+		}
+		if status == models.HoldStatusVoided && !hold.IsActive() {
+			return fmt.Errorf("%w: hold is %s", models.ErrHoldNotActive, hold.EffectiveStatus())
+		}
+		if hold.Status != models.HoldStatusActive {
+			return fmt.Errorf("%w: hold is %s", models.ErrHoldNotActive, hold.Status)
+		}
+
+		hold.Status = status
+		if err := s.repo.Update(ctx, hold); err != nil {
+			return err
+		}
+
+		details := fmt.Sprintf("Released hold of %s %s as %s", hold.Amount, hold.Currency, status)
+		return s.auditSvc.LogAction(ctx, models.EntityTypeHold, hold.ID, models.ActionUpdate, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// Start runs the sweeper that releases expired holds until Stop is called.
+func (s *HoldService) Start(ctx context.Context) error {
+	s.logger.Info("starting hold sweeper", "interval", s.sweepInterval)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if n, err := s.ExpireHolds(ctx); err != nil {
+					s.logger.Error("failed to sweep expired holds", "error", err)
+				} else if n > 0 {
+					s.logger.Info("released expired holds", "count", n)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *HoldService) Stop() {
+	s.logger.Info("stopping hold sweeper")
+	close(s.stopChan)
+	s.wg.Wait()
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/logger"
+)
+
+func newTestHolds(ledger *testLedger) *HoldService {
+	uow := repositories.NewUnitOfWork(ledger.db)
+	auditSvc := NewAuditService(repositories.NewAuditLogRepository(ledger.db), logger.New("error"))
+	return NewHoldService(repositories.NewHoldRepository(ledger.db), uow, ledger.balanceSvc, ledger.txSvc, auditSvc, logger.New("error"), time.Hour, time.Minute)
+}
+
+func (l *testLedger) available(t *testing.T, userID uint) decimal.Decimal {
+	t.Helper()
+	balance, err := l.balanceSvc.GetBalance(context.Background(), userID, models.DefaultCurrency)
+	require.NoError(t, err)
+	return balance.Available()
+}
+
+func TestHoldReservesAvailableBalance(t *testing.T) {
+	ledger := newTestLedger(t)
+	holds := newTestHolds(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	hold, err := holds.Place(alice, 1, decimal.NewFromInt(60), "USD", "order-1", "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, models.HoldStatusActive, hold.Status)
+
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)), "hold moved funds")
+	assert.True(t, ledger.available(t, 1).Equal(decimal.NewFromInt(40)))
+
+	_, err = holds.Place(alice, 1, decimal.NewFromInt(50), "USD", "order-2", "", 0)
+	assert.ErrorIs(t, err, models.ErrInsufficientFunds)
+
+	err = ledger.txSvc.Debit(alice, 1, decimal.NewFromInt(50), "USD", "atm")
+	assert.ErrorIs(t, err, models.ErrInsufficientFunds)
+	err = ledger.txSvc.Transfer(alice, 1, 2, decimal.NewFromInt(50), "USD", "")
+	assert.ErrorIs(t, err, models.ErrInsufficientFunds)
+	require.NoError(t, ledger.txSvc.Debit(alice, 1, decimal.NewFromInt(40), "USD", "atm"))
+
+	_, err = holds.Void(alice, hold.ID)
+	require.NoError(t, err)
+	assert.True(t, ledger.available(t, 1).Equal(decimal.NewFromInt(60)))
+
+	_, err = holds.Void(alice, hold.ID)
+	assert.ErrorIs(t, err, models.ErrHoldNotActive)
+}
+
+func TestHoldCapture(t *testing.T) {
+	ledger := newTestLedger(t)
+	holds := newTestHolds(ledger)
+	alice, bob := asUser(1, models.RoleUser), asUser(2, models.RoleUser)
+
+	hold, err := holds.Place(alice, 1, decimal.NewFromInt(100), "USD", "", "", 0)
+	require.NoError(t, err)
+
+	_, err = holds.Capture(bob, hold.ID, decimal.Zero, "")
+	assert.ErrorIs(t, err, models.ErrNotFound, "other users' holds are hidden")
+
+	_, err = holds.Capture(alice, hold.ID, decimal.NewFromInt(101), "")
+	assert.ErrorIs(t, err, models.ErrCaptureExceedsHold)
+
+	// Capturing uses the reserved funds and releases the rest
+	tx, err := holds.Capture(alice, hold.ID, decimal.NewFromInt(70), "final amount")
+	require.NoError(t, err)
+	assert.Equal(t, models.TypeWithdrawal, tx.Type)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(30)))
+	assert.True(t, ledger.available(t, 1).Equal(decimal.NewFromInt(30)))
+
+	captured, err := holds.Get(alice, hold.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.HoldStatusCaptured, captured.Status)
+	assert.True(t, captured.CapturedAmount.Equal(decimal.NewFromInt(70)))
+	assert.Equal(t, tx.ID, *captured.TransactionID)
+
+	_, err = holds.Capture(alice, hold.ID, decimal.Zero, "")
+	assert.ErrorIs(t, err, models.ErrHoldNotActive)
+
+	report, err := ledger.journalSvc.CheckInvariants(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "journal invariants violated: %+v", report)
+}
+
+func TestHoldCaptureRollsBackOnFailure(t *testing.T) {
+	ledger := newTestLedger(t)
+	holds := newTestHolds(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	hold, err := holds.Place(alice, 1, decimal.NewFromInt(30), "USD", "", "", 0)
+	require.NoError(t, err)
+
+	ledger.failOn(t, "create", "postings", 1)
+	_, err = holds.Capture(alice, hold.ID, decimal.Zero, "")
+	require.ErrorIs(t, err, errInjected)
+
+	stored, err := holds.Get(alice, hold.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.HoldStatusActive, stored.Status)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)))
+	assert.True(t, ledger.available(t, 1).Equal(decimal.NewFromInt(70)))
+}
+
+func TestExpiredHoldsAreReleased(t *testing.T) {
+	ledger := newTestLedger(t)
+	holds := newTestHolds(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	hold, err := holds.Place(alice, 1, decimal.NewFromInt(80), "USD", "", "", 0)
+	require.NoError(t, err)
+	require.NoError(t, ledger.db.Model(hold).Update("expires_at", time.Now().Add(-time.Second)).Error)
+
+	// A lapsed hold stops reserving funds before the sweeper runs
+	assert.True(t, ledger.available(t, 1).Equal(decimal.NewFromInt(100)))
+	_, err = holds.Capture(alice, hold.ID, decimal.Zero, "")
+	assert.ErrorIs(t, err, models.ErrHoldNotActive)
+
+	released, err := holds.ExpireHolds(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, released)
+
+	expired, err := holds.Get(alice, hold.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.HoldStatusExpired, expired.Status)
+
+	released, err = holds.ExpireHolds(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, released)
+}
+
+func TestHoldCaptureRespectsSpendingLimits(t *testing.T) {
+	ledger := newTestLedger(t)
+	holds := newTestHolds(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	_, err := ledger.limitSvc.SetLimit(asUser(2, models.RoleAdmin), &models.SpendingLimit{
+		Role:            models.RoleUser,
+		TransactionType: models.TypeWithdrawal,
+		Currency:        "USD",
+		PerTransaction:  decimal.NewNullDecimal(decimal.NewFromInt(50)),
+	})
+	require.NoError(t, err)
+
+	hold, err := holds.Place(alice, 1, decimal.NewFromInt(70), "USD", "", "", 0)
+	require.NoError(t, err)
+
+	_, err = holds.Capture(alice, hold.ID, decimal.Zero, "")
+	assert.Equal(t, "per_transaction_limit", limitError(t, err).Code)
+
+	stored, err := holds.Get(alice, hold.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.HoldStatusActive, stored.Status)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)))
+
+	_, err = holds.Capture(alice, hold.ID, decimal.NewFromInt(50), "")
+	require.NoError(t, err)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(50)))
+}
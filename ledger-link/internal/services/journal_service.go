@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/shopspring/decimal"
+)
+
+var ledgerInvariantViolations = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ledger_invariant_violations",
+		Help: "Number of journal invariant violations found by the last check",
+	},
+	[]string{"kind"},
+)
+
+type JournalService struct {
+	repo        models.JournalRepository
+	balanceRepo models.BalanceRepository
+	logger      *logger.Logger
+}
+
+func NewJournalService(
+	repo models.JournalRepository,
+	balanceRepo models.BalanceRepository,
+	logger *logger.Logger,
+) *JournalService {
+	return &JournalService{
+		repo:        repo,
+		balanceRepo: balanceRepo,
+		logger:      logger,
+	}
+}
+
+// VerifyTransaction checks that the postings of a single transaction balance.
+func (s *JournalService) VerifyTransaction(ctx context.Context, transactionID uint) error {
+	postings, err := s.repo.GetByTransactionID(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if err := postings.Validate(); err != nil {
+		return fmt.Errorf("transaction %d: %w", transactionID, err)
+	}
+	return nil
+}
+
+// VerifyAccount compares a user's stored balance in currency with the sum of
+// the postings against the user's account in that currency.
+func (s *JournalService) VerifyAccount(ctx context.Context, userID uint, currency string) (*models.AccountCheck, error) {
+	key := models.BalanceKey{UserID: userID, Currency: models.NormalizeCurrency(currency)}
+
+	balance, err := s.balanceRepo.GetByUserID(ctx, key.UserID, key.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	posted, err := s.repo.SumByAccount(ctx, models.AccountTypeUser, key.UserID, key.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAccountCheck(key, balance.SafeAmount(), posted), nil
+}
+
+// CheckInvariants verifies that every transaction in the journal balances and
+// that every stored balance equals the sum of its postings.
+func (s *JournalService) CheckInvariants(ctx context.Context) (*models.LedgerCheckReport, error) {
+	report := &models.LedgerCheckReport{
+		CheckedAt:              time.Now(),
+		UnbalancedTransactions: []uint{},
+		MismatchedAccounts:     []models.AccountCheck{},
+	}
+
+	unbalanced, err := s.repo.FindUnbalancedTransactions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.UnbalancedTransactions = append(report.UnbalancedTransactions, unbalanced...)
+
+	posted, err := s.repo.SumUserAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	balances, err := s.balanceRepo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[models.BalanceKey]bool, len(balances))
+	for i := range balances {
+		key := models.BalanceKey{UserID: balances[i].UserID, Currency: balances[i].Currency}
+		seen[key] = true
+		check := newAccountCheck(key, balances[i].SafeAmount(), posted[key])
+		if !check.Balanced() {
+			report.MismatchedAccounts = append(report.MismatchedAccounts, *check)
+		}
+	}
+
+	// Postings against an account without a balance row are drift as well.
+	for key, sum := range posted {
+		if !seen[key] && !sum.IsZero() {
+			report.MismatchedAccounts = append(report.MismatchedAccounts, *newAccountCheck(key, decimal.Zero, sum))
+		}
+	}
+
+	ledgerInvariantViolations.WithLabelValues("unbalanced_transaction").Set(float64(len(report.UnbalancedTransactions)))
+	ledgerInvariantViolations.WithLabelValues("account_mismatch").Set(float64(len(report.MismatchedAccounts)))
+
+	if !report.OK() {
+		s.logger.Error("ledger invariant check failed",
+			"unbalanced_transactions", len(report.UnbalancedTransactions),
+			"mismatched_accounts", len(report.MismatchedAccounts))
+	}
+
+	return report, nil
+}
+
+func newAccountCheck(key models.BalanceKey, stored, posted decimal.Decimal) *models.AccountCheck {
+	return &models.AccountCheck{
+		UserID:        key.UserID,
+		Currency:      key.Currency,
+		StoredBalance: stored,
+		PostedBalance: posted,
+		Difference:    stored.Sub(posted),
+	}
+}
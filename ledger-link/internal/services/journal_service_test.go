@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+)
+
+func TestJournalCheckInvariants(t *testing.T) {
+	post := func(t *testing.T, ledger *testLedger, transactionID uint, accountType string, accountID uint, currency string, amount int64) {
+		t.Helper()
+		require.NoError(t, ledger.db.Create(&models.Posting{
+			TransactionID: transactionID,
+			AccountType:   accountType,
+			AccountID:     accountID,
+			Currency:      currency,
+			Amount:        decimal.NewFromInt(amount),
+		}).Error)
+	}
+
+	cases := []struct {
+		name       string
+		corrupt    func(t *testing.T, ledger *testLedger)
+		unbalanced []uint
+		mismatched []models.BalanceKey
+	}{
+		{
+			name:    "balanced journal",
+			corrupt: func(t *testing.T, ledger *testLedger) {},
+		},
+		{
+			name: "debits exceed credits",
+			corrupt: func(t *testing.T, ledger *testLedger) {
+				post(t, ledger, 2000, models.AccountTypeExternal, 0, "USD", -10)
+				post(t, ledger, 2000, models.AccountTypeExternal, 0, "USD", 5)
+			},
+			unbalanced: []uint{2000},
+		},
+		{
+			name: "single leg",
+			corrupt: func(t *testing.T, ledger *testLedger) {
+				post(t, ledger, 2000, models.AccountTypeUser, 1, "USD", 10)
+			},
+			unbalanced: []uint{2000},
+			mismatched: []models.BalanceKey{{UserID: 1, Currency: "USD"}},
+		},
+		{
+			name: "currencies offset each other",
+			corrupt: func(t *testing.T, ledger *testLedger) {
+				post(t, ledger, 2000, models.AccountTypeExternal, 0, "USD", -10)
+				post(t, ledger, 2000, models.AccountTypeExternal, 0, "EUR", 10)
+			},
+			unbalanced: []uint{2000},
+		},
+		{
+			name: "stored balance drifted",
+			corrupt: func(t *testing.T, ledger *testLedger) {
+				require.NoError(t, ledger.db.Exec("UPDATE balances SET amount = ? WHERE user_id = ?", "55", 2).Error)
+			},
+			mismatched: []models.BalanceKey{{UserID: 2, Currency: "USD"}},
+		},
+		{
+			name: "postings without a balance",
+			corrupt: func(t *testing.T, ledger *testLedger) {
+				post(t, ledger, 2000, models.AccountTypeAdjustment, 0, "EUR", -10)
+				post(t, ledger, 2000, models.AccountTypeUser, 1, "EUR", 10)
+			},
+			mismatched: []models.BalanceKey{{UserID: 1, Currency: "EUR"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ledger := newTestLedger(t)
+			ctx := context.Background()
+			require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", ""))
+			c.corrupt(t, ledger)
+
+			unbalanced, err := repositories.NewJournalRepository(ledger.db).FindUnbalancedTransactions(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, c.unbalanced, unbalanced)
+
+			report, err := ledger.journalSvc.CheckInvariants(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, len(c.unbalanced) == 0 && len(c.mismatched) == 0, report.OK())
+			assert.ElementsMatch(t, c.unbalanced, report.UnbalancedTransactions)
+
+			mismatched := make([]models.BalanceKey, 0, len(report.MismatchedAccounts))
+			for _, check := range report.MismatchedAccounts {
+				assert.False(t, check.Balanced())
+				assert.True(t, check.Difference.Equal(check.StoredBalance.Sub(check.PostedBalance)))
+				mismatched = append(mismatched, models.BalanceKey{UserID: check.UserID, Currency: check.Currency})
+			}
+			assert.ElementsMatch(t, c.mismatched, mismatched)
+		})
+	}
+}
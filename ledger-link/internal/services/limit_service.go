@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/shopspring/decimal"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+var limitRejections = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_spending_limit_rejections_total",
+		Help: "Total number of transactions rejected by spending limits by code",
+	},
+	[]string{"code"},
+)
+
+// LimitService enforces per-transaction caps and daily, weekly and monthly
+// amount and count caps on debits and transfers. Limits are set per role and
+// overridden per user by admins. Usage counts the user's completed and
+// in-review transactions of the same type and currency in the current
+// window. Checked in the posting's unit of work, the user's row is locked
+// until it commits, so two concurrent requests on any replica cannot both
+// spend the last of an allowance.
+type LimitService struct {
+	repo     models.LimitRepository
+	users    models.UserRepository
+	uow      models.UnitOfWork
+	auditSvc models.AuditService
+	logger   *logger.Logger
+}
+
+func NewLimitService(
+	repo models.LimitRepository,
+	users models.UserRepository,
+	uow models.UnitOfWork,
+	auditSvc models.AuditService,
+	logger *logger.Logger,
+) *LimitService {
+	return &LimitService{
+		repo:     repo,
+		users:    users,
+		uow:      uow,
+		auditSvc: auditSvc,
+		logger:   logger,
+	}
+}
+
+func (s *LimitService) Check(ctx context.Context, userID uint, txType models.TransactionType, currency string, amount decimal.Decimal) error {
+	currency = models.NormalizeCurrency(currency)
+	user, err := s.users.GetByIDForUpdate(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	limit, err := s.repo.FindEffective(ctx, userID, user.Role, txType, currency)
+	if err == models.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := limit.CheckAmount(amount); err != nil {
+		return s.reject(userID, err)
+	}
+
+	now := time.Now()
+	for _, window := range models.LimitWindows {
+		if amountCap, countCap := limit.Caps(window); !amountCap.Valid && countCap == nil {
+			continue
+		}
+		start, end := models.LimitWindowBounds(window, now)
+		usage, err := s.repo.SumSpending(ctx, userID, txType, currency, start)
+		if err != nil {
+			return err
+		}
+		if err := limit.CheckWindow(window, usage, amount, end); err != nil {
+			return s.reject(userID, err)
+		}
+	}
+	return nil
+}
+
+func (s *LimitService) reject(userID uint, err error) error {
+	if limitErr, ok := err.(*models.LimitExceededError); ok {
+		limitRejections.WithLabelValues(limitErr.Code).Inc()
+		s.logger.Info("transaction rejected by spending limit", "user_id", userID, "code", limitErr.Code)
+	}
+	return err
+}
+
+// SetLimit creates the limit for its scope, or replaces the caps of the one
+// already there.
+func (s *LimitService) SetLimit(ctx context.Context, limit *models.SpendingLimit) (*models.SpendingLimit, error) {
+	user, ok := auth.GetUserFromContext(ctx)
+	if !ok {
+		return nil, models.ErrUnauthorized
+	}
+	limit.Currency = models.NormalizeCurrency(limit.Currency)
+	if err := limit.Validate(); err != nil {
+		return nil, err
+	}
+	if limit.UserID != 0 {
+		if _, err := s.users.GetByID(ctx, limit.UserID); err != nil {
+			return nil, fmt.Errorf("failed to get user: %w", err)
+		}
+	}
+
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		action := models.ActionCreate
+		existing, err := s.repo.GetByScope(ctx, limit.Role, limit.UserID, limit.TransactionType, limit.Currency)
+		switch {
+		case err == nil:
+			action = models.ActionUpdate
+			limit.ID = existing.ID
+			limit.CreatedAt = existing.CreatedAt
+		case err != models.ErrNotFound:
+			return err
+		}
+		limit.CreatedBy = user.ID
+
+		if err := s.repo.Save(ctx, limit); err != nil {
+			return err
+		}
+		return s.auditSvc.LogAction(ctx, models.EntityTypeLimit, limit.ID, action, describeLimit(limit))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return limit, nil
+}
+
+func (s *LimitService) ListLimits(ctx context.Context, role string, userID uint) ([]models.SpendingLimit, error) {
+	return s.repo.List(ctx, role, userID)
+}
+
+func (s *LimitService) DeleteLimit(ctx context.Context, id uint) error {
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		limit, err := s.repo.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.Delete(ctx, id); err != nil {
+			return err
+		}
+		return s.auditSvc.LogAction(ctx, models.EntityTypeLimit, id, models.ActionDelete, "Removed "+describeLimit(limit))
+	})
+}
+
+func describeLimit(limit *models.SpendingLimit) string {
+	scope := fmt.Sprintf("role %s", limit.Role)
+	if limit.UserID != 0 {
+		scope = fmt.Sprintf("user %d", limit.UserID)
+	}
+	return fmt.Sprintf("%s %s limit for %s: per transaction %s, daily %s in %s, weekly %s in %s, monthly %s in %s",
+		limit.Currency, limit.TransactionType, scope,
+		amountCap(limit.PerTransaction),
+		amountCap(limit.DailyAmount), countCap(limit.DailyCount),
+		amountCap(limit.WeeklyAmount), countCap(limit.WeeklyCount),
+		amountCap(limit.MonthlyAmount), countCap(limit.MonthlyCount))
+}
+
+func amountCap(amount decimal.NullDecimal) string {
+	if !amount.Valid {
+		return "unlimited"
+	}
+	return amount.Decimal.String()
+}
+
+func countCap(count *int) string {
+	if count == nil {
+		return "unlimited transactions"
+	}
+	return fmt.Sprintf("%d transactions", *count)
+}
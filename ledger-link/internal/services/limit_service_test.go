@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+)
+
+func limitError(t *testing.T, err error) *models.LimitExceededError {
+	t.Helper()
+	var limitErr *models.LimitExceededError
+	require.True(t, errors.As(err, &limitErr), "expected a limit rejection, got %v", err)
+	return limitErr
+}
+
+func TestLimitsCapTransfersPerRoleAndUser(t *testing.T) {
+	ledger := newTestLedger(t)
+	admin := asUser(2, models.RoleAdmin)
+	ctx := context.Background()
+	two := 2
+
+	_, err := ledger.limitSvc.SetLimit(admin, &models.SpendingLimit{
+		Role:            models.RoleUser,
+		TransactionType: models.TypeTransfer,
+		Currency:        "usd",
+		PerTransaction:  decimal.NewNullDecimal(decimal.NewFromInt(40)),
+		DailyAmount:     decimal.NewNullDecimal(decimal.NewFromInt(50)),
+		DailyCount:      &two,
+	})
+	require.NoError(t, err)
+
+	limitErr := limitError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(45), "USD", ""))
+	assert.Equal(t, "per_transaction_limit", limitErr.Code)
+	assert.True(t, limitErr.RemainingAmount.Equal(decimal.NewFromInt(40)))
+	assert.Zero(t, ledger.count(t, &models.Transaction{}), "rejected transfer recorded")
+
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(30), "USD", ""))
+	limitErr = limitError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(25), "USD", ""))
+	assert.Equal(t, "daily_amount_limit", limitErr.Code)
+	assert.True(t, limitErr.RemainingAmount.Equal(decimal.NewFromInt(20)), "remaining %s", limitErr.RemainingAmount)
+	require.NotNil(t, limitErr.ResetsAt)
+
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(5), "USD", ""))
+	limitErr = limitError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(5), "USD", ""))
+	assert.Equal(t, "daily_count_limit", limitErr.Code)
+	assert.Equal(t, 0, *limitErr.RemainingCount)
+
+	// Debits are limited separately from transfers
+	require.NoError(t, ledger.txSvc.Debit(ctx, 1, decimal.NewFromInt(45), "USD", ""))
+
+	// A user's override replaces the role's limit
+	_, err = ledger.limitSvc.SetLimit(admin, &models.SpendingLimit{
+		UserID:          1,
+		TransactionType: models.TypeTransfer,
+		Currency:        "USD",
+		DailyAmount:     decimal.NewNullDecimal(decimal.NewFromInt(1000)),
+	})
+	require.NoError(t, err)
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(15), "USD", ""))
+
+	limits, err := ledger.limitSvc.ListLimits(ctx, "", 1)
+	require.NoError(t, err)
+	require.Len(t, limits, 1)
+	require.NoError(t, ledger.limitSvc.DeleteLimit(admin, limits[0].ID))
+	assert.ErrorIs(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(1), "USD", ""), models.ErrLimitExceeded)
+
+	_, err = ledger.limitSvc.SetLimit(admin, &models.SpendingLimit{Role: models.RoleUser, UserID: 1, TransactionType: models.TypeTransfer, Currency: "USD"})
+	assert.ErrorIs(t, err, models.ErrInvalidLimit)
+}
+
+func TestLimitsCountTransactionsHeldForReview(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+	ledger.useRiskRules(t, RiskRule{
+		Name:          "new-account-large-transfer",
+		Kind:          RuleNewAccountLargeAmount,
+		Action:        models.DecisionReview,
+		MinAmount:     decimal.NewFromInt(50),
+		MaxAccountAge: "168h",
+	})
+	_, err := ledger.limitSvc.SetLimit(asUser(2, models.RoleAdmin), &models.SpendingLimit{
+		Role:            models.RoleUser,
+		TransactionType: models.TypeTransfer,
+		Currency:        "USD",
+		DailyAmount:     decimal.NewNullDecimal(decimal.NewFromInt(80)),
+	})
+	require.NoError(t, err)
+
+	riskErr := riskError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(60), "USD", ""))
+	limitErr := limitError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(30), "USD", ""))
+	assert.True(t, limitErr.RemainingAmount.Equal(decimal.NewFromInt(20)), "remaining %s", limitErr.RemainingAmount)
+
+	// Rejecting the review frees its share of the allowance
+	reviews, err := ledger.riskSvc.ListReviews(ctx, models.RiskReviewPending)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	require.Equal(t, riskErr.TransactionID, reviews[0].TransactionID)
+	_, err = ledger.txSvc.RejectReview(asUser(2, models.RoleAdmin), reviews[0].ID, "")
+	require.NoError(t, err)
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(30), "USD", ""))
+}
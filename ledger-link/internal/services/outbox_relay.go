@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+var outboxPublished = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "ledger_outbox_events_published_total",
+		Help: "Total number of outbox events published to the event sink",
+	},
+)
+
+const (
+	DefaultOutboxInterval  = time.Second
+	DefaultOutboxRetention = 7 * 24 * time.Hour
+
+	outboxBatchSize  = 500
+	outboxLeaseName  = "outbox-relay"
+	outboxLeaseTicks = 10
+)
+
+// OutboxRelay publishes the events written to the outbox to an event sink.
+// Only the replica holding the relay lease publishes, one batch at a time in
+// the order the events were written, and an event is marked published only
+// after the sink has accepted it. A failed batch is published again on the
+// next tick, so delivery is at least once and the events of an account keep
+// their order. Published events are kept for the retention period and then
+// deleted.
+type OutboxRelay struct {
+	repo      models.OutboxRepository
+	sink      models.EventSink
+	lease     models.Lease
+	logger    *logger.Logger
+	interval  time.Duration
+	retention time.Duration
+	holder    string
+	cancel    context.CancelFunc
+	stopChan  chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewOutboxRelay(
+	repo models.OutboxRepository,
+	sink models.EventSink,
+	lease models.Lease,
+	logger *logger.Logger,
+	interval time.Duration,
+	retention time.Duration,
+) *OutboxRelay {
+	if interval <= 0 {
+		interval = DefaultOutboxInterval
+	}
+	if retention <= 0 {
+		retention = DefaultOutboxRetention
+	}
+	hostname, _ := os.Hostname()
+	return &OutboxRelay{
+		repo:      repo,
+		sink:      sink,
+		lease:     lease,
+		logger:    logger,
+		interval:  interval,
+		retention: retention,
+		holder:    fmt.Sprintf("%s-%s", hostname, uuid.NewString()),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// PublishPending publishes unpublished events until the outbox is drained
+// and returns how many were published. It stops at the first batch the sink
+// rejects.
+func (r *OutboxRelay) PublishPending(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		events, err := r.repo.ListUnpublished(ctx, outboxBatchSize)
+		if err != nil {
+			return published, err
+		}
+		if len(events) == 0 {
+			return published, nil
+		}
+
+		if err := r.sink.Publish(ctx, events); err != nil {
+			return published, fmt.Errorf("failed to publish outbox events: %w", err)
+		}
+
+		ids := make([]uint, len(events))
+		for i, event := range events {
+			ids[i] = event.ID
+		}
+		if err := r.repo.MarkPublished(ctx, ids, time.Now()); err != nil {
+			return published, err
+		}
+		published += len(events)
+		outboxPublished.Add(float64(len(events)))
+
+		if len(events) < outboxBatchSize {
+			return published, nil
+		}
+	}
+}
+
+// Prune deletes events published longer ago than the retention period.
+func (r *OutboxRelay) Prune(ctx context.Context) (int64, error) {
+	return r.repo.DeletePublishedBefore(ctx, time.Now().Add(-r.retention))
+}
+
+func (r *OutboxRelay) Start(ctx context.Context) error {
+	r.logger.Info("starting outbox relay", "interval", r.interval, "holder", r.holder)
+
+	// A sink waiting on its consumers gives up when the relay stops
+	ctx, r.cancel = context.WithCancel(ctx)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stopChan:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.tick(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (r *OutboxRelay) tick(ctx context.Context) {
+	ok, err := r.lease.Acquire(ctx, outboxLeaseName, r.holder, outboxLeaseTicks*r.interval)
+	if err != nil {
+		r.logger.Error("failed to acquire outbox relay lease", "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if n, err := r.PublishPending(ctx); err != nil {
+		r.logger.Error("failed to relay outbox events", "error", err, "published", n)
+	} else if n > 0 {
+		r.logger.Debug("relayed outbox events", "count", n)
+	}
+
+	if n, err := r.Prune(ctx); err != nil {
+		r.logger.Error("failed to prune outbox", "error", err)
+	} else if n > 0 {
+		r.logger.Info("pruned published outbox events", "count", n)
+	}
+}
+
+func (r *OutboxRelay) Stop() {
+	r.logger.Info("stopping outbox relay")
+	close(r.stopChan)
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+
+	if err := r.lease.Release(context.Background(), outboxLeaseName, r.holder); err != nil {
+		r.logger.Error("failed to release outbox relay lease", "error", err)
+	}
+	if err := r.sink.Close(); err != nil {
+		r.logger.Error("failed to close event sink", "error", err)
+	}
+}
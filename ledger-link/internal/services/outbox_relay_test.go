@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/events"
+	"ledger-link/pkg/logger"
+)
+
+// flakySink fails the first publish and hands the rest to a channel sink.
+type flakySink struct {
+	*events.ChannelSink
+	failed bool
+}
+
+func (s *flakySink) Publish(ctx context.Context, batch []models.OutboxEvent) error {
+	if !s.failed {
+		s.failed = true
+		return errors.New("sink unavailable")
+	}
+	return s.ChannelSink.Publish(ctx, batch)
+}
+
+func TestOutboxRelaysCommittedEventsInOrder(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+
+	// A transfer that rolls back leaves no events behind
+	ledger.failOn(t, "update", "balances", 2)
+	require.Error(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(99), "USD", ""))
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(30), "USD", ""))
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(20), "USD", ""))
+
+	sink := &flakySink{ChannelSink: events.NewChannelSink(100)}
+	relay := NewOutboxRelay(repositories.NewOutboxRepository(ledger.db), sink, repositories.NewLeaseRepository(ledger.db), logger.New("error"), time.Second, time.Hour)
+
+	_, err := relay.PublishPending(ctx)
+	require.Error(t, err)
+	var unpublished int64
+	require.NoError(t, ledger.db.Model(&models.OutboxEvent{}).Where("published_at IS NULL").Count(&unpublished).Error)
+	assert.Equal(t, ledger.count(t, &models.OutboxEvent{}), unpublished, "failed batch marked published")
+
+	n, err := relay.PublishPending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int(unpublished), n)
+
+	var lastID uint
+	var sender []string
+	for i := 0; i < n; i++ {
+		event := <-sink.Events()
+		assert.Greater(t, event.ID, lastID, "events out of order")
+		lastID = event.ID
+
+		if event.Type == models.EventBalanceUpdated && event.AccountID == 1 {
+			var balance models.BalanceData
+			require.NoError(t, json.Unmarshal(event.Payload, &balance))
+			require.NotNil(t, balance.TransactionID)
+			sender = append(sender, balance.Amount.String())
+		}
+	}
+	assert.Equal(t, []string{"70", "50"}, sender)
+
+	n, err = relay.PublishPending(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "published events relayed again")
+
+	pruned, err := NewOutboxRelay(repositories.NewOutboxRepository(ledger.db), sink, repositories.NewLeaseRepository(ledger.db), logger.New("error"), time.Second, time.Nanosecond).Prune(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, unpublished, pruned)
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"ledger-link/internal/models"
+)
+
+// StaticRateProvider serves mid rates from a fixed table keyed "FROM/TO".
+// A missing pair is derived from its inverse when that one is known.
+type StaticRateProvider struct {
+	rates map[string]decimal.Decimal
+}
+
+func NewStaticRateProvider(rates map[string]decimal.Decimal) *StaticRateProvider {
+	p := &StaticRateProvider{rates: make(map[string]decimal.Decimal, len(rates))}
+	for pair, rate := range rates {
+		p.rates[strings.ToUpper(pair)] = rate
+	}
+	return p
+}
+
+// LoadRateFile reads a JSON object of pair to rate, for example
+// {"USD/EUR": "0.92", "USD/TRY": "32.15"}.
+func LoadRateFile(path string) (*StaticRateProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate file: %w", err)
+	}
+
+	var rates map[string]decimal.Decimal
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return nil, fmt.Errorf("failed to parse rate file: %w", err)
+	}
+
+	for pair, rate := range rates {
+		parts := strings.Split(pair, "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid currency pair %q", pair)
+		}
+		for _, code := range parts {
+			if _, err := models.LookupCurrency(code); err != nil {
+				return nil, fmt.Errorf("invalid currency pair %q: %w", pair, err)
+			}
+		}
+		if !rate.IsPositive() {
+			return nil, fmt.Errorf("rate for %s must be positive", pair)
+		}
+	}
+
+	return NewStaticRateProvider(rates), nil
+}
+
+func (p *StaticRateProvider) Rate(ctx context.Context, from, to string) (decimal.Decimal, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == to {
+		return decimal.NewFromInt(1), nil
+	}
+
+	if rate, ok := p.rates[from+"/"+to]; ok {
+		return rate, nil
+	}
+	if inverse, ok := p.rates[to+"/"+from]; ok {
+		return decimal.NewFromInt(1).DivRound(inverse, 10), nil
+	}
+	return decimal.Zero, fmt.Errorf("%w: %s/%s", models.ErrRateUnavailable, from, to)
+}
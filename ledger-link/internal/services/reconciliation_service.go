@@ -0,0 +1,565 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/shopspring/decimal"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+var (
+	reconciliationDiscrepancies = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ledger_reconciliation_discrepancies",
+			Help: "Number of balances found drifting by the last reconciliation run",
+		},
+	)
+
+	reconciliationRepairs = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ledger_reconciliation_repairs_total",
+			Help: "Total number of discrepancy repairs by status",
+		},
+		[]string{"status"},
+	)
+)
+
+const (
+	DefaultReconciliationInterval = 24 * time.Hour
+
+	reconciliationLeaseName = "reconciliation"
+	// reconciliationCheckInterval is how often replicas check whether a
+	// scheduled run is due
+	reconciliationCheckInterval = time.Hour
+	reconciliationChunkSize     = 500
+)
+
+// ReconciliationService recomputes every balance from the completed
+// transactions behind it and reports the balances whose stored amount,
+// postings or history disagree. Repairs are approved one discrepancy at a
+// time by an admin and book the unexplained difference as an adjustment, so
+// the ledger accounts for the balance the user was shown.
+type ReconciliationService struct {
+	repo        models.ReconciliationRepository
+	balanceRepo models.BalanceRepository
+	txRepo      models.TransactionRepository
+	journalRepo models.JournalRepository
+	uow         models.UnitOfWork
+	auditSvc    models.AuditService
+	lease       models.Lease
+	logger      *logger.Logger
+	interval    time.Duration
+	holder      string
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+func NewReconciliationService(
+	repo models.ReconciliationRepository,
+	balanceRepo models.BalanceRepository,
+	txRepo models.TransactionRepository,
+	journalRepo models.JournalRepository,
+	uow models.UnitOfWork,
+	auditSvc models.AuditService,
+	lease models.Lease,
+	logger *logger.Logger,
+	interval time.Duration,
+) *ReconciliationService {
+	if interval <= 0 {
+		interval = DefaultReconciliationInterval
+	}
+	hostname, _ := os.Hostname()
+	return &ReconciliationService{
+		repo:        repo,
+		balanceRepo: balanceRepo,
+		txRepo:      txRepo,
+		journalRepo: journalRepo,
+		uow:         uow,
+		auditSvc:    auditSvc,
+		lease:       lease,
+		logger:      logger,
+		interval:    interval,
+		holder:      fmt.Sprintf("%s-%s", hostname, uuid.NewString()),
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Run reconciles every balance and stores the report.
+func (s *ReconciliationService) Run(ctx context.Context, trigger string) (*models.ReconciliationRun, error) {
+	run := &models.ReconciliationRun{
+		Trigger:       trigger,
+		StartedAt:     time.Now(),
+		Discrepancies: []models.Discrepancy{},
+	}
+
+	// One unit of work reads all source records consistently, so transactions
+	// posted during the run do not show up as drift
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		discrepancies, checked, err := s.check(ctx, 0)
+		if err != nil {
+			return err
+		}
+		run.Discrepancies = append(run.Discrepancies, discrepancies...)
+		run.BalancesChecked = checked
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile balances: %w", err)
+	}
+
+	run.FinishedAt = time.Now()
+	if err := s.repo.CreateRun(ctx, run); err != nil {
+		return nil, err
+	}
+
+	reconciliationDiscrepancies.Set(float64(len(run.Discrepancies)))
+	if len(run.Discrepancies) > 0 {
+		s.logger.Error("reconciliation found drifting balances",
+			"run_id", run.ID,
+			"trigger", trigger,
+			"discrepancies", len(run.Discrepancies),
+			"balances_checked", run.BalancesChecked)
+	} else {
+		s.logger.Info("reconciliation found no drift", "run_id", run.ID, "balances_checked", run.BalancesChecked)
+	}
+	return run, nil
+}
+
+func (s *ReconciliationService) GetRun(ctx context.Context, id uint) (*models.ReconciliationRun, error) {
+	return s.repo.GetRun(ctx, id)
+}
+
+func (s *ReconciliationService) ListRuns(ctx context.Context, limit int) ([]models.ReconciliationRun, error) {
+	if limit <= 0 || limit > models.MaxHistoryLimit {
+		limit = models.DefaultHistoryLimit
+	}
+	return s.repo.ListRuns(ctx, limit)
+}
+
+// Repair settles an open discrepancy on behalf of the admin in ctx. The
+// balance is checked again first and must still drift exactly as reported.
+// The difference between the stored and the computed balance is booked as an
+// adjustment, and a gap in the balance history is closed with an entry
+// bringing it up to the stored balance. The stored balance itself is left
+// alone. Offending transactions whose postings are wrong are not changed.
+func (s *ReconciliationService) Repair(ctx context.Context, discrepancyID uint) (*models.Discrepancy, error) {
+	admin, ok := auth.GetUserFromContext(ctx)
+	if !ok || !auth.HasPermission(ctx, models.PermissionReconciliationsManage) {
+		return nil, models.ErrUnauthorized
+	}
+
+	var repaired *models.Discrepancy
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		discrepancy, err := s.repo.GetDiscrepancyForUpdate(ctx, discrepancyID)
+		if err != nil {
+			return err
+		}
+		if discrepancy.Status != models.DiscrepancyOpen {
+			return models.ErrDiscrepancyRepaired
+		}
+		if discrepancy.Difference.IsZero() && !discrepancy.HistoryDrift() {
+			return fmt.Errorf("%w: only the postings of the offending transactions disagree", models.ErrInvalidInput)
+		}
+
+		// Hold the balance still while it is checked again and repaired
+		if _, err := s.balanceRepo.GetByUserIDForUpdate(ctx, discrepancy.UserID, discrepancy.Currency); err != nil && err != models.ErrNotFound {
+			return err
+		}
+		current, _, err := s.check(ctx, discrepancy.UserID)
+		if err != nil {
+			return err
+		}
+		still := false
+		for i := range current {
+			if current[i].Matches(discrepancy) {
+				still = true
+			}
+		}
+		if !still {
+			return models.ErrDiscrepancyChanged
+		}
+
+		if !discrepancy.Difference.IsZero() {
+			adjustment, err := s.bookAdjustment(ctx, discrepancy)
+			if err != nil {
+				return err
+			}
+			discrepancy.RepairTransactionID = &adjustment.ID
+		}
+
+		if discrepancy.HistoryDrift() {
+			entry := &models.BalanceHistory{
+				UserID:        discrepancy.UserID,
+				Currency:      discrepancy.Currency,
+				OldAmount:     discrepancy.HistoryBalance.Decimal,
+				NewAmount:     discrepancy.StoredBalance,
+				CreatedAt:     time.Now(),
+				TransactionID: discrepancy.RepairTransactionID,
+			}
+			if err := s.balanceRepo.CreateBalanceHistory(ctx, entry); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		discrepancy.Status = models.DiscrepancyRepaired
+		discrepancy.RepairedBy = &admin.ID
+		discrepancy.RepairedAt = &now
+		if err := s.repo.UpdateDiscrepancy(ctx, discrepancy); err != nil {
+			return err
+		}
+
+		details := fmt.Sprintf("Repaired drift of %s %s in user %d's balance (stored %s, computed %s)",
+			discrepancy.Difference, discrepancy.Currency, discrepancy.UserID, discrepancy.StoredBalance, discrepancy.ComputedBalance)
+		if err := s.auditSvc.LogAction(ctx, models.EntityTypeDiscrepancy, discrepancy.ID, models.ActionUpdate, details); err != nil {
+			return fmt.Errorf("failed to log discrepancy repair: %w", err)
+		}
+
+		repaired = discrepancy
+		return nil
+	})
+	if err != nil {
+		reconciliationRepairs.WithLabelValues("failure").Inc()
+		return nil, err
+	}
+
+	reconciliationRepairs.WithLabelValues("success").Inc()
+	s.logger.Info("repaired discrepancy",
+		"discrepancy_id", repaired.ID,
+		"user_id", repaired.UserID,
+		"currency", repaired.Currency,
+		"difference", repaired.Difference,
+		"admin_id", admin.ID)
+	return repaired, nil
+}
+
+// bookAdjustment records the drift of the discrepancy as a completed
+// adjustment with its postings, without touching the stored balance.
+func (s *ReconciliationService) bookAdjustment(ctx context.Context, discrepancy *models.Discrepancy) (*models.Transaction, error) {
+	adjustment := &models.Transaction{
+		FromUserID: discrepancy.UserID,
+		ToUserID:   discrepancy.UserID,
+		Amount:     discrepancy.Difference.Abs(),
+		Currency:   discrepancy.Currency,
+		Type:       models.TypeAdjustment,
+		Status:     models.StatusCompleted,
+		Notes:      fmt.Sprintf("Reconciliation repair of discrepancy %d", discrepancy.ID),
+		Debit:      discrepancy.Difference.IsNegative(),
+	}
+	if err := adjustment.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.txRepo.Create(ctx, adjustment); err != nil {
+		return nil, err
+	}
+
+	postings, err := models.BuildPostings(adjustment)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.journalRepo.CreatePostings(ctx, postings); err != nil {
+		return nil, err
+	}
+
+	details := fmt.Sprintf("Adjustment of %s %s books the drift found by discrepancy %d",
+		discrepancy.Difference, discrepancy.Currency, discrepancy.ID)
+	if err := s.auditSvc.LogAction(ctx, models.EntityTypeTransaction, adjustment.ID, models.ActionCreate, details); err != nil {
+		return nil, fmt.Errorf("failed to log adjustment: %w", err)
+	}
+	return adjustment, nil
+}
+
+// balanceFigures are what the records of one balance say it should be.
+type balanceFigures struct {
+	stored    decimal.Decimal
+	computed  decimal.Decimal
+	posted    decimal.Decimal
+	history   decimal.NullDecimal
+	offending map[uint]bool
+}
+
+func (f *balanceFigures) drifting() bool {
+	return !f.stored.Equal(f.computed) ||
+		!f.posted.Equal(f.computed) ||
+		(f.history.Valid && !f.history.Decimal.Equal(f.stored)) ||
+		len(f.offending) > 0
+}
+
+// check reconciles the balances of one user, or of every user when userID
+// is 0, and returns the drifting ones along with how many were checked.
+func (s *ReconciliationService) check(ctx context.Context, userID uint) ([]models.Discrepancy, int, error) {
+	figures := make(map[models.BalanceKey]*balanceFigures)
+	figure := func(key models.BalanceKey) *balanceFigures {
+		f, ok := figures[key]
+		if !ok {
+			f = &balanceFigures{offending: make(map[uint]bool)}
+			figures[key] = f
+		}
+		return f
+	}
+	// Transactions of the user also touch their counterparties' balances,
+	// which are only partly seen and left out
+	outside := func(key models.BalanceKey) bool {
+		return userID != 0 && key.UserID != userID
+	}
+
+	var balances []models.Balance
+	var err error
+	if userID == 0 {
+		balances, err = s.balanceRepo.GetAll(ctx)
+	} else {
+		balances, err = s.balanceRepo.ListByUserID(ctx, userID)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range balances {
+		figure(models.BalanceKey{UserID: balances[i].UserID, Currency: balances[i].Currency}).stored = balances[i].SafeAmount()
+	}
+
+	posted, err := s.repo.SumUserPostings(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	for key, sum := range posted {
+		figure(key).posted = sum
+	}
+
+	history, err := s.repo.LatestHistoryAmounts(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	for key, amount := range history {
+		figure(key).history = decimal.NewNullDecimal(amount)
+	}
+
+	originals := make(map[uint]*models.Transaction)
+	var afterID uint
+	for {
+		transactions, err := s.repo.ListCompletedTransactions(ctx, userID, afterID, reconciliationChunkSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(transactions) == 0 {
+			break
+		}
+
+		ids := make([]uint, 0, len(transactions))
+		for i := range transactions {
+			ids = append(ids, transactions[i].ID)
+		}
+		postings, err := s.repo.ListPostings(ctx, ids)
+		if err != nil {
+			return nil, 0, err
+		}
+		recorded := make(map[uint]models.Postings, len(transactions))
+		for _, p := range postings {
+			recorded[p.TransactionID] = append(recorded[p.TransactionID], p)
+		}
+		if err := s.loadOriginals(ctx, transactions, originals); err != nil {
+			return nil, 0, err
+		}
+
+		for i := range transactions {
+			tx := &transactions[i]
+			expected, err := expectedPostings(tx, originals)
+			if err != nil {
+				s.logger.Warn("cannot rebuild postings of transaction", "transaction_id", tx.ID, "error", err)
+				figure(models.BalanceKey{UserID: tx.ToUserID, Currency: tx.Currency}).offending[tx.ID] = true
+			}
+
+			want, got := expected.UserDeltas(), recorded[tx.ID].UserDeltas()
+			for key, delta := range want {
+				if !outside(key) {
+					figure(key).computed = figure(key).computed.Add(delta)
+				}
+			}
+			if !sameDeltas(want, got) {
+				for _, deltas := range []map[models.BalanceKey]decimal.Decimal{want, got} {
+					for key := range deltas {
+						if !outside(key) {
+							figure(key).offending[tx.ID] = true
+						}
+					}
+				}
+			}
+		}
+
+		if len(transactions) < reconciliationChunkSize {
+			break
+		}
+		afterID = transactions[len(transactions)-1].ID
+	}
+
+	unsettledPostings, err := s.repo.ListUnsettledPostings(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, p := range unsettledPostings {
+		figure(models.BalanceKey{UserID: p.AccountID, Currency: p.Currency}).offending[p.TransactionID] = true
+	}
+
+	unsettledHistory, err := s.repo.ListUnsettledHistory(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, entry := range unsettledHistory {
+		figure(models.BalanceKey{UserID: entry.UserID, Currency: entry.Currency}).offending[*entry.TransactionID] = true
+	}
+
+	discrepancies := []models.Discrepancy{}
+	for key, f := range figures {
+		if outside(key) || !f.drifting() {
+			continue
+		}
+		offending := make([]uint, 0, len(f.offending))
+		for id := range f.offending {
+			offending = append(offending, id)
+		}
+		sort.Slice(offending, func(i, j int) bool { return offending[i] < offending[j] })
+
+		discrepancies = append(discrepancies, models.Discrepancy{
+			UserID:                key.UserID,
+			Currency:              key.Currency,
+			StoredBalance:         f.stored,
+			ComputedBalance:       f.computed,
+			PostedBalance:         f.posted,
+			HistoryBalance:        f.history,
+			Difference:            f.stored.Sub(f.computed),
+			OffendingTransactions: offending,
+			Status:                models.DiscrepancyOpen,
+		})
+	}
+	sort.Slice(discrepancies, func(i, j int) bool {
+		if discrepancies[i].UserID != discrepancies[j].UserID {
+			return discrepancies[i].UserID < discrepancies[j].UserID
+		}
+		return discrepancies[i].Currency < discrepancies[j].Currency
+	})
+
+	return discrepancies, len(balances), nil
+}
+
+// loadOriginals adds the transactions reversed by the given ones to
+// originals.
+func (s *ReconciliationService) loadOriginals(ctx context.Context, transactions []models.Transaction, originals map[uint]*models.Transaction) error {
+	var missing []uint
+	for i := range transactions {
+		if id := transactions[i].ReversalOf; id != nil && originals[*id] == nil {
+			missing = append(missing, *id)
+		}
+	}
+
+	found, err := s.txRepo.GetByIDs(ctx, missing)
+	if err != nil {
+		return err
+	}
+	for i := range found {
+		originals[found[i].ID] = &found[i]
+	}
+	return nil
+}
+
+// expectedPostings rebuilds the postings a completed transaction should
+// have made.
+func expectedPostings(tx *models.Transaction, originals map[uint]*models.Transaction) (models.Postings, error) {
+	if tx.Type != models.TypeReversal {
+		return models.BuildPostings(tx)
+	}
+
+	original := originals[*tx.ReversalOf]
+	if original == nil {
+		return nil, fmt.Errorf("reversed transaction %d: %w", *tx.ReversalOf, models.ErrNotFound)
+	}
+	originalPostings, err := models.BuildPostings(original)
+	if err != nil {
+		return nil, err
+	}
+	return models.BuildReversalPostings(originalPostings, tx)
+}
+
+func sameDeltas(a, b map[models.BalanceKey]decimal.Decimal) bool {
+	for key, delta := range a {
+		if !delta.Equal(b[key]) {
+			return false
+		}
+	}
+	for key, delta := range b {
+		if !delta.Equal(a[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Start checks every hour whether a scheduled run is due until Stop is
+// called. Only the replica holding the reconciliation lease runs it.
+func (s *ReconciliationService) Start(ctx context.Context) error {
+	s.logger.Info("starting scheduled reconciliation", "interval", s.interval, "holder", s.holder)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(reconciliationCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.tick(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *ReconciliationService) tick(ctx context.Context) {
+	ok, err := s.lease.Acquire(ctx, reconciliationLeaseName, s.holder, 2*reconciliationCheckInterval)
+	if err != nil {
+		s.logger.Error("failed to acquire reconciliation lease", "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	runs, err := s.repo.ListRuns(ctx, 1)
+	if err != nil {
+		s.logger.Error("failed to get last reconciliation run", "error", err)
+		return
+	}
+	if len(runs) > 0 && time.Since(runs[0].StartedAt) < s.interval {
+		return
+	}
+
+	if _, err := s.Run(ctx, models.ReconciliationTriggerScheduled); err != nil {
+		s.logger.Error("scheduled reconciliation failed", "error", err)
+	}
+}
+
+func (s *ReconciliationService) Stop() {
+	s.logger.Info("stopping scheduled reconciliation")
+	close(s.stopChan)
+	s.wg.Wait()
+
+	if err := s.lease.Release(context.Background(), reconciliationLeaseName, s.holder); err != nil {
+		s.logger.Error("failed to release reconciliation lease", "error", err)
+	}
+}
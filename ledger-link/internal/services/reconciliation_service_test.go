@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/logger"
+)
+
+func newTestReconciliation(t *testing.T, ledger *testLedger) *ReconciliationService {
+	t.Helper()
+
+	// Give the opening postings of the seeded balances their adjustments
+	for i, amount := range []int64{100, 50} {
+		userID := uint(i + 1)
+		require.NoError(t, ledger.db.Create(&models.Transaction{
+			ID:         1000 + userID,
+			FromUserID: userID,
+			ToUserID:   userID,
+			Amount:     decimal.NewFromInt(amount),
+			Currency:   models.DefaultCurrency,
+			Type:       models.TypeAdjustment,
+			Status:     models.StatusCompleted,
+		}).Error)
+	}
+
+	log := logger.New("error")
+	return NewReconciliationService(
+		repositories.NewReconciliationRepository(ledger.db),
+		repositories.NewBalanceRepository(ledger.db),
+		repositories.NewTransactionRepository(ledger.db),
+		repositories.NewJournalRepository(ledger.db),
+		repositories.NewUnitOfWork(ledger.db),
+		NewAuditService(repositories.NewAuditLogRepository(ledger.db), log),
+		repositories.NewLeaseRepository(ledger.db),
+		log,
+		time.Hour,
+	)
+}
+
+func TestReconciliationRepairsDrift(t *testing.T) {
+	ledger := newTestLedger(t)
+	reconciliation := newTestReconciliation(t, ledger)
+	ctx := context.Background()
+
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", ""))
+
+	run, err := reconciliation.Run(ctx, models.ReconciliationTriggerCLI)
+	require.NoError(t, err)
+	assert.Equal(t, 2, run.BalancesChecked)
+	assert.Empty(t, run.Discrepancies)
+
+	// Lose 5 from bob's balance behind the ledger's back
+	require.NoError(t, ledger.db.Exec("UPDATE balances SET amount = ? WHERE user_id = ?", "55", 2).Error)
+
+	run, err = reconciliation.Run(ctx, models.ReconciliationTriggerCLI)
+	require.NoError(t, err)
+	require.Len(t, run.Discrepancies, 1)
+	discrepancy := run.Discrepancies[0]
+	assert.Equal(t, uint(2), discrepancy.UserID)
+	assert.True(t, discrepancy.StoredBalance.Equal(decimal.NewFromInt(55)))
+	assert.True(t, discrepancy.ComputedBalance.Equal(decimal.NewFromInt(60)))
+	assert.True(t, discrepancy.Difference.Equal(decimal.NewFromInt(-5)))
+	assert.True(t, discrepancy.HistoryDrift())
+
+	_, err = reconciliation.Repair(asUser(2, models.RoleUser), discrepancy.ID)
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+
+	repaired, err := reconciliation.Repair(asUser(1, models.RoleAdmin), discrepancy.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DiscrepancyRepaired, repaired.Status)
+	require.NotNil(t, repaired.RepairTransactionID)
+
+	adjustment := ledger.lastTransaction(t)
+	assert.Equal(t, *repaired.RepairTransactionID, adjustment.ID)
+	assert.Equal(t, models.TypeAdjustment, adjustment.Type)
+	assert.True(t, adjustment.Debit)
+	assert.True(t, adjustment.Amount.Equal(decimal.NewFromInt(5)))
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(55)))
+
+	run, err = reconciliation.Run(ctx, models.ReconciliationTriggerCLI)
+	require.NoError(t, err)
+	assert.Empty(t, run.Discrepancies)
+
+	_, err = reconciliation.Repair(asUser(1, models.RoleAdmin), discrepancy.ID)
+	assert.ErrorIs(t, err, models.ErrDiscrepancyRepaired)
+}
+
+func TestReconciliationRefusesStaleRepair(t *testing.T) {
+	ledger := newTestLedger(t)
+	reconciliation := newTestReconciliation(t, ledger)
+	ctx := context.Background()
+
+	require.NoError(t, ledger.db.Exec("UPDATE balances SET amount = ? WHERE user_id = ?", "120", 1).Error)
+	run, err := reconciliation.Run(ctx, models.ReconciliationTriggerScheduled)
+	require.NoError(t, err)
+	require.Len(t, run.Discrepancies, 1)
+
+	// The balance moves on before the repair is approved
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", ""))
+
+	_, err = reconciliation.Repair(asUser(1, models.RoleAdmin), run.Discrepancies[0].ID)
+	assert.ErrorIs(t, err, models.ErrDiscrepancyChanged)
+	assert.Equal(t, models.TypeTransfer, ledger.lastTransaction(t).Type)
+}
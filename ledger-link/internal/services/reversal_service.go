@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+const DefaultReversalWindow = 24 * time.Hour
+
+// ReversalService refunds completed transactions with linked compensating
+// transactions. Admins may reverse any deposit, withdrawal or transfer; a
+// sender may reverse their own transfers within the policy window.
+type ReversalService struct {
+	repo   models.TransactionRepository
+	txSvc  *TransactionService
+	logger *logger.Logger
+	window time.Duration
+}
+
+func NewReversalService(
+	repo models.TransactionRepository,
+	txSvc *TransactionService,
+	logger *logger.Logger,
+	window time.Duration,
+) *ReversalService {
+	return &ReversalService{
+		repo:   repo,
+		txSvc:  txSvc,
+		logger: logger,
+		window: window,
+	}
+}
+
+// Reverse refunds amount of the transaction, or everything left to refund
+// when amount is zero, on behalf of the user in ctx.
+func (s *ReversalService) Reverse(ctx context.Context, transactionID uint, amount decimal.Decimal, notes string) (*models.Transaction, error) {
+	user, ok := auth.GetUserFromContext(ctx)
+	if !ok {
+		return nil, models.ErrUnauthorized
+	}
+
+	original, err := s.repo.GetByID(ctx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, user, original); err != nil {
+		return nil, err
+	}
+
+	if amount.IsZero() {
+		amount = original.RefundableAmount()
+	}
+	reversal, err := models.NewReversal(original, amount, notes)
+	if err != nil {
+		return nil, err
+	}
+	if err := reversal.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid transaction: %w", err)
+	}
+
+	if err := s.txSvc.CreateTransaction(ctx, reversal); err != nil {
+		return nil, err
+	}
+
+	if err := s.txSvc.processor.ReverseTransaction(ctx, reversal); err != nil {
+		transactionErrors.WithLabelValues(string(models.TypeReversal), "processing").Inc()
+		return nil, fmt.Errorf("failed to reverse transaction: %w", err)
+	}
+
+	s.logger.Info("Transaction reversed",
+		"transaction_id", original.ID,
+		"reversal_id", reversal.ID,
+		"reversed_by", user.ID,
+		"amount", amount,
+		"currency", original.Currency)
+
+	transactionCounter.WithLabelValues(string(models.TypeReversal), "success").Inc()
+	return reversal, nil
+}
+
+// authorize applies the reversal policy: holders of
+// PermissionTransactionsReverse may reverse anything, senders only their own
+// transfers within the window. Other users' transactions are reported as
+// missing.
+func (s *ReversalService) authorize(ctx context.Context, user *models.User, original *models.Transaction) error {
+	if auth.HasPermission(ctx, models.PermissionTransactionsReverse) {
+		return nil
+	}
+	if original.FromUserID != user.ID && original.ToUserID != user.ID {
+		return models.ErrNotFound
+	}
+	if original.Type != models.TypeTransfer || original.FromUserID != user.ID {
+		return models.ErrForbidden
+	}
+	if s.window <= 0 || time.Since(original.CreatedAt) > s.window {
+		return models.ErrReversalWindowClosed
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+func newTestReversals(ledger *testLedger) *ReversalService {
+	return NewReversalService(repositories.NewTransactionRepository(ledger.db), ledger.txSvc, logger.New("error"), time.Hour)
+}
+
+func asUser(id uint, role string) context.Context {
+	return auth.SetUserInContext(context.Background(), &models.User{ID: id, Role: role})
+}
+
+func (l *testLedger) lastTransaction(t *testing.T) *models.Transaction {
+	t.Helper()
+	var tx models.Transaction
+	require.NoError(t, l.db.Order("id DESC").First(&tx).Error)
+	return &tx
+}
+
+func TestReversalRefundsUpToOriginalAmount(t *testing.T) {
+	ledger := newTestLedger(t)
+	reversals := newTestReversals(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	require.NoError(t, ledger.txSvc.Transfer(alice, 1, 2, decimal.NewFromInt(30), "USD", "rent"))
+	original := ledger.lastTransaction(t)
+
+	partial, err := reversals.Reverse(alice, original.ID, decimal.NewFromInt(10), "overpaid")
+	require.NoError(t, err)
+	assert.Equal(t, original.ID, *partial.ReversalOf)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(80)))
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(70)))
+
+	_, err = reversals.Reverse(alice, original.ID, decimal.NewFromInt(25), "")
+	assert.ErrorIs(t, err, models.ErrRefundExceedsOriginal)
+
+	// A zero amount refunds the rest
+	rest, err := reversals.Reverse(alice, original.ID, decimal.Zero, "")
+	require.NoError(t, err)
+	assert.True(t, rest.Amount.Equal(decimal.NewFromInt(20)))
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)))
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(50)))
+
+	reversed, err := repositories.NewTransactionRepository(ledger.db).GetByID(context.Background(), original.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusCancelled, reversed.Status)
+	assert.True(t, reversed.RefundedAmount.Equal(decimal.NewFromInt(30)))
+	assert.Len(t, reversed.Reversals, 2)
+
+	_, err = reversals.Reverse(alice, original.ID, decimal.NewFromInt(1), "")
+	assert.ErrorIs(t, err, models.ErrNotReversible)
+
+	logs, err := ledger.txSvc.auditSvc.GetEntityAuditLog(context.Background(), models.EntityTypeTransaction, original.ID)
+	require.NoError(t, err)
+	var reverseEntries int
+	for _, log := range logs {
+		if log.Action == models.ActionReverse {
+			reverseEntries++
+		}
+	}
+	assert.Equal(t, 2, reverseEntries)
+
+	report, err := ledger.journalSvc.CheckInvariants(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "journal invariants violated: %+v", report)
+}
+
+func TestReversalPolicy(t *testing.T) {
+	ledger := newTestLedger(t)
+	reversals := newTestReversals(ledger)
+	alice, bob := asUser(1, models.RoleUser), asUser(2, models.RoleUser)
+	admin := asUser(1, models.RoleAdmin)
+
+	require.NoError(t, ledger.txSvc.Transfer(alice, 1, 2, decimal.NewFromInt(30), "USD", ""))
+	transfer := ledger.lastTransaction(t)
+
+	_, err := reversals.Reverse(bob, transfer.ID, decimal.Zero, "")
+	assert.ErrorIs(t, err, models.ErrForbidden, "recipients cannot pull money back")
+
+	require.NoError(t, ledger.db.Model(transfer).Update("created_at", time.Now().Add(-2*time.Hour)).Error)
+	_, err = reversals.Reverse(alice, transfer.ID, decimal.Zero, "")
+	assert.ErrorIs(t, err, models.ErrReversalWindowClosed)
+
+	_, err = reversals.Reverse(admin, transfer.ID, decimal.Zero, "chargeback")
+	require.NoError(t, err)
+
+	// Only admins reverse deposits and withdrawals
+	require.NoError(t, ledger.txSvc.Debit(alice, 1, decimal.NewFromInt(5), "USD", "atm"))
+	withdrawal := ledger.lastTransaction(t)
+	_, err = reversals.Reverse(alice, withdrawal.ID, decimal.Zero, "")
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = reversals.Reverse(admin, withdrawal.ID, decimal.Zero, "")
+	require.NoError(t, err)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)))
+
+	report, err := ledger.journalSvc.CheckInvariants(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "journal invariants violated: %+v", report)
+}
+
+func TestReversalRollsBackWhenRecipientCannotPay(t *testing.T) {
+	ledger := newTestLedger(t)
+	reversals := newTestReversals(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	require.NoError(t, ledger.txSvc.Transfer(alice, 1, 2, decimal.NewFromInt(30), "USD", ""))
+	original := ledger.lastTransaction(t)
+	require.NoError(t, ledger.txSvc.Debit(alice, 2, decimal.NewFromInt(70), "USD", "spent"))
+
+	_, err := reversals.Reverse(alice, original.ID, decimal.Zero, "")
+	require.Error(t, err)
+
+	assert.Equal(t, models.StatusFailed, ledger.lastStatus(t))
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(70)))
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(10)))
+
+	var stored models.Transaction
+	require.NoError(t, ledger.db.First(&stored, original.ID).Error)
+	assert.Equal(t, models.StatusCompleted, stored.Status)
+	assert.True(t, stored.RefundedAmount.IsZero())
+}
+
+func TestReversalUnwindsConversionInFull(t *testing.T) {
+	ledger := newTestLedger(t)
+	reversals := newTestReversals(ledger)
+	fx := newTestFX(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	quote, err := fx.CreateQuote(alice, 1, "USD", "EUR", decimal.NewFromInt(10))
+	require.NoError(t, err)
+	original, err := fx.ExecuteQuote(alice, 1, quote.ID, 2, "")
+	require.NoError(t, err)
+
+	_, err = reversals.Reverse(alice, original.ID, decimal.NewFromInt(5), "")
+	assert.ErrorIs(t, err, models.ErrNotReversible)
+
+	reversal, err := reversals.Reverse(alice, original.ID, decimal.Zero, "")
+	require.NoError(t, err)
+	assert.Equal(t, "EUR", reversal.Currency)
+	assert.Equal(t, "USD", reversal.ToCurrency)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)))
+	assert.True(t, ledger.balanceIn(t, 2, "EUR").IsZero())
+
+	report, err := ledger.journalSvc.CheckInvariants(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "journal invariants violated: %+v", report)
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+// RevocationList is the list of revoked access token IDs checked on every
+// request. Revocations are written to the durable fallback store and to the
+// fast primary store; checks read the primary and only fall back when it
+// cannot answer.
+type RevocationList struct {
+	primary  models.RevocationStore
+	fallback models.RevocationStore
+	logger   *logger.Logger
+}
+
+func NewRevocationList(primary, fallback models.RevocationStore, logger *logger.Logger) *RevocationList {
+	return &RevocationList{
+		primary:  primary,
+		fallback: fallback,
+		logger:   logger,
+	}
+}
+
+func (l *RevocationList) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := l.fallback.Revoke(ctx, jti, expiresAt); err != nil {
+		return err
+	}
+	if err := l.primary.Revoke(ctx, jti, expiresAt); err != nil {
+		l.logger.Error("failed to revoke token in primary store", "error", err, "jti", jti)
+	}
+	return nil
+}
+
+func (l *RevocationList) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	revoked, err := l.primary.IsRevoked(ctx, jti)
+	if err == nil {
+		return revoked, nil
+	}
+	l.logger.Error("failed to check primary revocation store", "error", err, "jti", jti)
+	return l.fallback.IsRevoked(ctx, jti)
+}
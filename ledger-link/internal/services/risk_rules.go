@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"ledger-link/internal/models"
+)
+
+// Kinds of risk rule.
+const (
+	// RuleNewAccountLargeAmount matches amounts sent from accounts younger
+	// than max_account_age.
+	RuleNewAccountLargeAmount = "new_account_large_amount"
+	// RuleFanOut matches a transfer that makes its sender pay more than
+	// max_recipients different users within window.
+	RuleFanOut = "fan_out"
+	// RuleRoundAmountBurst matches a round amount, a multiple of round_to,
+	// that makes more than max_count of them within window.
+	RuleRoundAmountBurst = "round_amount_burst"
+)
+
+// RiskRule is one rule of the rule file. Rules apply to amounts of at least
+// min_amount in the transaction types and currency they name, or in every
+// debit and transfer when left out. Durations are Go durations such as "72h"
+// or "15m".
+type RiskRule struct {
+	Name             string                   `json:"name"`
+	Kind             string                   `json:"kind"`
+	Action           models.RiskDecision      `json:"action"`
+	TransactionTypes []models.TransactionType `json:"transaction_types,omitempty"`
+	Currency         string                   `json:"currency,omitempty"`
+	MinAmount        decimal.Decimal          `json:"min_amount"`
+	MaxAccountAge    string                   `json:"max_account_age,omitempty"`
+	Window           string                   `json:"window,omitempty"`
+	MaxRecipients    int                      `json:"max_recipients,omitempty"`
+	RoundTo          decimal.Decimal          `json:"round_to"`
+	MaxCount         int                      `json:"max_count,omitempty"`
+}
+
+// riskRule is a checked RiskRule with its durations parsed.
+type riskRule struct {
+	RiskRule
+	maxAccountAge time.Duration
+	window        time.Duration
+}
+
+// RuleEngine is the RiskEvaluator behind the rule file. A transaction is
+// denied when a deny rule matches, held for review when a review rule
+// matches, and allowed otherwise. Every matching rule gives a reason.
+type RuleEngine struct {
+	rules []riskRule
+}
+
+// LoadRiskRules reads a JSON rule file, for example
+// {"rules": [{"name": "new-account-large-transfer", "kind": "new_account_large_amount",
+// "action": "review", "max_account_age": "168h", "min_amount": "1000"}]}.
+func LoadRiskRules(path string) (*RuleEngine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read risk rules: %w", err)
+	}
+
+	var file struct {
+		Rules []RiskRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse risk rules: %w", err)
+	}
+	return NewRuleEngine(file.Rules)
+}
+
+func NewRuleEngine(rules []RiskRule) (*RuleEngine, error) {
+	engine := &RuleEngine{}
+	for _, rule := range rules {
+		checked, err := checkRiskRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		engine.rules = append(engine.rules, checked)
+	}
+	return engine, nil
+}
+
+func checkRiskRule(rule RiskRule) (riskRule, error) {
+	checked := riskRule{RiskRule: rule}
+	invalid := func(reason string) (riskRule, error) {
+		return riskRule{}, fmt.Errorf("%w: rule %q %s", models.ErrInvalidRiskRules, rule.Name, reason)
+	}
+
+	if rule.Name == "" {
+		return invalid("has no name")
+	}
+	if rule.Action != models.DecisionReview && rule.Action != models.DecisionDeny {
+		return invalid("must deny or review")
+	}
+	for _, txType := range rule.TransactionTypes {
+		if txType != models.TypeWithdrawal && txType != models.TypeTransfer {
+			return invalid("can only screen withdrawals and transfers")
+		}
+	}
+	if rule.Currency != "" {
+		if _, err := models.LookupCurrency(rule.Currency); err != nil {
+			return invalid(err.Error())
+		}
+		checked.Currency = models.NormalizeCurrency(rule.Currency)
+	}
+	if rule.MinAmount.IsNegative() {
+		return invalid("has a negative min_amount")
+	}
+
+	var err error
+	if rule.Window != "" {
+		if checked.window, err = time.ParseDuration(rule.Window); err != nil || checked.window <= 0 {
+			return invalid("has an invalid window")
+		}
+	}
+
+	switch rule.Kind {
+	case RuleNewAccountLargeAmount:
+		if checked.maxAccountAge, err = time.ParseDuration(rule.MaxAccountAge); err != nil || checked.maxAccountAge <= 0 {
+			return invalid("needs a max_account_age")
+		}
+	case RuleFanOut:
+		if checked.window == 0 || rule.MaxRecipients <= 0 {
+			return invalid("needs a window and max_recipients")
+		}
+	case RuleRoundAmountBurst:
+		if checked.window == 0 || rule.MaxCount <= 0 || !rule.RoundTo.IsPositive() {
+			return invalid("needs a window, max_count and round_to")
+		}
+	default:
+		return invalid(fmt.Sprintf("has unknown kind %q", rule.Kind))
+	}
+	return checked, nil
+}
+
+// HistoryWindow is the longest window of the rules.
+func (e *RuleEngine) HistoryWindow() time.Duration {
+	var longest time.Duration
+	for _, rule := range e.rules {
+		if rule.window > longest {
+			longest = rule.window
+		}
+	}
+	return longest
+}
+
+func (e *RuleEngine) Evaluate(ctx context.Context, tx *models.Transaction, history models.RiskHistory) (models.RiskAssessment, error) {
+	assessment := models.RiskAssessment{Decision: models.DecisionAllow}
+	now := time.Now()
+	for _, rule := range e.rules {
+		if !rule.applies(tx) {
+			continue
+		}
+		reason, matched := rule.match(tx, history, now)
+		if !matched {
+			continue
+		}
+		assessment.Reasons = append(assessment.Reasons, rule.Name+": "+reason)
+		if rule.Action == models.DecisionDeny || assessment.Decision == models.DecisionAllow {
+			assessment.Decision = rule.Action
+		}
+	}
+	return assessment, nil
+}
+
+func (r *riskRule) applies(tx *models.Transaction) bool {
+	if r.Currency != "" && r.Currency != tx.Currency {
+		return false
+	}
+	if tx.Amount.LessThan(r.MinAmount) {
+		return false
+	}
+	if len(r.TransactionTypes) == 0 {
+		return true
+	}
+	for _, txType := range r.TransactionTypes {
+		if txType == tx.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether tx, on top of the sender's history, matches the
+// rule, and why.
+func (r *riskRule) match(tx *models.Transaction, history models.RiskHistory, now time.Time) (string, bool) {
+	switch r.Kind {
+	case RuleNewAccountLargeAmount:
+		if history.Account == nil {
+			return "", false
+		}
+		age := now.Sub(history.Account.CreatedAt)
+		return fmt.Sprintf("%s %s sent from an account opened %s ago",
+			tx.Amount, tx.Currency, age.Round(time.Minute)), age < r.maxAccountAge
+
+	case RuleFanOut:
+		if tx.Type != models.TypeTransfer {
+			return "", false
+		}
+		recipients := map[uint]bool{tx.ToUserID: true}
+		for _, past := range r.within(history.Recent, now) {
+			if past.Type == models.TypeTransfer {
+				recipients[past.ToUserID] = true
+			}
+		}
+		return fmt.Sprintf("%d recipients within %s", len(recipients), r.window),
+			len(recipients) > r.MaxRecipients
+
+	case RuleRoundAmountBurst:
+		if !r.isRound(tx) {
+			return "", false
+		}
+		count := 1
+		for _, past := range r.within(history.Recent, now) {
+			if r.applies(&past) && r.isRound(&past) {
+				count++
+			}
+		}
+		return fmt.Sprintf("%d round amounts within %s", count, r.window), count > r.MaxCount
+	}
+	return "", false
+}
+
+func (r *riskRule) within(transactions []models.Transaction, now time.Time) []models.Transaction {
+	start := now.Add(-r.window)
+	var recent []models.Transaction
+	for _, tx := range transactions {
+		if !tx.CreatedAt.Before(start) {
+			recent = append(recent, tx)
+		}
+	}
+	return recent
+}
+
+func (r *riskRule) isRound(tx *models.Transaction) bool {
+	return tx.Amount.Mod(r.RoundTo).IsZero()
+}
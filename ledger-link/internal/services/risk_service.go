@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+var riskDecisions = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_risk_decisions_total",
+		Help: "Total number of debits and transfers screened by the risk rules by decision",
+	},
+	[]string{"decision"},
+)
+
+// RiskService screens debits and transfers before they execute. The
+// evaluator is given the sender's account and the debits and transfers they
+// sent within its history window. Transactions it flags wait in review
+// status for an admin other than their sender.
+type RiskService struct {
+	evaluator models.RiskEvaluator
+	repo      models.RiskRepository
+	users     models.UserRepository
+	auditSvc  models.AuditService
+	logger    *logger.Logger
+}
+
+func NewRiskService(
+	evaluator models.RiskEvaluator,
+	repo models.RiskRepository,
+	users models.UserRepository,
+	auditSvc models.AuditService,
+	logger *logger.Logger,
+) *RiskService {
+	return &RiskService{
+		evaluator: evaluator,
+		repo:      repo,
+		users:     users,
+		auditSvc:  auditSvc,
+		logger:    logger,
+	}
+}
+
+func (s *RiskService) Screen(ctx context.Context, tx *models.Transaction) (models.RiskAssessment, error) {
+	account, err := s.users.GetByID(ctx, tx.FromUserID)
+	if err != nil {
+		return models.RiskAssessment{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	history := models.RiskHistory{Account: account}
+	if window := s.evaluator.HistoryWindow(); window > 0 {
+		history.Since = time.Now().Add(-window)
+		if history.Recent, err = s.repo.RecentTransactions(ctx, tx.FromUserID, history.Since); err != nil {
+			return models.RiskAssessment{}, err
+		}
+	}
+
+	assessment, err := s.evaluator.Evaluate(ctx, tx, history)
+	if err != nil {
+		return models.RiskAssessment{}, fmt.Errorf("failed to evaluate risk: %w", err)
+	}
+
+	riskDecisions.WithLabelValues(string(assessment.Decision)).Inc()
+	if assessment.Decision != models.DecisionAllow {
+		s.logger.Info("transaction stopped by risk rules",
+			"user_id", tx.FromUserID,
+			"type", tx.Type,
+			"decision", assessment.Decision,
+			"reasons", assessment.Reasons)
+	}
+	return assessment, nil
+}
+
+// Hold queues tx, already in review status, for an admin.
+func (s *RiskService) Hold(ctx context.Context, tx *models.Transaction, assessment models.RiskAssessment) (*models.RiskReview, error) {
+	review := &models.RiskReview{
+		TransactionID: tx.ID,
+		UserID:        tx.FromUserID,
+		Reasons:       assessment.Reasons,
+		Status:        models.RiskReviewPending,
+	}
+	if err := s.repo.CreateReview(ctx, review); err != nil {
+		return nil, err
+	}
+
+	details := "Held for review: " + strings.Join(assessment.Reasons, "; ")
+	if err := s.auditSvc.LogAction(ctx, models.EntityTypeTransaction, tx.ID, models.ActionUpdate, details); err != nil {
+		return nil, fmt.Errorf("failed to log risk review: %w", err)
+	}
+	return review, nil
+}
+
+// Resolve approves or rejects a pending review on behalf of the calling
+// admin, who may not review their own transactions.
+func (s *RiskService) Resolve(ctx context.Context, reviewID uint, status models.RiskReviewStatus, note string) (*models.RiskReview, error) {
+	admin, ok := auth.GetUserFromContext(ctx)
+	if !ok || !auth.HasPermission(ctx, models.PermissionReviewsDecide) {
+		return nil, models.ErrUnauthorized
+	}
+	if status != models.RiskReviewApproved && status != models.RiskReviewRejected {
+		return nil, fmt.Errorf("%w: unknown review status %q", models.ErrInvalidInput, status)
+	}
+
+	review, err := s.repo.GetReviewForUpdate(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if review.Status != models.RiskReviewPending {
+		return nil, models.ErrReviewResolved
+	}
+	if review.UserID == admin.ID {
+		return nil, fmt.Errorf("%w: admins cannot review their own transactions", models.ErrForbidden)
+	}
+
+	now := time.Now()
+	review.Status = status
+	review.ReviewedBy = &admin.ID
+	review.ReviewedAt = &now
+	review.Note = note
+	if err := s.repo.UpdateReview(ctx, review); err != nil {
+		return nil, err
+	}
+
+	action, details := models.ActionApprove, "Approved after review"
+	if status == models.RiskReviewRejected {
+		action, details = models.ActionReject, "Rejected after review"
+	}
+	if note != "" {
+		details += ": " + note
+	}
+	if err := s.auditSvc.LogAction(ctx, models.EntityTypeTransaction, review.TransactionID, action, details); err != nil {
+		return nil, fmt.Errorf("failed to log risk review: %w", err)
+	}
+	return review, nil
+}
+
+func (s *RiskService) ListReviews(ctx context.Context, status models.RiskReviewStatus) ([]models.RiskReview, error) {
+	return s.repo.ListReviews(ctx, status)
+}
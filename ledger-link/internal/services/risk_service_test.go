@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+)
+
+func riskError(t *testing.T, err error) *models.RiskError {
+	t.Helper()
+	var riskErr *models.RiskError
+	require.True(t, errors.As(err, &riskErr), "expected a risk decision, got %v", err)
+	return riskErr
+}
+
+func (l *testLedger) useRiskRules(t *testing.T, rules ...RiskRule) {
+	t.Helper()
+	engine, err := NewRuleEngine(rules)
+	require.NoError(t, err)
+	l.riskSvc.evaluator = engine
+}
+
+func (l *testLedger) transaction(t *testing.T, id uint) *models.Transaction {
+	t.Helper()
+	var tx models.Transaction
+	require.NoError(t, l.db.First(&tx, id).Error)
+	return &tx
+}
+
+func TestRiskRulesHoldTransfersForReview(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+	ledger.useRiskRules(t, RiskRule{
+		Name:          "new-account-large-transfer",
+		Kind:          RuleNewAccountLargeAmount,
+		Action:        models.DecisionReview,
+		MinAmount:     decimal.NewFromInt(50),
+		MaxAccountAge: "168h",
+	})
+
+	// Below the rule's minimum the transfer runs at once
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", ""))
+
+	riskErr := riskError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(60), "USD", ""))
+	assert.ErrorIs(t, riskErr, models.ErrHeldForReview)
+	require.Len(t, riskErr.Reasons, 1)
+	assert.Equal(t, models.StatusReview, ledger.transaction(t, riskErr.TransactionID).Status)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(90)), "held transfer moved funds")
+
+	reviews, err := ledger.riskSvc.ListReviews(ctx, models.RiskReviewPending)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+
+	// The sender cannot approve their own transfer
+	_, err = ledger.txSvc.ApproveReview(asUser(1, models.RoleAdmin), reviews[0].ID, "")
+	assert.ErrorIs(t, err, models.ErrForbidden)
+
+	tx, err := ledger.txSvc.ApproveReview(asUser(2, models.RoleAdmin), reviews[0].ID, "checked with alice")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusCompleted, tx.Status)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(30)))
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(120)))
+
+	_, err = ledger.txSvc.RejectReview(asUser(2, models.RoleAdmin), reviews[0].ID, "")
+	assert.ErrorIs(t, err, models.ErrReviewResolved)
+
+	// A rejected debit fails without touching the balance
+	riskErr = riskError(t, ledger.txSvc.Debit(ctx, 2, decimal.NewFromInt(100), "USD", ""))
+	reviews, err = ledger.riskSvc.ListReviews(ctx, models.RiskReviewPending)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	tx, err = ledger.txSvc.RejectReview(asUser(1, models.RoleAdmin), reviews[0].ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusFailed, tx.Status)
+	assert.Equal(t, riskErr.TransactionID, tx.ID)
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(120)))
+}
+
+func TestRiskRulesDenyFanOutAndRoundBursts(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+	ledger.useRiskRules(t,
+		RiskRule{
+			Name:          "rapid-fan-out",
+			Kind:          RuleFanOut,
+			Action:        models.DecisionDeny,
+			Window:        "1h",
+			MaxRecipients: 1,
+		},
+		RiskRule{
+			Name:             "round-amount-burst",
+			Kind:             RuleRoundAmountBurst,
+			Action:           models.DecisionReview,
+			TransactionTypes: []models.TransactionType{models.TypeWithdrawal},
+			Window:           "1h",
+			RoundTo:          decimal.NewFromInt(10),
+			MaxCount:         2,
+		},
+	)
+
+	carol := models.User{Username: "carol", Email: "carol@example.com", PasswordHash: "not-a-real-hash"}
+	require.NoError(t, ledger.db.Create(&carol).Error)
+
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(5), "USD", ""))
+	riskErr := riskError(t, ledger.txSvc.Transfer(ctx, 1, carol.ID, decimal.NewFromInt(5), "USD", ""))
+	assert.ErrorIs(t, riskErr, models.ErrRiskDenied)
+	assert.Equal(t, models.StatusFailed, ledger.transaction(t, riskErr.TransactionID).Status)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(95)))
+
+	require.NoError(t, ledger.txSvc.Debit(ctx, 1, decimal.NewFromInt(10), "USD", ""))
+	require.NoError(t, ledger.txSvc.Debit(ctx, 1, decimal.NewFromInt(7), "USD", ""))
+	require.NoError(t, ledger.txSvc.Debit(ctx, 1, decimal.NewFromInt(20), "USD", ""))
+	riskErr = riskError(t, ledger.txSvc.Debit(ctx, 1, decimal.NewFromInt(30), "USD", ""))
+	assert.Equal(t, models.DecisionReview, riskErr.Decision)
+	assert.Equal(t, []string{"round-amount-burst: 3 round amounts within 1h0m0s"}, riskErr.Reasons)
+
+	_, err := NewRuleEngine([]RiskRule{{Name: "bad", Kind: RuleFanOut, Action: models.DecisionReview}})
+	assert.ErrorIs(t, err, models.ErrInvalidRiskRules)
+}
+
+func TestRiskRulesScreenFXCapturesAndSchedules(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+	alice, admin := asUser(1, models.RoleUser), asUser(2, models.RoleAdmin)
+	ledger.useRiskRules(t, RiskRule{
+		Name:          "new-account-large-amount",
+		Kind:          RuleNewAccountLargeAmount,
+		Action:        models.DecisionReview,
+		MinAmount:     decimal.NewFromInt(20),
+		MaxAccountAge: "168h",
+	})
+	approve := func(transactionID uint) {
+		t.Helper()
+		reviews, err := ledger.riskSvc.ListReviews(ctx, models.RiskReviewPending)
+		require.NoError(t, err)
+		require.Len(t, reviews, 1)
+		require.Equal(t, transactionID, reviews[0].TransactionID)
+		_, err = ledger.txSvc.ApproveReview(admin, reviews[0].ID, "")
+		require.NoError(t, err)
+	}
+
+	// A held FX transfer uses up its quote and converts at it once approved
+	fx := newTestFX(ledger)
+	quote, err := fx.CreateQuote(ctx, 1, "USD", "EUR", decimal.NewFromInt(20))
+	require.NoError(t, err)
+	_, err = fx.ExecuteQuote(ctx, 1, quote.ID, 2, "")
+	riskErr := riskError(t, err)
+	assert.ErrorIs(t, riskErr, models.ErrHeldForReview)
+	_, err = fx.ExecuteQuote(ctx, 1, quote.ID, 2, "")
+	assert.ErrorIs(t, err, models.ErrQuoteUsed)
+	approve(riskErr.TransactionID)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(80)))
+	assert.True(t, ledger.balanceIn(t, 2, "EUR").Equal(quote.TargetAmount))
+
+	// A held capture claims its hold and withdraws once approved
+	holds := newTestHolds(ledger)
+	hold, err := holds.Place(alice, 1, decimal.NewFromInt(30), "USD", "", "", 0)
+	require.NoError(t, err)
+	_, err = holds.Capture(alice, hold.ID, decimal.Zero, "")
+	riskErr = riskError(t, err)
+	_, err = holds.Capture(alice, hold.ID, decimal.Zero, "")
+	assert.ErrorIs(t, err, models.ErrHoldNotActive)
+	approve(riskErr.TransactionID)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(50)))
+
+	// A held scheduled transfer moves the schedule on instead of retrying
+	schedules := newTestSchedules(ledger)
+	schedule := &models.Schedule{
+		ToUserID: 2,
+		Amount:   decimal.NewFromInt(25),
+		Currency: "USD",
+		Kind:     models.ScheduleKindOnce,
+		StartAt:  time.Now().Add(-time.Second),
+	}
+	require.NoError(t, schedules.Create(alice, schedule))
+	n, err := schedules.RunDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	current, err := schedules.Get(alice, schedule.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ScheduleStatusCompleted, current.Status)
+	runs, err := schedules.ListRuns(alice, schedule.ID)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, models.ScheduleRunHeld, runs[0].Status)
+	require.NotNil(t, runs[0].TransactionID)
+	assert.Equal(t, models.StatusReview, ledger.transaction(t, *runs[0].TransactionID).Status)
+	approve(*runs[0].TransactionID)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(25)))
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+// rolePermissionsTTL bounds how long a replica keeps serving permissions
+// that were changed on another replica
+const rolePermissionsTTL = 30 * time.Second
+
+// RoleService resolves what each role may do, and lets holders of
+// PermissionRolesManage change role permissions and assign roles to users.
+type RoleService struct {
+	repo     models.RoleRepository
+	users    models.UserRepository
+	uow      models.UnitOfWork
+	auditSvc models.AuditService
+	logger   *logger.Logger
+
+	mu       sync.RWMutex
+	cached   map[string][]string
+	loadedAt time.Time
+}
+
+func NewRoleService(
+	repo models.RoleRepository,
+	users models.UserRepository,
+	uow models.UnitOfWork,
+	auditSvc models.AuditService,
+	logger *logger.Logger,
+) *RoleService {
+	return &RoleService{
+		repo:     repo,
+		users:    users,
+		uow:      uow,
+		auditSvc: auditSvc,
+		logger:   logger,
+	}
+}
+
+// Permissions returns the permissions the role holds. Admins hold every
+// permission; unknown roles hold none.
+func (s *RoleService) Permissions(ctx context.Context, role string) ([]string, error) {
+	if role == models.RoleAdmin {
+		return append([]string(nil), models.Permissions...), nil
+	}
+
+	s.mu.RLock()
+	cached, fresh := s.cached, time.Since(s.loadedAt) < rolePermissionsTTL
+	s.mu.RUnlock()
+	if cached == nil || !fresh {
+		var err error
+		if cached, err = s.load(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return cached[role], nil
+}
+
+func (s *RoleService) load(ctx context.Context) (map[string][]string, error) {
+	roles, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make(map[string][]string, len(models.DefaultRolePermissions))
+	for role, granted := range models.DefaultRolePermissions {
+		permissions[role] = granted
+	}
+	for _, role := range roles {
+		permissions[role.Name] = role.Permissions
+	}
+
+	s.mu.Lock()
+	s.cached, s.loadedAt = permissions, time.Now()
+	s.mu.Unlock()
+	return permissions, nil
+}
+
+// ListRoles returns every role with the permissions it holds.
+func (s *RoleService) ListRoles(ctx context.Context) ([]models.Role, error) {
+	if _, err := s.manager(ctx); err != nil {
+		return nil, err
+	}
+
+	stored, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]models.Role, len(stored))
+	for _, role := range stored {
+		byName[role.Name] = role
+	}
+
+	roles := make([]models.Role, 0, len(models.Roles))
+	for _, name := range models.Roles {
+		role, ok := byName[name]
+		if !ok {
+			role = models.Role{Name: name, Permissions: models.DefaultRolePermissions[name]}
+		}
+		if name == models.RoleAdmin {
+			role.Permissions = models.Permissions
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// SetPermissions replaces the permissions of a role. Only admins may grant
+// or revoke permissions they do not hold themselves.
+func (s *RoleService) SetPermissions(ctx context.Context, name string, permissions []string) (*models.Role, error) {
+	manager, err := s.manager(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions = uniqueStrings(permissions)
+	if err := models.ValidatePermissions(name, permissions); err != nil {
+		return nil, err
+	}
+	for _, permission := range permissions {
+		if !auth.HasPermission(ctx, permission) {
+			return nil, fmt.Errorf("%w: cannot grant %s", models.ErrForbidden, permission)
+		}
+	}
+
+	var role *models.Role
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		role, err = s.repo.GetByName(ctx, name)
+		if errors.Is(err, models.ErrNotFound) {
+			role, err = &models.Role{Name: name, Permissions: models.DefaultRolePermissions[name]}, nil
+		}
+		if err != nil {
+			return err
+		}
+
+		previous := role.Permissions
+		kept := make(map[string]bool, len(permissions))
+		for _, permission := range permissions {
+			kept[permission] = true
+		}
+		for _, permission := range previous {
+			if !kept[permission] && !auth.HasPermission(ctx, permission) {
+				return fmt.Errorf("%w: cannot revoke %s", models.ErrForbidden, permission)
+			}
+		}
+
+		role.Permissions = permissions
+		role.UpdatedBy = manager.ID
+		if err := s.repo.Save(ctx, role); err != nil {
+			return err
+		}
+		details := fmt.Sprintf("Permissions of role %s changed from [%s] to [%s]",
+			role.Name, strings.Join(previous, ", "), strings.Join(permissions, ", "))
+		if err := s.auditSvc.LogAction(ctx, models.EntityTypeRole, role.ID, models.ActionUpdate, details); err != nil {
+			return fmt.Errorf("failed to log role change: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidate()
+	s.logger.Info("role permissions changed", "role", role.Name, "permissions", permissions, "changed_by", manager.ID)
+	return role, nil
+}
+
+// AssignRole gives the user a role, which applies from their next request.
+// Nobody may change their own role, only admins may make or unmake admins,
+// and others may only move users between roles whose permissions they hold,
+// so they can neither grant nor take away a permission they lack.
+func (s *RoleService) AssignRole(ctx context.Context, userID uint, role string) (*models.User, error) {
+	manager, err := s.manager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !models.IsValidRole(role) {
+		return nil, models.ErrInvalidRole
+	}
+	if userID == manager.ID {
+		return nil, fmt.Errorf("%w: cannot change your own role", models.ErrForbidden)
+	}
+
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == role {
+		return user, nil
+	}
+	if manager.Role != models.RoleAdmin && (role == models.RoleAdmin || user.Role == models.RoleAdmin) {
+		return nil, fmt.Errorf("%w: only admins may change admins", models.ErrForbidden)
+	}
+	granted, err := s.Permissions(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+	for _, permission := range granted {
+		if !auth.HasPermission(ctx, permission) {
+			return nil, fmt.Errorf("%w: cannot grant %s", models.ErrForbidden, permission)
+		}
+	}
+	revoked, err := s.Permissions(ctx, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	for _, permission := range revoked {
+		if !auth.HasPermission(ctx, permission) {
+			return nil, fmt.Errorf("%w: cannot revoke %s", models.ErrForbidden, permission)
+		}
+	}
+
+	previous := user.Role
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.users.UpdateRole(ctx, user.ID, role); err != nil {
+			return err
+		}
+		details := fmt.Sprintf("Role changed from %s to %s", previous, role)
+		if err := s.auditSvc.LogAction(ctx, models.EntityTypeUser, user.ID, models.ActionUpdate, details); err != nil {
+			return fmt.Errorf("failed to log role assignment: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = role
+	s.logger.Info("role assigned", "user_id", user.ID, "from", previous, "to", role, "assigned_by", manager.ID)
+	return user, nil
+}
+
+// manager returns the user in ctx if they may manage roles.
+func (s *RoleService) manager(ctx context.Context) (*models.User, error) {
+	user, ok := auth.GetUserFromContext(ctx)
+	if !ok || !auth.HasPermission(ctx, models.PermissionRolesManage) {
+		return nil, models.ErrUnauthorized
+	}
+	return user, nil
+}
+
+func (s *RoleService) invalidate() {
+	s.mu.Lock()
+	s.cached = nil
+	s.mu.Unlock()
+}
+
+func uniqueStrings(values []string) []string {
+	unique := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
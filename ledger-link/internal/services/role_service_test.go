@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+func newTestRoles(ledger *testLedger) *RoleService {
+	log := logger.New("error")
+	return NewRoleService(repositories.NewRoleRepository(ledger.db), repositories.NewUserRepository(ledger.db),
+		repositories.NewUnitOfWork(ledger.db), NewAuditService(repositories.NewAuditLogRepository(ledger.db), log), log)
+}
+
+// asStaff is asUser with the permissions of the role, as AuthMiddleware sets
+// them.
+func asStaff(t *testing.T, roles *RoleService, id uint, role string) context.Context {
+	t.Helper()
+	permissions, err := roles.Permissions(context.Background(), role)
+	require.NoError(t, err)
+	return auth.SetPermissionsInContext(asUser(id, role), permissions)
+}
+
+func TestRolePermissionsAndAssignments(t *testing.T) {
+	ledger := newTestLedger(t)
+	roles := newTestRoles(ledger)
+	admin := asUser(10, models.RoleAdmin)
+	ctx := context.Background()
+
+	permissions, err := roles.Permissions(ctx, models.RoleSupport)
+	require.NoError(t, err)
+	assert.Equal(t, models.DefaultRolePermissions[models.RoleSupport], permissions)
+	permissions, err = roles.Permissions(ctx, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.Permissions, permissions)
+
+	_, err = roles.SetPermissions(asStaff(t, roles, 3, models.RoleSupport), models.RoleSupport, nil)
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+	_, err = roles.SetPermissions(admin, models.RoleSupport, []string{models.PermissionAccountsRead, "accounts:delete"})
+	assert.ErrorIs(t, err, models.ErrInvalidPermission)
+	_, err = roles.SetPermissions(admin, models.RoleAdmin, nil)
+	assert.ErrorIs(t, err, models.ErrInvalidPermission)
+	_, err = roles.SetPermissions(admin, "superuser", nil)
+	assert.ErrorIs(t, err, models.ErrInvalidRole)
+
+	// Support loses statements; the change applies at once
+	support, err := roles.SetPermissions(admin, models.RoleSupport, []string{models.PermissionAccountsRead, models.PermissionAccountsRead})
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.PermissionAccountsRead}, support.Permissions)
+	permissions, err = roles.Permissions(ctx, models.RoleSupport)
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.PermissionAccountsRead}, permissions)
+	trail := ledger.auditTrail(t, models.EntityTypeRole, support.ID)
+	require.Len(t, trail, 1)
+	assert.Contains(t, trail[0].Details, "changed from [accounts:read, statements:read] to [accounts:read]")
+
+	listed, err := roles.ListRoles(admin)
+	require.NoError(t, err)
+	require.Len(t, listed, len(models.Roles))
+	for _, role := range listed {
+		switch role.Name {
+		case models.RoleSupport:
+			assert.Equal(t, []string{models.PermissionAccountsRead}, role.Permissions)
+		case models.RoleAuditor:
+			assert.Equal(t, models.DefaultRolePermissions[models.RoleAuditor], role.Permissions)
+		}
+	}
+
+	user, err := roles.AssignRole(admin, 1, models.RoleOperator)
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleOperator, user.Role)
+	stored, err := repositories.NewUserRepository(ledger.db).GetByID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleOperator, stored.Role)
+	_, err = roles.AssignRole(admin, 1, "superuser")
+	assert.ErrorIs(t, err, models.ErrInvalidRole)
+	_, err = roles.AssignRole(admin, 99, models.RoleSupport)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	// A delegated manager may only hand out what they hold, never admin,
+	// and not to themselves
+	_, err = roles.SetPermissions(admin, models.RoleOperator, append(models.DefaultRolePermissions[models.RoleOperator], models.PermissionRolesManage))
+	require.NoError(t, err)
+	manager := asStaff(t, roles, 1, models.RoleOperator)
+	_, err = roles.AssignRole(manager, 2, models.RoleOperator)
+	require.NoError(t, err)
+	_, err = roles.AssignRole(manager, 2, models.RoleSupport)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = roles.AssignRole(manager, 2, models.RoleAdmin)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = roles.AssignRole(manager, 1, models.RoleUser)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = roles.SetPermissions(manager, models.RoleUser, []string{models.PermissionAuditLogsRead})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+
+	trail = ledger.auditTrail(t, models.EntityTypeUser, 2)
+	require.NotEmpty(t, trail)
+	assert.Equal(t, "Role changed from user to operator", trail[len(trail)-1].Details)
+
+	// Nor take away what they do not hold: moving an auditor to a role the
+	// manager could grant, or stripping the auditor role
+	_, err = roles.AssignRole(admin, 2, models.RoleAuditor)
+	require.NoError(t, err)
+	_, err = roles.AssignRole(manager, 2, models.RoleUser)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = roles.SetPermissions(manager, models.RoleAuditor, nil)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	auditor, err := roles.Permissions(ctx, models.RoleAuditor)
+	require.NoError(t, err)
+	assert.Equal(t, models.DefaultRolePermissions[models.RoleAuditor], auditor)
+}
+
+func TestPermissionsOpenStaffSurfaces(t *testing.T) {
+	ledger := newTestLedger(t)
+	roles := newTestRoles(ledger)
+	holds := newTestHolds(ledger)
+	admin, alice := asUser(10, models.RoleAdmin), asUser(1, models.RoleUser)
+	ctx := context.Background()
+
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", ""))
+	tx, err := ledger.txSvc.GetTransaction(alice, 1)
+	require.NoError(t, err)
+
+	// Other users' transactions are missing unless the role may read any
+	// account
+	_, err = ledger.txSvc.GetTransaction(asUser(3, models.RoleUser), tx.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = ledger.txSvc.GetTransaction(asStaff(t, roles, 3, models.RoleSupport), tx.ID)
+	require.NoError(t, err)
+	_, err = ledger.txSvc.GetTransaction(ctx, tx.ID)
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+
+	// Changing another user's hold takes accounts:write
+	hold, err := holds.Place(alice, 1, decimal.NewFromInt(20), "USD", "", "", 0)
+	require.NoError(t, err)
+	_, err = holds.Void(asStaff(t, roles, 3, models.RoleOperator), hold.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = roles.SetPermissions(admin, models.RoleOperator, append(models.DefaultRolePermissions[models.RoleOperator], models.PermissionAccountsWrite))
+	require.NoError(t, err)
+	_, err = holds.Void(asStaff(t, roles, 3, models.RoleOperator), hold.ID)
+	require.NoError(t, err)
+
+	// Deciding risk reviews takes reviews:decide
+	ledger.useRiskRules(t, RiskRule{
+		Name:          "new-account-large-transfer",
+		Kind:          RuleNewAccountLargeAmount,
+		Action:        models.DecisionReview,
+		MinAmount:     decimal.NewFromInt(50),
+		MaxAccountAge: "168h",
+	})
+	riskErr := riskError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(60), "USD", ""))
+	reviews, err := ledger.riskSvc.ListReviews(ctx, models.RiskReviewPending)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	_, err = ledger.txSvc.ApproveReview(asStaff(t, roles, 3, models.RoleOperator), reviews[0].ID, "")
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+	_, err = roles.SetPermissions(admin, models.RoleOperator, []string{models.PermissionReviewsDecide})
+	require.NoError(t, err)
+	approved, err := ledger.txSvc.ApproveReview(asStaff(t, roles, 3, models.RoleOperator), reviews[0].ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, riskErr.TransactionID, approved.ID)
+	assert.Equal(t, models.StatusCompleted, approved.Status)
+}
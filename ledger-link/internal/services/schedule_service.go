@@ -0,0 +1,437 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+var scheduleRuns = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_schedule_runs_total",
+		Help: "Total number of scheduled transfer runs by outcome",
+	},
+	[]string{"status"},
+)
+
+const (
+	DefaultSchedulerInterval  = 30 * time.Second
+	DefaultScheduleRetryDelay = 5 * time.Minute
+	DefaultScheduleMaxRetries = 3
+
+	scheduleBatchSize   = 100
+	scheduleRunsLimit   = 100
+	schedulerLeaseName  = "scheduler"
+	schedulerLeaseTicks = 3
+)
+
+// errScheduleClaimed means the occurrence was already run, by another
+// replica or an earlier tick, by the time its transfer was posted.
+var errScheduleClaimed = errors.New("schedule occurrence already claimed")
+
+// ScheduleService keeps standing orders and runs them when they fall due.
+// Due transfers go through TransactionService's transfer path, and each run
+// is recorded next to the schedule. Only the replica holding the scheduler
+// lease runs schedules; the occurrence is also claimed under a row lock in
+// the transfer's own unit of work, so it cannot be posted twice.
+type ScheduleService struct {
+	repo       models.ScheduleRepository
+	uow        models.UnitOfWork
+	txSvc      *TransactionService
+	auditSvc   models.AuditService
+	lease      models.Lease
+	logger     *logger.Logger
+	interval   time.Duration
+	retryDelay time.Duration
+	holder     string
+	stopChan   chan struct{}
+	wg         sync.WaitGroup
+}
+
+func NewScheduleService(
+	repo models.ScheduleRepository,
+	uow models.UnitOfWork,
+	txSvc *TransactionService,
+	auditSvc models.AuditService,
+	lease models.Lease,
+	logger *logger.Logger,
+	interval time.Duration,
+	retryDelay time.Duration,
+) *ScheduleService {
+	if interval <= 0 {
+		interval = DefaultSchedulerInterval
+	}
+	if retryDelay <= 0 {
+		retryDelay = DefaultScheduleRetryDelay
+	}
+	hostname, _ := os.Hostname()
+	return &ScheduleService{
+		repo:       repo,
+		uow:        uow,
+		txSvc:      txSvc,
+		auditSvc:   auditSvc,
+		lease:      lease,
+		logger:     logger,
+		interval:   interval,
+		retryDelay: retryDelay,
+		holder:     fmt.Sprintf("%s-%s", hostname, uuid.NewString()),
+		stopChan:   make(chan struct{}),
+	}
+}
+
+// Create stores a schedule for the user in ctx. Without a start time an
+// interval schedule first runs one interval from now and a cron schedule at
+// its next match. The retry policy is the default and retries
+// DefaultScheduleMaxRetries times unless told otherwise.
+func (s *ScheduleService) Create(ctx context.Context, schedule *models.Schedule) error {
+	user, ok := auth.GetUserFromContext(ctx)
+	if !ok {
+		return models.ErrUnauthorized
+	}
+
+	now := time.Now()
+	schedule.UserID = user.ID
+	schedule.Currency = models.NormalizeCurrency(schedule.Currency)
+	if schedule.StartAt.IsZero() {
+		switch schedule.Kind {
+		case models.ScheduleKindInterval:
+			schedule.StartAt = now.Add(time.Duration(schedule.IntervalSeconds) * time.Second)
+		case models.ScheduleKindCron:
+			schedule.StartAt = now
+		}
+	}
+	if schedule.FailurePolicy == "" {
+		schedule.FailurePolicy = models.FailurePolicyRetry
+	}
+	if schedule.FailurePolicy == models.FailurePolicyRetry && schedule.MaxRetries == 0 {
+		schedule.MaxRetries = DefaultScheduleMaxRetries
+	}
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+
+	schedule.NextRunAt = schedule.FirstRun()
+	if schedule.NextRunAt == nil {
+		return fmt.Errorf("%w: no run falls before the end date", models.ErrInvalidSchedule)
+	}
+	// Nobody is present when the transfers run, so the code they need is
+	// asked for now
+	if err := s.txSvc.stepUp(ctx, schedule.Currency, schedule.Amount); err != nil {
+		return err
+	}
+	schedule.Status = models.ScheduleStatusActive
+
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, schedule); err != nil {
+			return err
+		}
+
+		details := fmt.Sprintf("Scheduled %s transfer of %s %s to user %d, first run %s",
+			schedule.Kind, schedule.Amount, schedule.Currency, schedule.ToUserID, schedule.NextRunAt.Format(time.RFC3339))
+		return s.auditSvc.LogAction(ctx, models.EntityTypeSchedule, schedule.ID, models.ActionCreate, details)
+	})
+}
+
+// Get returns the schedule if the user in ctx owns it or may read any account.
+// Other users' schedules are reported as missing.
+func (s *ScheduleService) Get(ctx context.Context, scheduleID uint) (*models.Schedule, error) {
+	return s.get(ctx, scheduleID, models.PermissionAccountsRead)
+}
+
+// owned returns the schedule if the user in ctx owns it or may change any
+// account, for changes to it.
+func (s *ScheduleService) owned(ctx context.Context, scheduleID uint) (*models.Schedule, error) {
+	return s.get(ctx, scheduleID, models.PermissionAccountsWrite)
+}
+
+// get returns the schedule if the user in ctx owns it or holds permission.
+func (s *ScheduleService) get(ctx context.Context, scheduleID uint, permission string) (*models.Schedule, error) {
+	user, ok := auth.GetUserFromContext(ctx)
+	if !ok {
+		return nil, models.ErrUnauthorized
+	}
+
+	schedule, err := s.repo.GetByID(ctx, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.UserID == user.ID || auth.HasPermission(ctx, permission) {
+		return schedule, nil
+	}
+	return nil, models.ErrNotFound
+}
+
+func (s *ScheduleService) List(ctx context.Context, userID uint, status models.ScheduleStatus) ([]models.Schedule, error) {
+	return s.repo.ListByUserID(ctx, userID, status)
+}
+
+// Cancel stops an active schedule; runs already posted stay as they are.
+func (s *ScheduleService) Cancel(ctx context.Context, scheduleID uint) (*models.Schedule, error) {
+	if _, err := s.owned(ctx, scheduleID); err != nil {
+		return nil, err
+	}
+
+	var schedule *models.Schedule
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		schedule, err = s.repo.GetByIDForUpdate(ctx, scheduleID)
+		if err != nil {
+			return err
+		}
+		if schedule.Status != models.ScheduleStatusActive {
+			return fmt.Errorf("%w: schedule is %s", models.ErrScheduleNotActive, schedule.Status)
+		}
+
+		schedule.Status = models.ScheduleStatusCancelled
+		schedule.NextRunAt = nil
+		if err := s.repo.Update(ctx, schedule); err != nil {
+			return err
+		}
+		return s.auditSvc.LogAction(ctx, models.EntityTypeSchedule, schedule.ID, models.ActionUpdate, "Cancelled schedule")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// ListRuns returns the most recent runs of a schedule the user in ctx may
+// see.
+func (s *ScheduleService) ListRuns(ctx context.Context, scheduleID uint) ([]models.ScheduleRun, error) {
+	if _, err := s.Get(ctx, scheduleID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListRuns(ctx, scheduleID, scheduleRunsLimit)
+}
+
+// RunDue runs every schedule that is due and returns how many transfers
+// succeeded.
+func (s *ScheduleService) RunDue(ctx context.Context) (int, error) {
+	due, err := s.repo.ListDue(ctx, time.Now(), scheduleBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	succeeded := 0
+	for i := range due {
+		schedule := &due[i]
+		// Scheduled transfers act on behalf of the schedule's owner
+		ctx := auth.SetUserInContext(ctx, &models.User{ID: schedule.UserID, Role: models.RoleUser})
+		ok, err := s.run(ctx, schedule)
+		if err != nil {
+			s.logger.Error("failed to run schedule", "error", err, "schedule_id", schedule.ID)
+			continue
+		}
+		if ok {
+			succeeded++
+		}
+	}
+	return succeeded, nil
+}
+
+// run posts the transfer for the schedule's current occurrence. The
+// occurrence is claimed under a row lock first, and only the run that claims
+// it records and posts the transfer, in the same unit of work. A transfer
+// the limits or risk rules turn away is recorded with the claim; one that
+// fails to post is rolled back and recorded afterwards under the failure
+// policy.
+func (s *ScheduleService) run(ctx context.Context, schedule *models.Schedule) (bool, error) {
+	dueAt := *schedule.NextRunAt
+	attempt := schedule.Attempts + 1
+
+	tx := &models.Transaction{
+		FromUserID: schedule.UserID,
+		ToUserID:   schedule.ToUserID,
+		Amount:     schedule.Amount,
+		Currency:   schedule.Currency,
+		Type:       models.TypeTransfer,
+		Status:     models.StatusPending,
+		Notes:      schedule.Notes,
+	}
+
+	var runErr error
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		current, err := s.claim(ctx, schedule.ID, dueAt)
+		if err != nil {
+			return err
+		}
+
+		moveOn := func(status models.ScheduleRunStatus) func(ctx context.Context) error {
+			return func(ctx context.Context) error {
+				current.RecordSuccess(time.Now())
+				if err := s.repo.Update(ctx, current); err != nil {
+					return err
+				}
+				return s.repo.CreateRun(ctx, &models.ScheduleRun{
+					ScheduleID:    current.ID,
+					ScheduledFor:  dueAt,
+					Attempt:       attempt,
+					Status:        status,
+					TransactionID: &tx.ID,
+				})
+			}
+		}
+
+		runErr = s.txSvc.withinLimits(ctx, tx)
+		if runErr == nil {
+			runErr = s.txSvc.screen(ctx, tx, moveOn(models.ScheduleRunHeld))
+		}
+		switch {
+		case runErr == nil:
+			runErr = s.txSvc.transfer(ctx, tx, moveOn(models.ScheduleRunSucceeded))
+			return runErr
+		case errors.Is(runErr, models.ErrHeldForReview):
+			return nil
+		case errors.Is(runErr, models.ErrLimitExceeded), errors.Is(runErr, models.ErrRiskDenied):
+			return s.recordFailure(ctx, current, dueAt, attempt, tx, runErr)
+		default:
+			return runErr
+		}
+	})
+	switch {
+	case err == nil && runErr == nil:
+		scheduleRuns.WithLabelValues(string(models.ScheduleRunSucceeded)).Inc()
+		return true, nil
+	case err == nil:
+		if errors.Is(runErr, models.ErrHeldForReview) {
+			scheduleRuns.WithLabelValues(string(models.ScheduleRunHeld)).Inc()
+		}
+		return false, nil
+	case errors.Is(err, errScheduleClaimed):
+		return false, nil
+	case runErr == nil:
+		return false, err
+	}
+
+	// The claim was rolled back with the transfer; take it again to record
+	// the failure, along with the failed transfer if it got that far
+	return false, s.uow.Do(ctx, func(ctx context.Context) error {
+		current, err := s.claim(ctx, schedule.ID, dueAt)
+		if err != nil {
+			if errors.Is(err, errScheduleClaimed) {
+				return nil
+			}
+			return err
+		}
+
+		if tx.ID != 0 {
+			tx.ID = 0
+			if err := s.txSvc.CreateTransaction(ctx, tx); err != nil {
+				return err
+			}
+			if err := s.txSvc.processor.SetStatus(ctx, tx, models.StatusFailed); err != nil {
+				return err
+			}
+		}
+		return s.recordFailure(ctx, current, dueAt, attempt, tx, runErr)
+	})
+}
+
+// recordFailure applies the failure policy to the claimed schedule and
+// records the failed run, linked to its transaction if one was recorded.
+func (s *ScheduleService) recordFailure(ctx context.Context, current *models.Schedule, dueAt time.Time, attempt int, tx *models.Transaction, cause error) error {
+	status := current.RecordFailure(time.Now(), cause, s.retryDelay)
+	if err := s.repo.Update(ctx, current); err != nil {
+		return err
+	}
+
+	run := &models.ScheduleRun{
+		ScheduleID:   current.ID,
+		ScheduledFor: dueAt,
+		Attempt:      attempt,
+		Status:       status,
+		Error:        cause.Error(),
+	}
+	if tx.ID != 0 {
+		run.TransactionID = &tx.ID
+	}
+	if err := s.repo.CreateRun(ctx, run); err != nil {
+		return err
+	}
+
+	scheduleRuns.WithLabelValues(string(status)).Inc()
+	s.logger.Warn("scheduled transfer failed",
+		"schedule_id", current.ID,
+		"attempt", attempt,
+		"outcome", status,
+		"error", cause)
+	return nil
+}
+
+// claim locks the schedule and checks that the occurrence at dueAt is still
+// the one waiting to run.
+func (s *ScheduleService) claim(ctx context.Context, scheduleID uint, dueAt time.Time) (*models.Schedule, error) {
+	current, err := s.repo.GetByIDForUpdate(ctx, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if !current.IsDue(time.Now()) || !current.NextRunAt.Equal(dueAt) {
+		return nil, errScheduleClaimed
+	}
+	return current, nil
+}
+
+// Start runs due schedules on every tick until Stop is called. Ticks only
+// run on the replica holding the scheduler lease.
+func (s *ScheduleService) Start(ctx context.Context) error {
+	s.logger.Info("starting scheduler", "interval", s.interval, "holder", s.holder)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.tick(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *ScheduleService) tick(ctx context.Context) {
+	ok, err := s.lease.Acquire(ctx, schedulerLeaseName, s.holder, schedulerLeaseTicks*s.interval)
+	if err != nil {
+		s.logger.Error("failed to acquire scheduler lease", "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if n, err := s.RunDue(ctx); err != nil {
+		s.logger.Error("failed to run due schedules", "error", err)
+	} else if n > 0 {
+		s.logger.Info("ran scheduled transfers", "count", n)
+	}
+}
+
+func (s *ScheduleService) Stop() {
+	s.logger.Info("stopping scheduler")
+	close(s.stopChan)
+	s.wg.Wait()
+
+	if err := s.lease.Release(context.Background(), schedulerLeaseName, s.holder); err != nil {
+		s.logger.Error("failed to release scheduler lease", "error", err)
+	}
+}
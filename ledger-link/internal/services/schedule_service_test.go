@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/logger"
+)
+
+func newTestSchedules(ledger *testLedger) *ScheduleService {
+	uow := repositories.NewUnitOfWork(ledger.db)
+	auditSvc := NewAuditService(repositories.NewAuditLogRepository(ledger.db), logger.New("error"))
+	lease := repositories.NewLeaseRepository(ledger.db)
+	return NewScheduleService(repositories.NewScheduleRepository(ledger.db), uow, ledger.txSvc, auditSvc, lease, logger.New("error"), time.Minute, time.Minute)
+}
+
+// makeDue moves the schedule's next run into the past.
+func (l *testLedger) makeDue(t *testing.T, scheduleID uint) {
+	t.Helper()
+	require.NoError(t, l.db.Model(&models.Schedule{}).Where("id = ?", scheduleID).
+		Update("next_run_at", time.Now().Add(-time.Second)).Error)
+}
+
+func TestScheduleRunsDueTransferOnce(t *testing.T) {
+	ledger := newTestLedger(t)
+	schedules := newTestSchedules(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	schedule := &models.Schedule{
+		ToUserID:        2,
+		Amount:          decimal.NewFromInt(10),
+		Currency:        "USD",
+		Kind:            models.ScheduleKindInterval,
+		IntervalSeconds: 3600,
+		StartAt:         time.Now().Add(-time.Minute),
+	}
+	require.NoError(t, schedules.Create(alice, schedule))
+	assert.Equal(t, models.FailurePolicyRetry, schedule.FailurePolicy)
+
+	n, err := schedules.RunDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(90)))
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(60)))
+
+	// The occurrence has been claimed, so a second tick does nothing
+	n, err = schedules.RunDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(90)))
+
+	current, err := schedules.Get(alice, schedule.ID)
+	require.NoError(t, err)
+	assert.True(t, current.NextRunAt.Equal(schedule.StartAt.Add(time.Hour)))
+
+	runs, err := schedules.ListRuns(alice, schedule.ID)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, models.ScheduleRunSucceeded, runs[0].Status)
+	require.NotNil(t, runs[0].TransactionID)
+
+	_, err = schedules.ListRuns(asUser(2, models.RoleUser), schedule.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound, "other users' schedules are hidden")
+}
+
+func TestScheduleFailurePolicies(t *testing.T) {
+	ledger := newTestLedger(t)
+	schedules := newTestSchedules(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	once := &models.Schedule{
+		ToUserID:      2,
+		Amount:        decimal.NewFromInt(500),
+		Currency:      "USD",
+		Kind:          models.ScheduleKindOnce,
+		StartAt:       time.Now().Add(-time.Second),
+		FailurePolicy: models.FailurePolicyRetry,
+		MaxRetries:    1,
+	}
+	require.NoError(t, schedules.Create(alice, once))
+
+	weekly := &models.Schedule{
+		ToUserID:      2,
+		Amount:        decimal.NewFromInt(500),
+		Currency:      "USD",
+		Kind:          models.ScheduleKindCron,
+		CronExpr:      "0 9 * * 1",
+		FailurePolicy: models.FailurePolicySkip,
+	}
+	require.NoError(t, schedules.Create(alice, weekly))
+	ledger.makeDue(t, weekly.ID)
+
+	n, err := schedules.RunDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// The one-off transfer is retried after the delay
+	retried, err := schedules.Get(alice, once.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ScheduleStatusActive, retried.Status)
+	assert.Equal(t, 1, retried.Attempts)
+	assert.True(t, retried.NextRunAt.After(time.Now()))
+	assert.Contains(t, retried.LastError, "insufficient funds")
+
+	// The weekly one skips to next Monday
+	skipped, err := schedules.Get(alice, weekly.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ScheduleStatusActive, skipped.Status)
+	assert.Equal(t, time.Monday, skipped.NextRunAt.UTC().Weekday())
+	runs, err := schedules.ListRuns(alice, weekly.ID)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, models.ScheduleRunSkipped, runs[0].Status)
+
+	// Out of retries, the one-off schedule fails for good
+	ledger.makeDue(t, once.ID)
+	_, err = schedules.RunDue(context.Background())
+	require.NoError(t, err)
+	failed, err := schedules.Get(alice, once.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ScheduleStatusFailed, failed.Status)
+	assert.Nil(t, failed.NextRunAt)
+
+	runs, err = schedules.ListRuns(alice, once.ID)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, models.ScheduleRunFailed, runs[0].Status)
+	assert.Equal(t, 2, runs[0].Attempt)
+	assert.Equal(t, models.ScheduleRunRetrying, runs[1].Status)
+
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)))
+	assert.Equal(t, int64(4), ledger.count(t, &models.Posting{}), "failed runs left postings behind")
+}
+
+func TestSchedulerLease(t *testing.T) {
+	ledger := newTestLedger(t)
+	lease := repositories.NewLeaseRepository(ledger.db)
+	ctx := context.Background()
+
+	ok, err := lease.Acquire(ctx, "scheduler", "a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = lease.Acquire(ctx, "scheduler", "b", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok, "lease is held by another replica")
+
+	ok, err = lease.Acquire(ctx, "scheduler", "a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok, "holder renews its lease")
+
+	require.NoError(t, lease.Release(ctx, "scheduler", "a"))
+	ok, err = lease.Acquire(ctx, "scheduler", "b", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestScheduledTransfersRespectSpendingLimits(t *testing.T) {
+	ledger := newTestLedger(t)
+	schedules := newTestSchedules(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	_, err := ledger.limitSvc.SetLimit(asUser(2, models.RoleAdmin), &models.SpendingLimit{
+		Role:            models.RoleUser,
+		TransactionType: models.TypeTransfer,
+		Currency:        "USD",
+		PerTransaction:  decimal.NewNullDecimal(decimal.NewFromInt(20)),
+	})
+	require.NoError(t, err)
+
+	schedule := &models.Schedule{
+		ToUserID:      2,
+		Amount:        decimal.NewFromInt(30),
+		Currency:      "USD",
+		Kind:          models.ScheduleKindOnce,
+		StartAt:       time.Now().Add(-time.Second),
+		FailurePolicy: models.FailurePolicyRetry,
+		MaxRetries:    1,
+	}
+	require.NoError(t, schedules.Create(alice, schedule))
+
+	n, err := schedules.RunDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)))
+	assert.Zero(t, ledger.count(t, &models.Transaction{}), "rejected transfer recorded")
+
+	current, err := schedules.Get(alice, schedule.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, current.Attempts)
+	assert.Contains(t, current.LastError, models.ErrLimitExceeded.Error())
+
+	runs, err := schedules.ListRuns(alice, schedule.ID)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, models.ScheduleRunRetrying, runs[0].Status)
+	assert.Nil(t, runs[0].TransactionID)
+}
+
+func TestScheduleRunClaimsBeforeRecordingTheTransfer(t *testing.T) {
+	ledger := newTestLedger(t)
+	schedules := newTestSchedules(ledger)
+	alice := asUser(1, models.RoleUser)
+
+	schedule := &models.Schedule{
+		ToUserID:        2,
+		Amount:          decimal.NewFromInt(10),
+		Currency:        "USD",
+		Kind:            models.ScheduleKindInterval,
+		IntervalSeconds: 3600,
+		StartAt:         time.Now().Add(-time.Minute),
+	}
+	require.NoError(t, schedules.Create(alice, schedule))
+	stale, err := schedules.Get(alice, schedule.ID)
+	require.NoError(t, err)
+
+	// A posting failure rolls back the claim; the failure is recorded
+	// afterwards with the failed transfer
+	ledger.failOn(t, "create", "postings", 1)
+	ok, err := schedules.run(alice, stale)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, models.StatusFailed, ledger.lastStatus(t))
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)))
+	runs, err := schedules.ListRuns(alice, schedule.ID)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, models.ScheduleRunRetrying, runs[0].Status)
+	require.NotNil(t, runs[0].TransactionID)
+	assert.Equal(t, models.StatusFailed, ledger.transaction(t, *runs[0].TransactionID).Status)
+
+	// A run that lost the occurrence to another one records nothing
+	ok, err = schedules.run(alice, stale)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, int64(1), ledger.count(t, &models.Transaction{}))
+	assert.Equal(t, int64(1), ledger.count(t, &models.ScheduleRun{}))
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+var snapshotRuns = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_balance_snapshot_runs_total",
+		Help: "Total number of daily balance snapshot runs by status",
+	},
+	[]string{"status"},
+)
+
+const (
+	DefaultSnapshotInterval = time.Hour
+
+	snapshotLeaseName = "balance-snapshots"
+	// snapshotSettleDelay is how long after midnight the day's snapshot is
+	// taken, so that transactions still committing at midnight are in it
+	snapshotSettleDelay = 5 * time.Minute
+)
+
+// SnapshotService records every balance at the start of each UTC day. The
+// snapshots are checkpoints for BalanceService.GetBalanceAtTime, which then
+// only replays the history recorded since the latest one.
+type SnapshotService struct {
+	repo       models.BalanceRepository
+	uow        models.UnitOfWork
+	balanceSvc models.BalanceService
+	lease      models.Lease
+	logger     *logger.Logger
+	interval   time.Duration
+	holder     string
+	stopChan   chan struct{}
+	wg         sync.WaitGroup
+}
+
+func NewSnapshotService(
+	repo models.BalanceRepository,
+	uow models.UnitOfWork,
+	balanceSvc models.BalanceService,
+	lease models.Lease,
+	logger *logger.Logger,
+	interval time.Duration,
+) *SnapshotService {
+	if interval <= 0 {
+		interval = DefaultSnapshotInterval
+	}
+	hostname, _ := os.Hostname()
+	return &SnapshotService{
+		repo:       repo,
+		uow:        uow,
+		balanceSvc: balanceSvc,
+		lease:      lease,
+		logger:     logger,
+		interval:   interval,
+		holder:     fmt.Sprintf("%s-%s", hostname, uuid.NewString()),
+		stopChan:   make(chan struct{}),
+	}
+}
+
+// TakeSnapshots records every balance as it stood at asOf and returns how
+// many were recorded. Taking the same snapshot again overwrites it.
+func (s *SnapshotService) TakeSnapshots(ctx context.Context, asOf time.Time) (int, error) {
+	var count int
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		balances, err := s.balanceSvc.GetBalancesAtTime(ctx, asOf)
+		if err != nil {
+			return err
+		}
+
+		snapshots := make([]models.BalanceSnapshot, 0, len(balances))
+		for i := range balances {
+			snapshots = append(snapshots, models.BalanceSnapshot{
+				UserID:   balances[i].UserID,
+				Currency: balances[i].Currency,
+				AsOf:     asOf,
+				Amount:   balances[i].Amount,
+			})
+		}
+		count = len(snapshots)
+		return s.repo.SaveSnapshots(ctx, snapshots)
+	})
+	if err != nil {
+		snapshotRuns.WithLabelValues("failure").Inc()
+		return 0, fmt.Errorf("failed to take balance snapshots: %w", err)
+	}
+
+	snapshotRuns.WithLabelValues("success").Inc()
+	return count, nil
+}
+
+// RunDue takes today's snapshot unless it has already been taken.
+func (s *SnapshotService) RunDue(ctx context.Context) (int, error) {
+	day := models.SnapshotDay(time.Now().Add(-snapshotSettleDelay))
+
+	latest, err := s.repo.LatestSnapshotTime(ctx, day)
+	if err == nil && latest.Equal(day) {
+		return 0, nil
+	} else if err != nil && err != models.ErrNotFound {
+		return 0, err
+	}
+	return s.TakeSnapshots(ctx, day)
+}
+
+// Start checks for a due snapshot immediately and then every interval until
+// Stop is called. Only the replica holding the snapshot lease takes it.
+func (s *SnapshotService) Start(ctx context.Context) error {
+	s.logger.Info("starting balance snapshots", "interval", s.interval, "holder", s.holder)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.tick(ctx)
+
+			select {
+			case <-s.stopChan:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *SnapshotService) tick(ctx context.Context) {
+	ok, err := s.lease.Acquire(ctx, snapshotLeaseName, s.holder, 2*s.interval)
+	if err != nil {
+		s.logger.Error("failed to acquire snapshot lease", "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if n, err := s.RunDue(ctx); err != nil {
+		s.logger.Error("failed to take balance snapshots", "error", err)
+	} else if n > 0 {
+		s.logger.Info("took balance snapshots", "count", n)
+	}
+}
+
+func (s *SnapshotService) Stop() {
+	s.logger.Info("stopping balance snapshots")
+	close(s.stopChan)
+	s.wg.Wait()
+
+	if err := s.lease.Release(context.Background(), snapshotLeaseName, s.holder); err != nil {
+		s.logger.Error("failed to release snapshot lease", "error", err)
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/logger"
+)
+
+func newTestSnapshots(ledger *testLedger) *SnapshotService {
+	return NewSnapshotService(
+		repositories.NewBalanceRepository(ledger.db),
+		repositories.NewUnitOfWork(ledger.db),
+		ledger.balanceSvc,
+		repositories.NewLeaseRepository(ledger.db),
+		logger.New("error"),
+		time.Hour,
+	)
+}
+
+func (l *testLedger) balanceAt(t *testing.T, userID uint, currency string, at time.Time) decimal.Decimal {
+	t.Helper()
+	balance, err := l.balanceSvc.GetBalanceAtTime(context.Background(), userID, currency, at)
+	require.NoError(t, err)
+	return balance.Amount
+}
+
+func TestBalanceAtTimeReplaysFromSnapshots(t *testing.T) {
+	ledger := newTestLedger(t)
+	snapshots := newTestSnapshots(ledger)
+	ctx := context.Background()
+
+	tick := func() time.Time {
+		time.Sleep(2 * time.Millisecond)
+		now := time.Now()
+		time.Sleep(2 * time.Millisecond)
+		return now
+	}
+
+	t0 := tick()
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", ""))
+	t1 := tick()
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(5), "USD", ""))
+	t2 := tick()
+
+	// Without snapshots the history is undone from the current balance
+	assert.True(t, ledger.balanceAt(t, 1, "USD", t0).Equal(decimal.NewFromInt(100)))
+	assert.True(t, ledger.balanceAt(t, 1, "USD", t1).Equal(decimal.NewFromInt(90)))
+	assert.True(t, ledger.balanceAt(t, 1, "USD", t2).Equal(decimal.NewFromInt(85)))
+
+	n, err := snapshots.TakeSnapshots(ctx, t1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	balances, err := ledger.balanceSvc.GetBalancesAtTime(ctx, t2)
+	require.NoError(t, err)
+	require.Len(t, balances, 2)
+	assert.True(t, balances[0].Amount.Equal(decimal.NewFromInt(85)))
+	assert.True(t, balances[1].Amount.Equal(decimal.NewFromInt(65)))
+
+	// Later times replay from the snapshot, earlier ones still walk back
+	require.NoError(t, ledger.db.Model(&models.BalanceSnapshot{}).Where("user_id = ?", 1).
+		Update("amount", decimal.NewFromInt(1090)).Error)
+	assert.True(t, ledger.balanceAt(t, 1, "USD", t2).Equal(decimal.NewFromInt(1085)))
+	assert.True(t, ledger.balanceAt(t, 1, "USD", t0).Equal(decimal.NewFromInt(100)))
+	balances, err = ledger.balanceSvc.GetBalancesAtTime(ctx, t2)
+	require.NoError(t, err)
+	assert.True(t, balances[0].Amount.Equal(decimal.NewFromInt(1085)))
+}
+
+func TestBalanceAtTimeCountsEveryChange(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	// A busy balance with more history than a single replay chunk
+	const changes = 2*historyReplayChunkSize + 5
+	start := time.Now().Add(-time.Hour)
+	history := make([]models.BalanceHistory, changes)
+	for i := range history {
+		history[i] = models.BalanceHistory{
+			UserID:    1,
+			Currency:  "EUR",
+			OldAmount: decimal.NewFromInt(int64(i)),
+			NewAmount: decimal.NewFromInt(int64(i + 1)),
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+		}
+	}
+	require.NoError(t, ledger.db.CreateInBatches(history, 500).Error)
+	require.NoError(t, ledger.db.Create(&models.Balance{UserID: 1, Currency: "EUR", Amount: decimal.NewFromInt(changes)}).Error)
+
+	assert.True(t, ledger.balanceAt(t, 1, "EUR", start.Add(-time.Second)).IsZero())
+	assert.True(t, ledger.balanceAt(t, 1, "EUR", start.Add(1500*time.Second)).Equal(decimal.NewFromInt(1501)))
+	assert.True(t, ledger.balanceAt(t, 1, "EUR", time.Now()).Equal(decimal.NewFromInt(changes)))
+}
+
+func TestSnapshotsRunOncePerDay(t *testing.T) {
+	ledger := newTestLedger(t)
+	snapshots := newTestSnapshots(ledger)
+	ctx := context.Background()
+
+	// The seeded balances predate today's snapshot
+	require.NoError(t, ledger.db.Exec("UPDATE balances SET created_at = ?", time.Now().AddDate(0, 0, -2)).Error)
+
+	n, err := snapshots.RunDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = snapshots.RunDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	day := models.SnapshotDay(time.Now().Add(-snapshotSettleDelay))
+	snapshot, err := repositories.NewBalanceRepository(ledger.db).GetLatestSnapshot(ctx, 2, "USD", time.Now())
+	require.NoError(t, err)
+	assert.True(t, snapshot.AsOf.Equal(day))
+	assert.True(t, snapshot.Amount.Equal(decimal.NewFromInt(50)))
+}
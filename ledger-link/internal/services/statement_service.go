@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+const statementChunkSize = 500
+
+// StatementService produces account statements from the balance history,
+// with each entry described by the transaction behind it. Entries are read
+// in chunks and handed to the writer one by one, so a statement of any
+// length is never held in memory.
+type StatementService struct {
+	balanceRepo models.BalanceRepository
+	txRepo      models.TransactionRepository
+	balanceSvc  models.BalanceService
+	logger      *logger.Logger
+}
+
+func NewStatementService(
+	balanceRepo models.BalanceRepository,
+	txRepo models.TransactionRepository,
+	balanceSvc models.BalanceService,
+	logger *logger.Logger,
+) *StatementService {
+	return &StatementService{
+		balanceRepo: balanceRepo,
+		txRepo:      txRepo,
+		balanceSvc:  balanceSvc,
+		logger:      logger,
+	}
+}
+
+// Generate writes the statement of the user's balance in currency over
+// (from, to]. The entries are the same history entries GetBalanceAtTime
+// replays, so the opening balance plus the entries must come to the closing
+// balance; if they do not, Generate fails before ending the statement.
+func (s *StatementService) Generate(ctx context.Context, userID uint, currency string, from, to time.Time, w models.StatementWriter) error {
+	currency = models.NormalizeCurrency(currency)
+	if _, err := models.LookupCurrency(currency); err != nil {
+		return err
+	}
+	if !to.After(from) {
+		return fmt.Errorf("%w: statement period ends before it starts", models.ErrInvalidInput)
+	}
+
+	opening, err := s.balanceSvc.GetBalanceAtTime(ctx, userID, currency, from)
+	if err != nil {
+		return fmt.Errorf("failed to get opening balance: %w", err)
+	}
+	closing, err := s.balanceSvc.GetBalanceAtTime(ctx, userID, currency, to)
+	if err != nil {
+		return fmt.Errorf("failed to get closing balance: %w", err)
+	}
+
+	statement := &models.Statement{
+		UserID:         userID,
+		Currency:       currency,
+		From:           from,
+		To:             to,
+		OpeningBalance: opening.Amount,
+		ClosingBalance: closing.Amount,
+	}
+	if err := w.Begin(statement); err != nil {
+		return err
+	}
+
+	running := opening.Amount
+	var afterID uint
+	for {
+		history, err := s.balanceRepo.ListBalanceHistoryBetween(ctx, userID, currency, from, to, afterID, statementChunkSize)
+		if err != nil {
+			return err
+		}
+		if len(history) == 0 {
+			break
+		}
+
+		transactions, err := s.transactionsOf(ctx, history)
+		if err != nil {
+			return err
+		}
+
+		for i := range history {
+			change := &history[i]
+			running = running.Add(change.NewAmount.Sub(change.OldAmount))
+			entry := describeEntry(userID, change, transactions)
+			entry.Balance = running
+			if err := w.Entry(entry); err != nil {
+				return err
+			}
+		}
+		afterID = history[len(history)-1].ID
+	}
+
+	if !running.Equal(statement.ClosingBalance) {
+		s.logger.Error("statement does not reconcile",
+			"user_id", userID,
+			"currency", currency,
+			"from", from,
+			"to", to,
+			"entries_total", running,
+			"closing_balance", statement.ClosingBalance)
+		return fmt.Errorf("%w: entries come to %s, balance is %s", models.ErrStatementMismatch, running, statement.ClosingBalance)
+	}
+	return w.End(statement)
+}
+
+// transactionsOf loads the transactions behind a chunk of history entries.
+func (s *StatementService) transactionsOf(ctx context.Context, history []models.BalanceHistory) (map[uint]*models.Transaction, error) {
+	ids := make([]uint, 0, len(history))
+	for _, change := range history {
+		if change.TransactionID != nil {
+			ids = append(ids, *change.TransactionID)
+		}
+	}
+
+	transactions, err := s.txRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*models.Transaction, len(transactions))
+	for i := range transactions {
+		byID[transactions[i].ID] = &transactions[i]
+	}
+	return byID, nil
+}
+
+func describeEntry(userID uint, change *models.BalanceHistory, transactions map[uint]*models.Transaction) *models.StatementEntry {
+	entry := &models.StatementEntry{
+		PostedAt:      change.CreatedAt,
+		TransactionID: change.TransactionID,
+		Amount:        change.NewAmount.Sub(change.OldAmount),
+		Description:   "Balance change",
+	}
+
+	var tx *models.Transaction
+	if change.TransactionID != nil {
+		tx = transactions[*change.TransactionID]
+	}
+	if tx == nil {
+		if change.TransactionID == nil && entry.Amount.GreaterThan(decimal.Zero) {
+			entry.Description = "Batch deposit"
+		}
+		return entry
+	}
+
+	entry.Type = tx.Type
+	if tx.FromUserID != tx.ToUserID {
+		entry.CounterpartyID = tx.FromUserID
+		if tx.FromUserID == userID {
+			entry.CounterpartyID = tx.ToUserID
+		}
+	}
+	entry.Description = tx.Notes
+	if entry.Description == "" {
+		entry.Description = string(tx.Type)
+	}
+	return entry
+}
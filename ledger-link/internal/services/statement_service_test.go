@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/logger"
+)
+
+type recordingStatementWriter struct {
+	begun, ended *models.Statement
+	entries      []models.StatementEntry
+}
+
+func (w *recordingStatementWriter) Begin(s *models.Statement) error {
+	w.begun = s
+	return nil
+}
+
+func (w *recordingStatementWriter) Entry(e *models.StatementEntry) error {
+	w.entries = append(w.entries, *e)
+	return nil
+}
+
+func (w *recordingStatementWriter) End(s *models.Statement) error {
+	w.ended = s
+	return nil
+}
+
+func TestStatementReconcilesWithBalanceAtTime(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+	statements := NewStatementService(
+		repositories.NewBalanceRepository(ledger.db),
+		repositories.NewTransactionRepository(ledger.db),
+		ledger.balanceSvc,
+		logger.New("error"),
+	)
+
+	tick := func() time.Time {
+		time.Sleep(2 * time.Millisecond)
+		now := time.Now()
+		time.Sleep(2 * time.Millisecond)
+		return now
+	}
+
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", "before"))
+	from := tick()
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(5), "USD", "rent"))
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 2, 1, decimal.NewFromInt(7), "USD", ""))
+	require.NoError(t, ledger.txSvc.Debit(ctx, 1, decimal.NewFromInt(3), "USD", "cash"))
+	to := tick()
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(1), "USD", "after"))
+
+	w := &recordingStatementWriter{}
+	require.NoError(t, statements.Generate(ctx, 1, "usd", from, to, w))
+	require.NotNil(t, w.ended)
+
+	opening, err := ledger.balanceSvc.GetBalanceAtTime(ctx, 1, "USD", from)
+	require.NoError(t, err)
+	closing, err := ledger.balanceSvc.GetBalanceAtTime(ctx, 1, "USD", to)
+	require.NoError(t, err)
+	assert.True(t, w.begun.OpeningBalance.Equal(opening.Amount))
+	assert.True(t, w.ended.ClosingBalance.Equal(closing.Amount))
+	assert.True(t, opening.Amount.Equal(decimal.NewFromInt(90)))
+	assert.True(t, closing.Amount.Equal(decimal.NewFromInt(89)))
+
+	require.Len(t, w.entries, 3)
+	assert.Equal(t, "rent", w.entries[0].Description)
+	assert.Equal(t, uint(2), w.entries[0].CounterpartyID)
+	assert.True(t, w.entries[0].Amount.Equal(decimal.NewFromInt(-5)))
+	assert.Equal(t, string(models.TypeTransfer), w.entries[1].Description, "entries without notes are described by type")
+	assert.True(t, w.entries[1].Balance.Equal(decimal.NewFromInt(92)))
+	assert.Equal(t, models.TypeWithdrawal, w.entries[2].Type)
+	require.NotNil(t, w.entries[2].TransactionID)
+	assert.True(t, w.entries[2].Balance.Equal(closing.Amount))
+
+	err = statements.Generate(ctx, 1, "USD", to, from, &recordingStatementWriter{})
+	assert.ErrorIs(t, err, models.ErrInvalidInput)
+	err = statements.Generate(ctx, 1, "XXX", from, to, &recordingStatementWriter{})
+	assert.ErrorIs(t, err, models.ErrUnsupportedCurrency)
+}
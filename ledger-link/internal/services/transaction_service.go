@@ -2,7 +2,9 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -37,7 +39,7 @@ var (
 			Name: "ledger_user_balance",
 			Help: "Current balance for users",
 		},
-		[]string{"user_id"},
+		[]string{"user_id", "currency"},
 	)
 
 	transactionAmount = promauto.NewHistogramVec(
@@ -66,30 +68,51 @@ var (
 	)
 )
 
+// TransactionService moves money between accounts. Taking money out of an
+// account, whether by debit, transfer, conversion, hold capture or schedule,
+// needs a fresh TOTP code above the step-up threshold of its currency.
 type TransactionService struct {
-	repo       models.TransactionRepository
-	processor  *processor.TransactionProcessor
-	balanceSvc models.BalanceService
-	auditSvc   models.AuditService
-	logger     *logger.Logger
+	repo             models.TransactionRepository
+	processor        *processor.TransactionProcessor
+	uow              models.UnitOfWork
+	balanceSvc       models.BalanceService
+	auditSvc         models.AuditService
+	limits           models.LimitService
+	risk             models.RiskService
+	twoFactor        models.TwoFactorService
+	stepUpThresholds models.CurrencyAmounts
+	logger           *logger.Logger
 }
 
 func NewTransactionService(
 	repo models.TransactionRepository,
+	journal models.JournalRepository,
+	uow models.UnitOfWork,
 	balanceSvc models.BalanceService,
 	auditSvc models.AuditService,
+	events models.TransactionEvents,
+	updates models.UpdatePublisher,
+	limits models.LimitService,
+	risk models.RiskService,
+	twoFactor models.TwoFactorService,
+	stepUpThresholds models.CurrencyAmounts,
 	logger *logger.Logger,
 ) *TransactionService {
 	return &TransactionService{
-		repo:       repo,
-		balanceSvc: balanceSvc,
-		auditSvc:   auditSvc,
-		logger:     logger,
-		processor:  processor.NewTransactionProcessor(repo, balanceSvc, auditSvc, logger),
+		repo:             repo,
+		uow:              uow,
+		balanceSvc:       balanceSvc,
+		auditSvc:         auditSvc,
+		limits:           limits,
+		risk:             risk,
+		twoFactor:        twoFactor,
+		stepUpThresholds: stepUpThresholds,
+		logger:           logger,
+		processor:        processor.NewTransactionProcessor(repo, journal, uow, balanceSvc, auditSvc, events, updates, logger),
 	}
 }
 
-func (s *TransactionService) Credit(ctx context.Context, userID uint, amount decimal.Decimal, notes string) error {
+func (s *TransactionService) Credit(ctx context.Context, userID uint, amount decimal.Decimal, currency, notes string) error {
 	timer := prometheus.NewTimer(transactionDuration.WithLabelValues("credit"))
 	defer timer.ObserveDuration()
 
@@ -102,12 +125,14 @@ func (s *TransactionService) Credit(ctx context.Context, userID uint, amount dec
 		ToUserID:   userID,
 		FromUserID: userID,
 		Amount:     amount,
+		Currency:   models.NormalizeCurrency(currency),
 		Type:       models.TypeDeposit,
 		Status:     models.StatusPending,
 		Notes:      notes,
 	}
 
 	if err := tx.Validate(); err != nil {
+		transactionErrors.WithLabelValues("credit", "invalid_transaction").Inc()
 		return fmt.Errorf("invalid transaction: %w", err)
 	}
 
@@ -123,7 +148,7 @@ func (s *TransactionService) Credit(ctx context.Context, userID uint, amount dec
 	return nil
 }
 
-func (s *TransactionService) Debit(ctx context.Context, userID uint, amount decimal.Decimal, notes string) error {
+func (s *TransactionService) Debit(ctx context.Context, userID uint, amount decimal.Decimal, currency, notes string) error {
 	timer := prometheus.NewTimer(transactionDuration.WithLabelValues("debit"))
 	defer timer.ObserveDuration()
 
@@ -135,6 +160,7 @@ func (s *TransactionService) Debit(ctx context.Context, userID uint, amount deci
 		FromUserID: userID,
 		ToUserID:   userID,
 		Amount:     amount,
+		Currency:   models.NormalizeCurrency(currency),
 		Type:       models.TypeWithdrawal,
 		Status:     models.StatusPending,
 		Notes:      notes,
@@ -144,56 +170,183 @@ func (s *TransactionService) Debit(ctx context.Context, userID uint, amount deci
 		return fmt.Errorf("invalid transaction: %w", err)
 	}
 
-	balance, err := s.balanceSvc.GetBalance(ctx, userID)
+	if err := s.stepUp(ctx, tx.Currency, tx.Amount); err != nil {
+		return err
+	}
+	if err := s.withinLimits(ctx, tx); err != nil {
+		return err
+	}
+
+	balance, err := s.balanceSvc.GetBalance(ctx, userID, tx.Currency)
 	if err != nil {
 		return fmt.Errorf("failed to get balance: %w", err)
 	}
 
-	if balance.SafeAmount().LessThan(amount) {
-		return fmt.Errorf("insufficient funds")
+	// Funds reserved by active holds cannot be debited
+	if balance.Available().LessThan(amount) {
+		return models.ErrInsufficientFunds
+	}
+
+	if err := s.screen(ctx, tx); err != nil {
+		return err
 	}
 
 	if err := s.CreateTransaction(ctx, tx); err != nil {
 		return err
 	}
 
-	if err := balance.SubtractAmount(amount); err != nil {
-		tx.Status = models.StatusFailed
-		if updateErr := s.ProcessTransaction(ctx, tx); updateErr != nil {
-			s.logger.Error("failed to update failed transaction", "error", updateErr)
-		}
+	// The processor re-checks the limits and the balance under lock and
+	// commits the balance, history, postings, audit entries and status
+	// atomically.
+	if err := s.processor.ProcessTransaction(ctx, tx, s.limitStep(tx)); err != nil {
+		transactionErrors.WithLabelValues("debit", "processing").Inc()
 		return fmt.Errorf("failed to debit amount: %w", err)
 	}
 
-	if err := s.balanceSvc.UpdateBalance(ctx, userID, balance.SafeAmount()); err != nil {
-		if rbErr := balance.AddAmount(amount); rbErr != nil {
-			s.logger.Error("failed to rollback balance update", "error", rbErr)
+	transactionCounter.WithLabelValues("debit", "success").Inc()
+	balanceGauge.WithLabelValues(fmt.Sprintf("%d", userID), tx.Currency).Set(balance.SafeAmount().Sub(amount).InexactFloat64())
+
+	return nil
+}
+
+// stepUp checks the fresh TOTP code of the user in ctx, carried by
+// auth.GetTOTPCodeFromContext, when amount is over the step-up threshold of
+// currency. Schedules step up when they are made, since nobody is present
+// when they run.
+func (s *TransactionService) stepUp(ctx context.Context, currency string, amount decimal.Decimal) error {
+	if s.twoFactor == nil || !s.stepUpThresholds.Exceeds(currency, amount) {
+		return nil
+	}
+	return s.twoFactor.StepUp(ctx, auth.GetTOTPCodeFromContext(ctx))
+}
+
+// withinLimits checks tx against its sender's spending limits. Called
+// before tx is recorded, a transaction over a limit leaves no trace.
+func (s *TransactionService) withinLimits(ctx context.Context, tx *models.Transaction) error {
+	if s.limits == nil {
+		return nil
+	}
+	err := s.limits.Check(ctx, tx.FromUserID, tx.Type, tx.Currency, tx.Amount)
+	if errors.Is(err, models.ErrLimitExceeded) {
+		transactionErrors.WithLabelValues(string(tx.Type), "limit_exceeded").Inc()
+	}
+	return err
+}
+
+// limitStep checks the limits again in the posting's unit of work, where
+// the sender's row stays locked until the posting commits. Of two
+// concurrent transactions that each fit on their own, the second then sees
+// the first and fails if together they do not fit.
+func (s *TransactionService) limitStep(tx *models.Transaction) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return s.withinLimits(ctx, tx)
+	}
+}
+
+// screen runs tx past the risk rules before it is recorded. A denied
+// transaction is recorded as failed and a flagged one is left in review
+// status with its review; both come back as a *models.RiskError. The held
+// steps run in the unit of work that leaves tx in review, so callers can
+// take whatever tx will consume once approved.
+func (s *TransactionService) screen(ctx context.Context, tx *models.Transaction, held ...func(ctx context.Context) error) error {
+	if s.risk == nil {
+		return nil
+	}
+	assessment, err := s.risk.Screen(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if assessment.Decision == models.DecisionAllow {
+		return nil
+	}
+
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.CreateTransaction(ctx, tx); err != nil {
+			return err
+		}
+		if assessment.Decision == models.DecisionDeny {
+			details := "Denied by risk rules: " + strings.Join(assessment.Reasons, "; ")
+			if err := s.auditSvc.LogAction(ctx, models.EntityTypeTransaction, tx.ID, models.ActionUpdate, details); err != nil {
+				return fmt.Errorf("failed to log risk decision: %w", err)
+			}
+			return s.processor.SetStatus(ctx, tx, models.StatusFailed)
+		}
+		if err := s.processor.SetStatus(ctx, tx, models.StatusReview); err != nil {
+			return err
 		}
-		tx.Status = models.StatusFailed
-		if updateErr := s.ProcessTransaction(ctx, tx); updateErr != nil {
-			s.logger.Error("failed to update failed transaction", "error", updateErr)
+		for _, step := range held {
+			if err := step(ctx); err != nil {
+				return err
+			}
 		}
-		return fmt.Errorf("failed to update balance: %w", err)
+		_, err := s.risk.Hold(ctx, tx, assessment)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record risk decision: %w", err)
 	}
 
-	tx.Status = models.StatusCompleted
-	if err := s.ProcessTransaction(ctx, tx); err != nil {
-		s.logger.Error("failed to update completed transaction", "error", err)
-		return fmt.Errorf("failed to update transaction status: %w", err)
+	transactionErrors.WithLabelValues(string(tx.Type), "risk_"+string(assessment.Decision)).Inc()
+	return &models.RiskError{
+		Decision:      assessment.Decision,
+		TransactionID: tx.ID,
+		Reasons:       assessment.Reasons,
 	}
+}
 
-	details := fmt.Sprintf("Debit transaction %d completed: %s debited from user %d", tx.ID, amount, userID)
-	if err := s.auditSvc.LogAction(ctx, models.EntityTypeTransaction, tx.ID, "debit", details); err != nil {
-		s.logger.Error("failed to log debit audit", "error", err)
+// ApproveReview executes a transaction held for review once an admin
+// approves it. Spending limits were applied when it was made, and it has
+// counted against them while in review; the balance is checked again, and a
+// sender who can no longer fund it sees it fail.
+func (s *TransactionService) ApproveReview(ctx context.Context, reviewID uint, note string) (*models.Transaction, error) {
+	tx, err := s.resolveReview(ctx, reviewID, models.RiskReviewApproved, note, models.StatusPending)
+	if err != nil {
+		return nil, err
 	}
 
-	transactionCounter.WithLabelValues("debit", "success").Inc()
-	balanceGauge.WithLabelValues(fmt.Sprintf("%d", userID)).Set(balance.SafeAmount().InexactFloat64())
+	if err := s.processor.ProcessTransaction(ctx, tx); err != nil {
+		transactionErrors.WithLabelValues(string(tx.Type), "processing").Inc()
+		return tx, fmt.Errorf("failed to process approved transaction: %w", err)
+	}
 
-	return nil
+	transactionCounter.WithLabelValues(string(tx.Type), "success").Inc()
+	return tx, nil
+}
+
+// RejectReview fails a transaction held for review.
+func (s *TransactionService) RejectReview(ctx context.Context, reviewID uint, note string) (*models.Transaction, error) {
+	return s.resolveReview(ctx, reviewID, models.RiskReviewRejected, note, models.StatusFailed)
 }
 
-func (s *TransactionService) Transfer(ctx context.Context, fromUserID, toUserID uint, amount decimal.Decimal, notes string) error {
+// resolveReview closes the review and moves its transaction out of review
+// status in one unit of work.
+func (s *TransactionService) resolveReview(ctx context.Context, reviewID uint, decision models.RiskReviewStatus, note string, status models.TransactionStatus) (*models.Transaction, error) {
+	if s.risk == nil {
+		return nil, models.ErrNotFound
+	}
+
+	var tx *models.Transaction
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		review, err := s.risk.Resolve(ctx, reviewID, decision, note)
+		if err != nil {
+			return err
+		}
+		tx, err = s.repo.GetByIDForUpdate(ctx, review.TransactionID)
+		if err != nil {
+			return fmt.Errorf("failed to get transaction: %w", err)
+		}
+		if tx.Status != models.StatusReview {
+			return fmt.Errorf("%w: transaction %d is %s", models.ErrInvalidStatus, tx.ID, tx.Status)
+		}
+		return s.processor.SetStatus(ctx, tx, status)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (s *TransactionService) Transfer(ctx context.Context, fromUserID, toUserID uint, amount decimal.Decimal, currency, notes string) error {
 	timer := prometheus.NewTimer(transactionDuration.WithLabelValues("transfer"))
 	defer timer.ObserveDuration()
 
@@ -205,6 +358,7 @@ func (s *TransactionService) Transfer(ctx context.Context, fromUserID, toUserID
 		FromUserID: fromUserID,
 		ToUserID:   toUserID,
 		Amount:     amount,
+		Currency:   models.NormalizeCurrency(currency),
 		Type:       models.TypeTransfer,
 		Status:     models.StatusPending,
 		Notes:      notes,
@@ -214,36 +368,49 @@ func (s *TransactionService) Transfer(ctx context.Context, fromUserID, toUserID
 		return fmt.Errorf("invalid transaction: %w", err)
 	}
 
+	if err := s.stepUp(ctx, tx.Currency, tx.Amount); err != nil {
+		return err
+	}
+	if err := s.withinLimits(ctx, tx); err != nil {
+		return err
+	}
+	if err := s.screen(ctx, tx); err != nil {
+		return err
+	}
+	return s.transfer(ctx, tx)
+}
+
+// transfer validates, records and posts a transfer built by the caller,
+// which has checked it against the spending limits. Steps run in the same
+// unit of work as the postings, ahead of them; the limits are checked again
+// after the steps.
+func (s *TransactionService) transfer(ctx context.Context, tx *models.Transaction, steps ...func(ctx context.Context) error) error {
+	if err := tx.Validate(); err != nil {
+		return fmt.Errorf("invalid transaction: %w", err)
+	}
+	steps = append(steps, s.limitStep(tx))
+
 	// Create the transaction first
 	if err := s.repo.Create(ctx, tx); err != nil {
 		transactionErrors.WithLabelValues("transfer", "creation").Inc()
 		return fmt.Errorf("failed to create transaction: %w", err)
 	}
 
-	// Use the processor to handle the transfer
-	if err := s.processor.ProcessTransaction(ctx, tx); err != nil {
-		tx.Status = models.StatusFailed
-		if updateErr := s.repo.Update(ctx, tx); updateErr != nil {
-			s.logger.Error("failed to update failed transaction status", "error", updateErr)
-		}
+	// Use the processor to handle the transfer; it sets the final status
+	if err := s.processor.ProcessTransaction(ctx, tx, steps...); err != nil {
 		transactionErrors.WithLabelValues("transfer", "processing").Inc()
 		return fmt.Errorf("failed to process transfer: %w", err)
 	}
 
-	// Update transaction status to completed
-	tx.Status = models.StatusCompleted
-	if err := s.repo.Update(ctx, tx); err != nil {
-		s.logger.Error("failed to update completed transaction status", "error", err)
-	}
-
 	s.logger.Info("Transfer completed successfully",
 		"transaction_id", tx.ID,
-		"from_user", fromUserID,
-		"to_user", toUserID,
-		"amount", amount)
+		"from_user", tx.FromUserID,
+		"to_user", tx.ToUserID,
+		"amount", tx.Amount,
+		"currency", tx.Currency)
 
 	transactionCounter.WithLabelValues("transfer", "success").Inc()
-	transactionAmount.WithLabelValues("transfer").Observe(amount.InexactFloat64())
+	transactionAmount.WithLabelValues("transfer").Observe(tx.Amount.InexactFloat64())
 
 	return nil
 }
@@ -268,21 +435,45 @@ func (s *TransactionService) GetUserTransactions(ctx context.Context, userID uin
 	return s.repo.GetByUserID(ctx, userID)
 }
 
+// GetTransactionHistory returns one page of the user's history. One extra
+// row is read to tell whether another page follows.
+func (s *TransactionService) GetTransactionHistory(ctx context.Context, filter models.TransactionFilter) (*models.TransactionPage, error) {
+	if err := filter.Normalize(); err != nil {
+		return nil, err
+	}
+
+	limit := filter.Limit
+	filter.Limit++
+	transactions, err := s.repo.FindHistory(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.TransactionPage{Transactions: transactions}
+	if len(transactions) > limit {
+		page.Transactions = transactions[:limit]
+		last := page.Transactions[limit-1]
+		page.NextCursor = models.HistoryCursor{CreatedAt: last.CreatedAt, ID: last.ID, Sort: filter.Sort}.Encode()
+	}
+	return page, nil
+}
+
 func (s *TransactionService) SubmitTransaction(ctx context.Context, tx *models.Transaction) error {
 	activeTransactions.WithLabelValues(string(tx.Type)).Inc()
 	defer activeTransactions.WithLabelValues(string(tx.Type)).Dec()
 
+	tx.Currency = models.NormalizeCurrency(tx.Currency)
+	if err := models.ValidateMoney(tx.Amount, tx.Currency); err != nil {
+		transactionErrors.WithLabelValues(string(tx.Type), "invalid_currency").Inc()
+		return fmt.Errorf("invalid transaction: %w", err)
+	}
+
 	if err := s.CreateTransaction(ctx, tx); err != nil {
 		transactionErrors.WithLabelValues(string(tx.Type), "creation").Inc()
 		return err
 	}
 
 	if err := s.processor.ProcessTransaction(ctx, tx); err != nil {
-		tx.Status = models.StatusFailed
-		if updateErr := s.ProcessTransaction(ctx, tx); updateErr != nil {
-			s.logger.Error("failed to update failed transaction", "error", updateErr)
-			transactionErrors.WithLabelValues(string(tx.Type), "status_update").Inc()
-		}
 		transactionErrors.WithLabelValues(string(tx.Type), "processing").Inc()
 		return fmt.Errorf("failed to process transaction: %w", err)
 	}
@@ -291,21 +482,22 @@ func (s *TransactionService) SubmitTransaction(ctx context.Context, tx *models.T
 	return nil
 }
 
+// GetTransaction returns the transaction if the user in ctx sent or received
+// it, or may read any account. Other users' transactions are reported as
+// missing.
 func (s *TransactionService) GetTransaction(ctx context.Context, transactionID uint) (*models.Transaction, error) {
+	user, ok := auth.GetUserFromContext(ctx)
+	if !ok {
+		return nil, models.ErrUnauthorized
+	}
+
 	tx, err := s.repo.GetByID(ctx, transactionID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transaction: %w", err)
 	}
-
-	userID := auth.GetUserIDFromContext(ctx)
-	if userID == 0 {
-		return nil, fmt.Errorf("unauthorized access")
+	if tx.FromUserID != user.ID && tx.ToUserID != user.ID && !auth.HasPermission(ctx, models.PermissionAccountsRead) {
+		return nil, models.ErrNotFound
 	}
-
-	if tx.FromUserID != userID && tx.ToUserID != userID {
-		return nil, fmt.Errorf("unauthorized access to transaction")
-	}
-
 	return tx, nil
 }
 
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/cache"
+	"ledger-link/pkg/logger"
+)
+
+var errInjected = errors.New("injected failure")
+
+type testLedger struct {
+	db         *gorm.DB
+	txSvc      *TransactionService
+	balanceSvc *BalanceService
+	journalSvc *JournalService
+	webhookSvc *WebhookService
+	updates    *cache.UpdateBroker
+	limitSvc   *LimitService
+	riskSvc    *RiskService
+}
+
+// newTestLedger wires the real repositories and services against a fresh
+// SQLite database with two users holding 100 and 50.
+func newTestLedger(t *testing.T) *testLedger {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "ledger.db") + "?_pragma=synchronous(OFF)&_pragma=journal_mode(MEMORY)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(
+		&models.User{},
+		&models.Balance{},
+		&models.Transaction{},
+		&models.BalanceHistory{},
+		&models.AuditLog{},
+		&models.Posting{},
+		&models.FXQuote{},
+		&models.Hold{},
+		&models.Schedule{},
+		&models.ScheduleRun{},
+		&models.LeaseRecord{},
+		&models.BalanceSnapshot{},
+		&models.ReconciliationRun{},
+		&models.Discrepancy{},
+		&models.WebhookSubscription{},
+		&models.WebhookDelivery{},
+		&models.OutboxEvent{},
+		&models.SpendingLimit{},
+		&models.RiskReview{},
+		&models.Approval{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.APIKey{},
+		&models.Role{},
+		&models.TwoFactor{},
+		&models.RecoveryCode{},
+		&models.LoginChallenge{},
+		&models.LoginLockout{},
+		&models.UserToken{},
+	))
+
+	for i, amount := range []int64{100, 50} {
+		user := &models.User{
+			Username:     []string{"alice", "bob"}[i],
+			Email:        []string{"alice@example.com", "bob@example.com"}[i],
+			PasswordHash: "not-a-real-hash",
+		}
+		require.NoError(t, db.Create(user).Error)
+		require.NoError(t, db.Create(&models.Balance{UserID: user.ID, Amount: decimal.NewFromInt(amount)}).Error)
+		// Opening postings keep the journal in step with the seeded balances
+		require.NoError(t, db.Create(&models.Posting{TransactionID: 1000 + user.ID, AccountType: models.AccountTypeUser, AccountID: user.ID, Currency: models.DefaultCurrency, Amount: decimal.NewFromInt(amount)}).Error)
+		require.NoError(t, db.Create(&models.Posting{TransactionID: 1000 + user.ID, AccountType: models.AccountTypeAdjustment, Currency: models.DefaultCurrency, Amount: decimal.NewFromInt(-amount)}).Error)
+	}
+
+	redisServer := miniredis.RunT(t)
+	cacheService := cache.NewCacheService(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}))
+	log := logger.New("error")
+
+	uow := repositories.NewUnitOfWork(db)
+	journalRepo := repositories.NewJournalRepository(db)
+	balanceRepo := repositories.NewBalanceRepository(db)
+	auditSvc := NewAuditService(repositories.NewAuditLogRepository(db), log)
+	updates := cache.NewUpdateBroker(cacheService, 100)
+	balanceSvc := NewBalanceService(balanceRepo, repositories.NewHoldRepository(db), uow, auditSvc, log, cacheService, updates)
+	limitSvc := NewLimitService(repositories.NewLimitRepository(db), repositories.NewUserRepository(db), uow, auditSvc, log)
+	webhookSvc := NewWebhookService(repositories.NewWebhookRepository(db), uow, auditSvc, repositories.NewLeaseRepository(db), log, time.Second, 3, time.Second, true)
+	riskRules, err := NewRuleEngine(nil)
+	require.NoError(t, err)
+	riskSvc := NewRiskService(riskRules, repositories.NewRiskRepository(db), repositories.NewUserRepository(db), auditSvc, log)
+
+	return &testLedger{
+		db:         db,
+		txSvc:      NewTransactionService(repositories.NewTransactionRepository(db), journalRepo, uow, balanceSvc, auditSvc, webhookSvc, updates, limitSvc, riskSvc, nil, nil, log),
+		balanceSvc: balanceSvc,
+		journalSvc: NewJournalService(journalRepo, balanceRepo, log),
+		webhookSvc: webhookSvc,
+		updates:    updates,
+		limitSvc:   limitSvc,
+		riskSvc:    riskSvc,
+	}
+}
+
+// failOn makes the nth write of the given kind ("create" or "update") to
+// table fail.
+func (l *testLedger) failOn(t *testing.T, op, table string, nth int) {
+	t.Helper()
+
+	processor := l.db.Callback().Create()
+	if op == "update" {
+		processor = l.db.Callback().Update()
+	}
+
+	seen := 0
+	name := "test:fail_" + op + "_" + table
+	require.NoError(t, processor.Before("gorm:"+op).Register(name, func(tx *gorm.DB) {
+		if tx.Statement.Table != table {
+			return
+		}
+		seen++
+		if seen == nth {
+			tx.AddError(errInjected)
+		}
+	}))
+	t.Cleanup(func() { processor.Remove(name) })
+}
+
+func (l *testLedger) balance(t *testing.T, userID uint) decimal.Decimal {
+	t.Helper()
+	return l.balanceIn(t, userID, models.DefaultCurrency)
+}
+
+func (l *testLedger) balanceIn(t *testing.T, userID uint, currency string) decimal.Decimal {
+	t.Helper()
+	var balance models.Balance
+	require.NoError(t, l.db.First(&balance, "user_id = ? AND currency = ?", userID, currency).Error)
+	return balance.Amount
+}
+
+func (l *testLedger) count(t *testing.T, model interface{}) int64 {
+	t.Helper()
+	var n int64
+	require.NoError(t, l.db.Model(model).Count(&n).Error)
+	return n
+}
+
+func (l *testLedger) lastStatus(t *testing.T) models.TransactionStatus {
+	t.Helper()
+	var tx models.Transaction
+	require.NoError(t, l.db.Order("id DESC").First(&tx).Error)
+	return tx.Status
+}
+
+func (l *testLedger) assertNothingPartial(t *testing.T) {
+	t.Helper()
+	assert.True(t, l.balance(t, 1).Equal(decimal.NewFromInt(100)), "sender balance changed")
+	assert.True(t, l.balance(t, 2).Equal(decimal.NewFromInt(50)), "receiver balance changed")
+	assert.Zero(t, l.count(t, &models.BalanceHistory{}), "balance history left behind")
+	assert.Zero(t, l.count(t, &models.AuditLog{}), "audit log left behind")
+	assert.Equal(t, int64(4), l.count(t, &models.Posting{}), "postings left behind")
+	assert.NotEqual(t, models.StatusCompleted, l.lastStatus(t))
+}
+
+func TestTransferCommitsAtomically(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(30), "USD", "rent"))
+
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(70)))
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(80)))
+	assert.Equal(t, int64(2), ledger.count(t, &models.BalanceHistory{}))
+	assert.Equal(t, int64(6), ledger.count(t, &models.Posting{}))
+	assert.Equal(t, models.StatusCompleted, ledger.lastStatus(t))
+
+	report, err := ledger.journalSvc.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "journal invariants violated: %+v", report)
+}
+
+func TestTransferRollsBackOnFailure(t *testing.T) {
+	steps := []struct {
+		name  string
+		op    string
+		table string
+		nth   int
+	}{
+		{"sender balance update", "update", "balances", 1},
+		{"receiver balance update", "update", "balances", 2},
+		{"sender balance history", "create", "balance_history", 1},
+		{"receiver balance history", "create", "balance_history", 2},
+		{"balance audit log", "create", "audit_logs", 1},
+		{"transfer audit log", "create", "audit_logs", 3},
+		{"postings", "create", "postings", 1},
+		{"transaction status", "update", "transactions", 1},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			ledger := newTestLedger(t)
+			ledger.failOn(t, step.op, step.table, step.nth)
+
+			err := ledger.txSvc.Transfer(context.Background(), 1, 2, decimal.NewFromInt(30), "USD", "rent")
+			require.Error(t, err)
+			assert.ErrorIs(t, err, errInjected)
+
+			ledger.assertNothingPartial(t)
+		})
+	}
+}
+
+func TestDebitRollsBackOnFailure(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.failOn(t, "create", "postings", 1)
+
+	err := ledger.txSvc.Debit(context.Background(), 1, decimal.NewFromInt(40), "USD", "atm")
+	require.ErrorIs(t, err, errInjected)
+
+	ledger.assertNothingPartial(t)
+	assert.Equal(t, models.StatusFailed, ledger.lastStatus(t))
+}
+
+func TestDepositRollsBackOnFailure(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.failOn(t, "create", "audit_logs", 2)
+
+	deposit := &models.Transaction{
+		FromUserID: 1,
+		ToUserID:   1,
+		Amount:     decimal.NewFromInt(10),
+		Type:       models.TypeDeposit,
+	}
+	err := ledger.txSvc.SubmitTransaction(context.Background(), deposit)
+	require.ErrorIs(t, err, errInjected)
+
+	ledger.assertNothingPartial(t)
+	assert.Equal(t, models.StatusFailed, ledger.lastStatus(t))
+}
+
+func TestBalancesAreKeptPerCurrency(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+
+	eur := &models.Transaction{
+		FromUserID: 1,
+		ToUserID:   1,
+		Amount:     decimal.RequireFromString("25.50"),
+		Currency:   "eur",
+		Type:       models.TypeDeposit,
+	}
+	require.NoError(t, ledger.txSvc.SubmitTransaction(ctx, eur))
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.RequireFromString("10.25"), "EUR", "dinner"))
+
+	assert.True(t, ledger.balanceIn(t, 1, "EUR").Equal(decimal.RequireFromString("15.25")))
+	assert.True(t, ledger.balanceIn(t, 2, "EUR").Equal(decimal.RequireFromString("10.25")))
+	assert.True(t, ledger.balance(t, 1).Equal(decimal.NewFromInt(100)), "USD balance changed")
+	assert.True(t, ledger.balance(t, 2).Equal(decimal.NewFromInt(50)), "USD balance changed")
+
+	balances, err := ledger.balanceSvc.GetBalances(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, balances, 2)
+	assert.Equal(t, "EUR", balances[0].Currency)
+	assert.Equal(t, "USD", balances[1].Currency)
+
+	history, err := ledger.balanceSvc.GetBalanceHistory(ctx, 1, "EUR", 10)
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	// EUR cannot pay for a USD debit
+	err = ledger.txSvc.Transfer(ctx, 2, 1, decimal.NewFromInt(60), "USD", "too much")
+	require.Error(t, err)
+	assert.True(t, ledger.balanceIn(t, 2, "EUR").Equal(decimal.RequireFromString("10.25")))
+
+	report, err := ledger.journalSvc.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "journal invariants violated: %+v", report)
+}
+
+func TestCurrencyValidation(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+
+	err := ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(1), "XYZ", "")
+	assert.ErrorIs(t, err, models.ErrUnsupportedCurrency)
+
+	err = ledger.txSvc.Transfer(ctx, 1, 2, decimal.RequireFromString("0.001"), "USD", "")
+	assert.ErrorIs(t, err, models.ErrInvalidPrecision)
+
+	err = ledger.txSvc.Debit(ctx, 1, decimal.RequireFromString("1.5"), "JPY", "")
+	assert.ErrorIs(t, err, models.ErrInvalidPrecision)
+
+	assert.Zero(t, ledger.count(t, &models.Transaction{}), "invalid transaction was recorded")
+	assert.Equal(t, int64(2), ledger.count(t, &models.Balance{}), "balance opened for invalid currency")
+}
+
+func TestTransactionHistoryPages(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(int64(i)), "USD", fmt.Sprintf("rent %d%%", i)))
+	}
+	require.Error(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(500), "USD", "too much"))
+
+	// Walking the pages returns every transaction once, newest first
+	var seen []uint
+	filter := models.TransactionFilter{UserID: 1, Limit: 2}
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 4, "pagination does not terminate")
+		page, err := ledger.txSvc.GetTransactionHistory(ctx, filter)
+		require.NoError(t, err)
+		for _, tx := range page.Transactions {
+			seen = append(seen, tx.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor, err = models.DecodeHistoryCursor(page.NextCursor)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []uint{6, 5, 4, 3, 2, 1}, seen)
+
+	page, err := ledger.txSvc.GetTransactionHistory(ctx, models.TransactionFilter{
+		UserID:    2,
+		Statuses:  []models.TransactionStatus{models.StatusCompleted},
+		MinAmount: decimal.NewNullDecimal(decimal.NewFromInt(2)),
+		MaxAmount: decimal.NewNullDecimal(decimal.NewFromInt(4)),
+		Sort:      models.SortOldestFirst,
+	})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 3)
+	assert.Equal(t, uint(2), page.Transactions[0].ID)
+	assert.Empty(t, page.NextCursor)
+
+	// Wildcards in the notes filter match literally
+	page, err = ledger.txSvc.GetTransactionHistory(ctx, models.TransactionFilter{UserID: 1, Notes: "3%", CounterpartyID: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 1)
+	assert.Equal(t, "rent 3%", page.Transactions[0].Notes)
+	page, err = ledger.txSvc.GetTransactionHistory(ctx, models.TransactionFilter{UserID: 1, Notes: "t_3"})
+	require.NoError(t, err)
+	assert.Empty(t, page.Transactions)
+
+	page, err = ledger.txSvc.GetTransactionHistory(ctx, models.TransactionFilter{UserID: 1, CounterpartyID: 3})
+	require.NoError(t, err)
+	assert.Empty(t, page.Transactions)
+
+	cursor := &models.HistoryCursor{ID: 3, Sort: models.SortNewestFirst}
+	_, err = ledger.txSvc.GetTransactionHistory(ctx, models.TransactionFilter{UserID: 1, Sort: models.SortOldestFirst, Cursor: cursor})
+	assert.ErrorIs(t, err, models.ErrInvalidCursor)
+}
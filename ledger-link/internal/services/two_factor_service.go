@@ -0,0 +1,443 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+const (
+	// LoginChallengeTTL is how long a user has to enter their code after
+	// their password was accepted
+	LoginChallengeTTL = 5 * time.Minute
+	// MaxChallengeAttempts is how many codes a login challenge accepts
+	// before it is spent, so codes cannot be guessed
+	MaxChallengeAttempts = 5
+	// MaxCodeAttempts is how many wrong codes in a row a user may give,
+	// across logins, step-ups and managing two-factor authentication,
+	// before their codes are refused for CodeLockout, so codes cannot be
+	// guessed with a stolen password or session
+	MaxCodeAttempts   = 5
+	CodeLockout       = 15 * time.Minute
+	DefaultTOTPIssuer = "Ledger Link"
+)
+
+var twoFactorVerifications = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_two_factor_verifications_total",
+		Help: "Total number of two-factor code checks by purpose and result",
+	},
+	[]string{"purpose", "status"},
+)
+
+// TwoFactorService manages TOTP enrollment and recovery codes, and checks
+// the codes that complete logins and step up sensitive operations. A code
+// is accepted once: TOTP codes at or before the last accepted time step are
+// refused, and recovery codes are spent when used.
+type TwoFactorService struct {
+	repo     models.TwoFactorRepository
+	users    models.UserRepository
+	uow      models.UnitOfWork
+	auditSvc models.AuditService
+	logger   *logger.Logger
+	issuer   string
+}
+
+func NewTwoFactorService(
+	repo models.TwoFactorRepository,
+	users models.UserRepository,
+	uow models.UnitOfWork,
+	auditSvc models.AuditService,
+	logger *logger.Logger,
+	issuer string,
+) *TwoFactorService {
+	if issuer == "" {
+		issuer = DefaultTOTPIssuer
+	}
+	return &TwoFactorService{
+		repo:     repo,
+		users:    users,
+		uow:      uow,
+		auditSvc: auditSvc,
+		logger:   logger,
+		issuer:   issuer,
+	}
+}
+
+// Enroll starts enrollment for the calling user with a new secret. Logins
+// are not protected until Confirm; enrolling again before that replaces
+// the secret.
+func (s *TwoFactorService) Enroll(ctx context.Context) (*models.TwoFactorEnrollment, error) {
+	userID := auth.GetUserIDFromContext(ctx)
+	if userID == 0 {
+		return nil, models.ErrUnauthorized
+	}
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TOTP secret: %w", err)
+	}
+
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		credential, err := s.repo.GetByUserIDForUpdate(ctx, userID)
+		if errors.Is(err, models.ErrNotFound) {
+			credential = &models.TwoFactor{UserID: userID}
+		} else if err != nil {
+			return err
+		}
+		if credential.IsEnabled() {
+			return models.ErrTwoFactorEnabled
+		}
+		credential.Secret = secret
+		credential.LastUsedStep = 0
+		return s.repo.Save(ctx, credential)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(s.issuer, user.Email, secret),
+	}, nil
+}
+
+// Confirm turns two-factor authentication on once code shows the user's
+// authenticator produces the enrolled secret's codes, and returns their
+// recovery codes. They are not stored and cannot be shown again.
+func (s *TwoFactorService) Confirm(ctx context.Context, code string) ([]string, error) {
+	userID := auth.GetUserIDFromContext(ctx)
+	if userID == 0 {
+		return nil, models.ErrUnauthorized
+	}
+	codes, hashes, err := models.NewRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recovery codes: %w", err)
+	}
+
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		credential, err := s.repo.GetByUserIDForUpdate(ctx, userID)
+		if errors.Is(err, models.ErrNotFound) {
+			return models.ErrTwoFactorNotEnrolled
+		}
+		if err != nil {
+			return err
+		}
+		if credential.IsEnabled() {
+			return models.ErrTwoFactorEnabled
+		}
+		if err := s.verifyTOTP(ctx, credential, code); err != nil {
+			return err
+		}
+		now := time.Now()
+		credential.ConfirmedAt = &now
+		if err := s.repo.Save(ctx, credential); err != nil {
+			return err
+		}
+		return s.repo.ReplaceRecoveryCodes(ctx, userID, hashes)
+	})
+	s.observe("confirm", err)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, userID, "Two-factor authentication enabled")
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off for the calling user, given
+// a TOTP code or a recovery code.
+func (s *TwoFactorService) Disable(ctx context.Context, code string) error {
+	userID := auth.GetUserIDFromContext(ctx)
+	if userID == 0 {
+		return models.ErrUnauthorized
+	}
+
+	err := s.checkCode(ctx, userID, code, s.verify, func(ctx context.Context, credential *models.TwoFactor) error {
+		return s.repo.Delete(ctx, userID)
+	})
+	s.observe("disable", err)
+	if err != nil {
+		return err
+	}
+
+	s.audit(ctx, userID, "Two-factor authentication disabled")
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the calling user's recovery codes, used
+// or not, given a TOTP code.
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, code string) ([]string, error) {
+	userID := auth.GetUserIDFromContext(ctx)
+	if userID == 0 {
+		return nil, models.ErrUnauthorized
+	}
+	codes, hashes, err := models.NewRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recovery codes: %w", err)
+	}
+
+	err = s.checkCode(ctx, userID, code, s.verifyTOTP, func(ctx context.Context, credential *models.TwoFactor) error {
+		return s.repo.ReplaceRecoveryCodes(ctx, userID, hashes)
+	})
+	s.observe("recovery_codes", err)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, userID, "Two-factor recovery codes regenerated")
+	return codes, nil
+}
+
+// Status reports whether the calling user has two-factor authentication on.
+func (s *TwoFactorService) Status(ctx context.Context) (*models.TwoFactorStatus, error) {
+	userID := auth.GetUserIDFromContext(ctx)
+	if userID == 0 {
+		return nil, models.ErrUnauthorized
+	}
+
+	credential, err := s.repo.GetByUserID(ctx, userID)
+	if errors.Is(err, models.ErrNotFound) || (err == nil && !credential.IsEnabled()) {
+		return &models.TwoFactorStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := s.repo.CountUnusedRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TwoFactorStatus{
+		Enabled:                true,
+		ConfirmedAt:            credential.ConfirmedAt,
+		RecoveryCodesRemaining: remaining,
+	}, nil
+}
+
+// IsEnabled reports whether the user's logins need a second factor.
+func (s *TwoFactorService) IsEnabled(ctx context.Context, userID uint) (bool, error) {
+	credential, err := s.repo.GetByUserID(ctx, userID)
+	if errors.Is(err, models.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return credential.IsEnabled(), nil
+}
+
+// StepUp checks the fresh TOTP code a sensitive operation of the calling
+// user needs. Users without two-factor authentication, and API keys, which
+// cannot hold a second factor, pass without one. Recovery codes are not
+// accepted: they are for getting back in, not for moving money. Wrong codes
+// count towards MaxCodeAttempts.
+func (s *TwoFactorService) StepUp(ctx context.Context, code string) error {
+	userID := auth.GetUserIDFromContext(ctx)
+	if userID == 0 {
+		return models.ErrUnauthorized
+	}
+	if _, ok := auth.GetAPIKeyFromContext(ctx); ok {
+		return nil
+	}
+	enabled, err := s.IsEnabled(ctx, userID)
+	if err != nil || !enabled {
+		return err
+	}
+	if code == "" {
+		twoFactorVerifications.WithLabelValues("step_up", "missing").Inc()
+		return models.ErrTwoFactorRequired
+	}
+
+	err = s.checkCode(ctx, userID, code, s.verifyTOTP, nil)
+	s.observe("step_up", err)
+	return err
+}
+
+// Challenge opens the second step of the user's login and returns its
+// token.
+func (s *TwoFactorService) Challenge(ctx context.Context, userID uint) (string, time.Time, error) {
+	token, hash, err := models.NewOpaqueToken()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create login challenge: %w", err)
+	}
+	challenge := &models.LoginChallenge{
+		UserID:    userID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(LoginChallengeTTL),
+	}
+	if err := s.repo.CreateChallenge(ctx, challenge); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, challenge.ExpiresAt, nil
+}
+
+// CompleteChallenge checks code, a TOTP code or a recovery code, against
+// the login challenge and returns the user it was for. A challenge is
+// spent once completed or after MaxChallengeAttempts wrong codes. Wrong
+// codes also count towards the user's MaxCodeAttempts, which a new
+// challenge does not start over, and codes are refused while locked.
+func (s *TwoFactorService) CompleteChallenge(ctx context.Context, token, code string) (uint, error) {
+	var userID uint
+	var codeErr error
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		challenge, err := s.repo.GetChallengeForUpdate(ctx, models.HashOpaqueToken(token))
+		if errors.Is(err, models.ErrNotFound) {
+			return models.ErrInvalidChallenge
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if challenge.UsedAt != nil || !now.Before(challenge.ExpiresAt) || challenge.Attempts >= MaxChallengeAttempts {
+			return models.ErrInvalidChallenge
+		}
+
+		challenge.Attempts++
+		// A wrong or locked code must still count as an attempt, so it
+		// does not roll the unit of work back
+		codeErr = s.checkCode(ctx, challenge.UserID, code, s.verify, func(ctx context.Context, credential *models.TwoFactor) error {
+			challenge.UsedAt = &now
+			userID = challenge.UserID
+			return nil
+		})
+		if codeErr != nil && !errors.Is(codeErr, models.ErrInvalidTwoFactorCode) && !errors.Is(codeErr, models.ErrTwoFactorLocked) {
+			return codeErr
+		}
+		return s.repo.UpdateChallenge(ctx, challenge)
+	})
+	if err == nil {
+		err = codeErr
+	}
+	s.observe("login", err)
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// checkCode checks a code of the user with check against their locked
+// credential and, when it is accepted, runs then in the same unit of work. Wrong codes are counted; the one that reaches MaxCodeAttempts locks
+// the user's codes for CodeLockout, and codes are refused with a
+// *models.TwoFactorLockedError until it ends.
+func (s *TwoFactorService) checkCode(ctx context.Context, userID uint, code string, check func(ctx context.Context, credential *models.TwoFactor, code string) error, then func(ctx context.Context, credential *models.TwoFactor) error) error {
+	var codeErr error
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		credential, err := s.enabled(ctx, userID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if credential.IsLocked(now) {
+			return &models.TwoFactorLockedError{LockedUntil: *credential.LockedUntil}
+		}
+
+		codeErr = check(ctx, credential, code)
+		if codeErr == nil {
+			if credential.FailedAttempts > 0 || credential.LockedUntil != nil {
+				credential.FailedAttempts = 0
+				credential.LockedUntil = nil
+				if err := s.repo.Save(ctx, credential); err != nil {
+					return err
+				}
+			}
+			if then == nil {
+				return nil
+			}
+			return then(ctx, credential)
+		}
+		if !errors.Is(codeErr, models.ErrInvalidTwoFactorCode) {
+			return codeErr
+		}
+
+		// A wrong code must still be counted, so it does not roll the
+		// unit of work back
+		credential.FailedAttempts++
+		if credential.FailedAttempts >= MaxCodeAttempts {
+			lockedUntil := now.Add(CodeLockout)
+			credential.FailedAttempts = 0
+			credential.LockedUntil = &lockedUntil
+			codeErr = &models.TwoFactorLockedError{LockedUntil: lockedUntil}
+			s.logger.Warn("two-factor codes locked after failed attempts", "user_id", userID, "locked_until", lockedUntil)
+		}
+		return s.repo.Save(ctx, credential)
+	})
+	if err == nil {
+		err = codeErr
+	}
+	return err
+}
+
+// enabled returns the user's locked credential, or ErrTwoFactorNotEnabled.
+// It must run inside a unit of work.
+func (s *TwoFactorService) enabled(ctx context.Context, userID uint) (*models.TwoFactor, error) {
+	credential, err := s.repo.GetByUserIDForUpdate(ctx, userID)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, models.ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !credential.IsEnabled() {
+		return nil, models.ErrTwoFactorNotEnabled
+	}
+	return credential, nil
+}
+
+// verify accepts a TOTP code or an unused recovery code, which it spends.
+// It must run inside a unit of work.
+func (s *TwoFactorService) verify(ctx context.Context, credential *models.TwoFactor, code string) error {
+	err := s.verifyTOTP(ctx, credential, code)
+	if !errors.Is(err, models.ErrInvalidTwoFactorCode) || code == "" {
+		return err
+	}
+	used, err := s.repo.UseRecoveryCode(ctx, credential.UserID, models.HashRecoveryCode(code), time.Now())
+	if err != nil {
+		return err
+	}
+	if !used {
+		return models.ErrInvalidTwoFactorCode
+	}
+	s.audit(ctx, credential.UserID, "Two-factor recovery code used")
+	return nil
+}
+
+// verifyTOTP accepts a TOTP code of a later time step than the last one
+// accepted. It must run inside a unit of work.
+func (s *TwoFactorService) verifyTOTP(ctx context.Context, credential *models.TwoFactor, code string) error {
+	step, ok := auth.ValidateTOTP(credential.Secret, code, time.Now())
+	if !ok || step <= credential.LastUsedStep {
+		return models.ErrInvalidTwoFactorCode
+	}
+	credential.LastUsedStep = step
+	return s.repo.Save(ctx, credential)
+}
+
+func (s *TwoFactorService) observe(purpose string, err error) {
+	switch {
+	case err == nil:
+		twoFactorVerifications.WithLabelValues(purpose, "success").Inc()
+	case errors.Is(err, models.ErrInvalidTwoFactorCode), errors.Is(err, models.ErrInvalidChallenge):
+		twoFactorVerifications.WithLabelValues(purpose, "failure").Inc()
+	case errors.Is(err, models.ErrTwoFactorLocked):
+		twoFactorVerifications.WithLabelValues(purpose, "locked").Inc()
+	default:
+		twoFactorVerifications.WithLabelValues(purpose, "error").Inc()
+	}
+}
+
+func (s *TwoFactorService) audit(ctx context.Context, userID uint, details string) {
+	if err := s.auditSvc.LogAction(ctx, models.EntityTypeUser, userID, models.ActionUpdate, details); err != nil {
+		s.logger.Error("failed to log two-factor change", "error", err, "user_id", userID)
+	}
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+func newTestTwoFactor(ledger *testLedger) *TwoFactorService {
+	log := logger.New("error")
+	return NewTwoFactorService(repositories.NewTwoFactorRepository(ledger.db), repositories.NewUserRepository(ledger.db),
+		repositories.NewUnitOfWork(ledger.db), NewAuditService(repositories.NewAuditLogRepository(ledger.db), log), log, "")
+}
+
+// enrolledUser registers carol and turns on her two-factor authentication
+// with the code of the previous time step, so the tests can still use the
+// codes of the current and the next step. It returns her context, secret
+// and recovery codes.
+func enrolledUser(t *testing.T, ledger *testLedger, authSvc *AuthService, twoFactor *TwoFactorService) (context.Context, string, []string) {
+	t.Helper()
+	// Keep the test's codes within one step of the clock
+	if untilNext := auth.TOTPPeriod - time.Duration(time.Now().UnixNano())%auth.TOTPPeriod; untilNext < 3*time.Second {
+		time.Sleep(untilNext)
+	}
+
+	tokens, err := authSvc.Register(context.Background(), "carol@example.com", "correct-horse-battery", "carol")
+	require.NoError(t, err)
+	user, err := authSvc.ValidateToken(context.Background(), tokens.Token)
+	require.NoError(t, err)
+	ctx := asUser(user.ID, user.Role)
+
+	enrollment, err := twoFactor.Enroll(ctx)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/Ledger%20Link:carol@example.com?"), enrollment.URI)
+	recoveryCodes, err := twoFactor.Confirm(ctx, totpCode(t, enrollment.Secret, -1))
+	require.NoError(t, err)
+	require.Len(t, recoveryCodes, models.RecoveryCodeCount)
+	return ctx, enrollment.Secret, recoveryCodes
+}
+
+// totpCode returns the secret's code offset steps from now.
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now())+offset)
+	require.NoError(t, err)
+	return code
+}
+
+func TestTwoFactorLoginTakesACodeOnce(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	twoFactor := newTestTwoFactor(ledger)
+	ctx := context.Background()
+
+	_, err := twoFactor.Confirm(asUser(1, models.RoleUser), "123456")
+	assert.ErrorIs(t, err, models.ErrTwoFactorNotEnrolled)
+
+	carol, secret, recoveryCodes := enrolledUser(t, ledger, authSvc, twoFactor)
+	_, err = twoFactor.Enroll(carol)
+	assert.ErrorIs(t, err, models.ErrTwoFactorEnabled)
+	trail := ledger.auditTrail(t, models.EntityTypeUser, auth.GetUserIDFromContext(carol))
+	assert.Equal(t, "Two-factor authentication enabled", trail[len(trail)-1].Details)
+
+	// The password alone only gets a challenge
+	result, err := authSvc.Login(ctx, "carol@example.com", "correct-horse-battery")
+	require.NoError(t, err)
+	assert.True(t, result.TwoFactorRequired)
+	assert.Nil(t, result.TokenPair)
+	require.NotEmpty(t, result.ChallengeToken)
+
+	// The code that confirmed enrollment cannot be replayed
+	_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, totpCode(t, secret, -1))
+	assert.ErrorIs(t, err, models.ErrInvalidTwoFactorCode)
+	tokens, err := authSvc.VerifyLogin(ctx, result.ChallengeToken, totpCode(t, secret, 0))
+	require.NoError(t, err)
+	_, err = authSvc.ValidateToken(ctx, tokens.Token)
+	require.NoError(t, err)
+	_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, totpCode(t, secret, 1))
+	assert.ErrorIs(t, err, models.ErrInvalidChallenge)
+
+	// Recovery codes work once, however they are typed
+	result, err = authSvc.Login(ctx, "carol@example.com", "correct-horse-battery")
+	require.NoError(t, err)
+	_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, " "+strings.ToUpper(recoveryCodes[0]))
+	require.NoError(t, err)
+	result, err = authSvc.Login(ctx, "carol@example.com", "correct-horse-battery")
+	require.NoError(t, err)
+	_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, recoveryCodes[0])
+	assert.ErrorIs(t, err, models.ErrInvalidTwoFactorCode)
+
+	status, err := twoFactor.Status(carol)
+	require.NoError(t, err)
+	assert.True(t, status.Enabled)
+	assert.Equal(t, models.RecoveryCodeCount-1, status.RecoveryCodesRemaining)
+
+	// Disabling with a recovery code drops the second step
+	require.NoError(t, twoFactor.Disable(carol, recoveryCodes[1]))
+	result, err = authSvc.Login(ctx, "carol@example.com", "correct-horse-battery")
+	require.NoError(t, err)
+	assert.False(t, result.TwoFactorRequired)
+	require.NotNil(t, result.TokenPair)
+}
+
+func TestLoginChallengeLimitsAttempts(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	twoFactor := newTestTwoFactor(ledger)
+	ctx := context.Background()
+	_, secret, _ := enrolledUser(t, ledger, authSvc, twoFactor)
+
+	result, err := authSvc.Login(ctx, "carol@example.com", "correct-horse-battery")
+	require.NoError(t, err)
+	for i := 0; i < MaxChallengeAttempts-1; i++ {
+		_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, "000000")
+		assert.ErrorIs(t, err, models.ErrInvalidTwoFactorCode)
+	}
+	// The last attempt also reaches MaxCodeAttempts
+	_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, "000000")
+	assert.ErrorIs(t, err, models.ErrTwoFactorLocked)
+	_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, totpCode(t, secret, 0))
+	assert.ErrorIs(t, err, models.ErrInvalidChallenge)
+	_, err = authSvc.VerifyLogin(ctx, "not-a-challenge", totpCode(t, secret, 0))
+	assert.ErrorIs(t, err, models.ErrInvalidChallenge)
+}
+
+func TestStepUpNeedsAFreshCode(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	twoFactor := newTestTwoFactor(ledger)
+
+	// Without two-factor authentication there is nothing to step up with
+	require.NoError(t, twoFactor.StepUp(asUser(1, models.RoleUser), ""))
+
+	carol, secret, recoveryCodes := enrolledUser(t, ledger, authSvc, twoFactor)
+	assert.ErrorIs(t, twoFactor.StepUp(carol, ""), models.ErrTwoFactorRequired)
+	assert.ErrorIs(t, twoFactor.StepUp(carol, recoveryCodes[0]), models.ErrInvalidTwoFactorCode)
+	code := totpCode(t, secret, 0)
+	require.NoError(t, twoFactor.StepUp(carol, code))
+	assert.ErrorIs(t, twoFactor.StepUp(carol, code), models.ErrInvalidTwoFactorCode)
+
+	// API keys cannot hold a second factor
+	require.NoError(t, twoFactor.StepUp(auth.SetAPIKeyInContext(carol, &models.APIKey{ID: 1}), ""))
+
+	err := authSvc.ChangePassword(carol, "correct-horse-battery", "battery-staple-horse", "")
+	assert.ErrorIs(t, err, models.ErrTwoFactorRequired)
+	require.NoError(t, authSvc.ChangePassword(carol, "correct-horse-battery", "battery-staple-horse", totpCode(t, secret, 1)))
+	_, err = authSvc.Login(context.Background(), "carol@example.com", "correct-horse-battery")
+	assert.ErrorIs(t, err, models.ErrInvalidCredentials)
+	result, err := authSvc.Login(context.Background(), "carol@example.com", "battery-staple-horse")
+	require.NoError(t, err)
+	assert.True(t, result.TwoFactorRequired)
+}
+
+func TestStepUpLocksAfterRepeatedWrongCodes(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	twoFactor := newTestTwoFactor(ledger)
+	carol, secret, recoveryCodes := enrolledUser(t, ledger, authSvc, twoFactor)
+
+	// A right code starts the count again
+	for i := 0; i < MaxCodeAttempts-1; i++ {
+		assert.ErrorIs(t, twoFactor.StepUp(carol, "000000"), models.ErrInvalidTwoFactorCode)
+	}
+	require.NoError(t, twoFactor.StepUp(carol, totpCode(t, secret, 0)))
+
+	for i := 0; i < MaxCodeAttempts-1; i++ {
+		assert.ErrorIs(t, twoFactor.StepUp(carol, "000000"), models.ErrInvalidTwoFactorCode)
+	}
+	err := twoFactor.StepUp(carol, "000000")
+	var locked *models.TwoFactorLockedError
+	require.ErrorAs(t, err, &locked)
+	assert.WithinDuration(t, time.Now().Add(CodeLockout), locked.LockedUntil, time.Minute)
+
+	// While locked, right codes are refused too, wherever they are given
+	assert.ErrorIs(t, twoFactor.StepUp(carol, totpCode(t, secret, 1)), models.ErrTwoFactorLocked)
+	assert.ErrorIs(t, twoFactor.Disable(carol, recoveryCodes[0]), models.ErrTwoFactorLocked)
+	assert.ErrorIs(t, authSvc.ChangePassword(carol, "correct-horse-battery", "a-new-password", totpCode(t, secret, 1)), models.ErrTwoFactorLocked)
+
+	require.NoError(t, ledger.db.Model(&models.TwoFactor{}).Where("user_id = ?", auth.GetUserIDFromContext(carol)).
+		Update("locked_until", time.Now().Add(-time.Second)).Error)
+	require.NoError(t, twoFactor.StepUp(carol, totpCode(t, secret, 1)))
+}
+
+func TestMovingMoneyOutStepsUpPerCurrency(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	twoFactor := newTestTwoFactor(ledger)
+	ledger.txSvc.twoFactor = twoFactor
+	ledger.txSvc.stepUpThresholds = models.CurrencyAmounts{"USD": decimal.NewFromInt(50), "JPY": decimal.NewFromInt(5000)}
+	holds := newTestHolds(ledger)
+	schedules := newTestSchedules(ledger)
+	fx := newTestFX(ledger)
+
+	carol, secret, _ := enrolledUser(t, ledger, authSvc, twoFactor)
+	carolID := auth.GetUserIDFromContext(carol)
+	for currency, amount := range map[string]int64{"USD": 500, "JPY": 50000} {
+		require.NoError(t, ledger.db.Save(&models.Balance{UserID: carolID, Currency: currency, Amount: decimal.NewFromInt(amount)}).Error)
+	}
+	withCode := func(offset int64) context.Context {
+		return auth.SetTOTPCodeInContext(carol, totpCode(t, secret, offset))
+	}
+
+	// The threshold is read in the transfer's own currency
+	require.NoError(t, ledger.txSvc.Transfer(carol, carolID, 2, decimal.NewFromInt(40), "USD", ""))
+	require.NoError(t, ledger.txSvc.Transfer(carol, carolID, 2, decimal.NewFromInt(1000), "JPY", ""))
+	assert.ErrorIs(t, ledger.txSvc.Transfer(carol, carolID, 2, decimal.NewFromInt(6000), "JPY", ""), models.ErrTwoFactorRequired)
+	assert.ErrorIs(t, ledger.txSvc.Transfer(carol, carolID, 2, decimal.NewFromInt(60), "USD", ""), models.ErrTwoFactorRequired)
+	require.NoError(t, ledger.txSvc.Transfer(withCode(0), carolID, 2, decimal.NewFromInt(60), "USD", ""))
+	// A currency without a threshold always needs a code
+	assert.ErrorIs(t, ledger.txSvc.Transfer(carol, carolID, 2, decimal.NewFromInt(1), "EUR", ""), models.ErrTwoFactorRequired)
+
+	// Every other way of moving money out asks for it too
+	assert.ErrorIs(t, ledger.txSvc.Debit(carol, carolID, decimal.NewFromInt(60), "USD", ""), models.ErrTwoFactorRequired)
+
+	quote, err := fx.CreateQuote(carol, carolID, "USD", "EUR", decimal.NewFromInt(60))
+	require.NoError(t, err)
+	_, err = fx.ExecuteQuote(carol, carolID, quote.ID, 2, "")
+	assert.ErrorIs(t, err, models.ErrTwoFactorRequired)
+
+	hold, err := holds.Place(carol, carolID, decimal.NewFromInt(60), "USD", "order-1", "", 0)
+	require.NoError(t, err)
+	_, err = holds.Capture(carol, hold.ID, decimal.Zero, "")
+	assert.ErrorIs(t, err, models.ErrTwoFactorRequired)
+
+	// Schedules ask when they are made, since nobody is there when they run
+	once := func() *models.Schedule {
+		return &models.Schedule{ToUserID: 2, Amount: decimal.NewFromInt(60), Currency: "USD", Kind: models.ScheduleKindOnce, StartAt: time.Now().Add(time.Hour)}
+	}
+	assert.ErrorIs(t, schedules.Create(carol, once()), models.ErrTwoFactorRequired)
+	assert.Zero(t, ledger.count(t, &models.Schedule{}))
+	require.NoError(t, schedules.Create(withCode(1), once()))
+	assert.Equal(t, int64(1), ledger.count(t, &models.Schedule{}))
+}
+
+func TestLoginCodesCountTowardsTheLockout(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	twoFactor := newTestTwoFactor(ledger)
+	ctx := context.Background()
+	carol, secret, _ := enrolledUser(t, ledger, authSvc, twoFactor)
+
+	// Logging in again with the password does not start the count over
+	for i := 0; i < MaxCodeAttempts-1; i++ {
+		result, err := authSvc.Login(ctx, "carol@example.com", "correct-horse-battery")
+		require.NoError(t, err)
+		_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, "000000")
+		assert.ErrorIs(t, err, models.ErrInvalidTwoFactorCode)
+	}
+	result, err := authSvc.Login(ctx, "carol@example.com", "correct-horse-battery")
+	require.NoError(t, err)
+	_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, "000000")
+	assert.ErrorIs(t, err, models.ErrTwoFactorLocked)
+
+	// While locked, the right code neither logs in nor steps up
+	result, err = authSvc.Login(ctx, "carol@example.com", "correct-horse-battery")
+	require.NoError(t, err)
+	_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, totpCode(t, secret, 0))
+	assert.ErrorIs(t, err, models.ErrTwoFactorLocked)
+	assert.ErrorIs(t, twoFactor.StepUp(carol, totpCode(t, secret, 0)), models.ErrTwoFactorLocked)
+
+	require.NoError(t, ledger.db.Model(&models.TwoFactor{}).Where("user_id = ?", auth.GetUserIDFromContext(carol)).
+		Update("locked_until", time.Now().Add(-time.Second)).Error)
+	_, err = authSvc.VerifyLogin(ctx, result.ChallengeToken, totpCode(t, secret, 0))
+	require.NoError(t, err)
+}
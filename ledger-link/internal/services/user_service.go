@@ -2,12 +2,15 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
 	"ledger-link/pkg/logger"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -55,29 +58,100 @@ var (
 		},
 		[]string{"field"},
 	)
+
+	loginLockouts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ledger_login_lockouts_total",
+			Help: "Total number of login lockout events by type",
+		},
+		[]string{"event"}, // event: locked/refused/unlocked
+	)
 )
 
+// LockoutPolicy is how failed logins lock an email address out: from the
+// Threshold-th failure on, for BaseDelay, doubling with each further
+// failure up to MaxDelay. A failure more than Window after the previous one
+// starts the count over.
+type LockoutPolicy struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+}
+
+var DefaultLockoutPolicy = LockoutPolicy{
+	Threshold: 5,
+	BaseDelay: time.Minute,
+	MaxDelay:  time.Hour,
+	Window:    24 * time.Hour,
+}
+
+// delay returns how long the failures lock the email out for.
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// UserService manages users and checks their passwords. Failed logins are
+// counted per email address, so an attacker spreading guesses over many
+// addresses cannot avoid the lockout; unknown addresses are counted and
+// locked like known ones so the answers do not tell them apart.
 type UserService struct {
 	repo           models.UserRepository
+	lockouts       models.LockoutRepository
+	uow            models.UnitOfWork
 	balanceService models.BalanceService
 	auditSvc       models.AuditService
 	logger         *logger.Logger
+	lockout        LockoutPolicy
+	sessions       models.SessionRevoker
 }
 
 func NewUserService(
 	repo models.UserRepository,
+	lockouts models.LockoutRepository,
+	uow models.UnitOfWork,
 	balanceService models.BalanceService,
 	auditSvc models.AuditService,
 	logger *logger.Logger,
+	lockout LockoutPolicy,
 ) *UserService {
+	if lockout.Threshold <= 0 {
+		lockout.Threshold = DefaultLockoutPolicy.Threshold
+	}
+	if lockout.BaseDelay <= 0 {
+		lockout.BaseDelay = DefaultLockoutPolicy.BaseDelay
+	}
+	if lockout.MaxDelay < lockout.BaseDelay {
+		lockout.MaxDelay = lockout.BaseDelay
+	}
+	if lockout.Window <= 0 {
+		lockout.Window = DefaultLockoutPolicy.Window
+	}
 	return &UserService{
 		repo:           repo,
+		lockouts:       lockouts,
+		uow:            uow,
 		balanceService: balanceService,
 		auditSvc:       auditSvc,
 		logger:         logger,
+		lockout:        lockout,
 	}
 }
 
+// SetSessionRevoker sets what ends a user's sessions when their password
+// changes. The auth service is built on the user service, so it is set
+// after both exist.
+func (s *UserService) SetSessionRevoker(sessions models.SessionRevoker) {
+	s.sessions = sessions
+}
+
 func (s *UserService) Create(ctx context.Context, user *models.User) error {
 	timer := prometheus.NewTimer(userOperationDuration.WithLabelValues("create"))
 	defer timer.ObserveDuration()
@@ -96,6 +170,7 @@ func (s *UserService) Create(ctx context.Context, user *models.User) error {
 
 	initialBalance := &models.Balance{
 		UserID:        user.ID,
+		Currency:      models.DefaultCurrency,
 		Amount:        decimal.NewFromInt(0),
 		LastUpdatedAt: time.Now(),
 		CreatedAt:     time.Now(),
@@ -122,17 +197,40 @@ func (s *UserService) GetByEmail(ctx context.Context, email string) (*models.Use
 	return user, nil
 }
 
+// Authenticate checks the password of the account with the email. Wrong
+// passwords and unknown emails both count towards the email's lockout, and
+// get the same answers: ErrInvalidCredentials, or an AccountLockedError
+// once it is locked out. While locked out, no password is checked.
 func (s *UserService) Authenticate(ctx context.Context, email, password string) (*models.User, error) {
-	user, err := s.GetByEmail(ctx, email)
-	if err != nil {
-		if err == models.ErrNotFound {
-			return nil, models.ErrInvalidCredentials
-		}
+	key := models.NormalizeEmail(email)
+	lockout, err := s.lockouts.GetByEmail(ctx, key)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
 		return nil, err
 	}
+	if lockout.IsLocked(time.Now()) {
+		loginLockouts.WithLabelValues("refused").Inc()
+		return nil, &models.AccountLockedError{LockedUntil: *lockout.LockedUntil}
+	}
+
+	user, err := s.repo.GetByEmail(ctx, email)
+	if errors.Is(err, models.ErrNotFound) {
+		// Take as long as a password check, so the response time does not
+		// tell whether the account exists
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, s.recordFailedLogin(ctx, key, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by email: %w", err)
+	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		return nil, models.ErrInvalidCredentials
+		return nil, s.recordFailedLogin(ctx, key, user)
+	}
+
+	if lockout != nil {
+		if err := s.lockouts.DeleteByEmail(ctx, key); err != nil {
+			s.logger.Error("failed to reset failed logins", "error", err, "user_id", user.ID)
+		}
 	}
 
 	details := fmt.Sprintf("User authenticated with email: %s", email)
@@ -143,6 +241,113 @@ func (s *UserService) Authenticate(ctx context.Context, email, password string)
 	return user, nil
 }
 
+// recordFailedLogin counts a failed login of the email, locking it out at
+// the policy's threshold, and returns the error the login fails with.
+func (s *UserService) recordFailedLogin(ctx context.Context, email string, user *models.User) error {
+	var lockedUntil *time.Time
+	var locked bool
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		lockout, err := s.lockouts.GetOrCreateForUpdate(ctx, email)
+		if err != nil {
+			return err
+		}
+
+		// A concurrent failure locked it out already; this one is not
+		// counted, as none are while locked out
+		now := time.Now()
+		if lockout.IsLocked(now) {
+			lockedUntil = lockout.LockedUntil
+			return nil
+		}
+
+		if lockout.LastFailedAt != nil && now.Sub(*lockout.LastFailedAt) > s.lockout.Window {
+			lockout.FailedAttempts = 0
+		}
+		lockout.FailedAttempts++
+		lockout.LastFailedAt = &now
+		if user != nil {
+			lockout.UserID = &user.ID
+		}
+		if lockout.FailedAttempts >= s.lockout.Threshold {
+			until := now.Add(s.lockout.delay(lockout.FailedAttempts))
+			lockout.LockedUntil = &until
+			lockedUntil = &until
+			locked = true
+		}
+		return s.lockouts.Save(ctx, lockout)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record failed login: %w", err)
+	}
+	if lockedUntil == nil {
+		return models.ErrInvalidCredentials
+	}
+
+	if locked {
+		loginLockouts.WithLabelValues("locked").Inc()
+		s.logger.Warn("login locked out after failed attempts", "email", email, "locked_until", *lockedUntil)
+		if user != nil {
+			details := fmt.Sprintf("Login locked until %s after failed attempts", lockedUntil.UTC().Format(time.RFC3339))
+			if err := s.auditSvc.LogAction(ctx, models.EntityTypeUser, user.ID, models.ActionUpdate, details); err != nil {
+				s.logger.Error("failed to log login lockout", "error", err, "user_id", user.ID)
+			}
+		}
+	}
+	return &models.AccountLockedError{LockedUntil: *lockedUntil}
+}
+
+// ListLockouts returns the emails currently locked out. It needs the
+// lockouts:manage permission.
+func (s *UserService) ListLockouts(ctx context.Context) ([]models.LoginLockout, error) {
+	if !auth.HasPermission(ctx, models.PermissionLockoutsManage) {
+		return nil, models.ErrUnauthorized
+	}
+	return s.lockouts.ListLocked(ctx, time.Now())
+}
+
+// Unlock lifts a lockout and clears its failed logins. It needs the
+// lockouts:manage permission.
+func (s *UserService) Unlock(ctx context.Context, id uint) (*models.LoginLockout, error) {
+	admin, ok := auth.GetUserFromContext(ctx)
+	if !ok || !auth.HasPermission(ctx, models.PermissionLockoutsManage) {
+		return nil, models.ErrUnauthorized
+	}
+
+	lockout, err := s.lockouts.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.lockouts.Delete(ctx, id); err != nil {
+		return nil, err
+	}
+
+	loginLockouts.WithLabelValues("unlocked").Inc()
+	s.logger.Info("login lockout reset", "email", lockout.Email, "admin_id", admin.ID)
+	if lockout.UserID != nil {
+		details := fmt.Sprintf("Login lockout reset by admin %d", admin.ID)
+		if err := s.auditSvc.LogAction(ctx, models.EntityTypeUser, *lockout.UserID, models.ActionUpdate, details); err != nil {
+			s.logger.Error("failed to log login unlock", "error", err, "user_id", *lockout.UserID)
+		}
+	}
+	return lockout, nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash is checked against when the account does not exist.
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("ledger-link-no-such-account"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+// ChangePassword sets a new password after checking the old one, and ends
+// every session of the user, including the caller's, in the same unit of
+// work.
 func (s *UserService) ChangePassword(ctx context.Context, userID uint, oldPassword, newPassword string) error {
 	user, err := s.GetByID(ctx, userID)
 	if err != nil {
@@ -153,6 +358,18 @@ func (s *UserService) ChangePassword(ctx context.Context, userID uint, oldPasswo
 		return models.ErrInvalidCredentials
 	}
 
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.SetPassword(ctx, user, newPassword, "Password changed"); err != nil {
+			return err
+		}
+		return s.RevokeSessions(ctx, user.ID)
+	})
+}
+
+// SetPassword hashes and stores a new password for the user and audits it
+// with details. It does not end the user's sessions; callers run
+// RevokeSessions in the same unit of work.
+func (s *UserService) SetPassword(ctx context.Context, user *models.User, newPassword, details string) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
@@ -165,7 +382,6 @@ func (s *UserService) ChangePassword(ctx context.Context, userID uint, oldPasswo
 		return fmt.Errorf("failed to update user password: %w", err)
 	}
 
-	details := "Password changed"
 	if err := s.auditSvc.LogAction(ctx, models.EntityTypeUser, user.ID, models.ActionUpdate, details); err != nil {
 		s.logger.Error("failed to log password change", "error", err)
 	}
@@ -173,6 +389,26 @@ func (s *UserService) ChangePassword(ctx context.Context, userID uint, oldPasswo
 	return nil
 }
 
+// RevokeSessions ends every active session of the user, so a stolen token
+// stops working once the password is changed.
+func (s *UserService) RevokeSessions(ctx context.Context, userID uint) error {
+	if s.sessions == nil {
+		return nil
+	}
+	revoked, err := s.sessions.RevokeUserSessions(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	s.logger.Info("sessions revoked after password change", "user_id", userID, "sessions", revoked)
+	return nil
+}
+
+// ClearLockout forgets the failed logins of the email, as once its owner
+// proves who they are some other way.
+func (s *UserService) ClearLockout(ctx context.Context, email string) error {
+	return s.lockouts.DeleteByEmail(ctx, models.NormalizeEmail(email))
+}
+
 func (s *UserService) CanAccessUser(requestingUser *models.User, targetUserID uint) bool {
 	return requestingUser.Role == models.RoleAdmin || requestingUser.ID == targetUserID
 }
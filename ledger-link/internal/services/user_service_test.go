@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/logger"
+)
+
+func newTestUsers(ledger *testLedger, policy LockoutPolicy) *UserService {
+	log := logger.New("error")
+	return NewUserService(repositories.NewUserRepository(ledger.db), repositories.NewLockoutRepository(ledger.db),
+		repositories.NewUnitOfWork(ledger.db), ledger.balanceSvc, NewAuditService(repositories.NewAuditLogRepository(ledger.db), log), log, policy)
+}
+
+// lockedUntil asserts err is a lockout and returns when it ends.
+func lockedUntil(t *testing.T, err error) time.Time {
+	t.Helper()
+	var locked *models.AccountLockedError
+	require.ErrorAs(t, err, &locked)
+	return locked.LockedUntil
+}
+
+func TestFailedLoginsLockTheEmailOut(t *testing.T) {
+	ledger := newTestLedger(t)
+	users := newTestUsers(ledger, LockoutPolicy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: 3 * time.Minute})
+	ctx := context.Background()
+
+	carol := &models.User{Email: "carol@example.com", Username: "carol"}
+	require.NoError(t, carol.SetPassword("correct-horse-battery"))
+	carol, err := users.Register(ctx, carol)
+	require.NoError(t, err)
+
+	// An unknown email gets the same answers as a known one
+	for _, email := range []string{"carol@example.com", "nobody@example.com"} {
+		for i := 0; i < 2; i++ {
+			_, err = users.Authenticate(ctx, email, "wrong-password")
+			assert.ErrorIs(t, err, models.ErrInvalidCredentials, email)
+		}
+		_, err = users.Authenticate(ctx, email, "wrong-password")
+		assert.WithinDuration(t, time.Now().Add(time.Minute), lockedUntil(t, err), 5*time.Second, email)
+	}
+
+	// Locked out, not even the right password gets in, in any case
+	_, err = users.Authenticate(ctx, "CAROL@example.com", "correct-horse-battery")
+	assert.ErrorIs(t, err, models.ErrAccountLocked)
+	trail := ledger.auditTrail(t, models.EntityTypeUser, carol.ID)
+	assert.Contains(t, trail[len(trail)-1].Details, "Login locked until")
+
+	// Each failure after the lockout ends doubles it, up to the maximum
+	for _, want := range []time.Duration{2 * time.Minute, 3 * time.Minute} {
+		require.NoError(t, ledger.db.Model(&models.LoginLockout{}).Where("email = ?", "carol@example.com").
+			Update("locked_until", time.Now().Add(-time.Second)).Error)
+		_, err = users.Authenticate(ctx, "carol@example.com", "wrong-password")
+		assert.WithinDuration(t, time.Now().Add(want), lockedUntil(t, err), 5*time.Second)
+	}
+
+	_, err = users.ListLockouts(asUser(carol.ID, models.RoleUser))
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+	admin := asUser(10, models.RoleAdmin)
+	lockouts, err := users.ListLockouts(admin)
+	require.NoError(t, err)
+	require.Len(t, lockouts, 2)
+	var carolLockout models.LoginLockout
+	for _, lockout := range lockouts {
+		if lockout.Email == "carol@example.com" {
+			carolLockout = lockout
+		}
+	}
+	require.NotNil(t, carolLockout.UserID)
+	assert.Equal(t, carol.ID, *carolLockout.UserID)
+	assert.Equal(t, 5, carolLockout.FailedAttempts)
+
+	_, err = users.Unlock(admin, carolLockout.ID)
+	require.NoError(t, err)
+	trail = ledger.auditTrail(t, models.EntityTypeUser, carol.ID)
+	assert.Equal(t, "Login lockout reset by admin 10", trail[len(trail)-1].Details)
+	_, err = users.Unlock(admin, carolLockout.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	// A successful login starts the count over
+	_, err = users.Authenticate(ctx, "carol@example.com", "wrong-password")
+	assert.ErrorIs(t, err, models.ErrInvalidCredentials)
+	_, err = users.Authenticate(ctx, "carol@example.com", "correct-horse-battery")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = users.Authenticate(ctx, "carol@example.com", "wrong-password")
+		assert.ErrorIs(t, err, models.ErrInvalidCredentials)
+	}
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+const (
+	DefaultPasswordResetTTL     = time.Hour
+	DefaultEmailVerificationTTL = 48 * time.Hour
+	// userTokenResendInterval is how soon after one token another is mailed
+	// for the same purpose, so the endpoints cannot flood an inbox
+	userTokenResendInterval = time.Minute
+)
+
+var userTokensMailed = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_user_tokens_mailed_total",
+		Help: "Total number of email verification and password reset mails by result",
+	},
+	[]string{"purpose", "status"},
+)
+
+// UserTokenService mails users single-use links to verify their email
+// address and to reset their password, and redeems the tokens in them.
+// Issuing a token spends the earlier unused ones for the same purpose.
+// Password reset links are mailed in the background.
+type UserTokenService struct {
+	repo            models.UserTokenRepository
+	users           models.UserRepository
+	userSvc         *UserService
+	uow             models.UnitOfWork
+	mailer          models.Mailer
+	auditSvc        models.AuditService
+	logger          *logger.Logger
+	baseURL         string
+	resetTTL        time.Duration
+	verificationTTL time.Duration
+	wg              sync.WaitGroup
+}
+
+func NewUserTokenService(
+	repo models.UserTokenRepository,
+	users models.UserRepository,
+	userSvc *UserService,
+	uow models.UnitOfWork,
+	mailer models.Mailer,
+	auditSvc models.AuditService,
+	logger *logger.Logger,
+	baseURL string,
+	resetTTL time.Duration,
+	verificationTTL time.Duration,
+) *UserTokenService {
+	if resetTTL <= 0 {
+		resetTTL = DefaultPasswordResetTTL
+	}
+	if verificationTTL <= 0 {
+		verificationTTL = DefaultEmailVerificationTTL
+	}
+	return &UserTokenService{
+		repo:            repo,
+		users:           users,
+		userSvc:         userSvc,
+		uow:             uow,
+		mailer:          mailer,
+		auditSvc:        auditSvc,
+		logger:          logger,
+		baseURL:         strings.TrimRight(baseURL, "/"),
+		resetTTL:        resetTTL,
+		verificationTTL: verificationTTL,
+	}
+}
+
+// SendEmailVerification mails the user a link to verify their email
+// address, as after they register.
+func (s *UserTokenService) SendEmailVerification(ctx context.Context, user *models.User) error {
+	if user.IsEmailVerified() {
+		return models.ErrEmailAlreadyVerified
+	}
+	body := "Welcome to Ledger Link. Confirm your email address by opening this link within %s:\n\n%s\n\n" +
+		"Until you do, you cannot withdraw or transfer funds. If you did not sign up, ignore this email."
+	return s.send(ctx, user, models.TokenPurposeEmailVerification, s.verificationTTL, "Verify your email address", body, "/verify-email")
+}
+
+// RequestEmailVerification mails the calling user a new verification link.
+func (s *UserTokenService) RequestEmailVerification(ctx context.Context) error {
+	userID := auth.GetUserIDFromContext(ctx)
+	if userID == 0 {
+		return models.ErrUnauthorized
+	}
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.SendEmailVerification(ctx, user)
+}
+
+// VerifyEmail redeems an email verification token.
+func (s *UserTokenService) VerifyEmail(ctx context.Context, token string) error {
+	var userID uint
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		stored, err := s.redeem(ctx, token, models.TokenPurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+		userID = stored.UserID
+		return s.users.MarkEmailVerified(ctx, userID, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.auditSvc.LogAction(ctx, models.EntityTypeUser, userID, models.ActionUpdate, "Email address verified"); err != nil {
+		s.logger.Error("failed to log email verification", "error", err, "user_id", userID)
+	}
+	return nil
+}
+
+// RequestPasswordReset mails a password reset link to the account with the
+// email, if there is one. The account is looked up and the link issued and
+// mailed after it returns, so neither its answer nor how long it takes tells
+// which accounts exist.
+func (s *UserTokenService) RequestPasswordReset(ctx context.Context, email string) error {
+	ctx = context.WithoutCancel(ctx)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.sendPasswordReset(ctx, strings.TrimSpace(email))
+	}()
+	return nil
+}
+
+func (s *UserTokenService) sendPasswordReset(ctx context.Context, email string) {
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, models.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to look up password reset account", "error", err)
+		return
+	}
+
+	body := "Someone asked to reset the password of your Ledger Link account. Choose a new one by opening this link within %s:\n\n%s\n\n" +
+		"It signs you out everywhere. If you did not ask for it, ignore this email; your password stays the same."
+	if err := s.send(ctx, user, models.TokenPurposePasswordReset, s.resetTTL, "Reset your password", body, "/reset-password"); err != nil {
+		s.logger.Error("failed to send password reset", "error", err, "user_id", user.ID)
+	}
+}
+
+// Stop waits for the password reset mails still being sent.
+func (s *UserTokenService) Stop() {
+	s.logger.Info("stopping user token mails")
+	s.wg.Wait()
+}
+
+// ResetPassword redeems a password reset token: it sets the new password
+// and ends every session of the user in the same unit of work, so the
+// password never changes while an old session lives on. Opening the link
+// also proves the user receives mail at their address, so it is marked
+// verified.
+func (s *UserTokenService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	var user *models.User
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		stored, err := s.redeem(ctx, token, models.TokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+		if user, err = s.users.GetByID(ctx, stored.UserID); err != nil {
+			return err
+		}
+		if err := s.userSvc.SetPassword(ctx, user, newPassword, "Password reset by email"); err != nil {
+			return err
+		}
+		if err := s.userSvc.RevokeSessions(ctx, user.ID); err != nil {
+			return err
+		}
+		if user.IsEmailVerified() {
+			return nil
+		}
+		return s.users.MarkEmailVerified(ctx, user.ID, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.userSvc.ClearLockout(ctx, user.Email); err != nil {
+		s.logger.Error("failed to reset failed logins", "error", err, "user_id", user.ID)
+	}
+	return nil
+}
+
+// send issues a token for the purpose and mails its link, unless one was
+// mailed less than userTokenResendInterval ago.
+func (s *UserTokenService) send(ctx context.Context, user *models.User, purpose string, ttl time.Duration, subject, body, path string) error {
+	token, hash, err := models.NewOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("failed to create token: %w", err)
+	}
+
+	var throttled bool
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		now := time.Now()
+		latest, err := s.repo.GetLatest(ctx, user.ID, purpose)
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			return err
+		}
+		if latest != nil && now.Sub(latest.CreatedAt) < userTokenResendInterval {
+			throttled = true
+			return nil
+		}
+		if err := s.repo.InvalidateUnused(ctx, user.ID, purpose, now); err != nil {
+			return err
+		}
+		return s.repo.Create(ctx, &models.UserToken{
+			UserID:    user.ID,
+			Purpose:   purpose,
+			TokenHash: hash,
+			ExpiresAt: now.Add(ttl),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if throttled {
+		userTokensMailed.WithLabelValues(purpose, "throttled").Inc()
+		return nil
+	}
+
+	link := s.baseURL + path + "?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, &models.MailMessage{
+		To:      user.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf(body, ttl, link),
+	})
+	if err != nil {
+		userTokensMailed.WithLabelValues(purpose, "failure").Inc()
+		return fmt.Errorf("failed to mail %s: %w", strings.ReplaceAll(purpose, "_", " "), err)
+	}
+	userTokensMailed.WithLabelValues(purpose, "success").Inc()
+	return nil
+}
+
+// redeem spends a valid token for the purpose. It must run inside a unit
+// of work.
+func (s *UserTokenService) redeem(ctx context.Context, token, purpose string) (*models.UserToken, error) {
+	stored, err := s.repo.GetByHashForUpdate(ctx, models.HashOpaqueToken(token))
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, models.ErrInvalidUserToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored.Purpose != purpose || !stored.IsValid(now) {
+		return nil, models.ErrInvalidUserToken
+	}
+	stored.UsedAt = &now
+	if err := s.repo.Update(ctx, stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
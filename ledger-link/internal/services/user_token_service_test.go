@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+)
+
+// testMailer keeps the messages it is asked to send.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []models.MailMessage
+}
+
+func (m *testMailer) Send(ctx context.Context, message *models.MailMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, *message)
+	return nil
+}
+
+func (m *testMailer) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sent)
+}
+
+var tokenLinkPattern = regexp.MustCompile(`https://app\.example\.com(/[a-z-]+)\?token=(\S+)`)
+
+// lastToken returns the token of the link in the last message to the
+// address, and checks the link points at the page.
+func (m *testMailer) lastToken(t *testing.T, to, page string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To != to {
+			continue
+		}
+		match := tokenLinkPattern.FindStringSubmatch(m.sent[i].Body)
+		require.NotNil(t, match, m.sent[i].Body)
+		assert.Equal(t, page, match[1])
+		token, err := url.QueryUnescape(match[2])
+		require.NoError(t, err)
+		return token
+	}
+	t.Fatalf("no mail sent to %s", to)
+	return ""
+}
+
+// testUserTokens returns the user token service of newTestAuth and its
+// mailer.
+func testUserTokens(authSvc *AuthService) (*UserTokenService, *testMailer) {
+	tokens := authSvc.userTokens.(*UserTokenService)
+	return tokens, tokens.mailer.(*testMailer)
+}
+
+// registerCarol registers carol and returns her user and session tokens.
+func registerCarol(t *testing.T, ledger *testLedger, authSvc *AuthService) (*models.User, *models.TokenPair) {
+	t.Helper()
+	tokens, err := authSvc.Register(context.Background(), "carol@example.com", "correct-horse-battery", "carol")
+	require.NoError(t, err)
+	var carol models.User
+	require.NoError(t, ledger.db.Where("email = ?", "carol@example.com").First(&carol).Error)
+	return &carol, tokens
+}
+
+func TestRegistrationMailsSingleUseVerificationLink(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	userTokens, mailer := testUserTokens(authSvc)
+	ctx := context.Background()
+
+	carol, _ := registerCarol(t, ledger, authSvc)
+	assert.False(t, carol.IsEmailVerified())
+	token := mailer.lastToken(t, "carol@example.com", "/verify-email")
+
+	// A verification token does not reset passwords
+	assert.ErrorIs(t, userTokens.ResetPassword(ctx, token, "another-password"), models.ErrInvalidUserToken)
+
+	require.NoError(t, userTokens.VerifyEmail(ctx, token))
+	verified, err := authSvc.userSvc.GetByID(ctx, carol.ID)
+	require.NoError(t, err)
+	assert.True(t, verified.IsEmailVerified())
+	assert.ErrorIs(t, userTokens.VerifyEmail(ctx, token), models.ErrInvalidUserToken)
+	assert.ErrorIs(t, userTokens.VerifyEmail(ctx, "not-a-token"), models.ErrInvalidUserToken)
+
+	carolCtx := auth.SetUserInContext(ctx, verified)
+	assert.ErrorIs(t, userTokens.RequestEmailVerification(carolCtx), models.ErrEmailAlreadyVerified)
+
+	trail := ledger.auditTrail(t, models.EntityTypeUser, carol.ID)
+	assert.Equal(t, "Email address verified", trail[len(trail)-1].Details)
+}
+
+func TestVerificationLinksExpireAndAreReplaced(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	userTokens, mailer := testUserTokens(authSvc)
+	ctx := context.Background()
+
+	carol, _ := registerCarol(t, ledger, authSvc)
+	first := mailer.lastToken(t, "carol@example.com", "/verify-email")
+	carolCtx := auth.SetUserInContext(ctx, carol)
+
+	// Asking again at once sends nothing
+	require.NoError(t, userTokens.RequestEmailVerification(carolCtx))
+	assert.Equal(t, 1, mailer.count())
+
+	// Later a new link is sent and the first stops working
+	require.NoError(t, ledger.db.Model(&models.UserToken{}).Where("user_id = ?", carol.ID).
+		Update("created_at", time.Now().Add(-2*userTokenResendInterval)).Error)
+	require.NoError(t, userTokens.RequestEmailVerification(carolCtx))
+	assert.Equal(t, 2, mailer.count())
+	second := mailer.lastToken(t, "carol@example.com", "/verify-email")
+	assert.NotEqual(t, first, second)
+	assert.ErrorIs(t, userTokens.VerifyEmail(ctx, first), models.ErrInvalidUserToken)
+
+	require.NoError(t, ledger.db.Model(&models.UserToken{}).Where("user_id = ?", carol.ID).
+		Update("expires_at", time.Now().Add(-time.Second)).Error)
+	assert.ErrorIs(t, userTokens.VerifyEmail(ctx, second), models.ErrInvalidUserToken)
+}
+
+func TestPasswordResetEndsSessions(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	userTokens, mailer := testUserTokens(authSvc)
+	ctx := context.Background()
+
+	carol, session := registerCarol(t, ledger, authSvc)
+
+	// Unknown addresses get the same answer, and no mail
+	require.NoError(t, userTokens.RequestPasswordReset(ctx, "nobody@example.com"))
+	userTokens.Stop()
+	assert.Equal(t, 1, mailer.count())
+
+	// The link is mailed after the request has been answered
+	cancelled, cancel := context.WithCancel(ctx)
+	require.NoError(t, userTokens.RequestPasswordReset(cancelled, "carol@example.com"))
+	cancel()
+	userTokens.Stop()
+	assert.Equal(t, 2, mailer.count())
+	token := mailer.lastToken(t, "carol@example.com", "/reset-password")
+	assert.ErrorIs(t, userTokens.VerifyEmail(ctx, token), models.ErrInvalidUserToken)
+
+	require.NoError(t, userTokens.ResetPassword(ctx, token, "a-new-password"))
+	assert.ErrorIs(t, userTokens.ResetPassword(ctx, token, "yet-another-password"), models.ErrInvalidUserToken)
+
+	_, err := authSvc.ValidateToken(ctx, session.Token)
+	assert.ErrorIs(t, err, auth.ErrRevokedToken)
+	_, err = authSvc.RefreshToken(ctx, session.RefreshToken)
+	assert.Error(t, err)
+
+	_, err = authSvc.Login(ctx, "carol@example.com", "correct-horse-battery")
+	assert.ErrorIs(t, err, models.ErrInvalidCredentials)
+	_, err = authSvc.Login(ctx, "carol@example.com", "a-new-password")
+	require.NoError(t, err)
+
+	// Following the link proved she reads mail at the address
+	reset, err := authSvc.userSvc.GetByID(ctx, carol.ID)
+	require.NoError(t, err)
+	assert.True(t, reset.IsEmailVerified())
+}
+
+func TestChangePasswordEndsSessions(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	ctx := context.Background()
+
+	carol, session := registerCarol(t, ledger, authSvc)
+	carolCtx := auth.SetUserInContext(ctx, carol)
+
+	assert.ErrorIs(t, authSvc.ChangePassword(carolCtx, "wrong-password", "a-new-password", ""), models.ErrInvalidCredentials)
+	_, err := authSvc.ValidateToken(ctx, session.Token)
+	require.NoError(t, err)
+
+	require.NoError(t, authSvc.ChangePassword(carolCtx, "correct-horse-battery", "a-new-password", ""))
+	_, err = authSvc.ValidateToken(ctx, session.Token)
+	assert.ErrorIs(t, err, auth.ErrRevokedToken)
+	_, err = authSvc.Login(ctx, "carol@example.com", "a-new-password")
+	require.NoError(t, err)
+}
+
+// failingRevoker cannot end sessions.
+type failingRevoker struct{}
+
+func (failingRevoker) RevokeUserSessions(ctx context.Context, userID uint) (int, error) {
+	return 0, errors.New("session store unavailable")
+}
+
+func TestPasswordStaysWhenSessionsCannotEnd(t *testing.T) {
+	ledger := newTestLedger(t)
+	authSvc, _ := newTestAuth(t, ledger)
+	userTokens, mailer := testUserTokens(authSvc)
+	ctx := context.Background()
+
+	carol, _ := registerCarol(t, ledger, authSvc)
+	carolCtx := auth.SetUserInContext(ctx, carol)
+	require.NoError(t, userTokens.RequestPasswordReset(ctx, "carol@example.com"))
+	userTokens.Stop()
+	token := mailer.lastToken(t, "carol@example.com", "/reset-password")
+
+	userSvc := authSvc.userSvc.(*UserService)
+	userSvc.SetSessionRevoker(failingRevoker{})
+	assert.Error(t, authSvc.ChangePassword(carolCtx, "correct-horse-battery", "a-new-password", ""))
+	assert.Error(t, userTokens.ResetPassword(ctx, token, "a-new-password"))
+
+	_, err := authSvc.Login(ctx, "carol@example.com", "a-new-password")
+	assert.ErrorIs(t, err, models.ErrInvalidCredentials)
+	_, err = authSvc.Login(ctx, "carol@example.com", "correct-horse-battery")
+	require.NoError(t, err)
+
+	// The link was not spent
+	userSvc.SetSessionRevoker(authSvc)
+	require.NoError(t, userTokens.ResetPassword(ctx, token, "a-new-password"))
+}
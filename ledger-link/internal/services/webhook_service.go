@@ -0,0 +1,536 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+var webhookAttempts = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ledger_webhook_delivery_attempts_total",
+		Help: "Total number of webhook delivery attempts by outcome",
+	},
+	[]string{"status"},
+)
+
+const (
+	DefaultWebhookInterval    = 5 * time.Second
+	DefaultWebhookMaxAttempts = 8
+	DefaultWebhookTimeout     = 10 * time.Second
+
+	webhookRetryDelay      = 30 * time.Second
+	webhookMaxRetryDelay   = 6 * time.Hour
+	webhookBatchSize       = 100
+	webhookDeliveriesLimit = 100
+	webhookLeaseName       = "webhooks"
+	webhookLeaseTicks      = 3
+	// webhookRedeliverInterval is how long after its last attempt a delivery
+	// may be redelivered
+	webhookRedeliverInterval = time.Minute
+	// webhookErrorBodyLimit is how much of a failed response is kept with the
+	// delivery
+	webhookErrorBodyLimit = 512
+)
+
+// WebhookService keeps webhook subscriptions and delivers transaction
+// lifecycle events to them. Events are queued as deliveries in the unit of
+// work that changes the transaction's status, so a crash cannot lose them,
+// and are sent in the background by the replica holding the webhook lease.
+// Failed deliveries are retried with exponential backoff until they run out
+// of attempts and are kept as dead letters, which can be redelivered.
+//
+// Endpoints must be https URLs on public addresses, checked when the
+// subscription is created and again on every connection so a host cannot
+// later resolve into the service's own network. Redirects are not followed.
+// allowInsecure lifts both rules so local endpoints can be used in
+// development.
+type WebhookService struct {
+	repo          models.WebhookRepository
+	uow           models.UnitOfWork
+	auditSvc      models.AuditService
+	lease         models.Lease
+	client        *http.Client
+	logger        *logger.Logger
+	interval      time.Duration
+	maxAttempts   int
+	allowInsecure bool
+	holder        string
+	stopChan      chan struct{}
+	wg            sync.WaitGroup
+}
+
+func NewWebhookService(
+	repo models.WebhookRepository,
+	uow models.UnitOfWork,
+	auditSvc models.AuditService,
+	lease models.Lease,
+	logger *logger.Logger,
+	interval time.Duration,
+	maxAttempts int,
+	timeout time.Duration,
+	allowInsecure bool,
+) *WebhookService {
+	if interval <= 0 {
+		interval = DefaultWebhookInterval
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultWebhookMaxAttempts
+	}
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowInsecure {
+		dialer.Control = checkWebhookDial
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	hostname, _ := os.Hostname()
+	return &WebhookService{
+		repo:     repo,
+		uow:      uow,
+		auditSvc: auditSvc,
+		lease:    lease,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger:        logger,
+		interval:      interval,
+		maxAttempts:   maxAttempts,
+		allowInsecure: allowInsecure,
+		holder:        fmt.Sprintf("%s-%s", hostname, uuid.NewString()),
+		stopChan:      make(chan struct{}),
+	}
+}
+
+// webhookAddressAllowed reports whether webhooks may be sent to ip. Loopback,
+// private, link-local, multicast and unspecified addresses lead into the
+// service's own network rather than to a subscriber.
+func webhookAddressAllowed(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsUnspecified()
+}
+
+// checkWebhookDial refuses connections to addresses webhooks may not be sent
+// to. It runs after the host has been resolved, on the address actually
+// dialed.
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !webhookAddressAllowed(ip) {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
+
+// checkEndpoint requires the subscription URL to be https and every address
+// its host resolves to to be public.
+func (s *WebhookService) checkEndpoint(ctx context.Context, rawURL string) error {
+	if s.allowInsecure {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", models.ErrInvalidWebhook)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%w: url must use https", models.ErrInvalidWebhook)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("%w: cannot resolve %s", models.ErrInvalidWebhook, u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !webhookAddressAllowed(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to a private address", models.ErrInvalidWebhook, u.Hostname())
+		}
+	}
+	return nil
+}
+
+// CreateSubscription subscribes url to the events of the current user's
+// transactions, or of every transaction when global is set, which only
+// admins may do. The returned subscription carries the signing secret; it is
+// not shown again.
+func (s *WebhookService) CreateSubscription(ctx context.Context, url string, events []string, global bool) (*models.WebhookSubscription, error) {
+	user, ok := auth.GetUserFromContext(ctx)
+	if !ok {
+		return nil, models.ErrUnauthorized
+	}
+	if global && user.Role != models.RoleAdmin {
+		return nil, fmt.Errorf("%w: only admins can subscribe to every user's events", models.ErrForbidden)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	subscription := &models.WebhookSubscription{
+		URL:       url,
+		Secret:    "whsec_" + hex.EncodeToString(secret),
+		Events:    events,
+		CreatedBy: user.ID,
+	}
+	if !global {
+		subscription.UserID = &user.ID
+	}
+	if subscription.Events == nil {
+		subscription.Events = []string{}
+	}
+	if err := subscription.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.checkEndpoint(ctx, subscription.URL); err != nil {
+		return nil, err
+	}
+
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateSubscription(ctx, subscription); err != nil {
+			return err
+		}
+
+		details := fmt.Sprintf("Subscribed %s to %v", subscription.URL, subscription.Events)
+		if global {
+			details = fmt.Sprintf("Subscribed %s to %v of every user", subscription.URL, subscription.Events)
+		}
+		return s.auditSvc.LogAction(ctx, models.EntityTypeWebhook, subscription.ID, models.ActionCreate, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// GetSubscription returns the subscription if the user in ctx owns it or is
+// an admin. Other users' subscriptions are reported as missing.
+func (s *WebhookService) GetSubscription(ctx context.Context, id uint) (*models.WebhookSubscription, error) {
+	user, ok := auth.GetUserFromContext(ctx)
+	if !ok {
+		return nil, models.ErrUnauthorized
+	}
+
+	subscription, err := s.repo.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role != models.RoleAdmin && (subscription.IsGlobal() || *subscription.UserID != user.ID) {
+		return nil, models.ErrNotFound
+	}
+	return subscription, nil
+}
+
+// ListSubscriptions returns the current user's subscriptions; admins also
+// see the global ones.
+func (s *WebhookService) ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	user, ok := auth.GetUserFromContext(ctx)
+	if !ok {
+		return nil, models.ErrUnauthorized
+	}
+	return s.repo.ListSubscriptions(ctx, user.ID, user.Role == models.RoleAdmin)
+}
+
+// DeleteSubscription stops the subscription. Its pending deliveries are
+// dropped into the dead letters when they come up.
+func (s *WebhookService) DeleteSubscription(ctx context.Context, id uint) error {
+	subscription, err := s.GetSubscription(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.repo.DeleteSubscription(ctx, subscription.ID); err != nil {
+			return err
+		}
+		details := fmt.Sprintf("Unsubscribed %s", subscription.URL)
+		return s.auditSvc.LogAction(ctx, models.EntityTypeWebhook, subscription.ID, models.ActionDelete, details)
+	})
+}
+
+// ListDeliveries returns the most recent deliveries of a subscription the
+// user in ctx may see, optionally filtered by status. Only admins see what
+// the endpoint answered; other users get its status code.
+func (s *WebhookService) ListDeliveries(ctx context.Context, subscriptionID uint, status models.WebhookDeliveryStatus) ([]models.WebhookDelivery, error) {
+	if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.repo.ListDeliveries(ctx, subscriptionID, status, webhookDeliveriesLimit)
+	if err != nil {
+		return nil, err
+	}
+	if user, _ := auth.GetUserFromContext(ctx); user.Role != models.RoleAdmin {
+		for i := range deliveries {
+			deliveries[i].Redact()
+		}
+	}
+	return deliveries, nil
+}
+
+// Redeliver queues a delivery of the subscription to be sent again on the
+// next tick with a fresh set of attempts, whatever became of it before. A
+// delivery that is still queued, or was attempted less than a minute ago,
+// cannot be redelivered.
+func (s *WebhookService) Redeliver(ctx context.Context, subscriptionID, deliveryID uint) (*models.WebhookDelivery, error) {
+	if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	var delivery *models.WebhookDelivery
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		delivery, err = s.repo.GetDelivery(ctx, deliveryID)
+		if err != nil {
+			return err
+		}
+		if delivery.SubscriptionID != subscriptionID {
+			return models.ErrNotFound
+		}
+
+		now := time.Now()
+		if delivery.Status == models.WebhookDeliveryPending ||
+			(delivery.LastAttemptAt != nil && now.Sub(*delivery.LastAttemptAt) < webhookRedeliverInterval) {
+			return models.ErrRedeliveryTooSoon
+		}
+
+		delivery.Redeliver(now)
+		if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		details := fmt.Sprintf("Redelivered %s event %s (delivery %d)", delivery.EventType, delivery.EventID, delivery.ID)
+		return s.auditSvc.LogAction(ctx, models.EntityTypeWebhook, subscriptionID, models.ActionUpdate, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if user, _ := auth.GetUserFromContext(ctx); user.Role != models.RoleAdmin {
+		delivery.Redact()
+	}
+	return delivery, nil
+}
+
+// StatusChanged queues the event raised by the transaction's new status for
+// every subscription of the users involved and every global subscription
+// that wants it. It must run in the unit of work that stores the status.
+func (s *WebhookService) StatusChanged(ctx context.Context, tx *models.Transaction) error {
+	event := models.EventForStatus(tx.Status)
+	if event == "" {
+		return nil
+	}
+
+	userIDs := []uint{tx.FromUserID}
+	if tx.ToUserID != tx.FromUserID {
+		userIDs = append(userIDs, tx.ToUserID)
+	}
+	subscriptions, err := s.repo.ListSubscriptionsFor(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	eventID := uuid.NewString()
+	var deliveries []models.WebhookDelivery
+	var payload []byte
+	for i := range subscriptions {
+		if !subscriptions[i].Wants(event) {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(models.NewWebhookEvent(eventID, event, tx, now))
+			if err != nil {
+				return fmt.Errorf("failed to encode webhook event: %w", err)
+			}
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			SubscriptionID: subscriptions[i].ID,
+			EventID:        eventID,
+			EventType:      event,
+			TransactionID:  tx.ID,
+			Payload:        string(payload),
+			Status:         models.WebhookDeliveryPending,
+			NextAttemptAt:  &now,
+		})
+	}
+	return s.repo.CreateDeliveries(ctx, deliveries)
+}
+
+// DeliverDue sends every delivery that is due and returns how many the
+// endpoints accepted.
+func (s *WebhookService) DeliverDue(ctx context.Context) (int, error) {
+	due, err := s.repo.ListDueDeliveries(ctx, time.Now(), webhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	subscriptions := make(map[uint]*models.WebhookSubscription)
+	delivered := 0
+	for i := range due {
+		delivery := &due[i]
+		subscription, ok := subscriptions[delivery.SubscriptionID]
+		if !ok {
+			subscription, err = s.repo.GetSubscription(ctx, delivery.SubscriptionID)
+			if err != nil && err != models.ErrNotFound {
+				return delivered, err
+			}
+			subscriptions[delivery.SubscriptionID] = subscription
+		}
+
+		if s.deliver(ctx, subscription, delivery) {
+			delivered++
+		}
+		if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// deliver makes one attempt at sending the delivery and records its outcome
+// on it. A nil subscription has been deleted.
+func (s *WebhookService) deliver(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery) bool {
+	if subscription == nil {
+		delivery.RecordFailure(time.Now(), 0, "subscription deleted", 0, 0, 0)
+		webhookAttempts.WithLabelValues(string(models.WebhookDeliveryDead)).Inc()
+		return false
+	}
+
+	statusCode, err := s.send(ctx, subscription, delivery)
+	now := time.Now()
+	if err == nil {
+		delivery.RecordSuccess(now, statusCode)
+		webhookAttempts.WithLabelValues(string(models.WebhookDeliveryDelivered)).Inc()
+		return true
+	}
+
+	delivery.RecordFailure(now, statusCode, err.Error(), s.maxAttempts, webhookRetryDelay, webhookMaxRetryDelay)
+	webhookAttempts.WithLabelValues(string(delivery.Status)).Inc()
+	if delivery.Status == models.WebhookDeliveryDead {
+		s.logger.Error("webhook delivery dead-lettered",
+			"delivery_id", delivery.ID,
+			"subscription_id", subscription.ID,
+			"attempts", delivery.Attempts,
+			"error", err)
+	} else {
+		s.logger.Warn("webhook delivery failed",
+			"delivery_id", delivery.ID,
+			"subscription_id", subscription.ID,
+			"attempt", delivery.Attempts,
+			"next_attempt_at", delivery.NextAttemptAt,
+			"error", err)
+	}
+	return false
+}
+
+// send posts the signed payload to the subscription's URL. Any 2xx response
+// accepts the delivery.
+func (s *WebhookService) send(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ledger-link-webhooks/1.0")
+	req.Header.Set(models.WebhookEventHeader, delivery.EventType)
+	req.Header.Set(models.WebhookDeliveryHeader, delivery.EventID)
+	req.Header.Set(models.WebhookSignatureHeader, models.SignWebhook(subscription.Secret, time.Now(), body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, webhookErrorBodyLimit))
+		return resp.StatusCode, fmt.Errorf("endpoint responded %d: %s", resp.StatusCode, bytes.TrimSpace(excerpt))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookErrorBodyLimit))
+	return resp.StatusCode, nil
+}
+
+// Start sends due deliveries on every tick until Stop is called. Ticks only
+// run on the replica holding the webhook lease.
+func (s *WebhookService) Start(ctx context.Context) error {
+	s.logger.Info("starting webhook delivery", "interval", s.interval, "holder", s.holder)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.tick(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *WebhookService) tick(ctx context.Context) {
+	ok, err := s.lease.Acquire(ctx, webhookLeaseName, s.holder, webhookLeaseTicks*s.interval)
+	if err != nil {
+		s.logger.Error("failed to acquire webhook lease", "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if n, err := s.DeliverDue(ctx); err != nil {
+		s.logger.Error("failed to deliver webhooks", "error", err)
+	} else if n > 0 {
+		s.logger.Info("delivered webhooks", "count", n)
+	}
+}
+
+func (s *WebhookService) Stop() {
+	s.logger.Info("stopping webhook delivery")
+	close(s.stopChan)
+	s.wg.Wait()
+
+	if err := s.lease.Release(context.Background(), webhookLeaseName, s.holder); err != nil {
+		s.logger.Error("failed to release webhook lease", "error", err)
+	}
+}
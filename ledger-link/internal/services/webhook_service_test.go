@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/internal/repositories"
+	"ledger-link/pkg/logger"
+)
+
+// webhookEndpoint records the webhook requests it receives and answers them
+// with status.
+type webhookEndpoint struct {
+	mu       sync.Mutex
+	status   int
+	events   []models.WebhookEvent
+	verified []bool
+	secret   string
+}
+
+func (e *webhookEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	var event models.WebhookEvent
+	json.Unmarshal(body, &event)
+	e.events = append(e.events, event)
+
+	// Recompute the signature with the timestamp the request was signed at
+	signature := r.Header.Get(models.WebhookSignatureHeader)
+	ts := strings.TrimPrefix(strings.Split(signature, ",")[0], "t=")
+	signedAt, _ := strconv.ParseInt(ts, 10, 64)
+	e.verified = append(e.verified, signature == models.SignWebhook(e.secret, time.Unix(signedAt, 0), body))
+
+	w.WriteHeader(e.status)
+}
+
+func TestWebhooksDeliverSignedEvents(t *testing.T) {
+	ledger := newTestLedger(t)
+	webhooks := ledger.webhookSvc
+	ctx := context.Background()
+
+	endpoint := &webhookEndpoint{status: http.StatusOK}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	mine, err := webhooks.CreateSubscription(asUser(1, models.RoleUser), server.URL, []string{models.EventTransactionCompleted}, false)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(mine.Secret, "whsec_"))
+
+	_, err = webhooks.CreateSubscription(asUser(2, models.RoleUser), server.URL, nil, true)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	global, err := webhooks.CreateSubscription(asUser(2, models.RoleAdmin), server.URL, nil, true)
+	require.NoError(t, err)
+
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", ""))
+	// The failed transfer's delivery survives its rolled back unit of work
+	require.Error(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(1000), "USD", ""))
+
+	mineDeliveries, err := webhooks.ListDeliveries(asUser(1, models.RoleUser), mine.ID, "")
+	require.NoError(t, err)
+	require.Len(t, mineDeliveries, 1)
+	assert.Equal(t, models.EventTransactionCompleted, mineDeliveries[0].EventType)
+
+	globalDeliveries, err := webhooks.ListDeliveries(asUser(2, models.RoleAdmin), global.ID, "")
+	require.NoError(t, err)
+	require.Len(t, globalDeliveries, 2)
+	assert.Equal(t, models.EventTransactionFailed, globalDeliveries[0].EventType)
+
+	_, err = webhooks.ListDeliveries(asUser(2, models.RoleUser), mine.ID, "")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	// Both subscriptions share the endpoint; the first is signed with mine's
+	// secret and verifies, the global ones do not
+	endpoint.secret = mine.Secret
+	delivered, err := webhooks.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, delivered)
+
+	require.Len(t, endpoint.events, 3)
+	assert.Equal(t, []bool{true, false, false}, endpoint.verified)
+	assert.Equal(t, models.EventTransactionCompleted, endpoint.events[0].Type)
+	assert.Equal(t, models.StatusCompleted, endpoint.events[0].Data.Status)
+	assert.True(t, endpoint.events[0].Data.Amount.Equal(decimal.NewFromInt(10)))
+	assert.Equal(t, models.EventTransactionFailed, endpoint.events[2].Type)
+
+	delivered, err = webhooks.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+}
+
+func TestWebhooksRetryIntoDeadLettersAndRedeliver(t *testing.T) {
+	ledger := newTestLedger(t)
+	webhooks := ledger.webhookSvc
+	ctx := context.Background()
+	owner := asUser(1, models.RoleUser)
+
+	endpoint := &webhookEndpoint{status: http.StatusInternalServerError}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	subscription, err := webhooks.CreateSubscription(owner, server.URL, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", ""))
+
+	delivery := func() models.WebhookDelivery {
+		deliveries, err := webhooks.ListDeliveries(owner, subscription.ID, "")
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		return deliveries[0]
+	}
+	makeDue := func() {
+		require.NoError(t, ledger.db.Exec("UPDATE webhook_deliveries SET next_attempt_at = ?", time.Now().Add(-time.Second)).Error)
+	}
+
+	_, err = webhooks.DeliverDue(ctx)
+	require.NoError(t, err)
+	first := delivery()
+	assert.Equal(t, models.WebhookDeliveryPending, first.Status)
+	assert.Equal(t, 1, first.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, first.LastStatusCode)
+	assert.WithinDuration(t, time.Now().Add(webhookRetryDelay), *first.NextAttemptAt, 5*time.Second)
+
+	// Not due again until the backoff has passed
+	_, err = webhooks.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivery().Attempts)
+
+	makeDue()
+	_, err = webhooks.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(2*webhookRetryDelay), *delivery().NextAttemptAt, 5*time.Second)
+
+	// The test ledger gives up after three attempts
+	makeDue()
+	_, err = webhooks.DeliverDue(ctx)
+	require.NoError(t, err)
+	dead := delivery()
+	assert.Equal(t, models.WebhookDeliveryDead, dead.Status)
+	assert.Nil(t, dead.NextAttemptAt)
+
+	deadLetters, err := webhooks.ListDeliveries(owner, subscription.ID, models.WebhookDeliveryDead)
+	require.NoError(t, err)
+	assert.Len(t, deadLetters, 1)
+
+	_, err = webhooks.Redeliver(asUser(2, models.RoleUser), subscription.ID, dead.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	// Not right after the last attempt, nor while the delivery is queued
+	_, err = webhooks.Redeliver(owner, subscription.ID, dead.ID)
+	assert.ErrorIs(t, err, models.ErrRedeliveryTooSoon)
+	require.NoError(t, ledger.db.Exec("UPDATE webhook_deliveries SET last_attempt_at = ?", time.Now().Add(-webhookRedeliverInterval)).Error)
+	redelivered, err := webhooks.Redeliver(owner, subscription.ID, dead.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookDeliveryPending, redelivered.Status)
+	_, err = webhooks.Redeliver(owner, subscription.ID, dead.ID)
+	assert.ErrorIs(t, err, models.ErrRedeliveryTooSoon)
+
+	trail := ledger.auditTrail(t, models.EntityTypeWebhook, subscription.ID)
+	require.Len(t, trail, 2)
+	assert.Equal(t, models.ActionUpdate, trail[1].Action)
+	assert.Contains(t, trail[1].Details, dead.EventID)
+
+	endpoint.status = http.StatusNoContent
+	delivered, err := webhooks.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, models.WebhookDeliveryDelivered, delivery().Status)
+	assert.Len(t, endpoint.events, 4)
+
+	// Each attempt carried the same event
+	for _, event := range endpoint.events {
+		assert.Equal(t, dead.EventID, event.ID)
+	}
+}
+
+func TestWebhooksOnlyReachPublicHTTPSEndpoints(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := context.Background()
+	owner := asUser(1, models.RoleUser)
+	strict := NewWebhookService(repositories.NewWebhookRepository(ledger.db), repositories.NewUnitOfWork(ledger.db),
+		NewAuditService(repositories.NewAuditLogRepository(ledger.db), logger.New("error")),
+		repositories.NewLeaseRepository(ledger.db), logger.New("error"), time.Second, 3, time.Second, false)
+
+	for _, url := range []string{
+		"http://example.com/hooks",
+		"https://127.0.0.1/hooks",
+		"https://localhost/hooks",
+		"https://10.1.2.3/hooks",
+		"https://192.168.0.1/hooks",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/hooks",
+		"https://[fe80::1]/hooks",
+		"https://224.0.0.1/hooks",
+		"https://0.0.0.0/hooks",
+	} {
+		_, err := strict.CreateSubscription(owner, url, nil, false)
+		assert.ErrorIs(t, err, models.ErrInvalidWebhook, url)
+	}
+
+	// A host that resolves into the network after subscribing is refused
+	// when connecting
+	endpoint := &webhookEndpoint{status: http.StatusOK}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	subscription, err := ledger.webhookSvc.CreateSubscription(owner, server.URL, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", ""))
+
+	delivered, err := strict.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+	assert.Empty(t, endpoint.events)
+
+	deliveries, err := strict.ListDeliveries(asUser(2, models.RoleAdmin), subscription.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Contains(t, deliveries[0].LastError, "not allowed")
+}
+
+func TestWebhooksDoNotFollowRedirectsOrShowResponsesToUsers(t *testing.T) {
+	ledger := newTestLedger(t)
+	webhooks := ledger.webhookSvc
+	ctx := context.Background()
+	owner := asUser(1, models.RoleUser)
+
+	target := &webhookEndpoint{status: http.StatusOK}
+	targetServer := httptest.NewServer(target)
+	defer targetServer.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", targetServer.URL)
+		w.WriteHeader(http.StatusFound)
+		w.Write([]byte("internal details"))
+	}))
+	defer redirect.Close()
+
+	subscription, err := webhooks.CreateSubscription(owner, redirect.URL, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, ledger.txSvc.Transfer(ctx, 1, 2, decimal.NewFromInt(10), "USD", ""))
+
+	delivered, err := webhooks.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+	assert.Empty(t, target.events)
+
+	deliveries, err := webhooks.ListDeliveries(owner, subscription.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, http.StatusFound, deliveries[0].LastStatusCode)
+	assert.Equal(t, "endpoint responded 302", deliveries[0].LastError)
+
+	deliveries, err = webhooks.ListDeliveries(asUser(2, models.RoleAdmin), subscription.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Contains(t, deliveries[0].LastError, "internal details")
+}
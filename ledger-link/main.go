@@ -41,14 +41,71 @@ func main() {
 		log.Fatal("failed to initialize service container", "error", err)
 	}
 
+	// Subcommands run against the same services and exit without serving
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		os.Exit(runReconcile(container, os.Args[2:]))
+	}
+
+	// Start the batch deposit workers and, next to them, the scheduler that
+	// submits due standing orders
+	if err := container.TransactionService.Start(context.Background()); err != nil {
+		log.Fatal("failed to start transaction processor", "error", err)
+	}
+	if err := container.ScheduleService.Start(context.Background()); err != nil {
+		log.Fatal("failed to start scheduler", "error", err)
+	}
+
+	// Take the daily balance snapshots behind as-of balance queries
+	if err := container.SnapshotService.Start(context.Background()); err != nil {
+		log.Fatal("failed to start balance snapshots", "error", err)
+	}
+
+	// Reconcile balances against their transactions on a schedule
+	if err := container.ReconciliationService.Start(context.Background()); err != nil {
+		log.Fatal("failed to start scheduled reconciliation", "error", err)
+	}
+
+	// Send queued webhook deliveries
+	if err := container.WebhookService.Start(context.Background()); err != nil {
+		log.Fatal("failed to start webhook delivery", "error", err)
+	}
+
+	// Publish ledger events from the outbox
+	if err := container.OutboxRelay.Start(context.Background()); err != nil {
+		log.Fatal("failed to start outbox relay", "error", err)
+	}
+
+	// Release expired holds in the background
+	if err := container.HoldService.Start(context.Background()); err != nil {
+		log.Fatal("failed to start hold sweeper", "error", err)
+	}
+
 	// Initialize router with handlers and middleware
 	router := router.NewRouter(
 		container.AuthHandler,
 		container.UserHandler,
 		container.TransactionHandler,
 		container.BalanceHandler,
-		middleware.NewAuthMiddleware(container.AuthService, log),
+		container.LedgerHandler,
+		container.FXHandler,
+		container.ReversalHandler,
+		container.HoldHandler,
+		container.ScheduleHandler,
+		container.StatementHandler,
+		container.ReconciliationHandler,
+		container.WebhookHandler,
+		container.StreamHandler,
+		container.LimitHandler,
+		container.RiskHandler,
+		container.ApprovalHandler,
+		container.JWKSHandler,
+		container.APIKeyHandler,
+		container.RoleHandler,
+		container.AuditHandler,
+		container.TwoFactorHandler,
+		middleware.NewAuthMiddleware(container.AuthService, container.APIKeyService, container.RoleService, cfg.APIKeys.TrustProxy, log),
 		middleware.NewRBACMiddleware(log),
+		middleware.NewIdempotencyMiddleware(container.IdempotencyStore, cfg.Idempotency.TTL, log),
 		ratelimit.NewRateLimiter(container.CacheService.RedisClient),
 	)
 
@@ -106,6 +163,14 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("server forced to shutdown", "error", err)
 	}
+	container.HoldService.Stop()
+	container.OutboxRelay.Stop()
+	container.WebhookService.Stop()
+	container.ReconciliationService.Stop()
+	container.SnapshotService.Stop()
+	container.ScheduleService.Stop()
+	container.TransactionService.Stop()
+	container.UserTokenService.Stop()
 
 	log.Info("server exited properly")
 }
@@ -11,6 +11,11 @@ const (
 	userIDKey      contextKey = "user_id"
 	userContextKey contextKey = "user"
 	userRoleKey    contextKey = "user_role"
+	userAgentKey   contextKey = "user_agent"
+	clientIPKey    contextKey = "client_ip"
+	apiKeyKey      contextKey = "api_key"
+	permissionsKey contextKey = "permissions"
+	totpCodeKey    contextKey = "totp_code"
 )
 
 // GetUserFromContext retrieves the user from the context
@@ -41,3 +46,66 @@ func SetUserInContext(ctx context.Context, user *models.User) context.Context {
 	ctx = context.WithValue(ctx, userRoleKey, user.Role)
 	return ctx
 }
+
+// SetClientInContext records the user agent and address of the client a
+// session is opened from
+func SetClientInContext(ctx context.Context, userAgent, ipAddress string) context.Context {
+	ctx = context.WithValue(ctx, userAgentKey, userAgent)
+	return context.WithValue(ctx, clientIPKey, ipAddress)
+}
+
+// GetClientFromContext retrieves the client's user agent and address
+func GetClientFromContext(ctx context.Context) (userAgent, ipAddress string) {
+	userAgent, _ = ctx.Value(userAgentKey).(string)
+	ipAddress, _ = ctx.Value(clientIPKey).(string)
+	return userAgent, ipAddress
+}
+
+// SetAPIKeyInContext records the API key a request was authenticated with
+func SetAPIKeyInContext(ctx context.Context, key *models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey, key)
+}
+
+// GetAPIKeyFromContext retrieves the API key of the request; it is absent
+// for requests authenticated with an access token
+func GetAPIKeyFromContext(ctx context.Context) (*models.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyKey).(*models.APIKey)
+	return key, ok
+}
+
+// SetPermissionsInContext records the permissions the user's role holds
+func SetPermissionsInContext(ctx context.Context, permissions []string) context.Context {
+	return context.WithValue(ctx, permissionsKey, permissions)
+}
+
+// HasPermission reports whether the user in the context holds permission.
+// Admins hold every permission.
+func HasPermission(ctx context.Context, permission string) bool {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return false
+	}
+	if user.Role == models.RoleAdmin {
+		return true
+	}
+	permissions, _ := ctx.Value(permissionsKey).([]string)
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTOTPCodeInContext records the fresh TOTP code a request brought to step
+// up a sensitive operation
+func SetTOTPCodeInContext(ctx context.Context, code string) context.Context {
+	return context.WithValue(ctx, totpCodeKey, code)
+}
+
+// GetTOTPCodeFromContext retrieves the request's step-up code; it is empty
+// when the request brought none
+func GetTOTPCodeFromContext(ctx context.Context) string {
+	code, _ := ctx.Value(totpCodeKey).(string)
+	return code
+}
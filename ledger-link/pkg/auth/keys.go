@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var ErrInvalidKey = errors.New("invalid signing key")
+
+// JWK is the public half of a verification key as published in a JWKS
+// (RFC 7517): n and e for RSA keys, crv and x for Ed25519 keys.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySet is implemented by token makers whose verification keys can be
+// published for other services.
+type KeySet interface {
+	JWKS() JWKSet
+}
+
+// VerificationKey is a public key tokens are verified with, named by its
+// kid. Its ID is the RFC 7638 thumbprint of the key, so every replica
+// derives the same kid from the same key.
+type VerificationKey struct {
+	ID        string
+	Algorithm string
+	Public    crypto.PublicKey
+}
+
+// NewVerificationKey returns the verification key for an RSA or Ed25519
+// public key.
+func NewVerificationKey(public crypto.PublicKey) (*VerificationKey, error) {
+	key := &VerificationKey{Public: public}
+	switch public.(type) {
+	case *rsa.PublicKey:
+		key.Algorithm = AlgorithmRS256
+	case ed25519.PublicKey:
+		key.Algorithm = AlgorithmEdDSA
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidKey, public)
+	}
+
+	// RFC 7638 hashes the required members in lexicographic order
+	var members []byte
+	jwk := key.JWK()
+	var err error
+	if jwk.KeyType == "RSA" {
+		members, err = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N})
+	} else {
+		members, err = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X})
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(members)
+	key.ID = base64.RawURLEncoding.EncodeToString(sum[:])
+	return key, nil
+}
+
+// JWK returns the key as published in the JWKS.
+func (k *VerificationKey) JWK() JWK {
+	jwk := JWK{KeyID: k.ID, Algorithm: k.Algorithm, Use: "sig"}
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+func (k *VerificationKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// KeySetMaker signs tokens with one private key and verifies them against
+// every key in its set, which holds the signing key and the keys it is
+// rotating from or to. Tokens carry the kid of the key that signed them.
+type KeySetMaker struct {
+	signer  crypto.Signer
+	signing *VerificationKey
+	keys    map[string]*VerificationKey
+	order   []string
+}
+
+// NewRS256Maker signs tokens with an RSA key; the extra keys are accepted
+// for verification only.
+func NewRS256Maker(private *rsa.PrivateKey, verification ...crypto.PublicKey) (*KeySetMaker, error) {
+	if private.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("%w: RSA keys need at least 2048 bits", ErrInvalidKey)
+	}
+	return newKeySetMaker(private, verification)
+}
+
+// NewEdDSAMaker signs tokens with an Ed25519 key; the extra keys are
+// accepted for verification only.
+func NewEdDSAMaker(private ed25519.PrivateKey, verification ...crypto.PublicKey) (*KeySetMaker, error) {
+	if len(private) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%w: malformed Ed25519 key", ErrInvalidKey)
+	}
+	return newKeySetMaker(private, verification)
+}
+
+func newKeySetMaker(signer crypto.Signer, verification []crypto.PublicKey) (*KeySetMaker, error) {
+	m := &KeySetMaker{signer: signer, keys: make(map[string]*VerificationKey)}
+	for _, public := range append([]crypto.PublicKey{signer.Public()}, verification...) {
+		key, err := NewVerificationKey(public)
+		if err != nil {
+			return nil, err
+		}
+		if m.signing == nil {
+			m.signing = key
+		}
+		if _, ok := m.keys[key.ID]; ok {
+			continue
+		}
+		m.keys[key.ID] = key
+		m.order = append(m.order, key.ID)
+	}
+	return m, nil
+}
+
+// NewKeySetMakerFromPEM loads the signing key from a PEM file and the extra
+// verification keys from PEM files holding public or private keys. The
+// signing key's type picks RS256 or EdDSA.
+func NewKeySetMakerFromPEM(privateKeyFile string, verificationFiles ...string) (*KeySetMaker, error) {
+	private, err := LoadPrivateKeyPEM(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	var verification []crypto.PublicKey
+	for _, file := range verificationFiles {
+		public, err := LoadPublicKeyPEM(file)
+		if err != nil {
+			return nil, err
+		}
+		verification = append(verification, public)
+	}
+
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		return NewRS256Maker(key, verification...)
+	case ed25519.PrivateKey:
+		return NewEdDSAMaker(key, verification...)
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %T in %s", ErrInvalidKey, private, privateKeyFile)
+	}
+}
+
+// LoadPrivateKeyPEM reads an RSA (PKCS #1 or PKCS #8) or Ed25519 (PKCS #8)
+// private key.
+func LoadPrivateKeyPEM(file string) (crypto.Signer, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidKey, file, err)
+		}
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidKey, file, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s does not hold a signing key", ErrInvalidKey, file)
+	}
+	return signer, nil
+}
+
+// LoadPublicKeyPEM reads a public key, or the public half of a private key,
+// from a PEM file.
+func LoadPublicKeyPEM(file string) (crypto.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidKey, file, err)
+		}
+		return key, nil
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidKey, file, err)
+		}
+		return key, nil
+	default:
+		private, err := LoadPrivateKeyPEM(file)
+		if err != nil {
+			return nil, err
+		}
+		return private.Public(), nil
+	}
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s holds no PEM block", ErrInvalidKey, file)
+	}
+	return block, nil
+}
+
+// KeyID returns the kid of the key tokens are signed with.
+func (m *KeySetMaker) KeyID() string {
+	return m.signing.ID
+}
+
+func (m *KeySetMaker) CreateToken(userID uint, role string, sessionID uint, duration time.Duration) (string, *Claims, error) {
+	claims := NewClaims(userID, role, sessionID, duration)
+
+	token := jwt.NewWithClaims(m.signing.method(), claims)
+	token.Header["kid"] = m.signing.ID
+	signed, err := token.SignedString(m.signer)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// VerifyToken accepts tokens signed by any key in the set, with the
+// algorithm of that key; tokens without a known kid are invalid.
+func (m *KeySetMaker) VerifyToken(tokenStr string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		if token.Method.Alg() != key.method().Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.Public, nil
+	})
+
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys tokens are verified with, signing key first.
+func (m *KeySetMaker) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(m.order))}
+	for _, kid := range m.order {
+		set.Keys = append(set.Keys, m.keys[kid].JWK())
+	}
+	return set
+}
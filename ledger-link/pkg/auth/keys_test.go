@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return file
+}
+
+func TestKeySetMakerRotatesKeys(t *testing.T) {
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	oldMaker, err := NewEdDSAMaker(oldKey)
+	require.NoError(t, err)
+	oldToken, _, err := oldMaker.CreateToken(1, "user", 7, time.Minute)
+	require.NoError(t, err)
+
+	// The new key signs while the old one is still accepted
+	maker, err := NewRS256Maker(newKey, oldKey.Public())
+	require.NoError(t, err)
+	token, claims, err := maker.CreateToken(2, "admin", 8, time.Minute)
+	require.NoError(t, err)
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, maker.KeyID(), parsed.Header["kid"])
+	assert.Equal(t, AlgorithmRS256, parsed.Header["alg"])
+
+	verified, err := maker.VerifyToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, claims.ID, verified.ID)
+	verified, err = maker.VerifyToken(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, uint(7), verified.SessionID)
+
+	// Once the old key is dropped its tokens no longer verify
+	_, err = oldMaker.VerifyToken(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	jwks := maker.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, JWK{KeyType: "RSA", KeyID: maker.KeyID(), Algorithm: AlgorithmRS256, Use: "sig", N: jwks.Keys[0].N, E: "AQAB"}, jwks.Keys[0])
+	assert.Equal(t, "OKP", jwks.Keys[1].KeyType)
+	assert.Equal(t, oldMaker.KeyID(), jwks.Keys[1].KeyID)
+}
+
+func TestKeySetMakerRejectsForeignTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	maker, err := NewRS256Maker(key)
+	require.NoError(t, err)
+
+	// A token MACed with the public key under the right kid is refused
+	public := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, NewClaims(1, "admin", 0, time.Minute))
+	forged.Header["kid"] = maker.KeyID()
+	signed, err := forged.SignedString(public)
+	require.NoError(t, err)
+	_, err = maker.VerifyToken(signed)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	shared, _, err := NewJWTMaker("secret").CreateToken(1, "admin", 0, time.Minute)
+	require.NoError(t, err)
+	_, err = maker.VerifyToken(shared)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	expired, _, err := maker.CreateToken(1, "user", 0, -time.Minute)
+	require.NoError(t, err)
+	_, err = maker.VerifyToken(expired)
+	assert.ErrorIs(t, err, ErrExpiredToken)
+}
+
+func TestNewKeySetMakerFromPEM(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+	privateFile := writePEM(t, "PRIVATE KEY", der)
+
+	previous, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKIXPublicKey(&previous.PublicKey)
+	require.NoError(t, err)
+	publicFile := writePEM(t, "PUBLIC KEY", der)
+
+	maker, err := NewKeySetMakerFromPEM(privateFile, publicFile)
+	require.NoError(t, err)
+	want, err := NewVerificationKey(public)
+	require.NoError(t, err)
+	assert.Equal(t, want.ID, maker.KeyID())
+	assert.Len(t, maker.JWKS().Keys, 2)
+
+	token, _, err := maker.CreateToken(3, "user", 0, time.Minute)
+	require.NoError(t, err)
+	_, err = maker.VerifyToken(token)
+	require.NoError(t, err)
+
+	_, err = NewKeySetMakerFromPEM(writePEM(t, "PRIVATE KEY", []byte("garbage")))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
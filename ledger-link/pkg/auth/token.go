@@ -6,24 +6,45 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 var (
 	ErrInvalidToken = errors.New("invalid token")
 	ErrExpiredToken = errors.New("token has expired")
+	ErrRevokedToken = errors.New("token has been revoked")
 )
 
+// TokenMaker signs and verifies access tokens. Every token gets a unique ID
+// (the jti claim) it can be revoked by, and names the session it was issued
+// to.
 type TokenMaker interface {
-	CreateToken(userID uint, role string, duration time.Duration) (string, error)
+	CreateToken(userID uint, role string, sessionID uint, duration time.Duration) (string, *Claims, error)
 	VerifyToken(token string) (*Claims, error)
 }
 
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Role   string `json:"role"`
+	UserID    uint   `json:"user_id"`
+	Role      string `json:"role"`
+	SessionID uint   `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// NewClaims returns the claims of a new token that expires after duration.
+func NewClaims(userID uint, role string, sessionID uint, duration time.Duration) *Claims {
+	now := time.Now()
+	return &Claims{
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+}
+
 type JWTMaker struct {
 	secretKey string
 }
@@ -32,18 +53,15 @@ func NewJWTMaker(secretKey string) TokenMaker {
 	return &JWTMaker{secretKey: secretKey}
 }
 
-func (m *JWTMaker) CreateToken(userID uint, role string, duration time.Duration) (string, error) {
-	claims := Claims{
-		UserID: userID,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
-	}
+func (m *JWTMaker) CreateToken(userID uint, role string, sessionID uint, duration time.Duration) (string, *Claims, error) {
+	claims := NewClaims(userID, role, sessionID, duration)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(m.secretKey))
+	signed, err := token.SignedString([]byte(m.secretKey))
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
 func (m *JWTMaker) VerifyToken(tokenStr string) (*Claims, error) {
@@ -67,4 +85,4 @@ func (m *JWTMaker) VerifyToken(tokenStr string) (*Claims, error) {
 	}
 
 	return claims, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPMatchesRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for unix, want := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		assert.Equal(t, want, hotp(key, TOTPStep(time.Unix(unix, 0)), 8), unix)
+	}
+
+	secret := totpEncoding.EncodeToString(key)
+	code, err := TOTPCode(secret, TOTPStep(time.Unix(59, 0)))
+	require.NoError(t, err)
+	assert.Equal(t, "287082", code)
+}
+
+func TestValidateTOTPAllowsOneStepOfDrift(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+
+	for _, drift := range []time.Duration{-TOTPPeriod, 0, TOTPPeriod} {
+		code, err := TOTPCode(secret, TOTPStep(now.Add(drift)))
+		require.NoError(t, err)
+		step, ok := ValidateTOTP(secret, code, now)
+		assert.True(t, ok, drift)
+		assert.Equal(t, TOTPStep(now.Add(drift)), step)
+	}
+
+	code, err := TOTPCode(secret, TOTPStep(now.Add(-2*TOTPPeriod)))
+	require.NoError(t, err)
+	_, ok := ValidateTOTP(secret, code, now)
+	assert.False(t, ok)
+	_, ok = ValidateTOTP(secret, "12345", now)
+	assert.False(t, ok)
+	_, ok = ValidateTOTP("not base32!", "123456", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("Ledger Link", "carol@example.com", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Ledger Link:carol@example.com", uri.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	assert.Equal(t, "Ledger Link", uri.Query().Get("issuer"))
+	assert.Equal(t, "6", uri.Query().Get("digits"))
+}
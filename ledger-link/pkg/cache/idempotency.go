@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"ledger-link/internal/models"
+)
+
+const KeyIdempotency = "idempotency"
+
+// IdempotencyStore is the Redis backed models.IdempotencyStore. Records
+// expire with their keys, so no cleanup job is needed.
+type IdempotencyStore struct {
+	cache *CacheService
+}
+
+func NewIdempotencyStore(cache *CacheService) *IdempotencyStore {
+	return &IdempotencyStore{
+		cache: cache,
+	}
+}
+
+func idempotencyKey(userID uint, key string) string {
+	return fmt.Sprintf("%s:%s", BuildKey(KeyIdempotency, userID), key)
+}
+
+func (s *IdempotencyStore) Reserve(ctx context.Context, record *models.IdempotencyRecord) (bool, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := s.cache.RedisClient.SetNX(ctx, idempotencyKey(record.UserID, record.Key), data, time.Until(record.ExpiresAt)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	return ok, nil
+}
+
+func (s *IdempotencyStore) Get(ctx context.Context, userID uint, key string) (*models.IdempotencyRecord, error) {
+	var record models.IdempotencyRecord
+	if err := s.cache.Get(ctx, idempotencyKey(userID, key), &record); err != nil {
+		if err == redis.Nil {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	return &record, nil
+}
+
+func (s *IdempotencyStore) Complete(ctx context.Context, record *models.IdempotencyRecord) error {
+	record.Completed = true
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if err := s.cache.RedisClient.SetArgs(ctx, idempotencyKey(record.UserID, record.Key), data, redis.SetArgs{
+		Mode:    "XX",
+		KeepTTL: true,
+	}).Err(); err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (s *IdempotencyStore) Release(ctx context.Context, userID uint, key string) error {
+	if err := s.cache.Delete(ctx, idempotencyKey(userID, key)); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const KeyLease = "lease"
+
+// renewLease extends the lease when the caller already holds it and takes it
+// when nobody does.
+var renewLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+var releaseLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Lease is the Redis backed models.Lease. The key expires with the lease, so
+// a crashed holder loses it after its ttl.
+type Lease struct {
+	cache *CacheService
+}
+
+func NewLease(cache *CacheService) *Lease {
+	return &Lease{
+		cache: cache,
+	}
+}
+
+func leaseKey(name string) string {
+	return fmt.Sprintf("%s:%s", KeyLease, name)
+}
+
+func (l *Lease) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	n, err := renewLease.Run(ctx, l.cache.RedisClient, []string{leaseKey(name)}, holder, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (l *Lease) Release(ctx context.Context, name, holder string) error {
+	if err := releaseLease.Run(ctx, l.cache.RedisClient, []string{leaseKey(name)}, holder).Err(); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const KeyRevokedToken = "revoked"
+
+// RevocationStore is the Redis backed models.RevocationStore. Entries expire
+// with the tokens they revoke.
+type RevocationStore struct {
+	cache *CacheService
+}
+
+func NewRevocationStore(cache *CacheService) *RevocationStore {
+	return &RevocationStore{
+		cache: cache,
+	}
+}
+
+func revokedTokenKey(jti string) string {
+	return fmt.Sprintf("%s:%s", KeyRevokedToken, jti)
+}
+
+func (s *RevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := s.cache.RedisClient.Set(ctx, revokedTokenKey(jti), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+func (s *RevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	n, err := s.cache.RedisClient.Exists(ctx, revokedTokenKey(jti)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return n > 0, nil
+}
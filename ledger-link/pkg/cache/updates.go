@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/redis/go-redis/v9"
+
+	"ledger-link/internal/models"
+)
+
+const KeyUpdates = "updates"
+
+// updateIDPattern matches the Redis stream entry IDs updates are numbered
+// with.
+var updateIDPattern = regexp.MustCompile(`^\d+-\d+$`)
+
+// UpdateBroker is the Redis backed models.UpdateBroker. Each user's updates
+// are appended to a capped stream, which numbers them and keeps the recent
+// ones for replay, and a message on the pub/sub channel of the same name
+// wakes the subscribers on every replica to read what is new.
+type UpdateBroker struct {
+	cache  *CacheService
+	replay int64
+}
+
+// NewUpdateBroker keeps roughly the last replay updates of each user for
+// clients resuming a stream, for up to a day after the last one.
+func NewUpdateBroker(cache *CacheService, replay int64) *UpdateBroker {
+	return &UpdateBroker{
+		cache:  cache,
+		replay: replay,
+	}
+}
+
+func updatesKey(userID uint) string {
+	return BuildKey(KeyUpdates, userID)
+}
+
+func (b *UpdateBroker) Publish(ctx context.Context, update *models.Update) error {
+	key := updatesKey(update.UserID)
+
+	var id *redis.StringCmd
+	_, err := b.cache.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		id = pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			MaxLen: b.replay,
+			Approx: true,
+			Values: map[string]interface{}{
+				"type": update.Type,
+				"data": string(update.Data),
+			},
+		})
+		pipe.Expire(ctx, key, LongTerm)
+		pipe.Publish(ctx, key, update.Type)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish %s update: %w", update.Type, err)
+	}
+
+	update.ID = id.Val()
+	return nil
+}
+
+// Subscribe listens before it reads the backlog, so no update published in
+// between is missed, and reads the stream again on every notification,
+// which keeps updates in stream order across publishing replicas.
+func (b *UpdateBroker) Subscribe(ctx context.Context, userID uint, lastID string) (<-chan models.Update, error) {
+	if lastID != "" && !updateIDPattern.MatchString(lastID) {
+		return nil, fmt.Errorf("%w: malformed event ID %q", models.ErrInvalidInput, lastID)
+	}
+
+	key := updatesKey(userID)
+	pubsub := b.cache.RedisClient.Subscribe(ctx, key)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to updates: %w", err)
+	}
+
+	cursor := lastID
+	if cursor == "" {
+		latest, err := b.cache.RedisClient.XRevRangeN(ctx, key, "+", "-", 1).Result()
+		if err != nil {
+			pubsub.Close()
+			return nil, fmt.Errorf("failed to read updates: %w", err)
+		}
+		cursor = "0-0"
+		if len(latest) > 0 {
+			cursor = latest[0].ID
+		}
+	}
+
+	updates := make(chan models.Update)
+	go func() {
+		defer close(updates)
+		defer pubsub.Close()
+
+		notifications := pubsub.Channel()
+		for {
+			entries, err := b.cache.RedisClient.XRange(ctx, key, cursor, "+").Result()
+			if err != nil {
+				return
+			}
+			for _, entry := range entries {
+				if entry.ID == cursor {
+					continue
+				}
+				update := models.Update{ID: entry.ID, UserID: userID}
+				update.Type, _ = entry.Values["type"].(string)
+				data, _ := entry.Values["data"].(string)
+				update.Data = json.RawMessage(data)
+
+				select {
+				case updates <- update:
+					cursor = entry.ID
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-notifications:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+
+	return updates, nil
+}
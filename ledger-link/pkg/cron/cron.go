@@ -0,0 +1,183 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expression is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week. Fields accept "*", numbers, ranges ("1-5"),
+// steps ("*/15", "0-30/10") and comma separated lists of those. Day of week
+// runs from 0 (Sunday) to 6; 7 is accepted as Sunday too.
+type Expression struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	anyDom  bool
+	anyDow  bool
+	literal string
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type bounds struct {
+	name     string
+	min, max int
+}
+
+var fieldBounds = []bounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses a five field expression or one of the @yearly, @monthly,
+// @weekly, @daily and @hourly descriptors.
+func Parse(spec string) (*Expression, error) {
+	literal := strings.TrimSpace(spec)
+	if expanded, ok := descriptors[literal]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(fieldBounds) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields", literal, len(fieldBounds))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseField(field, fieldBounds[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", literal, err)
+		}
+		sets[i] = set
+	}
+
+	// Sunday may be written as 0 or 7
+	dow := sets[4]
+	if dow&(1<<7) != 0 {
+		dow |= 1
+	}
+
+	return &Expression{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     dow,
+		anyDom:  fields[2] == "*",
+		anyDow:  fields[4] == "*",
+		literal: literal,
+	}, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field %q", b.name, part)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		lo, hi := b.min, b.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range in %s field %q", b.name, part)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %s field %q", b.name, part)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				hi = b.max
+			}
+		}
+
+		if lo < b.min || hi > b.max || lo > hi {
+			return 0, fmt.Errorf("%s field %q is outside %d-%d", b.name, part, b.min, b.max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// searchLimit bounds Next for expressions that never match, such as the 30th
+// of February.
+const searchLimit = 5
+
+// Next returns the first time strictly after t that matches the expression,
+// in t's location, or the zero time if there is none within five years.
+func (e *Expression) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + searchLimit
+
+	for t.Year() <= limit {
+		if e.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !e.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if e.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if e.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchDay follows the usual cron rule: when both day fields are restricted
+// a day matching either of them is enough.
+func (e *Expression) matchDay(t time.Time) bool {
+	domMatch := e.dom&(1<<uint(t.Day())) != 0
+	dowMatch := e.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case e.anyDom && e.anyDow:
+		return true
+	case e.anyDom:
+		return dowMatch
+	case e.anyDow:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+func (e *Expression) String() string {
+	return e.literal
+}
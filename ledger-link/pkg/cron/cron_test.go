@@ -0,0 +1,42 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC)
+
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, time.January, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 9 1 * *", time.Date(2024, time.February, 1, 9, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 8 * * 1-5", time.Date(2024, time.February, 1, 8, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"30 10 31 1 *", time.Date(2025, time.January, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2024, time.February, 4, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, c := range cases {
+		expr, err := Parse(c.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.spec, err)
+		}
+		if got := expr.Next(from); !got.Equal(c.want) {
+			t.Errorf("Next(%q) = %v, want %v", c.spec, got, c.want)
+		}
+	}
+}
+
+func TestParseRejectsInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", spec)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Package events holds the sinks the outbox relay publishes ledger events
+// to.
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"ledger-link/internal/models"
+)
+
+// ChannelSink hands events to consumers in the same process. Publish blocks
+// while the channel is full, so a slow consumer holds the relay back instead
+// of losing events.
+type ChannelSink struct {
+	events chan models.OutboxEvent
+}
+
+func NewChannelSink(buffer int) *ChannelSink {
+	return &ChannelSink{
+		events: make(chan models.OutboxEvent, buffer),
+	}
+}
+
+// Events returns the channel consumers read from.
+func (s *ChannelSink) Events() <-chan models.OutboxEvent {
+	return s.events
+}
+
+func (s *ChannelSink) Publish(ctx context.Context, events []models.OutboxEvent) error {
+	for _, event := range events {
+		select {
+		case s.events <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *ChannelSink) Close() error {
+	return nil
+}
+
+// FileSink appends events to a file as newline-delimited JSON, one event per
+// line, and syncs the file after every batch.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event file: %w", err)
+	}
+	return &FileSink{
+		file: file,
+	}, nil
+}
+
+func (s *FileSink) Publish(ctx context.Context, events []models.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := bufio.NewWriter(s.file)
+	enc := json.NewEncoder(w)
+	for i := range events {
+		if err := enc.Encode(&events[i]); err != nil {
+			return fmt.Errorf("failed to write event %d: %w", events[i].ID, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write events: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync event file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// RedisStreamSink adds events to a Redis stream. The stream is trimmed to
+// roughly maxLen entries; zero keeps every entry.
+type RedisStreamSink struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+func NewRedisStreamSink(client *redis.Client, stream string, maxLen int64) *RedisStreamSink {
+	return &RedisStreamSink{
+		client: client,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+func (s *RedisStreamSink) Publish(ctx context.Context, events []models.OutboxEvent) error {
+	pipe := s.client.Pipeline()
+	for _, event := range events {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.stream,
+			MaxLen: s.maxLen,
+			Approx: s.maxLen > 0,
+			Values: map[string]interface{}{
+				"id":         strconv.FormatUint(uint64(event.ID), 10),
+				"type":       event.Type,
+				"account_id": strconv.FormatUint(uint64(event.AccountID), 10),
+				"entity_id":  event.EntityID,
+				"payload":    string(event.Payload),
+				"created_at": event.CreatedAt.UTC().Format(time.RFC3339Nano),
+			},
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to add events to stream %s: %w", s.stream, err)
+	}
+	return nil
+}
+
+func (s *RedisStreamSink) Close() error {
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package mailer holds the mailers the service sends email with: SMTP, and
+// a file or the log for local development.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+var ErrInvalidMessage = errors.New("invalid mail message")
+
+// SMTPMailer sends email through an SMTP server, authenticating with PLAIN
+// when a username is set. The connection is upgraded with STARTTLS when the
+// server offers it.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host, port, username, password, from string) (*SMTPMailer, error) {
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", from, err)
+	}
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		from: from,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message *models.MailMessage) error {
+	data, err := Format(m.from, message, time.Now())
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	sender, _ := mail.ParseAddress(m.from)
+
+	// smtp.SendMail takes no context, so check it has not ended already
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := smtp.SendMail(m.addr, m.auth, sender.Address, []string{to.Address}, data); err != nil {
+		return fmt.Errorf("failed to send mail to %s: %w", to.Address, err)
+	}
+	return nil
+}
+
+// FileMailer appends messages to a file as newline-delimited JSON, for
+// local testing.
+type FileMailer struct {
+	mu   sync.Mutex
+	file *os.File
+	from string
+}
+
+func NewFileMailer(path, from string) (*FileMailer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mail file: %w", err)
+	}
+	return &FileMailer{
+		file: file,
+		from: from,
+	}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, message *models.MailMessage) error {
+	if err := validate(message); err != nil {
+		return err
+	}
+	line, err := json.Marshal(struct {
+		From string `json:"from"`
+		*models.MailMessage
+		SentAt time.Time `json:"sent_at"`
+	}{m.from, message, time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("failed to encode mail: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+	return nil
+}
+
+func (m *FileMailer) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.file.Close()
+}
+
+// LogMailer writes messages to the log instead of sending them, for local
+// testing. The bodies carry live tokens, so it must not be used in
+// production.
+type LogMailer struct {
+	logger *logger.Logger
+}
+
+func NewLogMailer(logger *logger.Logger) *LogMailer {
+	return &LogMailer{
+		logger: logger,
+	}
+}
+
+func (m *LogMailer) Send(ctx context.Context, message *models.MailMessage) error {
+	if err := validate(message); err != nil {
+		return err
+	}
+	m.logger.Info("mail not sent, logged instead", "to", message.To, "subject", message.Subject, "body", message.Body)
+	return nil
+}
+
+// Format renders a message as an RFC 5322 plain text email.
+func Format(from string, message *models.MailMessage, date time.Time) ([]byte, error) {
+	if err := validate(message); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", message.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
+
+// validate refuses messages whose headers could inject more headers.
+func validate(message *models.MailMessage) error {
+	if message == nil || message.To == "" {
+		return fmt.Errorf("%w: no recipient", ErrInvalidMessage)
+	}
+	if strings.ContainsAny(message.To, "\r\n") || strings.ContainsAny(message.Subject, "\r\n") {
+		return fmt.Errorf("%w: line break in header", ErrInvalidMessage)
+	}
+	if _, err := mail.ParseAddress(message.To); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	return nil
+}
@@ -1,40 +1,92 @@
 package middleware
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strings"
 
 	"ledger-link/internal/models"
 	"ledger-link/pkg/auth"
+	"ledger-link/pkg/httputil"
 	"ledger-link/pkg/logger"
 )
 
 type AuthMiddleware struct {
 	authService models.AuthService
-	logger      *logger.Logger
+	apiKeys     models.APIKeyService
+	roles       models.RoleService
+	// trustProxy takes the client address from X-Forwarded-For when
+	// checking API key allowlists
+	trustProxy bool
+	logger     *logger.Logger
 }
 
+// APIKeyHeader carries an API key; keys may also be sent as bearer tokens
+const APIKeyHeader = "X-API-Key"
+
 var publicPaths = map[string]bool{
-	"/api/v1/auth/register": true,
-	"/api/v1/auth/login":    true,
-	"/api/v1/auth/refresh":  true,
-	"/health":               true,
+	"/api/v1/auth/register":        true,
+	"/api/v1/auth/login":           true,
+	"/api/v1/auth/login/verify":    true,
+	"/api/v1/auth/refresh":         true,
+	"/api/v1/auth/password/forgot": true,
+	"/api/v1/auth/password/reset":  true,
+	"/api/v1/auth/email/verify":    true,
+	"/health":                      true,
 }
 
-func NewAuthMiddleware(authService models.AuthService, logger *logger.Logger) *AuthMiddleware {
+func NewAuthMiddleware(authService models.AuthService, apiKeys models.APIKeyService, roles models.RoleService, trustProxy bool, logger *logger.Logger) *AuthMiddleware {
 	return &AuthMiddleware{
 		authService: authService,
+		apiKeys:     apiKeys,
+		roles:       roles,
+		trustProxy:  trustProxy,
 		logger:      logger,
 	}
 }
 
+// authenticateAPIKey serves the request as the user the API key acts as,
+// with the key in the context for RBACMiddleware.RequireScope.
+func (m *AuthMiddleware) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	user, apiKey, err := m.apiKeys.Authenticate(r.Context(), key, httputil.ClientIP(r, m.trustProxy))
+	if err != nil {
+		m.logger.Error("API key validation failed", "error", err)
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	m.logger.Info("User from API key", "user_id", user.ID, "prefix", apiKey.Prefix)
+
+	ctx, err := m.withPermissions(auth.SetUserInContext(r.Context(), user), user)
+	if err != nil {
+		http.Error(w, "Failed to load permissions", http.StatusInternalServerError)
+		return
+	}
+	ctx = auth.SetAPIKeyInContext(ctx, apiKey)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// withPermissions adds the permissions of the user's role to the context
+// for RBACMiddleware.RequirePermission and auth.HasPermission.
+func (m *AuthMiddleware) withPermissions(ctx context.Context, user *models.User) (context.Context, error) {
+	permissions, err := m.roles.Permissions(ctx, user.Role)
+	if err != nil {
+		m.logger.Error("Failed to load role permissions", "error", err, "role", user.Role)
+		return nil, err
+	}
+	return auth.SetPermissionsInContext(ctx, permissions), nil
+}
+
 func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		m.logger.Info("Processing request", "path", r.URL.Path)
 
+		if key := r.Header.Get(APIKeyHeader); key != "" {
+			m.authenticateAPIKey(w, r, next, key)
+			return
+		}
+
 		authHeader := r.Header.Get("Authorization")
-		m.logger.Info("Auth header", "header", authHeader)
 
 		if authHeader == "" {
 			m.logger.Error("No auth header")
@@ -44,12 +96,17 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			m.logger.Error("Invalid auth header format", "header", authHeader)
+			m.logger.Error("Invalid auth header format")
 			http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
 			return
 		}
 
 		token := parts[1]
+		if strings.HasPrefix(token, models.APIKeyPrefix) {
+			m.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
 		user, err := m.authService.ValidateToken(r.Context(), token)
 		if err != nil {
 			m.logger.Error("Token validation failed", "error", err)
@@ -69,7 +126,11 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 		m.logger.Info("User role", "role", user.Role)
 
 		// Use the new context helper
-		ctx := auth.SetUserInContext(r.Context(), user)
+		ctx, err := m.withPermissions(auth.SetUserInContext(r.Context(), user), user)
+		if err != nil {
+			http.Error(w, "Failed to load permissions", http.StatusInternalServerError)
+			return
+		}
 
 		// Verify the user was set correctly
 		if verifyUser, ok := auth.GetUserFromContext(ctx); ok {
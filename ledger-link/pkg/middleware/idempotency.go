@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/logger"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	DefaultIdempotencyKeyTTL = 24 * time.Hour
+)
+
+// IdempotencyMiddleware makes money-moving endpoints safe to retry. A request
+// carrying an Idempotency-Key header is executed once per user and key; later
+// requests with the same key and payload receive the stored response, while a
+// different payload is rejected with 422.
+type IdempotencyMiddleware struct {
+	store  models.IdempotencyStore
+	ttl    time.Duration
+	logger *logger.Logger
+}
+
+func NewIdempotencyMiddleware(store models.IdempotencyStore, ttl time.Duration, logger *logger.Logger) *IdempotencyMiddleware {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyKeyTTL
+	}
+	return &IdempotencyMiddleware{
+		store:  store,
+		ttl:    ttl,
+		logger: logger,
+	}
+}
+
+// Handle must run after authentication so the key can be scoped to the user.
+// Requests without the header pass straight through.
+func (m *IdempotencyMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, "Idempotency key is too long", http.StatusBadRequest)
+			return
+		}
+
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		record := &models.IdempotencyRecord{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: hashRequest(r, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(m.ttl),
+		}
+
+		reserved, err := m.store.Reserve(r.Context(), record)
+		if err != nil {
+			m.logger.Error("failed to reserve idempotency key", "error", err, "user_id", userID)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !reserved {
+			m.replay(w, r, record)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// The key is freed for a retry instead of pinning the failure
+		if releasesKey(rec.statusCode) {
+			if err := m.store.Release(r.Context(), userID, key); err != nil {
+				m.logger.Error("failed to release idempotency key", "error", err, "user_id", userID)
+			}
+			return
+		}
+
+		record.StatusCode = rec.statusCode
+		record.ContentType = rec.Header().Get("Content-Type")
+		record.ResponseBody = rec.body.Bytes()
+		if err := m.store.Complete(r.Context(), record); err != nil {
+			m.logger.Error("failed to store idempotent response", "error", err, "user_id", userID)
+		}
+	})
+}
+
+func (m *IdempotencyMiddleware) replay(w http.ResponseWriter, r *http.Request, record *models.IdempotencyRecord) {
+	existing, err := m.store.Get(r.Context(), record.UserID, record.Key)
+	if err != nil {
+		if err == models.ErrNotFound {
+			// Released or expired between Reserve and Get
+			http.Error(w, models.ErrIdempotencyKeyInProgress.Error(), http.StatusConflict)
+			return
+		}
+		m.logger.Error("failed to get idempotency key", "error", err, "user_id", record.UserID)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if existing.RequestHash != record.RequestHash {
+		http.Error(w, models.ErrIdempotencyKeyReused.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if !existing.Completed {
+		http.Error(w, models.ErrIdempotencyKeyInProgress.Error(), http.StatusConflict)
+		return
+	}
+
+	if existing.ContentType != "" {
+		w.Header().Set("Content-Type", existing.ContentType)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(existing.StatusCode)
+	w.Write(existing.ResponseBody)
+}
+
+// releasesKey reports whether a response frees its idempotency key. Server
+// errors leave the outcome unknown to the client, and a request refused for
+// its credentials or step-up code, or locked out, moved nothing and may be
+// retried with the same key once the client has a code.
+func releasesKey(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= http.StatusInternalServerError
+}
+
+func hashRequest(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes the response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	rr.statusCode = code
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+	"ledger-link/pkg/cache"
+	"ledger-link/pkg/logger"
+)
+
+type idempotencyHarness struct {
+	redis   *miniredis.Miniredis
+	handler http.Handler
+	calls   int
+	status  int
+}
+
+func newIdempotencyHarness(t *testing.T) *idempotencyHarness {
+	t.Helper()
+
+	h := &idempotencyHarness{redis: miniredis.RunT(t), status: http.StatusOK}
+	store := cache.NewIdempotencyStore(cache.NewCacheService(redis.NewClient(&redis.Options{Addr: h.redis.Addr()})))
+	m := NewIdempotencyMiddleware(store, time.Hour, logger.New("error"))
+
+	h.handler = m.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(h.status)
+		fmt.Fprintf(w, `{"call":%d}`, h.calls)
+	}))
+	return h
+}
+
+func (h *idempotencyHarness) do(userID uint, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/transactions/transfer", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	ctx := auth.SetUserInContext(context.Background(), &models.User{ID: userID, Role: models.RoleUser})
+
+	rec := httptest.NewRecorder()
+	h.handler.ServeHTTP(rec, req.WithContext(ctx))
+	return rec
+}
+
+func TestIdempotencyReplaysStoredResponse(t *testing.T) {
+	h := newIdempotencyHarness(t)
+	h.status = http.StatusCreated
+
+	first := h.do(1, "key-1", `{"amount":"10"}`)
+	second := h.do(1, "key-1", `{"amount":"10"}`)
+
+	assert.Equal(t, 1, h.calls)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+	assert.Equal(t, "true", second.Header().Get(IdempotentReplayedHeader))
+	assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+}
+
+func TestIdempotencyRejectsDifferentPayload(t *testing.T) {
+	h := newIdempotencyHarness(t)
+
+	h.do(1, "key-1", `{"amount":"10"}`)
+	rec := h.do(1, "key-1", `{"amount":"20"}`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, 1, h.calls)
+}
+
+func TestIdempotencyKeysAreScopedPerUser(t *testing.T) {
+	h := newIdempotencyHarness(t)
+
+	h.do(1, "key-1", `{"amount":"10"}`)
+	rec := h.do(2, "key-1", `{"amount":"10"}`)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, h.calls)
+}
+
+func TestIdempotencyReleasesKeyOnServerError(t *testing.T) {
+	h := newIdempotencyHarness(t)
+	h.status = http.StatusInternalServerError
+
+	h.do(1, "key-1", `{"amount":"10"}`)
+	h.status = http.StatusOK
+	rec := h.do(1, "key-1", `{"amount":"10"}`)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, h.calls)
+}
+
+func TestIdempotencyReleasesKeyWhenStepUpFails(t *testing.T) {
+	h := newIdempotencyHarness(t)
+
+	// A transfer refused for a missing or wrong TOTP code is retried with one
+	for _, status := range []int{http.StatusForbidden, http.StatusTooManyRequests} {
+		h.status = status
+		assert.Equal(t, status, h.do(1, "key-1", `{"amount":"10"}`).Code)
+	}
+	h.status = http.StatusOK
+	rec := h.do(1, "key-1", `{"amount":"10"}`)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, 3, h.calls)
+
+	// Business refusals are kept like any other outcome
+	h.status = http.StatusUnprocessableEntity
+	h.do(1, "key-2", `{"amount":"10"}`)
+	h.status = http.StatusOK
+	rec = h.do(1, "key-2", `{"amount":"10"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, 4, h.calls)
+}
+
+func TestIdempotencyKeyExpires(t *testing.T) {
+	h := newIdempotencyHarness(t)
+
+	h.do(1, "key-1", `{"amount":"10"}`)
+	h.redis.FastForward(2 * time.Hour)
+	rec := h.do(1, "key-1", `{"amount":"20"}`)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, h.calls)
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	h := newIdempotencyHarness(t)
+	store := cache.NewIdempotencyStore(cache.NewCacheService(redis.NewClient(&redis.Options{Addr: h.redis.Addr()})))
+
+	// Simulate a concurrent request that has reserved the key but not finished
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/transactions/transfer", strings.NewReader(`{"amount":"10"}`))
+	reserved, err := store.Reserve(context.Background(), &models.IdempotencyRecord{
+		UserID:      1,
+		Key:         "key-1",
+		RequestHash: hashRequest(req, []byte(`{"amount":"10"}`)),
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	require.True(t, reserved)
+
+	rec := h.do(1, "key-1", `{"amount":"10"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Zero(t, h.calls)
+}
+
+func TestRequestsWithoutKeyPassThrough(t *testing.T) {
+	h := newIdempotencyHarness(t)
+
+	h.do(1, "", `{"amount":"10"}`)
+	h.do(1, "", `{"amount":"10"}`)
+
+	assert.Equal(t, 2, h.calls)
+}
@@ -27,6 +27,10 @@ var (
 		Limit:    30,
 		Duration: time.Minute,
 	}
+	RedeliverRateLimit = ratelimit.RateLimit{
+		Limit:    10,
+		Duration: time.Minute,
+	}
 )
 
 type RateLimitMiddleware struct {
@@ -58,3 +62,7 @@ func (m *RateLimitMiddleware) BalanceLimit(next http.Handler) http.Handler {
 func (m *RateLimitMiddleware) UserOperationLimit(next http.Handler) http.Handler {
 	return m.limiter.Limit("user", UserOperationRateLimit)(next)
 }
+
+func (m *RateLimitMiddleware) RedeliverLimit(next http.Handler) http.Handler {
+	return m.limiter.Limit("redeliver", RedeliverRateLimit)(next)
+}
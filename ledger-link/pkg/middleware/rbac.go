@@ -19,7 +19,8 @@ func NewRBACMiddleware(logger *logger.Logger) *RBACMiddleware {
 	}
 }
 
-// RequireAdmin ensures the user has admin role
+// RequireAdmin ensures the user has admin role. Admin routes are never
+// open to API keys.
 func (m *RBACMiddleware) RequireAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, ok := auth.GetUserFromContext(r.Context())
@@ -29,6 +30,12 @@ func (m *RBACMiddleware) RequireAdmin(next http.Handler) http.Handler {
 			return
 		}
 
+		if key, ok := auth.GetAPIKeyFromContext(r.Context()); ok {
+			m.logger.Error("API key used on admin route", "prefix", key.Prefix)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
 		userJSON, _ := json.Marshal(user)
 		m.logger.Info("RequireAdmin check", "user", string(userJSON))
 
@@ -42,7 +49,7 @@ func (m *RBACMiddleware) RequireAdmin(next http.Handler) http.Handler {
 	})
 }
 
-// RequireUser ensures the user has at least user role
+// RequireUser ensures the user has a known role
 func (m *RBACMiddleware) RequireUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, ok := auth.GetUserFromContext(r.Context())
@@ -55,8 +62,8 @@ func (m *RBACMiddleware) RequireUser(next http.Handler) http.Handler {
 		userJSON, _ := json.Marshal(user)
 		m.logger.Info("RequireUser check", "user", string(userJSON))
 
-		// Allow both regular users and admins
-		if user.Role == models.RoleUser || user.Role == models.RoleAdmin {
+		// Allow regular users, admins and staff
+		if models.IsValidRole(user.Role) {
 			m.logger.Info("User role check passed", "role", user.Role)
 			next.ServeHTTP(w, r)
 			return
@@ -103,3 +110,114 @@ func (m *RBACMiddleware) RequireOwnerOrAdmin(getResourceID func(*http.Request) u
 		})
 	}
 }
+
+// RequirePermission ensures the user's role holds the permission. Like
+// admin routes, permission routes are never open to API keys.
+func (m *RBACMiddleware) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := auth.GetUserFromContext(r.Context())
+			if !ok {
+				m.logger.Error("No user in context - RequirePermission")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if key, ok := auth.GetAPIKeyFromContext(r.Context()); ok {
+				m.logger.Error("API key used on permission route", "prefix", key.Prefix, "permission", permission)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			if !auth.HasPermission(r.Context(), permission) {
+				m.logger.Error("Role lacks permission", "user_id", user.ID, "role", user.Role, "permission", permission)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireOwnerOrPermission ensures the user owns the resource or their role
+// holds the permission
+func (m *RBACMiddleware) RequireOwnerOrPermission(permission string, getResourceID func(*http.Request) uint) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := auth.GetUserFromContext(r.Context())
+			if !ok {
+				m.logger.Error("No user in context - RequireOwnerOrPermission")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			resourceID := getResourceID(r)
+			if resourceID == user.ID || auth.HasPermission(r.Context(), permission) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			m.logger.Error("Access denied", "user_id", user.ID, "resource_id", resourceID, "permission", permission)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
+
+// RequireScope admits requests made with an API key only when the key has
+// the scope. Requests made with an access token are left to the role
+// checks.
+func (m *RBACMiddleware) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := auth.GetUserFromContext(r.Context()); !ok {
+				m.logger.Error("No user in context - RequireScope")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if key, ok := auth.GetAPIKeyFromContext(r.Context()); ok && !key.HasScope(scope) {
+				m.logger.Error("API key lacks scope", "prefix", key.Prefix, "scope", scope)
+				http.Error(w, "Forbidden: API key lacks scope "+scope, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAccessToken refuses requests made with an API key, for routes no
+// scope covers.
+func (m *RBACMiddleware) RequireAccessToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := auth.GetAPIKeyFromContext(r.Context()); ok {
+			m.logger.Error("API key used on a route without scopes", "prefix", key.Prefix, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireVerifiedEmail refuses users who have not verified their email
+// address, for routes that move money out of their account.
+func (m *RBACMiddleware) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetUserFromContext(r.Context())
+		if !ok {
+			m.logger.Error("No user in context - RequireVerifiedEmail")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !user.IsEmailVerified() {
+			m.logger.Error("Email address not verified", "user_id", user.ID, "path", r.URL.Path)
+			http.Error(w, "Email address is not verified", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
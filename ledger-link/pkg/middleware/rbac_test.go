@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"ledger-link/internal/models"
+	"ledger-link/pkg/logger"
+)
+
+// fakeCredentials accepts the access token "<role>-token" for a user with
+// that role, and the API key
+// "llk_0123_secret", which may only be used from 192.0.2.1. Roles hold their
+// default permissions. Users have verified their email unless unverified
+// is set.
+type fakeCredentials struct {
+	models.AuthService
+	models.APIKeyService
+	models.RoleService
+	scopes     []string
+	unverified bool
+}
+
+func (f *fakeCredentials) user(id uint, role string) *models.User {
+	user := &models.User{ID: id, Role: role}
+	if !f.unverified {
+		verifiedAt := time.Now()
+		user.EmailVerifiedAt = &verifiedAt
+	}
+	return user
+}
+
+func (f *fakeCredentials) ValidateToken(ctx context.Context, token string) (*models.User, error) {
+	role := strings.TrimSuffix(token, "-token")
+	if !strings.HasSuffix(token, "-token") || !models.IsValidRole(role) {
+		return nil, models.ErrUnauthorized
+	}
+	return f.user(1, role), nil
+}
+
+func (f *fakeCredentials) Permissions(ctx context.Context, role string) ([]string, error) {
+	if role == models.RoleAdmin {
+		return models.Permissions, nil
+	}
+	return models.DefaultRolePermissions[role], nil
+}
+
+func (f *fakeCredentials) Authenticate(ctx context.Context, key, ipAddress string) (*models.User, *models.APIKey, error) {
+	if key != "llk_0123_secret" || ipAddress != "192.0.2.1" {
+		return nil, nil, models.ErrInvalidAPIKey
+	}
+	return f.user(2, models.RoleAdmin), &models.APIKey{Prefix: "llk_0123", UserID: 2, Scopes: f.scopes}, nil
+}
+
+func serveWithCredentials(handler http.Handler, header, value string) int {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/balances", nil)
+	req.Header.Set(header, value)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAPIKeysOnlyReachScopedRoutes(t *testing.T) {
+	log := logger.New("error")
+	credentials := &fakeCredentials{scopes: []string{models.ScopeBalancesRead}}
+	authMiddleware := NewAuthMiddleware(credentials, credentials, credentials, false, log)
+	rbac := NewRBACMiddleware(log)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	balances := authMiddleware.Authenticate(rbac.RequireScope(models.ScopeBalancesRead)(ok))
+	transfers := authMiddleware.Authenticate(rbac.RequireScope(models.ScopeTransactionsWrite)(ok))
+	admin := authMiddleware.Authenticate(rbac.RequireAdmin(ok))
+	sessions := authMiddleware.Authenticate(rbac.RequireAccessToken(ok))
+
+	assert.Equal(t, http.StatusOK, serveWithCredentials(balances, APIKeyHeader, "llk_0123_secret"))
+	assert.Equal(t, http.StatusOK, serveWithCredentials(balances, "Authorization", "Bearer llk_0123_secret"))
+	assert.Equal(t, http.StatusUnauthorized, serveWithCredentials(balances, APIKeyHeader, "llk_0123_wrong"))
+	assert.Equal(t, http.StatusForbidden, serveWithCredentials(transfers, APIKeyHeader, "llk_0123_secret"))
+	assert.Equal(t, http.StatusForbidden, serveWithCredentials(admin, APIKeyHeader, "llk_0123_secret"))
+	assert.Equal(t, http.StatusForbidden, serveWithCredentials(sessions, APIKeyHeader, "llk_0123_secret"))
+
+	// Access tokens are left to the role checks
+	for _, handler := range []http.Handler{balances, transfers, admin, sessions} {
+		assert.Equal(t, http.StatusOK, serveWithCredentials(handler, "Authorization", "Bearer admin-token"))
+	}
+
+	// A spoofed X-Forwarded-For does not satisfy the allowlist unless the
+	// proxy is trusted
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/balances", nil)
+	req.RemoteAddr = "198.51.100.4:5000"
+	req.Header.Set(APIKeyHeader, "llk_0123_secret")
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	rec := httptest.NewRecorder()
+	balances.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewAuthMiddleware(credentials, credentials, credentials, true, log).Authenticate(ok).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRequirePermissionFollowsRolePermissions(t *testing.T) {
+	log := logger.New("error")
+	credentials := &fakeCredentials{scopes: []string{models.ScopeTransactionsRead}}
+	authMiddleware := NewAuthMiddleware(credentials, credentials, credentials, false, log)
+	rbac := NewRBACMiddleware(log)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	auditLogs := authMiddleware.Authenticate(rbac.RequirePermission(models.PermissionAuditLogsRead)(ok))
+	adjustments := authMiddleware.Authenticate(rbac.RequirePermission(models.PermissionAdjustmentsCreate)(ok))
+	roles := authMiddleware.Authenticate(rbac.RequirePermission(models.PermissionRolesManage)(ok))
+	users := authMiddleware.Authenticate(rbac.RequireOwnerOrPermission(models.PermissionAccountsRead, func(r *http.Request) uint { return 7 })(ok))
+
+	bearer := func(role string) string { return "Bearer " + role + "-token" }
+	for _, check := range []struct {
+		handler http.Handler
+		role    string
+		want    int
+	}{
+		{auditLogs, models.RoleAuditor, http.StatusOK},
+		{auditLogs, models.RoleSupport, http.StatusForbidden},
+		{adjustments, models.RoleOperator, http.StatusOK},
+		{adjustments, models.RoleAuditor, http.StatusForbidden},
+		{roles, models.RoleAdmin, http.StatusOK},
+		{roles, models.RoleOperator, http.StatusForbidden},
+		{users, models.RoleSupport, http.StatusOK},
+		{users, models.RoleUser, http.StatusForbidden},
+		{users, models.RoleAuditor, http.StatusForbidden},
+	} {
+		assert.Equal(t, check.want, serveWithCredentials(check.handler, "Authorization", bearer(check.role)), check.role)
+	}
+
+	// API keys never reach permission routes, even for admins
+	assert.Equal(t, http.StatusForbidden, serveWithCredentials(auditLogs, APIKeyHeader, "llk_0123_secret"))
+}
+
+func TestRequireVerifiedEmailBlocksUnverifiedUsers(t *testing.T) {
+	log := logger.New("error")
+	credentials := &fakeCredentials{scopes: []string{models.ScopeTransactionsWrite}}
+	authMiddleware := NewAuthMiddleware(credentials, credentials, credentials, false, log)
+	rbac := NewRBACMiddleware(log)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	transfers := authMiddleware.Authenticate(rbac.RequireScope(models.ScopeTransactionsWrite)(rbac.RequireVerifiedEmail(ok)))
+
+	assert.Equal(t, http.StatusOK, serveWithCredentials(transfers, "Authorization", "Bearer user-token"))
+	assert.Equal(t, http.StatusOK, serveWithCredentials(transfers, APIKeyHeader, "llk_0123_secret"))
+
+	// API keys act for their owner, so they are refused too
+	credentials.unverified = true
+	assert.Equal(t, http.StatusForbidden, serveWithCredentials(transfers, "Authorization", "Bearer user-token"))
+	assert.Equal(t, http.StatusForbidden, serveWithCredentials(transfers, APIKeyHeader, "llk_0123_secret"))
+}
@@ -63,7 +63,8 @@ func (s *Server) WithRateLimiter(redisClient *redis.Client) *Server {
 			// Apply rate limiting based on path
 			var handler http.Handler
 			switch r.URL.Path {
-			case "/api/v1/auth/login":
+			case "/api/v1/auth/login", "/api/v1/auth/login/verify",
+				"/api/v1/auth/password/forgot", "/api/v1/auth/password/reset", "/api/v1/auth/email/verify":
 				handler = rateLimiter.Limit("login", middleware.LoginRateLimit)(next)
 			case "/api/v1/auth/register":
 				handler = rateLimiter.Limit("register", middleware.RegisterRateLimit)(next)
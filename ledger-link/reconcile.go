@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"ledger-link/config"
+	"ledger-link/internal/models"
+	"ledger-link/pkg/auth"
+)
+
+// runReconcile implements the reconcile subcommand and returns the exit
+// code. Without flags it reconciles every balance and prints the report,
+// exiting with 1 when balances drift. -repair repairs the listed
+// discrepancies with the approval of the admin given by -admin.
+func runReconcile(container *config.ServiceContainer, args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	repair := flags.String("repair", "", "comma separated IDs of the discrepancies to repair")
+	adminID := flags.Uint("admin", 0, "ID of the admin approving the repairs")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx := context.Background()
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+
+	if *repair == "" {
+		run, err := container.ReconciliationService.Run(ctx, models.ReconciliationTriggerCLI)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "reconciliation failed:", err)
+			return 1
+		}
+		out.Encode(run)
+		if len(run.Discrepancies) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	admin, err := container.UserService.GetByID(ctx, *adminID)
+	if err != nil || admin.Role != models.RoleAdmin {
+		fmt.Fprintln(os.Stderr, "repairs need the ID of an admin in -admin")
+		return 2
+	}
+	ctx = auth.SetUserInContext(ctx, admin)
+
+	status := 0
+	for _, field := range strings.Split(*repair, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid discrepancy ID %q\n", field)
+			return 2
+		}
+
+		discrepancy, err := container.ReconciliationService.Repair(ctx, uint(id))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "discrepancy %d: %v\n", id, err)
+			status = 1
+			continue
+		}
+		out.Encode(discrepancy)
+	}
+	return status
+}